@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"shared/httpx"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// priorityHeader lets trusted callers choose the tier of a request
+const priorityHeader = "X-Priority"
+
+// priority is a request's admission tier; higher tiers keep being admitted after
+// lower ones are shed
+type priority int
+
+const (
+	priorityLow priority = iota
+	priorityNormal
+	priorityHigh
+)
+
+var priorityNames = []string{"low", "normal", "high"}
+
+func (p priority) String() string { return priorityNames[p] }
+
+func parsePriority(raw string) (priority, bool) {
+	i := slices.Index(priorityNames, strings.ToLower(strings.TrimSpace(raw)))
+	return priority(i), i >= 0
+}
+
+var (
+	admissionShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_admission_shed_total",
+		Help: "Requests rejected with 503 because the gateway was at its concurrency limit for their priority.",
+	}, []string{"priority"})
+	admissionInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_requests_in_flight",
+		Help: "Requests currently admitted by the concurrency limiter.",
+	})
+)
+
+// admissionConfig is read from the environment:
+//
+//	MAX_CONCURRENT_REQUESTS   requests handled at once; unset or 0 disables the limit
+//	PRIORITY_LOW_SHARE        fraction of the limit low-priority requests may fill, default 0.5
+//	PRIORITY_NORMAL_SHARE     fraction of the limit normal requests may fill, default 0.8;
+//	                          high-priority requests may use all of it
+//	PRIORITY_PATHS            default tiers by path prefix, e.g. "/api/users/export=low";
+//	                          the longest matching prefix wins, otherwise normal
+//	PRIORITY_TRUSTED_NETWORKS CIDRs whose X-Priority header is honoured, e.g. internal
+//	                          callers; everyone else's is ignored
+type admissionConfig struct {
+	MaxConcurrent int
+	LowShare      float64
+	NormalShare   float64
+	Paths         map[string]priority
+	Trusted       []netip.Prefix
+}
+
+func admissionConfigFromEnv() (admissionConfig, error) {
+	cfg := admissionConfig{LowShare: 0.5, NormalShare: 0.8, Paths: map[string]priority{}}
+
+	if raw := os.Getenv("MAX_CONCURRENT_REQUESTS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid MAX_CONCURRENT_REQUESTS %q", raw)
+		}
+		cfg.MaxConcurrent = n
+	}
+	for _, s := range []struct {
+		env string
+		dst *float64
+	}{{"PRIORITY_LOW_SHARE", &cfg.LowShare}, {"PRIORITY_NORMAL_SHARE", &cfg.NormalShare}} {
+		if raw := os.Getenv(s.env); raw != "" {
+			share, err := strconv.ParseFloat(raw, 64)
+			if err != nil || share <= 0 || share > 1 {
+				return cfg, fmt.Errorf("invalid %s %q (want more than 0, up to 1)", s.env, raw)
+			}
+			*s.dst = share
+		}
+	}
+	if cfg.LowShare > cfg.NormalShare {
+		return cfg, fmt.Errorf("PRIORITY_LOW_SHARE (%g) must not exceed PRIORITY_NORMAL_SHARE (%g)", cfg.LowShare, cfg.NormalShare)
+	}
+
+	if raw := os.Getenv("PRIORITY_PATHS"); raw != "" {
+		for _, pair := range strings.Split(raw, ",") {
+			prefix, tier, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			p, valid := parsePriority(tier)
+			if !ok || !strings.HasPrefix(prefix, "/") || !valid {
+				return cfg, fmt.Errorf("invalid PRIORITY_PATHS entry %q (want /prefix=low|normal|high)", pair)
+			}
+			cfg.Paths[prefix] = p
+		}
+	}
+
+	if raw := os.Getenv("PRIORITY_TRUSTED_NETWORKS"); raw != "" {
+		for _, cidr := range strings.Split(raw, ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+			if err != nil {
+				return cfg, fmt.Errorf("invalid PRIORITY_TRUSTED_NETWORKS entry %q: %w", cidr, err)
+			}
+			cfg.Trusted = append(cfg.Trusted, prefix.Masked())
+		}
+	}
+	return cfg, nil
+}
+
+// admissionController caps how many requests the gateway handles at once. Each
+// tier may only fill part of the capacity, so as load rises low-priority requests
+// are shed first, then normal ones, while high-priority requests are still admitted.
+type admissionController struct {
+	cfg    admissionConfig
+	limits [priorityHigh + 1]int // in-flight requests at which each tier is shed
+
+	mu       sync.Mutex
+	inFlight int
+}
+
+func newAdmissionController(cfg admissionConfig) *admissionController {
+	if cfg.MaxConcurrent == 0 {
+		return nil
+	}
+	c := &admissionController{cfg: cfg}
+	c.limits[priorityLow] = max(int(float64(cfg.MaxConcurrent)*cfg.LowShare), 1)
+	c.limits[priorityNormal] = max(int(float64(cfg.MaxConcurrent)*cfg.NormalShare), 1)
+	c.limits[priorityHigh] = cfg.MaxConcurrent
+	return c
+}
+
+// acquire admits a request of tier p if there is room for it, reporting whether it did
+func (c *admissionController) acquire(p priority) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlight >= c.limits[p] {
+		return false
+	}
+	c.inFlight++
+	admissionInFlight.Set(float64(c.inFlight))
+	return true
+}
+
+func (c *admissionController) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	admissionInFlight.Set(float64(c.inFlight))
+}
+
+// classify picks the tier of a request: X-Priority from a trusted network,
+// otherwise the longest PRIORITY_PATHS prefix the path starts with, otherwise normal
+func (c *admissionController) classify(r *http.Request) priority {
+	if raw := r.Header.Get(priorityHeader); raw != "" && c.trusted(r) {
+		if p, ok := parsePriority(raw); ok {
+			return p
+		}
+	}
+
+	p, longest := priorityNormal, -1
+	for prefix, tier := range c.cfg.Paths {
+		if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > longest {
+			p, longest = tier, len(prefix)
+		}
+	}
+	return p
+}
+
+// trusted reports whether the request's client is in PRIORITY_TRUSTED_NETWORKS
+func (c *admissionController) trusted(r *http.Request) bool {
+	addr, err := netip.ParseAddr(httpx.ClientIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range c.cfg.Trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// admit answers 503 when the gateway is too busy for the request's tier. The
+// X-Priority header is removed before proxying, so backends never see a tier a
+// caller chose for itself.
+func (g *Gateway) admit(next http.HandlerFunc) http.HandlerFunc {
+	if g.admission == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := g.admission.classify(r)
+		r.Header.Del(priorityHeader)
+
+		// Event streams stay open for as long as the client is connected, so
+		// counting them would leave no room for anything else
+		if r.Header.Get("Accept") == "text/event-stream" {
+			next(w, r)
+			return
+		}
+
+		if !g.admission.acquire(p) {
+			admissionShed.WithLabelValues(p.String()).Inc()
+			w.Header().Set("Retry-After", "1")
+			httpx.ErrorCode(w, http.StatusServiceUnavailable, "overloaded", "The gateway is at capacity, please retry")
+			return
+		}
+		defer g.admission.release()
+		next(w, r)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"shared/logging"
+	"shared/svcclient"
+	"strconv"
+	"time"
+)
+
+// defaultAggregateTimeout is the aggregation budget when AGGREGATE_TIMEOUT is unset
+const defaultAggregateTimeout = 3 * time.Second
+
+// aggregateClient has no timeout of its own; each aggregation bounds its calls
+// with one deadline shared between them
+var aggregateClient = svcclient.New(0)
+
+// aggregateTimeoutFromEnv reads AGGREGATE_TIMEOUT, the total time an aggregation
+// may spend waiting on its upstreams
+func aggregateTimeoutFromEnv() (time.Duration, error) {
+	raw := os.Getenv("AGGREGATE_TIMEOUT")
+	if raw == "" {
+		return defaultAggregateTimeout, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid AGGREGATE_TIMEOUT %q", raw)
+	}
+	return d, nil
+}
+
+// productView is the response of GET /api/aggregate/products/{id}
+type productView struct {
+	Product  json.RawMessage `json:"product"`
+	User     json.RawMessage `json:"user,omitempty"`
+	Warnings []string        `json:"warnings"`
+}
+
+// upstreamError is a failed upstream call; status is the upstream's status, or 0 if it couldn't be reached
+type upstreamError struct {
+	service  string
+	status   int
+	timedOut bool // the aggregation's deadline passed before the upstream answered
+	err      error
+}
+
+func (e *upstreamError) Error() string {
+	switch {
+	case e.timedOut:
+		return fmt.Sprintf("%s timed out", e.service)
+	case e.status != 0:
+		return fmt.Sprintf("%s returned %d", e.service, e.status)
+	default:
+		return fmt.Sprintf("%s unreachable: %v", e.service, e.err)
+	}
+}
+
+// aggregateProduct returns a product together with the user given by ?user_id=.
+// The product is the primary resource: if it can't be fetched the request fails
+// with 502, 504 if it timed out (or the product service's 404). The user only
+// enriches the response, so failing to fetch it still answers 200, with the
+// problem listed in warnings. Both fetches share one AGGREGATE_TIMEOUT deadline,
+// so a slow upstream can't hold the response past the budget.
+func (g *Gateway) aggregateProduct(w http.ResponseWriter, r *http.Request) {
+	productID := r.PathValue("id")
+	if _, err := strconv.ParseInt(productID, 10, 32); err != nil {
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID != "" {
+		if _, err := strconv.ParseInt(userID, 10, 32); err != nil {
+			httpx.Error(w, http.StatusBadRequest, "user_id must be an integer")
+			return
+		}
+	}
+
+	if err := g.prepareUpstream(r); err != nil {
+		unauthenticated(w, err)
+		return
+	}
+	markImpersonation(w, r)
+	g.assignExperiments(w, r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), g.aggregateTimeout)
+	defer cancel()
+
+	// Fetch both in parallel; the user result is only waited for if it was requested
+	type result struct {
+		body json.RawMessage
+		err  error
+	}
+	userCh := make(chan result, 1)
+	if userID != "" {
+		go func() {
+			body, err := g.fetch(ctx, r, "users", "/users/"+userID)
+			userCh <- result{body, err}
+		}()
+	}
+
+	product, err := g.fetch(ctx, r, "products", "/products/"+productID)
+	if err != nil {
+		var upErr *upstreamError
+		if errors.As(err, &upErr) && upErr.status == http.StatusNotFound {
+			httpx.Error(w, http.StatusNotFound, "product not found")
+			return
+		}
+		if errors.As(err, &upErr) && upErr.timedOut {
+			log.Printf("[Aggregate] product %s timed out after %s", productID, g.aggregateTimeout)
+			httpx.ErrorCode(w, http.StatusGatewayTimeout, classTimeout, "product service timed out")
+			return
+		}
+		log.Printf("[Aggregate] product %s failed: %v", productID, err)
+		httpx.Error(w, http.StatusBadGateway, "could not fetch product")
+		return
+	}
+
+	view := productView{Product: product, Warnings: []string{}}
+	if userID != "" {
+		res := <-userCh
+		if res.err != nil {
+			log.Printf("[Aggregate] user %s enrichment failed: %v", userID, res.err)
+			view.Warnings = append(view.Warnings, fmt.Sprintf("user %s: %v", userID, res.err))
+		} else {
+			view.User = res.body
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(view)
+}
+
+// fetch GETs path from a backend service on behalf of r, forwarding its upstream
+// headers. ctx carries the aggregation's shared deadline.
+func (g *Gateway) fetch(ctx context.Context, r *http.Request, service, path string) (json.RawMessage, error) {
+	baseURL, ok := g.services.lookup(service)
+	if !ok || baseURL == "" {
+		return nil, &upstreamError{service: service, err: fmt.Errorf("not configured")}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil)
+	if err != nil {
+		return nil, &upstreamError{service: service, err: err}
+	}
+	for _, h := range upstreamHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	copyExperimentHeaders(req.Header, r.Header)
+	// The gateway makes this request itself, so it names the client directly
+	req.Header.Set("X-Forwarded-For", httpx.ClientIP(r))
+
+	// The service client passes the remaining budget on as X-Request-Deadline
+	resp, err := aggregateClient.Do(req)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, &upstreamError{service: service, timedOut: true, err: err}
+	}
+	if err != nil {
+		g.logs.Printf(logging.Key{Message: "aggregate fetch failed", Service: service, Class: logging.ErrorClass(err)},
+			"[Aggregate] GET %s%s failed: %v", baseURL, path, err)
+		return nil, &upstreamError{service: service, err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstreamError{service: service, status: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, httpx.MaxBodyBytes))
+	if err != nil {
+		return nil, &upstreamError{service: service, timedOut: errors.Is(ctx.Err(), context.DeadlineExceeded), err: err}
+	}
+	if !json.Valid(body) {
+		return nil, &upstreamError{service: service, err: fmt.Errorf("invalid JSON response")}
+	}
+	return body, nil
+}
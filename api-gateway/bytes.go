@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// bytesTotal counts body bytes through the gateway. Only bodies are counted, not
+// headers, and a request's bytes are added once it has finished.
+var bytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_body_bytes_total",
+	Help: "Request and response body bytes through the gateway, by service and direction (request or response).",
+}, []string{"service", "direction"})
+
+// countingReader counts the bytes read from a request body as they pass through,
+// so bodies are still streamed to the backend rather than buffered
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64 // read by the handler while the proxy's copy goroutine may still be reading
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// countingWriter counts the response body bytes written through it
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so event
+// streams are still flushed as they arrive
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// countBytes wraps the body of r and w for byte accounting. The returned func
+// adds the counts to bytesTotal and logs them; call it when the request is done.
+func countBytes(w http.ResponseWriter, r *http.Request, service string) (http.ResponseWriter, func()) {
+	body := &countingReader{ReadCloser: r.Body}
+	r.Body = body
+	out := &countingWriter{ResponseWriter: w}
+	method, path := r.Method, r.URL.Path // before the path is rewritten for the backend
+	return out, func() {
+		in := body.n.Load()
+		bytesTotal.WithLabelValues(service, "request").Add(float64(in))
+		bytesTotal.WithLabelValues(service, "response").Add(float64(out.n))
+		log.Printf("[Route] Done %s %s (%s): %d bytes in, %d bytes out", method, path, service, in, out.n)
+	}
+}
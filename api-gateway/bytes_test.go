@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// counterValue reads the current value of a counter
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestBodyBytesCountedPerService(t *testing.T) {
+	// The backend echoes the body twice, so the response is twice the request
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+		w.Write(body)
+	}))
+	defer backend.Close()
+	g := newTestGateway(map[string]string{"products": backend.URL})
+
+	in := bytesTotal.WithLabelValues("products", "request")
+	out := bytesTotal.WithLabelValues("products", "response")
+	inBefore, outBefore := counterValue(t, in), counterValue(t, out)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/products", strings.NewReader(strings.Repeat("x", 1000)))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	g.routeRequest(w, r)
+
+	if w.Body.Len() != 2000 {
+		t.Fatalf("response has %d bytes, want 2000", w.Body.Len())
+	}
+	if got := counterValue(t, in) - inBefore; got != 1000 {
+		t.Errorf("request bytes = %v, want 1000", got)
+	}
+	if got := counterValue(t, out) - outBefore; got != 2000 {
+		t.Errorf("response bytes = %v, want 2000", got)
+	}
+}
+
+func TestCountingWriterKeepsStreaming(t *testing.T) {
+	// The second event is only sent once the client has the first, which needs
+	// each one flushed through the counting writer as it is written
+	gotFirst := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-gotFirst:
+		case <-time.After(5 * time.Second):
+		}
+		io.WriteString(w, "data: 2\n\n")
+	}))
+	defer backend.Close()
+	g := newTestGateway(map[string]string{"products": backend.URL})
+	frontend := httptest.NewServer(http.HandlerFunc(g.routeRequest))
+	defer frontend.Close()
+
+	first := make(chan string, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, frontend.URL+"/api/products/stream", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			first <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		first <- line
+	}()
+	select {
+	case line := <-first:
+		if line != "data: 1\n" {
+			t.Errorf("first line = %q, want data: 1", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("first event was held back until the stream ended")
+	}
+	close(gotFirst)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"shared/logging"
+	"shared/redis"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxCachedBody is the largest response body the gateway will cache
+const maxCachedBody = 1 << 20
+
+var cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_cache_lookups_total",
+	Help: "Response cache lookups, by backend and result (hit, miss or error).",
+}, []string{"backend", "result"})
+
+// cachedResponse is a stored upstream response
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// responseCache stores upstream responses by key
+type responseCache interface {
+	// Get returns the response stored under key, or nil if there is none
+	Get(ctx context.Context, key string) (*cachedResponse, error)
+	Set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration) error
+	// Name identifies the backend in logs and metrics
+	Name() string
+}
+
+// cacheConfig is read from the environment:
+//
+//	CACHE_TTL      how long GET responses are cached; unset or 0 disables caching.
+//	               A shorter max-age from the backend wins.
+//	CACHE_BACKEND  memory (default, per replica) or redis (shared by all replicas)
+//	REDIS_URL      required for the redis backend
+type cacheConfig struct {
+	TTL      time.Duration
+	Backend  string
+	RedisURL string
+}
+
+func cacheConfigFromEnv() (cacheConfig, error) {
+	cfg := cacheConfig{Backend: os.Getenv("CACHE_BACKEND"), RedisURL: os.Getenv("REDIS_URL")}
+
+	if raw := os.Getenv("CACHE_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl < 0 {
+			return cfg, fmt.Errorf("invalid CACHE_TTL %q", raw)
+		}
+		cfg.TTL = ttl
+	}
+
+	switch cfg.Backend {
+	case "":
+		cfg.Backend = "memory"
+	case "memory", "redis":
+	default:
+		return cfg, fmt.Errorf("invalid CACHE_BACKEND %q (want memory or redis)", cfg.Backend)
+	}
+	return cfg, nil
+}
+
+// newResponseCache builds the configured cache, or returns nil if caching is off.
+// The redis backend falls back to memory when REDIS_URL is unset.
+func newResponseCache(cfg cacheConfig) (responseCache, error) {
+	if cfg.TTL == 0 {
+		return nil, nil
+	}
+	if cfg.Backend == "redis" {
+		if cfg.RedisURL == "" {
+			log.Printf("WARNING: CACHE_BACKEND=redis but REDIS_URL is unset; responses are cached per replica")
+		} else {
+			client, err := redis.New(cfg.RedisURL)
+			if err != nil {
+				return nil, err
+			}
+			return &redisCache{client: client}, nil
+		}
+	}
+	return newMemoryCache(), nil
+}
+
+// cacheKey identifies a response. Besides the URL it covers everything the
+// gateway forwards that can change the response (tenant, identity, public host,
+// language), so one client is never served another's data.
+func cacheKey(r *http.Request) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.EscapedPath(), r.URL.RawQuery)
+	for _, name := range append(upstreamHeaders, "Accept", "Accept-Encoding", "Accept-Language") {
+		fmt.Fprintf(h, "%s: %s\n", name, r.Header.Get(name))
+	}
+	// Variants may be served different responses
+	for _, name := range slices.Sorted(maps.Keys(r.Header)) {
+		if strings.HasPrefix(name, experimentHeaderPrefix) {
+			fmt.Fprintf(h, "%s: %s\n", name, r.Header.Get(name))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isCacheable reports whether a request may be answered from, or stored in, the cache
+func isCacheable(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Accept") != "text/event-stream" &&
+		!strings.Contains(r.Header.Get("Cache-Control"), "no-cache")
+}
+
+// cacheTTL is how long a response may be stored, or 0 if it must not be. Only
+// complete 200s are cached, and the backend's Cache-Control is respected.
+func cacheTTL(status int, header http.Header, fallback time.Duration) time.Duration {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" || header.Get("Vary") == "*" {
+		return 0
+	}
+	ttl := fallback
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store", "no-cache", "private":
+			return 0
+		case "max-age", "s-maxage":
+			if secs, err := strconv.Atoi(value); err == nil {
+				ttl = min(ttl, time.Duration(secs)*time.Second)
+			}
+		}
+	}
+	return max(ttl, 0)
+}
+
+// serveCached answers r from the cache if it can, reporting whether it did
+func (g *Gateway) serveCached(w http.ResponseWriter, r *http.Request, key string) bool {
+	resp, err := g.cache.Get(r.Context(), key)
+	switch {
+	case err != nil:
+		cacheLookups.WithLabelValues(g.cache.Name(), "error").Inc()
+		g.logs.Printf(logging.Key{Message: "cache error", Service: g.cache.Name(), Class: logging.ErrorClass(err)},
+			"[Cache] %s lookup failed, proxying instead: %v", g.cache.Name(), err)
+		return false
+	case resp == nil:
+		cacheLookups.WithLabelValues(g.cache.Name(), "miss").Inc()
+		return false
+	}
+
+	cacheLookups.WithLabelValues(g.cache.Name(), "hit").Inc()
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+	return true
+}
+
+// store saves a captured response if it is cacheable
+func (g *Gateway) store(ctx context.Context, key string, c *captureWriter) {
+	ttl := cacheTTL(c.status, c.header, g.cacheTTL)
+	if ttl == 0 || c.overflow {
+		return
+	}
+	resp := &cachedResponse{Status: c.status, Header: c.header, Body: c.body.Bytes()}
+	if err := g.cache.Set(context.WithoutCancel(ctx), key, resp, ttl); err != nil {
+		g.logs.Printf(logging.Key{Message: "cache error", Service: g.cache.Name(), Class: logging.ErrorClass(err)},
+			"[Cache] %s store failed: %v", g.cache.Name(), err)
+	}
+}
+
+// captureWriter passes a response through while keeping a copy for the cache
+type captureWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header // snapshot taken when the status is written
+	body     bytes.Buffer
+	overflow bool // the body outgrew maxCachedBody, so it won't be stored
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+		c.header = c.Header().Clone()
+		c.Header().Set("X-Cache", "MISS")
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflow {
+		if c.body.Len()+len(b) > maxCachedBody {
+			c.overflow = true
+			c.body = bytes.Buffer{}
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (c *captureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// memoryCache keeps responses in this process, so each replica caches separately
+type memoryCache struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	resp    *cachedResponse
+	expires time.Time
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{now: time.Now, entries: map[string]memoryEntry{}}
+}
+
+func (c *memoryCache) Name() string { return "memory" }
+
+func (c *memoryCache) Get(_ context.Context, key string) (*cachedResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		return nil, nil
+	}
+	return entry.resp, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, resp *cachedResponse, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	// Drop expired entries at most once a minute
+	if now.Sub(c.lastSweep) >= time.Minute {
+		c.lastSweep = now
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = memoryEntry{resp: resp, expires: now.Add(ttl)}
+	return nil
+}
+
+// redisCache keeps responses in Redis as JSON, so every gateway replica shares them
+type redisCache struct {
+	client *redis.Client
+}
+
+func (c *redisCache) Name() string { return "redis" }
+
+func (c *redisCache) Get(ctx context.Context, key string) (*cachedResponse, error) {
+	raw, err := c.client.Bytes(ctx, "GET", "cache:"+key)
+	if errors.Is(err, redis.ErrNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var resp cachedResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("could not decode cached response: %w", err)
+	}
+	return &resp, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration) error {
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("could not encode cached response: %w", err)
+	}
+	_, err = c.client.Do(ctx, "SET", "cache:"+key, string(raw), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	return err
+}
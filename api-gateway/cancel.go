@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	clientCancels = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_client_cancels_total",
+		Help: "Proxied requests abandoned by the client before the upstream response was complete, by service and route.",
+	}, []string{"service", "route"})
+	wastedUpstream = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_client_cancel_wasted_upstream_milliseconds",
+		Help:    "How long the upstream had been working on a request when its client abandoned it.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 12), // 5ms to ~10s
+	}, []string{"service"})
+)
+
+// debugFromEnv reports whether LOG_LEVEL asks for debug lines, which are otherwise dropped
+func debugFromEnv() bool {
+	return strings.EqualFold(os.Getenv("LOG_LEVEL"), "debug")
+}
+
+func (g *Gateway) debugf(format string, args ...any) {
+	if g.debug {
+		log.Printf("[Debug] "+format, args...)
+	}
+}
+
+// observeCancel records a proxied request whose client went away while it was in
+// flight. clientCtx is the request context before the upstream deadline was
+// applied, so only the client disconnecting cancels it, and the cancellation
+// reaches the upstream through the proxied request's context.
+//
+// Event streams are skipped: a client closing one is how a stream normally ends.
+func (g *Gateway) observeCancel(clientCtx context.Context, r *http.Request, service, path string, start time.Time) {
+	if clientCtx.Err() != context.Canceled || r.Header.Get("Accept") == "text/event-stream" {
+		return
+	}
+	elapsed := time.Since(start)
+	route := routeLabel(path)
+	clientCancels.WithLabelValues(service, route).Inc()
+	wastedUpstream.WithLabelValues(service).Observe(float64(elapsed.Milliseconds()))
+	g.debugf("Client canceled %s %s (%s) after %s upstream", r.Method, path, service, elapsed.Round(time.Millisecond))
+}
+
+// routeLabel turns a path into a metric label with few distinct values: segments
+// containing a digit (IDs) become {id}, and only the first three segments are kept
+func routeLabel(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) > 3 {
+		segments = append(segments[:3], "...")
+	}
+	for i, s := range segments {
+		if strings.ContainsAny(s, "0123456789") {
+			segments[i] = "{id}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// cancelStats returns the cancel count for service and route and the number and
+// sum of wasted-milliseconds observations for service
+func cancelStats(t *testing.T, service, route string) (cancels float64, wasted uint64, wastedMs float64) {
+	t.Helper()
+	var histogram dto.Metric
+	if err := wastedUpstream.WithLabelValues(service).(prometheus.Metric).Write(&histogram); err != nil {
+		t.Fatal(err)
+	}
+	cancels = counterValue(t, clientCancels.WithLabelValues(service, route))
+	return cancels, histogram.GetHistogram().GetSampleCount(), histogram.GetHistogram().GetSampleSum()
+}
+
+// abandonedRequest sends a request for target through g and cancels it once the
+// backend has been working on it for hold, returning whether the backend saw its
+// own context canceled
+func abandonedRequest(t *testing.T, target string, header http.Header, hold time.Duration) bool {
+	t.Helper()
+	arrived := make(chan struct{})
+	backendCanceled := make(chan bool, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		select {
+		case <-r.Context().Done():
+			backendCanceled <- true
+		case <-time.After(5 * time.Second):
+			backendCanceled <- false
+		}
+	}))
+	defer backend.Close()
+	g := newTestGateway(map[string]string{"users": backend.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.routeRequest(httptest.NewRecorder(), r)
+	}()
+
+	<-arrived
+	time.Sleep(hold)
+	cancel()
+	canceled := <-backendCanceled
+	<-done
+	return canceled
+}
+
+func TestClientCancelReachesBackendAndIsCounted(t *testing.T) {
+	cancels, wasted, wastedMs := cancelStats(t, "users", "/users/{id}")
+
+	if !abandonedRequest(t, "/api/users/42", nil, 50*time.Millisecond) {
+		t.Fatal("backend's context was not canceled when the client went away")
+	}
+
+	gotCancels, gotWasted, gotWastedMs := cancelStats(t, "users", "/users/{id}")
+	if gotCancels != cancels+1 {
+		t.Errorf("cancels = %v, want %v", gotCancels, cancels+1)
+	}
+	if gotWasted != wasted+1 {
+		t.Errorf("wasted observations = %d, want %d", gotWasted, wasted+1)
+	}
+	if ms := gotWastedMs - wastedMs; ms < 50 || ms > 5000 {
+		t.Errorf("wasted = %vms, want at least the 50ms the backend worked", ms)
+	}
+}
+
+func TestClosedEventStreamIsNotCounted(t *testing.T) {
+	cancels, wasted, _ := cancelStats(t, "users", "/users/stream")
+
+	header := http.Header{"Accept": {"text/event-stream"}}
+	if !abandonedRequest(t, "/api/users/stream", header, 0) {
+		t.Fatal("backend's context was not canceled when the client went away")
+	}
+
+	if gotCancels, gotWasted, _ := cancelStats(t, "users", "/users/stream"); gotCancels != cancels || gotWasted != wasted {
+		t.Errorf("got %v cancels and %d observations, want the stream's close ignored", gotCancels-cancels, gotWasted-wasted)
+	}
+}
+
+func TestCompletedRequestIsNotCounted(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	g := newTestGateway(map[string]string{"users": backend.URL})
+	cancels, _, _ := cancelStats(t, "users", "/users/{id}")
+
+	g.routeRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/users/7", nil))
+
+	if got, _, _ := cancelStats(t, "users", "/users/{id}"); got != cancels {
+		t.Errorf("cancels = %v, want %v", got, cancels)
+	}
+}
+
+func TestRouteLabel(t *testing.T) {
+	tests := map[string]string{
+		"/users":                   "/users",
+		"/users/42":                "/users/{id}",
+		"/users/42/":               "/users/{id}",
+		"/products/sku-9/reviews":  "/products/{id}/reviews",
+		"/users/export":            "/users/export",
+		"/products/1/reviews/2/up": "/products/{id}/reviews/...",
+	}
+	for path, want := range tests {
+		if got := routeLabel(path); got != want {
+			t.Errorf("routeLabel(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"shared/admin"
+	"shared/httpx"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configDocumentVersion is the version of the document /admin/config/export
+// writes; /admin/config/import refuses any other
+const configDocumentVersion = 1
+
+// adminUserHeader names the operator changing the configuration. The admin token
+// is shared, so the name is taken on trust and only recorded.
+const adminUserHeader = "X-Admin-User"
+
+// redacted stands in for a secret in an exported document
+const redacted = "[redacted]"
+
+// runtimeConfig is the configuration that can be replaced while the gateway runs.
+// Once applied it is never modified, so readers may keep the maps they are given.
+type runtimeConfig struct {
+	Services      map[string]string `json:"services"`     // service name -> backend URL
+	Fallbacks     map[string]string `json:"fallbacks"`    // service name -> read-only fallback backend URL
+	TenantHosts   map[string]string `json:"tenant_hosts"` // lowercase hostname -> tenant ID
+	DefaultTenant string            `json:"default_tenant"`
+	RateLimits    rateLimitQuotas   `json:"rate_limits"`
+	Experiments   []experiment      `json:"experiments"`
+}
+
+// startupConfig is the configuration read once at startup. It is exported for
+// reference only: changing it takes a restart, so an import must leave it as is.
+type startupConfig struct {
+	UpstreamTimeout  string `json:"upstream_timeout"`
+	AggregateTimeout string `json:"aggregate_timeout"`
+	CacheTTL         string `json:"cache_ttl"`
+	TrailingSlash    string `json:"trailing_slash"`
+	MaxConcurrent    int    `json:"max_concurrent_requests"`
+	TrustedProxies   string `json:"trusted_proxies"`
+	TLSMinVersion    string `json:"tls_min_version"`    // empty when TLS is off
+	RateLimitBackend string `json:"rate_limit_backend"` // empty when rate limiting was off at startup
+	DevPrincipal     string `json:"dev_principal"`
+	JWTSigningKey    string `json:"jwt_signing_key"`
+	AdminToken       string `json:"admin_token"`
+}
+
+// configDocument is written by /admin/config/export and read by /admin/config/import.
+// Startup may be left out of an import.
+type configDocument struct {
+	Version int            `json:"version"`
+	Runtime runtimeConfig  `json:"runtime"`
+	Startup *startupConfig `json:"startup,omitempty"`
+}
+
+// configSnapshot is a runtime configuration as it was applied, for /admin/config/history
+type configSnapshot struct {
+	ID        int           `json:"id"`
+	AppliedAt time.Time     `json:"applied_at"`
+	AppliedBy string        `json:"applied_by"`          // X-Admin-User, or "environment" at startup
+	ClientIP  string        `json:"client_ip,omitempty"` // where the change came from
+	Source    string        `json:"source"`              // startup, import or "rollback to <id>"
+	Config    runtimeConfig `json:"config"`
+}
+
+// configStore holds the runtime configuration and the last few applied. The
+// service URLs are also kept in Gateway.services, which the proxy reads on every
+// request; applyConfig replaces both under the store's lock.
+type configStore struct {
+	size int
+
+	mu        sync.RWMutex
+	current   runtimeConfig
+	fallbacks map[string]*url.URL // current.Fallbacks, parsed
+	history   []configSnapshot    // oldest first
+	nextID    int
+}
+
+// configHistorySizeFromEnv reads CONFIG_HISTORY_SIZE, the number of applied
+// configurations kept for /admin/config/history and rollback; default 10
+func configHistorySizeFromEnv() (int, error) {
+	raw := os.Getenv("CONFIG_HISTORY_SIZE")
+	if raw == "" {
+		return 10, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid CONFIG_HISTORY_SIZE %q", raw)
+	}
+	return n, nil
+}
+
+func newConfigStore(size int) *configStore {
+	return &configStore{size: size}
+}
+
+// tenantForHost resolves the tenant that owns a hostname, reporting whether it
+// was mapped or is the default
+func (s *configStore) tenantForHost(host string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if tenantID, ok := s.current.TenantHosts[normalizeHost(host)]; ok {
+		return tenantID, true
+	}
+	return s.current.DefaultTenant, false
+}
+
+// fallback returns the fallback backend of a service, or nil
+func (s *configStore) fallback(service string) *url.URL {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fallbacks[service]
+}
+
+// rateLimits returns the current quotas
+func (s *configStore) rateLimits() rateLimitQuotas {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current.RateLimits
+}
+
+// experiments returns the current experiments
+func (s *configStore) experiments() []experiment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current.Experiments
+}
+
+// runtime returns the current runtime configuration
+func (s *configStore) runtime() runtimeConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// snapshots returns the applied configurations held, newest first
+func (s *configStore) snapshots() []configSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := slices.Clone(s.history)
+	slices.Reverse(out)
+	return out
+}
+
+// snapshot returns the applied configuration with the given ID, if it is still held
+func (s *configStore) snapshot(id int) (configSnapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, snap := range s.history {
+		if snap.ID == id {
+			return snap, true
+		}
+	}
+	return configSnapshot{}, false
+}
+
+// applyConfig replaces the runtime configuration with cfg, which must have been
+// validated, and records it in the history. Every setting changes under one lock,
+// so a concurrent import or rollback never leaves a mix of the two behind.
+func (g *Gateway) applyConfig(cfg runtimeConfig, source, appliedBy, clientIP string) configSnapshot {
+	g.config.mu.Lock()
+	defer g.config.mu.Unlock()
+	return g.applyConfigLocked(cfg, source, appliedBy, clientIP)
+}
+
+// updateConfig applies the current runtime configuration as changed by change,
+// under the same lock, so a concurrent import is never undone by it. change gets
+// a copy it may modify, except for the maps and slices shared with the current
+// configuration, which it must replace instead. Nothing is applied if change
+// returns false.
+func (g *Gateway) updateConfig(change func(*runtimeConfig) bool, source, appliedBy, clientIP string) (configSnapshot, bool) {
+	g.config.mu.Lock()
+	defer g.config.mu.Unlock()
+
+	cfg := g.config.current
+	if !change(&cfg) {
+		return configSnapshot{}, false
+	}
+	return g.applyConfigLocked(cfg, source, appliedBy, clientIP), true
+}
+
+func (g *Gateway) applyConfigLocked(cfg runtimeConfig, source, appliedBy, clientIP string) configSnapshot {
+	fallbacks := make(map[string]*url.URL, len(cfg.Fallbacks))
+	for service, raw := range cfg.Fallbacks {
+		fallbacks[service], _ = url.Parse(raw)
+	}
+
+	s := g.config
+	g.services.replace(maps.Clone(cfg.Services))
+	s.current = cfg
+	s.fallbacks = fallbacks
+
+	s.nextID++
+	snap := configSnapshot{
+		ID:        s.nextID,
+		AppliedAt: time.Now().UTC(),
+		AppliedBy: appliedBy,
+		ClientIP:  clientIP,
+		Source:    source,
+		Config:    cfg,
+	}
+	s.history = append(s.history, snap)
+	if len(s.history) > s.size {
+		s.history = s.history[len(s.history)-s.size:]
+	}
+	return snap
+}
+
+// startupConfig describes the configuration read at startup, with secrets redacted
+func (g *Gateway) startupConfig() startupConfig {
+	cfg := startupConfig{
+		UpstreamTimeout:  g.upstreamTimeout.String(),
+		AggregateTimeout: g.aggregateTimeout.String(),
+		CacheTTL:         g.cacheTTL.String(),
+		TrailingSlash:    string(g.slashPolicy),
+		DevPrincipal:     g.devPrincipal,
+	}
+	if g.admission != nil {
+		cfg.MaxConcurrent = g.admission.cfg.MaxConcurrent
+	}
+	proxies := make([]string, len(g.trustedProxies))
+	for i, prefix := range g.trustedProxies {
+		proxies[i] = prefix.String()
+	}
+	cfg.TrustedProxies = strings.Join(proxies, ",")
+	if g.tls != nil {
+		cfg.TLSMinVersion = tls.VersionName(g.tls.MinVersion)
+	}
+	if g.limiter != nil {
+		cfg.RateLimitBackend = g.limiter.Name()
+	}
+	if g.jwtKey != nil {
+		cfg.JWTSigningKey = redacted
+	}
+	if admin.TokenFromEnv() != "" {
+		cfg.AdminToken = redacted
+	}
+	return cfg
+}
+
+// validateConfig checks an imported document against what this gateway can
+// apply. Nothing is changed; the problems found are returned, none if it is valid.
+func (g *Gateway) validateConfig(doc configDocument) []httpx.FieldError {
+	var v httpx.Validation
+	if doc.Version != configDocumentVersion {
+		v.Add("version", fmt.Sprintf("must be %d", configDocumentVersion))
+	}
+
+	cfg := doc.Runtime
+	if len(cfg.Services) == 0 {
+		v.Add("runtime.services", "must name at least one service")
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+		field := "runtime.services." + name
+		if name == "" || strings.Contains(name, "/") {
+			v.Add(field, "service names must be a single path segment")
+		} else if !isBackendURL(cfg.Services[name]) {
+			v.Add(field, "must be an http(s) URL")
+		}
+	}
+	for _, service := range slices.Sorted(maps.Keys(cfg.Fallbacks)) {
+		field := "runtime.fallbacks." + service
+		if _, ok := cfg.Services[service]; !ok {
+			v.Add(field, "is not a configured service")
+		} else if !isBackendURL(cfg.Fallbacks[service]) {
+			v.Add(field, "must be an http(s) URL")
+		}
+	}
+	for _, host := range slices.Sorted(maps.Keys(cfg.TenantHosts)) {
+		field := "runtime.tenant_hosts." + host
+		if host == "" || host != normalizeHost(host) {
+			v.Add(field, "hostnames must be lowercase and without a port")
+		} else if cfg.TenantHosts[host] == "" {
+			v.Add(field, "must not be empty")
+		}
+	}
+	v.Required("runtime.default_tenant", &cfg.DefaultTenant)
+
+	validateQuota(&v, "runtime.rate_limits.ip", cfg.RateLimits.IP)
+	validateQuota(&v, "runtime.rate_limits.user", cfg.RateLimits.User)
+	for _, key := range slices.Sorted(maps.Keys(cfg.RateLimits.Overrides)) {
+		field := "runtime.rate_limits.overrides." + key
+		kind, id, _ := strings.Cut(key, ":")
+		if id == "" || (kind != principalUser && kind != principalIP) {
+			v.Add(field, "keys must be user:<id> or ip:<address>")
+			continue
+		}
+		validateQuota(&v, field, cfg.RateLimits.Overrides[key])
+	}
+	if g.limiter == nil && cfg.RateLimits.enabled() {
+		v.Add("runtime.rate_limits", "rate limiting was off at startup; set RATE_LIMIT_RPS and restart to turn it on")
+	}
+	validateExperiments(&v, "runtime.experiments", cfg.Experiments)
+
+	if doc.Startup != nil {
+		want, have := reflect.ValueOf(*doc.Startup), reflect.ValueOf(g.startupConfig())
+		for i := range want.NumField() {
+			if want.Field(i).Interface() != have.Field(i).Interface() {
+				name, _, _ := strings.Cut(want.Type().Field(i).Tag.Get("json"), ",")
+				v.Add("startup."+name, "can only be changed with a restart")
+			}
+		}
+	}
+	return v.Errors()
+}
+
+func validateQuota(v *httpx.Validation, field string, q quota) {
+	if q.Rate < 0 {
+		v.Add(field+".rate", "must not be negative")
+	}
+	if q.Rate > 0 && q.Burst < 1 {
+		v.Add(field+".burst", "must be at least 1")
+	}
+}
+
+// isBackendURL reports whether raw is an absolute http(s) URL
+func isBackendURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// appliedBy names who is making an admin change, for the config history
+func appliedBy(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get(adminUserHeader)); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// exportConfig serves GET /admin/config/export: the effective configuration as a
+// document /admin/config/import accepts, on this gateway or another
+func (g *Gateway) exportConfig(w http.ResponseWriter, r *http.Request) {
+	startup := g.startupConfig()
+	httpx.WriteJSON(w, r, http.StatusOK, configDocument{
+		Version: configDocumentVersion,
+		Runtime: g.config.runtime(),
+		Startup: &startup,
+	})
+}
+
+// importConfig serves POST /admin/config/import. The document is validated as a
+// whole first: if anything is wrong nothing changes and 422 lists the problems.
+func (g *Gateway) importConfig(w http.ResponseWriter, r *http.Request) {
+	var doc configDocument
+	if err := httpx.DecodeJSON(w, r, &doc); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+	if problems := g.validateConfig(doc); len(problems) > 0 {
+		httpx.ValidationFailed(w, problems)
+		return
+	}
+
+	snap := g.applyConfig(doc.Runtime, "import", appliedBy(r), httpx.ClientIP(r))
+	log.Printf("[Config] Imported configuration %d, applied by %s from %s", snap.ID, snap.AppliedBy, snap.ClientIP)
+	httpx.WriteJSON(w, r, http.StatusOK, snap)
+}
+
+// configHistory serves GET /admin/config/history, newest first
+func (g *Gateway) configHistory(w http.ResponseWriter, r *http.Request) {
+	httpx.WriteJSON(w, r, http.StatusOK, map[string]any{"snapshots": g.config.snapshots()})
+}
+
+// rollbackConfig serves POST /admin/config/rollback/{n}, applying snapshot n from
+// the history again. The rollback is itself recorded as a new snapshot.
+func (g *Gateway) rollbackConfig(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "snapshot must be a number")
+		return
+	}
+	target, ok := g.config.snapshot(id)
+	if !ok {
+		httpx.Error(w, http.StatusNotFound, fmt.Sprintf("no snapshot %d; only the last %d are kept", id, g.config.size))
+		return
+	}
+
+	snap := g.applyConfig(target.Config, fmt.Sprintf("rollback to %d", id), appliedBy(r), httpx.ClientIP(r))
+	log.Printf("[Config] Rolled back to configuration %d as %d, applied by %s from %s", id, snap.ID, snap.AppliedBy, snap.ClientIP)
+	httpx.WriteJSON(w, r, http.StatusOK, snap)
+}
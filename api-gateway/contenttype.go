@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// defaultedContentTypes counts proxied responses that arrived without a Content-Type
+var defaultedContentTypes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_default_content_type_total",
+	Help: "Proxied responses given the default Content-Type because the backend sent none, by service.",
+}, []string{"service"})
+
+// defaultContentTypes is what a proxied response is labelled with when its
+// backend sends a body without a Content-Type, instead of leaving clients to sniff it
+type defaultContentTypes struct {
+	Fallback string            // DEFAULT_CONTENT_TYPE; for services without their own
+	Services map[string]string // DEFAULT_CONTENT_TYPES; by service
+}
+
+// defaultContentTypesFromEnv reads the defaults:
+//
+//	DEFAULT_CONTENT_TYPE   default application/octet-stream
+//	DEFAULT_CONTENT_TYPES  comma-separated service=type overrides, e.g. users=application/json
+func defaultContentTypesFromEnv(services []string) (defaultContentTypes, error) {
+	d := defaultContentTypes{Fallback: "application/octet-stream", Services: map[string]string{}}
+	if raw := os.Getenv("DEFAULT_CONTENT_TYPE"); raw != "" {
+		if !isMediaType(raw) {
+			return d, fmt.Errorf("invalid DEFAULT_CONTENT_TYPE %q", raw)
+		}
+		d.Fallback = raw
+	}
+	for _, entry := range strings.Split(os.Getenv("DEFAULT_CONTENT_TYPES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		service, contentType, ok := strings.Cut(entry, "=")
+		if !ok || !slices.Contains(services, service) || !isMediaType(contentType) {
+			return d, fmt.Errorf("invalid DEFAULT_CONTENT_TYPES entry %q (want service=type, service one of %s)",
+				entry, strings.Join(services, ", "))
+		}
+		d.Services[service] = contentType
+	}
+	return d, nil
+}
+
+// isMediaType reports whether raw is a valid Content-Type value
+func isMediaType(raw string) bool {
+	_, _, err := mime.ParseMediaType(raw)
+	return err == nil
+}
+
+// forService returns the default Content-Type of a service
+func (d defaultContentTypes) forService(service string) string {
+	if contentType, ok := d.Services[service]; ok {
+		return contentType
+	}
+	return d.Fallback
+}
+
+// String describes the defaults for the startup log
+func (d defaultContentTypes) String() string {
+	parts := []string{d.Fallback}
+	for _, service := range slices.Sorted(maps.Keys(d.Services)) {
+		parts = append(parts, fmt.Sprintf("%s for %s", d.Services[service], service))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// apply sets the default Content-Type on a response from service that has a
+// body but no Content-Type. Responses that can't carry a body are left alone.
+func (d defaultContentTypes) apply(service string, resp *http.Response) {
+	if _, ok := resp.Header["Content-Type"]; ok || resp.ContentLength == 0 {
+		return
+	}
+	switch {
+	case resp.StatusCode < 200, resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return
+	}
+	resp.Header.Set("Content-Type", d.forService(service))
+	defaultedContentTypes.WithLabelValues(service).Inc()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsRequest sends a request through corsMiddleware(maxAge) and reports the
+// response and whether it reached the next handler
+func corsRequest(maxAge int, method string, header http.Header) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	handler := corsMiddleware(maxAge)(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})
+	r := httptest.NewRequest(method, "/api/users", nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w, reached
+}
+
+var preflight = http.Header{
+	"Origin":                        {"https://shop.example.com"},
+	"Access-Control-Request-Method": {"POST"},
+}
+
+func TestPreflightCarriesMaxAge(t *testing.T) {
+	for maxAge, want := range map[int]string{600: "600", 86400: "86400", 0: ""} {
+		w, reached := corsRequest(maxAge, http.MethodOptions, preflight)
+		if reached || w.Code != http.StatusNoContent {
+			t.Errorf("max age %d: got %d (reached backend: %v), want 204 from the gateway", maxAge, w.Code, reached)
+		}
+		if got := w.Header().Get("Access-Control-Max-Age"); got != want {
+			t.Errorf("max age %d: Access-Control-Max-Age = %q, want %q", maxAge, got, want)
+		}
+	}
+}
+
+func TestMaxAgeOnlyOnPreflight(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+	}{
+		{name: "GET", method: http.MethodGet, header: http.Header{"Origin": {"https://shop.example.com"}}},
+		// Capability discovery goes to the backend for its Allow header
+		{name: "OPTIONS without preflight headers", method: http.MethodOptions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, reached := corsRequest(600, tt.method, tt.header)
+			if !reached {
+				t.Error("request didn't reach the backend")
+			}
+			if got := w.Header().Get("Access-Control-Max-Age"); got != "" {
+				t.Errorf("Access-Control-Max-Age = %q, want none", got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+				t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+			}
+		})
+	}
+}
+
+func TestCORSMaxAgeFromEnv(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{env: "", want: 600},
+		{env: "0", want: 0},
+		{env: "7200", want: 7200},
+		{env: "-1", wantErr: true},
+		{env: "10m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("CORS_MAX_AGE", tt.env)
+		got, err := corsMaxAgeFromEnv()
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("CORS_MAX_AGE=%q: got %d, %v; want %d, error %v", tt.env, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
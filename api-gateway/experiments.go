@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"shared/auth"
+	"shared/httpx"
+	"shared/ids"
+	"shared/tenant"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// experimentHeaderPrefix starts the header that tells backends a request's
+// variant of an experiment, e.g. X-Experiment-Checkout: one-page
+const experimentHeaderPrefix = "X-Experiment-"
+
+// experimentCookie holds the random ID that anonymous clients are bucketed by
+const experimentCookie = "gw_experiment_id"
+
+// experimentCookieMaxAge keeps an anonymous client in the same variants for a year
+const experimentCookieMaxAge = 365 * 24 * time.Hour
+
+// experimentBuckets is how finely traffic is split: percentages have two decimals
+const experimentBuckets = 10000
+
+// experimentNamePattern keeps names usable in a header name; variants must also
+// be safe in a header value
+var (
+	experimentNamePattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+	experimentVariantPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+var experimentAssignments = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_experiment_assignments_total",
+	Help: "Proxied requests sent to the backend with an experiment variant, by experiment and variant.",
+}, []string{"experiment", "variant"})
+
+// experiment is an A/B experiment the gateway buckets clients into, so backends
+// only have to read X-Experiment-<Name> instead of each doing their own bucketing
+type experiment struct {
+	Name     string   `json:"name"`
+	Percent  float64  `json:"percent"` // share of clients in the experiment, 0 to 100
+	Variants []string `json:"variants"`
+	Paused   bool     `json:"paused"` // no client gets a variant while paused
+}
+
+// header is the request header carrying the experiment's variant
+func (e experiment) header() string {
+	return http.CanonicalHeaderKey(experimentHeaderPrefix + e.Name)
+}
+
+// assign returns the variant of principal, or "" if it falls outside the
+// experiment's share of traffic. It is a pure hash of the two, so every replica
+// agrees without sharing state, and raising Percent keeps the clients already in.
+func (e experiment) assign(principal string) string {
+	sum := sha256.Sum256([]byte(e.Name + "\x00" + principal))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % experimentBuckets
+	if float64(bucket) >= e.Percent*experimentBuckets/100 {
+		return ""
+	}
+	return e.Variants[binary.BigEndian.Uint64(sum[8:16])%uint64(len(e.Variants))]
+}
+
+// experimentsFromEnv reads EXPERIMENTS, the experiments at startup, e.g.
+// "checkout=50:control|one-page,search=10:a|b" puts half the clients in checkout,
+// split between control and one-page. They can be replaced through /admin/config/import.
+func experimentsFromEnv() ([]experiment, error) {
+	var experiments []experiment
+	for _, entry := range strings.Split(os.Getenv("EXPERIMENTS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		rawPercent, variants, ok2 := strings.Cut(spec, ":")
+		percent, err := strconv.ParseFloat(rawPercent, 64)
+		if !ok || !ok2 || err != nil {
+			return nil, fmt.Errorf("invalid EXPERIMENTS entry %q (want name=percent:variant|variant)", entry)
+		}
+		experiments = append(experiments, experiment{Name: name, Percent: percent, Variants: strings.Split(variants, "|")})
+	}
+
+	var v httpx.Validation
+	validateExperiments(&v, "EXPERIMENTS", experiments)
+	if !v.Valid() {
+		problem := v.Errors()[0]
+		return nil, fmt.Errorf("invalid %s: %s", problem.Field, problem.Message)
+	}
+	return experiments, nil
+}
+
+func validateExperiments(v *httpx.Validation, field string, experiments []experiment) {
+	headers := map[string]bool{}
+	for i, e := range experiments {
+		prefix := fmt.Sprintf("%s.%d.", field, i)
+		switch {
+		case !experimentNamePattern.MatchString(e.Name):
+			v.Add(prefix+"name", "must be letters, digits and hyphens")
+		case headers[e.header()]:
+			v.Add(prefix+"name", "is already used by another experiment")
+		}
+		headers[e.header()] = true
+
+		if e.Percent < 0 || e.Percent > 100 {
+			v.Add(prefix+"percent", "must be between 0 and 100")
+		}
+		if len(e.Variants) == 0 {
+			v.Add(prefix+"variants", "must name at least one variant")
+		}
+		for j, variant := range e.Variants {
+			if !experimentVariantPattern.MatchString(variant) {
+				v.Add(fmt.Sprintf("%svariants.%d", prefix, j), "must be letters, digits, dots, underscores and hyphens")
+			} else if slices.Index(e.Variants, variant) < j {
+				v.Add(fmt.Sprintf("%svariants.%d", prefix, j), "is listed twice")
+			}
+		}
+	}
+}
+
+// assignExperiments sets an X-Experiment-<Name> header on r for every running
+// experiment the caller is in, replacing any the client sent. Callers with a
+// verified identity are bucketed by user; anonymous ones by a cookie set here on
+// their first request. Call it after prepareUpstream has set the identity.
+func (g *Gateway) assignExperiments(w http.ResponseWriter, r *http.Request) {
+	for name := range r.Header {
+		if strings.HasPrefix(name, experimentHeaderPrefix) {
+			r.Header.Del(name)
+		}
+	}
+
+	experiments := g.config.experiments()
+	if !slices.ContainsFunc(experiments, func(e experiment) bool { return !e.Paused && e.Percent > 0 }) {
+		return
+	}
+
+	principal := experimentPrincipal(w, r)
+	for _, e := range experiments {
+		if e.Paused {
+			continue
+		}
+		if variant := e.assign(principal); variant != "" {
+			r.Header.Set(e.header(), variant)
+			experimentAssignments.WithLabelValues(e.Name, variant).Inc()
+		}
+	}
+}
+
+// experimentPrincipal identifies who a request is bucketed as: the user when
+// authenticated, otherwise the experiment cookie, which is issued if missing
+func experimentPrincipal(w http.ResponseWriter, r *http.Request) string {
+	if userID := r.Header.Get(auth.HeaderUserID); userID != "" {
+		return "user:" + r.Header.Get(tenant.Header) + ":" + userID
+	}
+
+	if c, err := r.Cookie(experimentCookie); err == nil && c.Value != "" && len(c.Value) <= 64 {
+		return "anonymous:" + c.Value
+	}
+	id := ids.Random().NewID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     experimentCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(experimentCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return "anonymous:" + id
+}
+
+// copyExperimentHeaders copies the X-Experiment-* headers of r onto an upstream
+// request the gateway makes itself
+func copyExperimentHeaders(dst, src http.Header) {
+	for name, values := range src {
+		if strings.HasPrefix(name, experimentHeaderPrefix) {
+			dst[name] = slices.Clone(values)
+		}
+	}
+}
+
+// experimentsHandler serves GET /admin/experiments: every configured experiment,
+// paused or not. Assignment counts are in gateway_experiment_assignments_total.
+func (g *Gateway) experimentsHandler(w http.ResponseWriter, r *http.Request) {
+	experiments := g.config.experiments()
+	if experiments == nil {
+		experiments = []experiment{}
+	}
+	httpx.WriteJSON(w, r, http.StatusOK, map[string]any{"experiments": experiments})
+}
+
+// pauseExperiment serves POST /admin/experiments/{name}/pause. Clients keep
+// their buckets, so resuming puts everyone back in the variant they had.
+func (g *Gateway) pauseExperiment(w http.ResponseWriter, r *http.Request) {
+	g.setExperimentPaused(w, r, true)
+}
+
+// resumeExperiment serves POST /admin/experiments/{name}/resume
+func (g *Gateway) resumeExperiment(w http.ResponseWriter, r *http.Request) {
+	g.setExperimentPaused(w, r, false)
+}
+
+// setExperimentPaused applies the change as a new configuration, so it shows in
+// /admin/config/history and can be rolled back like an import. Like an import, it
+// only changes this replica.
+func (g *Gateway) setExperimentPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	name := r.PathValue("name")
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+
+	var changed experiment
+	snap, ok := g.updateConfig(func(cfg *runtimeConfig) bool {
+		i := slices.IndexFunc(cfg.Experiments, func(e experiment) bool { return strings.EqualFold(e.Name, name) })
+		if i < 0 {
+			return false
+		}
+		cfg.Experiments = slices.Clone(cfg.Experiments)
+		cfg.Experiments[i].Paused = paused
+		changed = cfg.Experiments[i]
+		return true
+	}, fmt.Sprintf("%s experiment %s", action, name), appliedBy(r), httpx.ClientIP(r))
+	if !ok {
+		httpx.Error(w, http.StatusNotFound, fmt.Sprintf("no experiment %s", name))
+		return
+	}
+
+	log.Printf("[Config] Experiment %s: %sd as configuration %d, applied by %s from %s", changed.Name, action, snap.ID, snap.AppliedBy, snap.ClientIP)
+	httpx.WriteJSON(w, r, http.StatusOK, changed)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"shared/httpx"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// FallbackHeader marks a response served by a service's fallback backend, which
+// may lag behind the primary
+const FallbackHeader = "X-Gateway-Fallback"
+
+// fallbackEnv names the variable holding each service's optional read-only fallback
+var fallbackEnv = map[string]string{
+	"products": "PRODUCT_SERVICE_FALLBACK_URL",
+}
+
+// fallbackRequests counts reads handed to a fallback backend because the primary was unavailable
+var fallbackRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_fallback_requests_total",
+	Help: "Reads served by a fallback backend because the primary was unavailable, by service and reason.",
+}, []string{"service", "reason"})
+
+// errPrimaryUnavailable is returned from ModifyResponse to hand a primary's 503
+// over to the fallback instead of the client
+var errPrimaryUnavailable = errors.New("primary answered 503")
+
+// fallbacksFromEnv reads the fallback backend URLs, keyed by service; services without one are left out
+func fallbacksFromEnv() (map[string]string, error) {
+	fallbacks := map[string]string{}
+	for service, env := range fallbackEnv {
+		raw := os.Getenv(env)
+		if raw == "" {
+			continue
+		}
+		if !isBackendURL(raw) {
+			return nil, fmt.Errorf("%s=%q is not an http(s) URL", env, raw)
+		}
+		fallbacks[service] = raw
+	}
+	return fallbacks, nil
+}
+
+// fallbackFor returns the backend that may answer r when service's primary is
+// unavailable, or nil. Fallbacks are read-only, so only GET and HEAD qualify.
+func (g *Gateway) fallbackFor(service string, r *http.Request) *url.URL {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil
+	}
+	return g.config.fallback(service)
+}
+
+// serveFallback proxies r, already prepared for the primary, to a fallback
+// backend. Failures of the fallback itself are answered like any proxy error
+// but don't count against the primary's passive health.
+func (g *Gateway) serveFallback(w http.ResponseWriter, r *http.Request, service string, target *url.URL, reason string) {
+	fallbackRequests.WithLabelValues(service, reason).Inc()
+	log.Printf("[Route] %s unavailable (%s); serving %s %s from fallback %s", service, reason, r.Method, r.URL.Path, target)
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = g.transport
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		resp.Header.Set(FallbackHeader, service)
+		g.contentTypes.apply(service, resp)
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		tracker, _ := w.(*headerTracker)
+		failure := classifyProxyError(err, tracker != nil && tracker.wroteHeader)
+		proxyErrors.WithLabelValues(service+"-fallback", failure.class).Inc()
+		log.Printf("[Route] FALLBACK ERROR (%s): %v (target: %s%s)", failure.class, err, target, r.URL.Path)
+		if failure.status != 0 {
+			httpx.ErrorCode(w, failure.status, failure.class, failure.msg)
+		}
+	}
+	proxy.ServeHTTP(w, r)
+}
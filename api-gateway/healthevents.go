@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"shared/events"
+	"shared/ids"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// eventHealthChanged is the type of the event sent for every health transition
+const eventHealthChanged = "gateway.health_changed"
+
+// healthEventBuffer is how many transitions can wait to be sent before new ones are dropped
+const healthEventBuffer = 64
+
+var healthEventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "gateway_health_events_dropped_total",
+	Help: "Health transition events that could not be sent to HEALTH_EVENTS_URL.",
+})
+
+// healthTransition is the data of a gateway.health_changed event. Service is
+// "gateway" for the overall status reported by /health.
+type healthTransition struct {
+	Service string    `json:"service"`
+	From    string    `json:"from"` // "unknown" for the first check after startup
+	To      string    `json:"to"`
+	At      time.Time `json:"at"`
+}
+
+// healthEvents sends health transitions to a webhook, one at a time and in the
+// order they happened, so probes never wait on the receiver
+type healthEvents struct {
+	publisher events.Publisher
+	ids       ids.Generator
+	queue     chan events.Event
+}
+
+// healthEventsFromEnv reads HEALTH_EVENTS_URL, where a gateway.health_changed
+// event is POSTed on every health transition; nil when unset
+func healthEventsFromEnv() (*healthEvents, error) {
+	raw := os.Getenv("HEALTH_EVENTS_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid HEALTH_EVENTS_URL %q (want an http or https URL)", raw)
+	}
+	return newHealthEvents(events.NewWebhook(raw)), nil
+}
+
+func newHealthEvents(publisher events.Publisher) *healthEvents {
+	return &healthEvents{publisher: publisher, ids: ids.Random(), queue: make(chan events.Event, healthEventBuffer)}
+}
+
+// run sends queued transitions until ctx is cancelled
+func (e *healthEvents) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-e.queue:
+			sendCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			if err := e.publisher.Publish(sendCtx, event); err != nil {
+				healthEventsDropped.Inc()
+				t := event.Data.(healthTransition)
+				log.Printf("[Health Check] Could not send %s -> %s of %s to HEALTH_EVENTS_URL: %v", t.From, t.To, t.Service, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// send queues a transition; a nil healthEvents sends nothing. When the receiver
+// has fallen too far behind the transition is dropped and logged instead.
+func (e *healthEvents) send(t healthTransition) {
+	if e == nil {
+		return
+	}
+	event := events.Event{ID: e.ids.NewID(), Type: eventHealthChanged, OccurredAt: t.At, Data: t}
+	select {
+	case e.queue <- event:
+	default:
+		healthEventsDropped.Inc()
+		log.Printf("[Health Check] Dropped %s -> %s of %s; HEALTH_EVENTS_URL is falling behind", t.From, t.To, t.Service)
+	}
+}
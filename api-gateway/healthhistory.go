@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	backendUptime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_backend_uptime_ratio",
+		Help: "Share of the remembered health checks per backend that passed (healthy or degraded).",
+	}, []string{"service"})
+
+	backendFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_backend_consecutive_failures",
+		Help: "Health checks per backend that have failed in a row.",
+	}, []string{"service"})
+)
+
+// healthSample is the result of one health check
+type healthSample struct {
+	Time       time.Time `json:"time"`
+	OK         bool      `json:"ok"`
+	Status     string    `json:"status"`
+	LatencyMS  int64     `json:"latency_ms"`
+	ErrorClass string    `json:"error_class,omitempty"`
+}
+
+// healthHistory keeps the last size health checks of each backend in a ring buffer
+type healthHistory struct {
+	size int
+
+	mu       sync.Mutex
+	samples  map[string][]healthSample // oldest first
+	failures map[string]int            // consecutive failures
+}
+
+func newHealthHistory(size int) *healthHistory {
+	return &healthHistory{size: size, samples: map[string][]healthSample{}, failures: map[string]int{}}
+}
+
+// add records a check and updates the backend's uptime and failure gauges
+func (h *healthHistory) add(service string, sample healthSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples := append(h.samples[service], sample)
+	if len(samples) > h.size {
+		samples = samples[len(samples)-h.size:]
+	}
+	h.samples[service] = samples
+
+	if sample.OK {
+		h.failures[service] = 0
+	} else {
+		h.failures[service]++
+	}
+
+	backendUptime.WithLabelValues(service).Set(uptime(samples))
+	backendFailures.WithLabelValues(service).Set(float64(h.failures[service]))
+}
+
+// serviceHistory is one backend's entry in GET /admin/health-history
+type serviceHistory struct {
+	UptimePercent       float64        `json:"uptime_percent"`
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+	Samples             []healthSample `json:"samples"`
+}
+
+// snapshot copies the history of every backend
+func (h *healthHistory) snapshot() map[string]serviceHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := make(map[string]serviceHistory, len(h.samples))
+	for service, samples := range h.samples {
+		out[service] = serviceHistory{
+			UptimePercent:       uptime(samples) * 100,
+			ConsecutiveFailures: h.failures[service],
+			Samples:             append([]healthSample(nil), samples...),
+		}
+	}
+	return out
+}
+
+func uptime(samples []healthSample) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	ok := 0
+	for _, s := range samples {
+		if s.OK {
+			ok++
+		}
+	}
+	return float64(ok) / float64(len(samples))
+}
+
+// healthHistoryHandler serves GET /admin/health-history
+func (g *Gateway) healthHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(g.history.snapshot())
+}
+
+// healthCheckConfig configures the background health checker
+type healthCheckConfig struct {
+	Interval    time.Duration // HEALTH_CHECK_INTERVAL; average time between checks
+	HistorySize int           // HEALTH_HISTORY_SIZE; checks remembered per backend
+}
+
+// healthCheckConfigFromEnv reads the health checker configuration. By default an
+// hour of history is kept at one check every 15s.
+func healthCheckConfigFromEnv() (healthCheckConfig, error) {
+	cfg := healthCheckConfig{Interval: 15 * time.Second, HistorySize: 240}
+
+	if raw := os.Getenv("HEALTH_CHECK_INTERVAL"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid HEALTH_CHECK_INTERVAL %q", raw)
+		}
+		cfg.Interval = d
+	}
+	if raw := os.Getenv("HEALTH_HISTORY_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid HEALTH_HISTORY_SIZE %q", raw)
+		}
+		cfg.HistorySize = n
+	}
+	return cfg, nil
+}
+
+// runHealthChecks probes every backend until ctx is cancelled. Each wait is the
+// interval ±20%, so several gateway replicas drift apart instead of probing in lockstep.
+func (g *Gateway) runHealthChecks(ctx context.Context, interval time.Duration) {
+	for {
+		jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(interval))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval + jitter):
+		}
+
+		g.probeAll(ctx, g.services.snapshot())
+	}
+}
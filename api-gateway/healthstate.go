@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// backendHealth exposes the last probed status of each backend
+var backendHealth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gateway_backend_health",
+	Help: "Last health check result per backend: 1 healthy, 0.5 degraded, 0 unhealthy.",
+}, []string{"service"})
+
+func healthValue(status string) float64 {
+	switch status {
+	case "healthy":
+		return 1
+	case "degraded":
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+// healthStates remembers the last status seen for each backend
+type healthStates struct {
+	events *healthEvents // Told of every change; nil unless HEALTH_EVENTS_URL is set
+
+	mu     sync.Mutex
+	status map[string]string
+}
+
+// record stores status for name and returns the previous status ("unknown" the
+// first time) and whether it changed. Changes are sent as health events.
+func (h *healthStates) record(name, status string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.status == nil {
+		h.status = map[string]string{}
+	}
+	prev, ok := h.status[name]
+	h.status[name] = status
+	if !ok {
+		prev = "unknown"
+	} else if prev == status {
+		return prev, false
+	}
+	// Queued under the lock, so events leave in the order the changes were recorded
+	h.events.send(healthTransition{Service: name, From: prev, To: status, At: time.Now().UTC()})
+	return prev, true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"shared/auth"
+	"shared/httpx"
+	"shared/jwt"
+	"shared/tenant"
+	"strings"
+	"time"
+)
+
+// errWrongTenant is returned for a token issued for another tenant than the host's
+var errWrongTenant = errors.New("token was issued for another tenant")
+
+// forwardIdentity replaces any client-supplied identity headers with the caller's
+// verified identity: from a bearer token when JWT_SIGNING_KEY is set, otherwise
+// DEV_PRINCIPAL in local development. Requests without either are anonymous.
+func (g *Gateway) forwardIdentity(r *http.Request) error {
+	r.Header.Del(auth.HeaderUserID)
+	r.Header.Del(auth.HeaderRoles)
+	r.Header.Del(auth.HeaderImpersonatedBy)
+
+	if token, ok := bearerToken(r); ok && g.jwtKey != nil {
+		claims, err := jwt.Verify(token, g.jwtKey, time.Now())
+		if err != nil {
+			return err
+		}
+		if claims.Tenant != "" && claims.Tenant != r.Header.Get(tenant.Header) {
+			return errWrongTenant
+		}
+		r.Header.Set(auth.HeaderUserID, claims.Subject)
+		r.Header.Set(auth.HeaderRoles, strings.Join(claims.Roles, ","))
+		if claims.ImpersonatedBy != "" {
+			r.Header.Set(auth.HeaderImpersonatedBy, claims.ImpersonatedBy)
+		}
+		return nil
+	}
+
+	if id, roles, ok := strings.Cut(g.devPrincipal, ":"); ok || id != "" {
+		r.Header.Set(auth.HeaderUserID, id)
+		r.Header.Set(auth.HeaderRoles, roles)
+	}
+	return nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// markImpersonation echoes X-Impersonated-By on the response to an impersonated
+// request, so the frontend can show a banner
+func markImpersonation(w http.ResponseWriter, r *http.Request) {
+	if by := r.Header.Get(auth.HeaderImpersonatedBy); by != "" {
+		w.Header().Set(auth.HeaderImpersonatedBy, by)
+	}
+}
+
+// unauthenticated answers 401 for a bearer token that failed verification
+func unauthenticated(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	httpx.Error(w, http.StatusUnauthorized, fmt.Sprintf("invalid token: %v", err))
+}
@@ -1,21 +1,58 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"math"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
+	"shared/admin"
+	"shared/auth"
+	"shared/health"
+	"shared/httpx"
+	"shared/ids"
+	"shared/jwt"
+	"shared/logging"
+	"shared/tenant"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Gateway struct {
-	serviceMap map[string]string // Maps service name -> backend url
+	services         serviceTable         // Maps service name -> backend url
+	logs             *logging.Sampler     // Collapses repeated error lines during outages
+	backendStates    healthStates         // Last health status seen per backend, for logging changes
+	history          *healthHistory       // Recent health checks per backend, for /admin/health-history
+	upstreamTimeout  time.Duration        // UPSTREAM_TIMEOUT; budget for a proxied request, passed to backends as X-Request-Deadline
+	transport        *http.Transport      // Shared by every proxied request; applies the connect, TLS and header timeouts
+	devPrincipal     string               // DEV_PRINCIPAL ("id:role,role"); identity forwarded for every request in local development
+	jwtKey           []byte               // JWT_SIGNING_KEY; verifies bearer tokens issued by the services
+	slashPolicy      trailingSlash        // TRAILING_SLASH; what to do with a trailing slash on /api/ paths
+	limiter          rateLimiter          // Per-client request limit on /api/; nil when no rate limit is set
+	cache            responseCache        // Proxied GET responses; nil when CACHE_TTL is unset
+	cacheTTL         time.Duration        // CACHE_TTL; how long responses are kept unless the backend says less
+	aggregateTimeout time.Duration        // AGGREGATE_TIMEOUT; shared budget for the upstream calls of one aggregation
+	outliers         *outlierDetector     // Passive health from proxied traffic; ejects failing backends
+	admission        *admissionController // Priority-aware concurrency limit on /api/; nil when MAX_CONCURRENT_REQUESTS is unset
+	trustedProxies   httpx.TrustedProxies // TRUSTED_PROXIES; whose X-Forwarded-For is passed on
+	debug            bool                 // LOG_LEVEL=debug; logs per-request detail such as client cancellations
+	recent           *recentRequests      // Last requests, for /admin/recent; nil when RECENT_REQUESTS_SIZE is 0
+	tls              *tls.Config          // TLS_CERT_FILE and friends; nil when the gateway serves plain HTTP
+	config           *configStore         // Tenant hosts, fallbacks and rate limit quotas; replaced by /admin/config/import
+	contentTypes     defaultContentTypes  // Labels proxied responses whose backend sent no Content-Type
+	starting         atomic.Bool          // Set while STARTUP_PROBE=degrade waits for required services; /health answers 503
 }
 
 func main() {
@@ -24,104 +61,353 @@ func main() {
 	userServiceURL := os.Getenv("USER_SERVICE_URL")
 	productServiceURL := os.Getenv("PRODUCT_SERVICE_URL")
 
-	// Log service URLs at startup
 	log.Printf("=== API Gateway Configuration ===")
-	log.Printf("USER_SERVICE_URL: %s", userServiceURL)
-	log.Printf("PRODUCT_SERVICE_URL: %s", productServiceURL)
 
-	gateway := &Gateway{
-		serviceMap: map[string]string{
-			"users":    userServiceURL,
-			"products": productServiceURL,
-		},
+	serviceEnv := map[string]string{
+		"users":    "USER_SERVICE_URL",
+		"products": "PRODUCT_SERVICE_URL",
+	}
+	serviceMap := map[string]string{
+		"users":    userServiceURL,
+		"products": productServiceURL,
+	}
+	requiredServices, err := requiredServicesFromEnv(serviceMap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	startupProbe, err := startupProbeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	startupWait, err := health.StartupWaitFromEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	http.HandleFunc("/health", corsMiddleware(gateway.healthCheck))
-	http.HandleFunc("/api/", corsMiddleware(gateway.routeRequest))
+	// Misconfigured required services stop the gateway here; optional ones are
+	// left out of routing with a warning
+	upstreams, err := checkUpstreams(serviceMap, serviceEnv, requiredServices)
+	if err != nil {
+		logUpstreams(upstreams)
+		log.Fatal(err)
+	}
+	serviceMap = usableServices(upstreams)
 
-	log.Printf("Starting API Gateway on :8080")
-	log.Printf("Health check available at: http://localhost:8080/health")
+	fallbacks, err := fallbacksFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for service, target := range fallbacks {
+		if _, ok := serviceMap[service]; !ok {
+			log.Printf("WARNING: %s is ignored; %s is left out of routing", fallbackEnv[service], service)
+			delete(fallbacks, service)
+			continue
+		}
+		log.Printf("%s: %s (fallback for reads)", fallbackEnv[service], target)
+	}
 
-	http.ListenAndServe(":8080", nil)
-}
+	tenantHosts, err := parseTenantHosts(os.Getenv("TENANT_HOSTS"))
+	if err != nil {
+		log.Fatalf("Invalid TENANT_HOSTS: %v", err)
+	}
+	defaultTenant := os.Getenv("TENANT_DEFAULT")
+	if defaultTenant == "" {
+		defaultTenant = tenant.Default
+	}
+	log.Printf("TENANT_HOSTS: %v (default tenant: %s)", tenantHosts, defaultTenant)
 
-// corsMiddleware adds CORS headers to all responses
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	configHistorySize, err := configHistorySizeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		// Call the next handler
-		next(w, r)
+	gateway := &Gateway{
+		devPrincipal: os.Getenv("DEV_PRINCIPAL"),
+		jwtKey:       jwt.KeyFromEnv(),
+		debug:        debugFromEnv(),
+		config:       newConfigStore(configHistorySize),
 	}
-}
 
-func (g *Gateway) healthCheck(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	timeouts, err := upstreamTimeoutsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gateway.upstreamTimeout = timeouts.Total
+	gateway.transport = newUpstreamTransport(timeouts)
+	log.Printf("UPSTREAM timeouts: connect %s, TLS %s, header %s, total %s",
+		timeouts.Connect, timeouts.TLSHandshake, timeouts.ResponseHeader, timeouts.Total)
 
-	type ServiceHealth struct {
-		Name   string `json:"name"`
-		Status string `json:"status"`
-		URL    string `json:"url"`
+	gateway.slashPolicy, err = trailingSlashFromEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	type HealthResponse struct {
-		Gateway  string          `json:"gateway"`
-		Services []ServiceHealth `json:"services"`
+	if gateway.aggregateTimeout, err = aggregateTimeoutFromEnv(); err != nil {
+		log.Fatal(err)
 	}
 
-	services := []ServiceHealth{}
-	allHealthy := true
+	gateway.logs, err = logging.SamplerFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid log sampling config: %v", err)
+	}
+	if gateway.devPrincipal != "" {
+		log.Printf("WARNING: DEV_PRINCIPAL is set; every request is forwarded as %s", gateway.devPrincipal)
+	}
 
-	// Check each backend service
-	for serviceName, serviceURL := range g.serviceMap {
-		status := "healthy"
+	rateCfg, err := rateLimitConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if gateway.limiter, err = newRateLimiter(rateCfg); err != nil {
+		log.Fatal(err)
+	}
+	experiments, err := experimentsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gateway.applyConfig(runtimeConfig{
+		Services:      serviceMap,
+		Fallbacks:     fallbacks,
+		TenantHosts:   tenantHosts,
+		DefaultTenant: defaultTenant,
+		RateLimits:    rateCfg.rateLimitQuotas,
+		Experiments:   experiments,
+	}, "startup", "environment", "")
+	if gateway.limiter != nil {
+		log.Printf("RATE_LIMIT: %g/s per IP (burst %d), %g/s per user (burst %d), %d overrides (%s backend)",
+			rateCfg.IP.Rate, rateCfg.IP.Burst, rateCfg.User.Rate, rateCfg.User.Burst, len(rateCfg.Overrides), gateway.limiter.Name())
+	}
+	for _, e := range experiments {
+		log.Printf("EXPERIMENTS: %s in %g%% of clients, variants %s (sent as %s)", e.Name, e.Percent, strings.Join(e.Variants, ", "), e.header())
+	}
 
-		// Make HTTP request to service health endpoint
-		healthURL := fmt.Sprintf("%s/health", serviceURL)
-		log.Printf("[Health Check] Checking %s at: %s", serviceName, healthURL)
+	admissionCfg, err := admissionConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gateway.admission = newAdmissionController(admissionCfg)
+	if gateway.admission != nil {
+		log.Printf("MAX_CONCURRENT_REQUESTS: %d (low priority shed at %d, normal at %d)",
+			admissionCfg.MaxConcurrent, gateway.admission.limits[priorityLow], gateway.admission.limits[priorityNormal])
+	}
 
-		client := &http.Client{Timeout: 2 * time.Second}
-		resp, err := client.Get(healthURL)
+	cacheCfg, err := cacheConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if gateway.cache, err = newResponseCache(cacheCfg); err != nil {
+		log.Fatal(err)
+	}
+	gateway.cacheTTL = cacheCfg.TTL
+	if gateway.cache != nil {
+		log.Printf("CACHE: GET responses kept up to %s (%s backend)", cacheCfg.TTL, gateway.cache.Name())
+	}
+
+	outlierCfg, err := outlierConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gateway.outliers = newOutlierDetector(outlierCfg, func(ctx context.Context, service string) bool {
+		url, _ := gateway.services.lookup(service)
+		return gateway.probe(ctx, service, url).Status != "unhealthy"
+	})
+
+	recentSize, err := recentSizeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gateway.recent = newRecentRequests(recentSize)
 
+	healthCfg, err := healthCheckConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gateway.history = newHealthHistory(healthCfg.HistorySize)
+
+	// Ops can be paged on health transitions, sent to HEALTH_EVENTS_URL as they happen
+	if gateway.backendStates.events, err = healthEventsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if gateway.backendStates.events != nil {
+		go gateway.backendStates.events.run(context.Background())
+		log.Printf("HEALTH_EVENTS_URL: health transitions are sent as %s events", eventHealthChanged)
+	}
+
+	// Required services are probed before the gateway reports ready, depending on STARTUP_PROBE
+	switch startupProbe {
+	case startupProbeOff:
+		logUpstreams(upstreams)
+	case startupProbeFailFast:
+		err := gateway.probeUpstreams(context.Background(), upstreams, startupWait)
+		logUpstreams(upstreams)
 		if err != nil {
-			status = "unhealthy"
-			allHealthy = false
-			log.Printf("[Health Check] %s FAILED - Error: %v", serviceName, err)
-		} else if resp.StatusCode != http.StatusOK {
-			status = "unhealthy"
-			allHealthy = false
-			log.Printf("[Health Check] %s FAILED - Status: %d", serviceName, resp.StatusCode)
-		} else {
-			log.Printf("[Health Check] %s OK - Status: %d", serviceName, resp.StatusCode)
+			log.Fatal(err)
 		}
+	case startupProbeDegrade:
+		gateway.starting.Store(true)
+		go func() {
+			defer gateway.starting.Store(false)
+			err := gateway.probeUpstreams(context.Background(), upstreams, startupWait)
+			logUpstreams(upstreams)
+			if err != nil {
+				log.Printf("WARNING: reporting ready without every required service: %v", err)
+			}
+		}()
+	}
+	go gateway.runHealthChecks(context.Background(), healthCfg.Interval)
+
+	if gateway.trustedProxies, err = httpx.TrustedProxiesFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("TRUSTED_PROXIES: %v", gateway.trustedProxies)
+
+	if gateway.tls, err = tlsConfigFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if gateway.tls != nil {
+		log.Printf("TLS: minimum version %s", tls.VersionName(gateway.tls.MinVersion))
+	}
 
-		if resp != nil {
-			resp.Body.Close()
+	if gateway.contentTypes, err = defaultContentTypesFromEnv(slices.Sorted(maps.Keys(serviceEnv))); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("DEFAULT_CONTENT_TYPE: %s", gateway.contentTypes)
+
+	security := securityHeadersFromEnv()
+	corsMaxAge, err := corsMaxAgeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cors := corsMiddleware(corsMaxAge)
+
+	errorLanguages, err := httpx.LanguagesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.HandleFunc("/health", security.middleware(cors(gateway.healthCheck)))
+	http.HandleFunc("GET /health/{service}", security.middleware(cors(gateway.serviceHealthCheck)))
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/api/", security.middleware(cors(gateway.recordRecent(gateway.rateLimit(gateway.admit(gateway.routeRequest))))))
+	http.HandleFunc("GET /admin/health-history", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.healthHistoryHandler)).ServeHTTP))
+	http.HandleFunc("GET /admin/stats", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.statsHandler)).ServeHTTP))
+	http.HandleFunc("GET /admin/recent", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.recentHandler)).ServeHTTP))
+	http.HandleFunc("GET /admin/route-test", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.routeTest)).ServeHTTP))
+	http.HandleFunc("GET /admin/config/export", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.exportConfig)).ServeHTTP))
+	http.HandleFunc("POST /admin/config/import", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.importConfig)).ServeHTTP))
+	http.HandleFunc("GET /admin/config/history", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.configHistory)).ServeHTTP))
+	http.HandleFunc("POST /admin/config/rollback/{n}", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.rollbackConfig)).ServeHTTP))
+	http.HandleFunc("GET /admin/experiments", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.experimentsHandler)).ServeHTTP))
+	http.HandleFunc("POST /admin/experiments/{name}/pause", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.pauseExperiment)).ServeHTTP))
+	http.HandleFunc("POST /admin/experiments/{name}/resume", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.resumeExperiment)).ServeHTTP))
+	http.HandleFunc("GET /admin/tls-info", security.middleware(admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(gateway.tlsInfoHandler)).ServeHTTP))
+	http.HandleFunc("GET /api/aggregate/products/{id}", security.middleware(cors(gateway.recordRecent(gateway.rateLimit(gateway.admit(gateway.aggregateProduct))))))
+
+	server := &http.Server{
+		Addr:      ":8080",
+		Handler:   httpx.ClientIPs(gateway.trustedProxies)(httpx.ErrorLanguages(errorLanguages)(gateway.normalizeAPIPaths(http.DefaultServeMux))),
+		TLSConfig: gateway.tls,
+	}
+	if gateway.tls != nil {
+		log.Printf("Starting API Gateway on :8080 (TLS)")
+		log.Printf("Health check available at: https://localhost:8080/health")
+		// The certificate is already loaded into TLSConfig
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+
+	log.Printf("Starting API Gateway on :8080")
+	log.Printf("Health check available at: http://localhost:8080/health")
+
+	server.ListenAndServe()
+}
+
+// corsMaxAgeFromEnv reads CORS_MAX_AGE, how many seconds browsers may cache a
+// preflight result; default 600, 0 leaves it to the browser's own default
+func corsMaxAgeFromEnv() (int, error) {
+	raw := os.Getenv("CORS_MAX_AGE")
+	if raw == "" {
+		return 600, nil
+	}
+	seconds, err := strconv.Atoi(raw)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid CORS_MAX_AGE %q", raw)
+	}
+	return seconds, nil
+}
+
+// corsMiddleware adds CORS headers to all responses. Preflights are answered
+// with Access-Control-Max-Age set to maxAge seconds, unless it is 0.
+func corsMiddleware(maxAge int) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			// Set CORS headers
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+httpx.IdempotencyKeyHeader)
+			w.Header().Set("Access-Control-Expose-Headers", auth.HeaderImpersonatedBy+", "+httpx.AlreadyDeletedHeader)
+
+			// Answer CORS preflights here; other OPTIONS requests (capability
+			// discovery) go to the backend, which replies with its Allow header
+			if isPreflight(r) {
+				if maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(maxAge))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			// Call the next handler
+			next(w, r)
 		}
+	}
+}
 
-		services = append(services, ServiceHealth{
-			Name:   serviceName,
-			Status: status,
-			URL:    serviceURL,
-		})
+// serviceHealth is one backend's entry in the /health response
+type serviceHealth struct {
+	Name         string          `json:"name"`
+	Status       string          `json:"status"`
+	URL          string          `json:"url"`
+	Dependencies json.RawMessage `json:"dependencies,omitempty"`
+}
+
+func (g *Gateway) healthCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	type HealthResponse struct {
+		Gateway  string          `json:"gateway"`
+		Services []serviceHealth `json:"services"`
+	}
+
+	if g.starting.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{Gateway: "starting", Services: []serviceHealth{}})
+		return
+	}
+
+	// No lock is held while the backends are probed
+	results := g.probeAll(r.Context(), g.services.snapshot())
+	services := mergeHealth(results, g.services.snapshot())
+
+	anyDegraded, anyUnhealthy := false, false
+	for _, health := range services {
+		switch health.Status {
+		case "degraded":
+			anyDegraded = true
+		case "unhealthy":
+			anyUnhealthy = true
+		}
 	}
 
 	gatewayStatus := "healthy"
-	if !allHealthy {
-		gatewayStatus = "degraded"
+	switch {
+	case anyUnhealthy:
+		gatewayStatus = "unhealthy"
 		w.WriteHeader(http.StatusServiceUnavailable)
-		log.Printf("[Health Check] Overall status: DEGRADED (503)")
-	} else {
-		log.Printf("[Health Check] Overall status: HEALTHY (200)")
+	case anyDegraded:
+		gatewayStatus = "degraded"
+	}
+	if prev, changed := g.backendStates.record("gateway", gatewayStatus); changed {
+		log.Printf("[Health Check] Overall status: %s -> %s", strings.ToUpper(prev), strings.ToUpper(gatewayStatus))
 	}
 
 	response := HealthResponse{
@@ -132,63 +418,290 @@ func (g *Gateway) healthCheck(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// serviceHealthCheck serves GET /health/{service}: one backend's entry in /health,
+// checked on its own. It answers 503 if the backend is unhealthy and 404 if the
+// gateway has no such service.
+func (g *Gateway) serviceHealthCheck(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("service")
+	url, ok := g.services.snapshot()[name]
+	if !ok {
+		httpx.Error(w, http.StatusNotFound, fmt.Sprintf("unknown service %q", name))
+		return
+	}
+
+	health := g.probe(r.Context(), name, url)
+	status := http.StatusOK
+	if health.Status == "unhealthy" {
+		status = http.StatusServiceUnavailable
+	}
+	httpx.WriteJSON(w, r, status, health)
+}
+
+// probe checks a backend's readiness, which distinguishes a required dependency
+// being down (unhealthy) from an optional one (degraded), and records the result
+func (g *Gateway) probe(ctx context.Context, serviceName, serviceURL string) serviceHealth {
+	health := serviceHealth{Name: serviceName, Status: "unhealthy", URL: serviceURL}
+	sample := healthSample{Time: time.Now().UTC()}
+	var detail string
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serviceURL+"/readyz", nil)
+	var resp *http.Response
+	if err == nil {
+		resp, err = http.DefaultClient.Do(req)
+	}
+	sample.LatencyMS = time.Since(sample.Time).Milliseconds()
+
+	if err != nil {
+		detail = fmt.Sprintf("error: %v", err)
+		sample.ErrorClass = logging.ErrorClass(err)
+	} else {
+		var report struct {
+			Status       string          `json:"status"`
+			Dependencies json.RawMessage `json:"dependencies"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			detail = fmt.Sprintf("status %d, unreadable report: %v", resp.StatusCode, err)
+			sample.ErrorClass = "invalid_report"
+		} else {
+			health.Dependencies = report.Dependencies
+			switch report.Status {
+			case "ok":
+				health.Status = "healthy"
+			case "degraded":
+				health.Status = "degraded"
+			default:
+				sample.ErrorClass = fmt.Sprintf("status_%d", resp.StatusCode)
+			}
+			detail = fmt.Sprintf("status %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+	sample.Status = health.Status
+	sample.OK = health.Status != "unhealthy"
+
+	// Probes run often, so only changes are logged; /health, /metrics and
+	// /admin/health-history show the current state and how it got there
+	if prev, changed := g.backendStates.record(serviceName, health.Status); changed {
+		log.Printf("[Health Check] %s %s -> %s (%s)", serviceName, strings.ToUpper(prev), strings.ToUpper(health.Status), detail)
+	}
+	backendHealth.WithLabelValues(serviceName).Set(healthValue(health.Status))
+	g.history.add(serviceName, sample)
+
+	return health
+}
+
 func (g *Gateway) routeRequest(w http.ResponseWriter, r *http.Request) {
 	originalPath := r.URL.Path
 	log.Printf("[Route] Incoming %s %s", r.Method, originalPath)
 
-	// Path validation - should already start with /api/ due to HandleFunc pattern
-	if !strings.HasPrefix(r.URL.Path, "/api/") {
-		log.Printf("[Route] ERROR: Invalid path (missing /api/ prefix): %s", r.URL.Path)
-		http.Error(w, "Invalid path", http.StatusNotFound)
+	decision := g.decideRoute(r.Method, r.Host, r.URL.EscapedPath())
+	if decision.Status != http.StatusOK {
+		log.Printf("[Route] ERROR: %s", strings.Join(decision.Trace, "; "))
+		httpx.Error(w, decision.Status, decision.Error)
+		return
+	}
+	service, targetURL := decision.Service, decision.Upstream
+
+	w, done := countBytes(w, r, service)
+	defer done()
+
+	fallback := g.fallbackFor(service, r)
+	ejected := false
+	if ok, retryAfter := g.outliers.admit(service); !ok && fallback != nil {
+		ejected = true
+	} else if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		httpx.ErrorCode(w, http.StatusServiceUnavailable, classEjected, "Service temporarily unavailable")
 		return
 	}
 
-	// Step 2: Extract service name from path
-	// Example: /api/users/123 → service = "___"
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 3 {
-		log.Printf("[Route] ERROR: Invalid path (too short): %s", r.URL.Path)
-		http.Error(w, "Invalid path", http.StatusNotFound)
+	proxy := httputil.NewSingleHostReverseProxy(decision.target)
+	proxy.Transport = g.transport
+
+	// Passive health: every response, or failure to get one, is reported once
+	var start time.Time
+	observed := false
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		observed = true
+		g.outliers.observe(service, resp.StatusCode >= 500, time.Since(start))
+		g.contentTypes.apply(service, resp)
+		if resp.StatusCode == http.StatusServiceUnavailable && fallback != nil {
+			return errPrimaryUnavailable
+		}
+		return nil
+	}
+
+	// Add error handler to proxy
+	proxyFailed := false
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		proxyFailed = true
+		if errors.Is(err, errPrimaryUnavailable) {
+			g.serveFallback(w, r, service, fallback, "status_503")
+			return
+		}
+		tracker, _ := w.(*headerTracker)
+		failure := classifyProxyError(err, tracker != nil && tracker.wroteHeader)
+		proxyErrors.WithLabelValues(service, failure.class).Inc()
+		if !observed && failure.class != classCanceled {
+			observed = true
+			g.outliers.observe(service, true, time.Since(start))
+		}
+
+		g.logs.Printf(logging.Key{Message: "proxy error", Service: service, Class: failure.class},
+			"[Route] PROXY ERROR (%s): %v (target: %s%s)", failure.class, err, targetURL, r.URL.Path)
+		// A request that never reached the primary can safely be tried elsewhere
+		if failure.status == http.StatusServiceUnavailable && fallback != nil {
+			g.serveFallback(w, r, service, fallback, failure.class)
+			return
+		}
+		if failure.status != 0 {
+			httpx.ErrorCode(w, failure.status, failure.class, failure.msg)
+		}
+	}
+
+	if err := g.prepareUpstream(r); err != nil {
+		unauthenticated(w, err)
 		return
 	}
-	service := pathParts[2]
-	log.Printf("[Route] Service extracted: %s", service)
+	markImpersonation(w, r)
+	g.assignExperiments(w, r)
+
+	// The key is taken after the identity and experiment headers are set, so it covers them
+	var key string
+	if g.cache != nil && isCacheable(r) {
+		key = cacheKey(r)
+		if g.serveCached(w, r, key) {
+			log.Printf("[Route] Served %s %s from cache", r.Method, originalPath)
+			return
+		}
+	}
 
-	// Step 3: Look up service URL
-	targetURL, exists := g.serviceMap[service]
-	if !exists {
-		log.Printf("[Route] ERROR: Service not found: %s (available: %v)", service, g.serviceMap)
-		http.Error(w, "Service not found", http.StatusNotFound)
+	if err := setEscapedPath(r.URL, decision.RewrittenPath); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	log.Printf("[Route] Target service URL: %s", targetURL)
 
-	// Step 4: Create a reverse proxy
-	// Research: httputil.NewSingleHostReverseProxy
-	// What URL do you pass it?
-	parsedURL, err := url.Parse(targetURL)
-	if err != nil {
-		log.Printf("[Route] ERROR: Failed to parse URL %s: %v", targetURL, err)
-		http.Error(w, "Invalid URL", http.StatusNotFound)
+	finalURL := fmt.Sprintf("%s%s", targetURL, r.URL.Path)
+	log.Printf("[Route] Proxying %s %s -> %s", r.Method, originalPath, finalURL)
+
+	// Give the backend a deadline budget, honouring a sooner one from the client.
+	// Event streams are long-lived, so they get none.
+	clientCtx := r.Context()
+	if r.Header.Get("Accept") == "text/event-stream" {
+		r.Header.Del(httpx.DeadlineHeader)
+	} else {
+		deadline := time.Now().Add(g.upstreamTimeout)
+		if clientDeadline, ok := httpx.ParseDeadline(r.Header.Get(httpx.DeadlineHeader)); ok && clientDeadline.Before(deadline) {
+			deadline = clientDeadline
+		}
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		r = r.WithContext(ctx)
+		httpx.SetDeadline(r.Header, deadline)
+	}
+
+	// Forward the request (proxy does this). The body is streamed to the backend as
+	// it arrives, so large uploads such as product imports are never held in memory;
+	// nothing before this point may read or buffer r.Body.
+	start = time.Now()
+	defer g.observeCancel(clientCtx, r, service, decision.RewrittenPath, start)
+	if ejected {
+		g.serveFallback(&headerTracker{ResponseWriter: w}, r, service, fallback, classEjected)
 		return
 	}
-	proxy := httputil.NewSingleHostReverseProxy(parsedURL)
+	if key == "" {
+		proxy.ServeHTTP(&headerTracker{ResponseWriter: w}, r)
+		return
+	}
+	capture := &captureWriter{ResponseWriter: w}
+	proxy.ServeHTTP(&headerTracker{ResponseWriter: capture}, r)
+	if !proxyFailed {
+		g.store(r.Context(), key, capture)
+	}
+}
 
-	// Add error handler to proxy
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("[Route] PROXY ERROR: %v (target: %s%s)", err, targetURL, r.URL.Path)
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+// prepareUpstream sets the headers backends rely on, in place on r: the tenant,
+// the caller's identity, and how the client reached the gateway. It fails if the
+// caller presented a bearer token that doesn't verify.
+func (g *Gateway) prepareUpstream(r *http.Request) error {
+	// Scope the request to the tenant that owns this hostname. Any
+	// client-supplied tenant header is overwritten so it can't be spoofed.
+	r.Header.Set(tenant.Header, g.tenantFor(r))
+
+	// The services trust the forwarded identity, so never pass on what the client sent
+	if err := g.forwardIdentity(r); err != nil {
+		return err
 	}
 
-	// Step 5: Modify the request path
-	// Strip /api/ and /serviceName so backend gets correct path
-	// Example: /api/users/123 → backend should see /users/123
-	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api/")
-	r.URL.Path = "/" + r.URL.Path // Add back the leading /
+	// The proxy appends the peer to X-Forwarded-For. Hops before it are only
+	// worth passing on if a trusted proxy added them; otherwise they are
+	// whatever the client made up.
+	if !g.trustedProxies.PeerTrusted(r) {
+		r.Header.Del("X-Forwarded-For")
+	}
 
-	finalURL := fmt.Sprintf("%s%s", targetURL, r.URL.Path)
-	log.Printf("[Route] Proxying %s %s -> %s", r.Method, originalPath, finalURL)
+	// Tell the backend how the client reached the gateway so it can build
+	// public URLs (e.g. pagination links). Values set by a load balancer in
+	// front of the gateway are kept.
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+	if r.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+	r.Header.Set("X-Forwarded-Prefix", "/api")
+
+	// Give the request an ID for the services to log, unless a load balancer
+	// in front already did
+	if r.Header.Get(httpx.RequestIDHeader) == "" {
+		r.Header.Set(httpx.RequestIDHeader, ids.Random().NewID())
+	}
+	return nil
+}
+
+// upstreamHeaders are the headers set by prepareUpstream, for copying onto requests the gateway makes itself
+var upstreamHeaders = []string{
+	tenant.Header,
+	auth.HeaderUserID,
+	auth.HeaderRoles,
+	auth.HeaderImpersonatedBy,
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Prefix",
+	httpx.RequestIDHeader,
+}
+
+// parseTenantHosts parses TENANT_HOSTS, e.g. "shop.example.com=default,brand-b.example.com=brand-b"
+func parseTenantHosts(raw string) (map[string]string, error) {
+	hosts := map[string]string{}
+	if raw == "" {
+		return hosts, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		host, tenantID, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || host == "" || tenantID == "" {
+			return nil, fmt.Errorf("expected host=tenant, got %q", pair)
+		}
+		hosts[strings.ToLower(host)] = tenantID
+	}
+	return hosts, nil
+}
+
+// tenantFor resolves the tenant for a request from its Host header
+func (g *Gateway) tenantFor(r *http.Request) string {
+	return g.tenantForHost(r.Host)
+}
 
-	// Step 6: Forward the request (proxy does this)
-	proxy.ServeHTTP(w, r)
+// tenantForHost resolves the tenant that owns a hostname
+func (g *Gateway) tenantForHost(host string) string {
+	tenantID, _ := g.config.tenantForHost(host)
+	return tenantID
 }
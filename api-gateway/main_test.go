@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"shared/httpx"
+	"shared/logging"
+	"testing"
+	"time"
+)
+
+// newTestGateway returns a Gateway proxying to services with the default timeouts,
+// as main sets one up but without passive ejection, caching or rate limits
+func newTestGateway(services map[string]string) *Gateway {
+	timeouts := upstreamTimeouts{Connect: 5 * time.Second, TLSHandshake: 10 * time.Second, ResponseHeader: 20 * time.Second, Total: 30 * time.Second}
+	g := &Gateway{
+		config:          newConfigStore(10),
+		logs:            logging.NewSampler(logging.DefaultWindow),
+		history:         newHealthHistory(10),
+		upstreamTimeout: timeouts.Total,
+		transport:       newUpstreamTransport(timeouts),
+		slashPolicy:     slashPreserve,
+		outliers:        newOutlierDetector(outlierConfig{MinRequests: 20, Window: 30 * time.Second, Cooldown: 30 * time.Second}, nil),
+	}
+	g.applyConfig(runtimeConfig{Services: services, DefaultTenant: "default"}, "test", "test", "")
+	return g
+}
+
+// newHealthGateway returns a Gateway routing to services, as much of one as the
+// health checks need
+func newHealthGateway(services map[string]string) *Gateway {
+	g := &Gateway{history: newHealthHistory(10)}
+	g.services.replace(services)
+	return g
+}
+
+func TestServiceHealthCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer healthy.Close()
+
+	// A closed server refuses connections, as a backend that is down does
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	g := newHealthGateway(map[string]string{"users": healthy.URL, "products": down.URL})
+
+	tests := []struct {
+		service string
+		want    int
+		status  string // of the body; empty for an unknown service
+	}{
+		{service: "users", want: http.StatusOK, status: "healthy"},
+		{service: "products", want: http.StatusServiceUnavailable, status: "unhealthy"},
+		{service: "orders", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/health/"+tt.service, nil)
+			r.SetPathValue("service", tt.service)
+			w := httptest.NewRecorder()
+			g.serviceHealthCheck(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.status == "" {
+				return
+			}
+			var health serviceHealth
+			if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil {
+				t.Fatal(err)
+			}
+			if health.Name != tt.service || health.Status != tt.status {
+				t.Errorf("health = %+v, want %s %s", health, tt.service, tt.status)
+			}
+		})
+	}
+}
+
+func TestRouteRequestStreamsLargeBody(t *testing.T) {
+	const chunk = 1 << 20 // 1MB
+	const total = 8 * chunk
+
+	body := bytes.Repeat([]byte("id,name,price\n"), total/14+1)[:total]
+	want := sha256.Sum256(body)
+
+	firstChunk := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadFull(r.Body, make([]byte, chunk)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		close(firstChunk)
+
+		// The first chunk was read above; hash the whole body the backend saw
+		h := sha256.New()
+		h.Write(body[:chunk])
+		n, err := io.Copy(h, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"bytes": n + chunk, "sha256": h.Sum(nil)})
+	}))
+	defer backend.Close()
+
+	gateway := httptest.NewServer(http.HandlerFunc(newTestGateway(map[string]string{"products": backend.URL}).routeRequest))
+	defer gateway.Close()
+
+	// The rest of the body is only sent once the backend has the first chunk, which
+	// can't happen if anything between them waits for the whole body
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(body[:chunk])
+		select {
+		case <-firstChunk:
+			pw.Write(body[chunk:])
+			pw.Close()
+		case <-time.After(5 * time.Second):
+			pw.CloseWithError(errors.New("the backend got nothing while the body was being sent"))
+		}
+	}()
+
+	resp, err := http.Post(gateway.URL+"/api/products/import", "text/csv", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d, want 200: %s", resp.StatusCode, msg)
+	}
+	var got struct {
+		Bytes  int64  `json:"bytes"`
+		SHA256 []byte `json:"sha256"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Bytes != total || !bytes.Equal(got.SHA256, want[:]) {
+		t.Errorf("backend got %d bytes (sha256 %x), want %d bytes (sha256 %x)", got.Bytes, got.SHA256, total, want)
+	}
+}
+
+func TestTenantForHost(t *testing.T) {
+	hosts, err := parseTenantHosts("shop.example.com=default, Brand-B.example.com=brand-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newTestGateway(nil)
+	g.applyConfig(runtimeConfig{TenantHosts: hosts, DefaultTenant: "default"}, "test", "test", "")
+
+	tests := map[string]string{
+		"shop.example.com":         "default",
+		"brand-b.example.com":      "brand-b",
+		"BRAND-B.example.com:8443": "brand-b",
+		"unknown.example.com":      "default",
+	}
+	for host, want := range tests {
+		if got := g.tenantForHost(host); got != want {
+			t.Errorf("tenantForHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	if _, err := parseTenantHosts("shop.example.com"); err == nil {
+		t.Error("parseTenantHosts accepted an entry without a tenant")
+	}
+}
+
+func TestRouteRequestForwardsDeadlineBudget(t *testing.T) {
+	var got time.Time
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = httpx.ParseDeadline(r.Header.Get(httpx.DeadlineHeader))
+	}))
+	defer backend.Close()
+	g := newTestGateway(map[string]string{"products": backend.URL})
+
+	tests := []struct {
+		name   string
+		client time.Duration // the client's own budget; 0 for none
+		want   time.Duration
+	}{
+		{name: "no client deadline", want: g.upstreamTimeout},
+		{name: "sooner client deadline", client: 2 * time.Second, want: 2 * time.Second},
+		{name: "later client deadline", client: time.Hour, want: g.upstreamTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			r := httptest.NewRequest(http.MethodGet, "/api/products/5", nil)
+			if tt.client > 0 {
+				httpx.SetDeadline(r.Header, start.Add(tt.client))
+			}
+			g.routeRequest(httptest.NewRecorder(), r)
+
+			if got.IsZero() {
+				t.Fatal("the backend got no deadline")
+			}
+			if d := got.Sub(start); d > tt.want+time.Second || d < tt.want-time.Second {
+				t.Errorf("deadline is %v away, want %v", d, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteRequestForwardsClientIP(t *testing.T) {
+	// The backend trusts the gateway and the load balancer in front of it, as the
+	// services do, and reads the client address only through httpx.ClientIP
+	var forwarded, clientIP string
+	backendTrusts, _ := httpx.ParseTrustedProxies("127.0.0.1,10.0.0.0/8")
+	backend := httptest.NewServer(httpx.ClientIPs(backendTrusts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, clientIP = r.Header.Get("X-Forwarded-For"), httpx.ClientIP(r)
+	})))
+	defer backend.Close()
+
+	g := newTestGateway(map[string]string{"users": backend.URL})
+	g.trustedProxies, _ = httpx.ParseTrustedProxies("10.0.0.0/8")
+
+	tests := []struct {
+		name, remote, xff string
+		wantForwarded     string
+		wantClient        string
+	}{
+		{name: "untrusted peer's header is dropped", remote: "203.0.113.9:5000", xff: "198.51.100.1",
+			wantForwarded: "203.0.113.9", wantClient: "203.0.113.9"},
+		{name: "trusted load balancer's hop is kept", remote: "10.0.0.1:5000", xff: "203.0.113.5",
+			wantForwarded: "203.0.113.5, 10.0.0.1", wantClient: "203.0.113.5"},
+		{name: "IPv6 client behind load balancer", remote: "10.0.0.1:5000", xff: "2001:db8::1",
+			wantForwarded: "2001:db8::1, 10.0.0.1", wantClient: "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Set("X-Forwarded-For", tt.xff)
+			g.routeRequest(httptest.NewRecorder(), r)
+
+			if forwarded != tt.wantForwarded || clientIP != tt.wantClient {
+				t.Errorf("backend got X-Forwarded-For %q and client %q, want %q and %q", forwarded, clientIP, tt.wantForwarded, tt.wantClient)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// outlierBuckets is how many slices the rolling window is kept in
+const outlierBuckets = 10
+
+var (
+	outlierEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_outlier_events_total",
+		Help: "Passive health events by service: ejected, readmitted or probe_failed.",
+	}, []string{"service", "event"})
+	upstreamEjected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_upstream_ejected",
+		Help: "1 while a backend is ejected by passive health checks, otherwise 0.",
+	}, []string{"service"})
+)
+
+// outlierConfig configures passive health checks, read from the environment:
+//
+//	OUTLIER_ERROR_RATE    fraction of failed requests that ejects a backend, default 0.5; 0 disables ejection
+//	OUTLIER_MIN_REQUESTS  requests needed in the window before the rate is trusted, default 20
+//	OUTLIER_WINDOW        how far back requests are counted, default 30s
+//	OUTLIER_COOLDOWN      time before an ejected backend is probed for readmission, default 30s
+type outlierConfig struct {
+	ErrorRate   float64
+	MinRequests int
+	Window      time.Duration
+	Cooldown    time.Duration
+}
+
+func outlierConfigFromEnv() (outlierConfig, error) {
+	cfg := outlierConfig{ErrorRate: 0.5, MinRequests: 20, Window: 30 * time.Second, Cooldown: 30 * time.Second}
+
+	if raw := os.Getenv("OUTLIER_ERROR_RATE"); raw != "" {
+		rate, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return cfg, fmt.Errorf("invalid OUTLIER_ERROR_RATE %q (want 0 to 1)", raw)
+		}
+		cfg.ErrorRate = rate
+	}
+	if raw := os.Getenv("OUTLIER_MIN_REQUESTS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid OUTLIER_MIN_REQUESTS %q", raw)
+		}
+		cfg.MinRequests = n
+	}
+	for _, d := range []struct {
+		env string
+		dst *time.Duration
+	}{{"OUTLIER_WINDOW", &cfg.Window}, {"OUTLIER_COOLDOWN", &cfg.Cooldown}} {
+		if raw := os.Getenv(d.env); raw != "" {
+			v, err := time.ParseDuration(raw)
+			if err != nil || v <= 0 {
+				return cfg, fmt.Errorf("invalid %s %q", d.env, raw)
+			}
+			*d.dst = v
+		}
+	}
+	return cfg, nil
+}
+
+// outlierDetector tracks the error rate and latency of real proxied traffic per
+// backend, and ejects a backend that fails too often so requests to it are
+// answered 503 straight away instead of piling onto it. After a cooldown the
+// backend is probed and readmitted if it answers. This is independent of the
+// periodic active checks, which can miss a backend failing between runs.
+type outlierDetector struct {
+	cfg   outlierConfig
+	now   func() time.Time
+	probe func(ctx context.Context, service string) bool // reports whether service is fit to readmit
+
+	mu        sync.Mutex
+	upstreams map[string]*upstreamStats
+}
+
+type upstreamStats struct {
+	buckets   [outlierBuckets]statsBucket
+	ejected   bool
+	probeAt   time.Time // when an ejected backend is next probed
+	ejections int
+}
+
+// statsBucket counts requests in one slice of the window
+type statsBucket struct {
+	start    time.Time
+	requests int
+	errors   int
+	latency  time.Duration // total, for the mean
+}
+
+func newOutlierDetector(cfg outlierConfig, probe func(ctx context.Context, service string) bool) *outlierDetector {
+	return &outlierDetector{cfg: cfg, now: time.Now, probe: probe, upstreams: map[string]*upstreamStats{}}
+}
+
+// admit reports whether a request may be sent to service, and if not, how long
+// until the backend is next probed
+func (d *outlierDetector) admit(service string) (bool, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u, ok := d.upstreams[service]
+	if !ok || !u.ejected {
+		return true, 0
+	}
+	return false, max(u.probeAt.Sub(d.now()), 0)
+}
+
+// observe records the outcome of one proxied request. failed means a transport
+// error or a 5xx from the backend; requests the client cancelled aren't observed.
+func (d *outlierDetector) observe(service string, failed bool, latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	u := d.stats(service)
+	if u.ejected {
+		// Requests already in flight when the backend was ejected
+		return
+	}
+	now := d.now()
+	b := d.bucket(u, now)
+	b.requests++
+	b.latency += latency
+	if failed {
+		b.errors++
+	}
+
+	if d.cfg.ErrorRate == 0 || !failed {
+		return
+	}
+	requests, errors, _ := d.totals(u, now)
+	if requests < d.cfg.MinRequests || float64(errors)/float64(requests) < d.cfg.ErrorRate {
+		return
+	}
+
+	u.ejected = true
+	u.ejections++
+	u.probeAt = now.Add(d.cfg.Cooldown)
+	upstreamEjected.WithLabelValues(service).Set(1)
+	outlierEvents.WithLabelValues(service, "ejected").Inc()
+	log.Printf("[Outlier] Ejected %s: %d of %d requests failed in the last %s; probing again in %s",
+		service, errors, requests, d.cfg.Window, d.cfg.Cooldown)
+	time.AfterFunc(d.cfg.Cooldown, func() { d.tryReadmit(service) })
+}
+
+// tryReadmit probes an ejected backend, readmitting it with a clean window if it
+// answers and otherwise waiting another cooldown
+func (d *outlierDetector) tryReadmit(service string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ok := d.probe(ctx, service)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u := d.stats(service)
+	if !ok {
+		u.probeAt = d.now().Add(d.cfg.Cooldown)
+		outlierEvents.WithLabelValues(service, "probe_failed").Inc()
+		log.Printf("[Outlier] %s failed its readmission probe; probing again in %s", service, d.cfg.Cooldown)
+		time.AfterFunc(d.cfg.Cooldown, func() { d.tryReadmit(service) })
+		return
+	}
+
+	u.ejected = false
+	u.buckets = [outlierBuckets]statsBucket{}
+	upstreamEjected.WithLabelValues(service).Set(0)
+	outlierEvents.WithLabelValues(service, "readmitted").Inc()
+	log.Printf("[Outlier] Readmitted %s after a successful probe", service)
+}
+
+// stats returns the entry for service, creating it; d.mu must be held
+func (d *outlierDetector) stats(service string) *upstreamStats {
+	u, ok := d.upstreams[service]
+	if !ok {
+		u = &upstreamStats{}
+		d.upstreams[service] = u
+	}
+	return u
+}
+
+// bucket returns the bucket now falls in, clearing it if it held an older slice
+func (d *outlierDetector) bucket(u *upstreamStats, now time.Time) *statsBucket {
+	width := d.cfg.Window / outlierBuckets
+	start := now.Truncate(width)
+	b := &u.buckets[(start.UnixNano()/int64(width))%outlierBuckets]
+	if !b.start.Equal(start) {
+		*b = statsBucket{start: start}
+	}
+	return b
+}
+
+// totals sums the buckets still inside the window
+func (d *outlierDetector) totals(u *upstreamStats, now time.Time) (requests, errors int, latency time.Duration) {
+	cutoff := now.Add(-d.cfg.Window)
+	for _, b := range u.buckets {
+		if b.start.After(cutoff) {
+			requests += b.requests
+			errors += b.errors
+			latency += b.latency
+		}
+	}
+	return requests, errors, latency
+}
+
+// upstreamReport is one backend's entry in /admin/stats
+type upstreamReport struct {
+	Service       string     `json:"service"`
+	Ejected       bool       `json:"ejected"`
+	NextProbe     *time.Time `json:"next_probe,omitempty"`
+	Ejections     int        `json:"ejections"`
+	Requests      int        `json:"requests"` // in the window
+	Errors        int        `json:"errors"`
+	ErrorRate     float64    `json:"error_rate"`
+	MeanLatencyMS float64    `json:"mean_latency_ms"`
+}
+
+// report summarises every backend seen so far
+func (d *outlierDetector) report() []upstreamReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	out := []upstreamReport{}
+	for service, u := range d.upstreams {
+		requests, errors, latency := d.totals(u, now)
+		r := upstreamReport{Service: service, Ejected: u.ejected, Ejections: u.ejections, Requests: requests, Errors: errors}
+		if u.ejected {
+			probeAt := u.probeAt.UTC()
+			r.NextProbe = &probeAt
+		}
+		if requests > 0 {
+			r.ErrorRate = float64(errors) / float64(requests)
+			r.MeanLatencyMS = float64(latency.Microseconds()) / 1000 / float64(requests)
+		}
+		out = append(out, r)
+	}
+	slices.SortFunc(out, func(a, b upstreamReport) int { return cmp.Compare(a.Service, b.Service) })
+	return out
+}
+
+// statsHandler serves GET /admin/stats: passive health per backend over the
+// current window, alongside the active checks in /admin/health-history
+func (g *Gateway) statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"window":    g.outliers.cfg.Window.String(),
+		"upstreams": g.outliers.report(),
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"shared/httpx"
+	"strings"
+)
+
+// trailingSlash is what the gateway does with a trailing slash on /api/ paths
+type trailingSlash string
+
+const (
+	slashPreserve trailingSlash = "preserve" // forward the path as sent (default)
+	slashStrip    trailingSlash = "strip"    // /api/users/ → /users
+	slashAdd      trailingSlash = "add"      // /api/users → /users/
+)
+
+// trailingSlashFromEnv reads TRAILING_SLASH: preserve, strip or add
+func trailingSlashFromEnv() (trailingSlash, error) {
+	switch raw := trailingSlash(strings.ToLower(os.Getenv("TRAILING_SLASH"))); raw {
+	case "", slashPreserve:
+		return slashPreserve, nil
+	case slashStrip, slashAdd:
+		return raw, nil
+	default:
+		return slashPreserve, fmt.Errorf("invalid TRAILING_SLASH %q (want preserve, strip or add)", raw)
+	}
+}
+
+var errDotSegment = errors.New("path must not contain .. segments")
+
+// normalizePath cleans an escaped request path: duplicate slashes are collapsed,
+// "." segments are dropped and the trailing slash policy is applied. Escapes are
+// kept as sent, so an encoded slash inside an ID stays part of that segment.
+// Paths with a ".." segment, in any encoding, are rejected rather than resolved.
+func normalizePath(escaped string, slash trailingSlash) (string, error) {
+	trailing := strings.HasSuffix(escaped, "/")
+
+	var segments []string
+	for _, seg := range strings.Split(escaped, "/") {
+		if seg == "" {
+			continue
+		}
+		decoded, err := url.PathUnescape(seg)
+		if err != nil {
+			return "", fmt.Errorf("malformed escape in path segment %q", seg)
+		}
+		if decoded == "." {
+			continue
+		}
+		// An encoded slash can smuggle a traversal into one segment, e.g. ..%2Fadmin
+		for _, part := range strings.Split(decoded, "/") {
+			if part == ".." {
+				return "", errDotSegment
+			}
+		}
+		segments = append(segments, seg)
+	}
+
+	path := "/" + strings.Join(segments, "/")
+	switch {
+	case len(segments) == 0:
+		return "/", nil
+	case slash == slashStrip:
+		trailing = false
+	case slash == slashAdd:
+		trailing = true
+	}
+	if trailing {
+		path += "/"
+	}
+	return path, nil
+}
+
+// setEscapedPath points u at an escaped path, keeping Path and RawPath in step so
+// encoded characters survive the reverse proxy
+func setEscapedPath(u *url.URL, escaped string) error {
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return err
+	}
+	u.Path = path
+	u.RawPath = escaped
+	return nil
+}
+
+// normalizeAPIPaths normalizes /api/ paths before the mux sees them. The mux would
+// otherwise answer unclean paths such as /api//users with a redirect, which many
+// clients don't follow for writes, and would resolve ".." rather than reject it.
+func (g *Gateway) normalizeAPIPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escaped := r.URL.EscapedPath()
+		if !strings.HasPrefix(escaped, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path, err := normalizePath(escaped, g.slashPolicy)
+		// Leave /api itself to the mux, which has no route for it
+		if err == nil && path != escaped && strings.HasPrefix(path, "/api/") {
+			err = setEscapedPath(r.URL, path)
+		}
+		if err != nil {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		name, path string
+		slash      trailingSlash
+		want       string // empty when the path is refused
+	}{
+		{name: "clean", path: "/api/users/5", slash: slashPreserve, want: "/api/users/5"},
+		{name: "root", path: "/", slash: slashAdd, want: "/"},
+		{name: "only slashes", path: "///", slash: slashStrip, want: "/"},
+		{name: "doubled slash", path: "/api//users//5", slash: slashPreserve, want: "/api/users/5"},
+		{name: "dot segment", path: "/api/./users/.", slash: slashPreserve, want: "/api/users"},
+		{name: "dot segment before slash", path: "/api/users/./", slash: slashPreserve, want: "/api/users/"},
+		{name: "encoded dot segment", path: "/api/%2e/users/%2E/5", slash: slashPreserve, want: "/api/users/5"},
+		{name: "encoded slash kept", path: "/api/products/a%2Fb", slash: slashPreserve, want: "/api/products/a%2Fb"},
+		{name: "encoded slash not collapsed", path: "/api/products/a%2F%2Fb", slash: slashPreserve, want: "/api/products/a%2F%2Fb"},
+		{name: "preserve keeps trailing slash", path: "/api/users/", slash: slashPreserve, want: "/api/users/"},
+		{name: "strip drops trailing slash", path: "/api/users//", slash: slashStrip, want: "/api/users"},
+		{name: "add appends trailing slash", path: "/api/users", slash: slashAdd, want: "/api/users/"},
+		{name: "add after dot segment", path: "/api/users/.", slash: slashAdd, want: "/api/users/"},
+		{name: "dot-dot", path: "/api/users/../admin", slash: slashPreserve},
+		{name: "trailing dot-dot", path: "/api/users/..", slash: slashPreserve},
+		{name: "encoded dot-dot", path: "/api/users/%2E%2E/admin", slash: slashPreserve},
+		{name: "mixed encoded dot-dot", path: "/api/users/.%2e/admin", slash: slashPreserve},
+		{name: "dot-dot behind encoded slash", path: "/api/users/..%2Fadmin", slash: slashPreserve},
+		{name: "dot-dot inside encoded slashes", path: "/api/users%2F..%2Fadmin", slash: slashPreserve},
+		{name: "malformed escape", path: "/api/users/%zz", slash: slashPreserve},
+		{name: "truncated escape", path: "/api/users/5%2", slash: slashPreserve},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizePath(tt.path, tt.slash)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("normalizePath(%q) = %q, want an error", tt.path, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("normalizePath(%q, %s) = %q, %v, want %q", tt.path, tt.slash, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetEscapedPath(t *testing.T) {
+	tests := []struct {
+		escaped, path string
+		wantErr       bool
+	}{
+		{escaped: "/users/5", path: "/users/5"},
+		{escaped: "/products/a%2Fb", path: "/products/a/b"},
+		{escaped: "/products/a%20b", path: "/products/a b"},
+		{escaped: "/products/caf%C3%A9", path: "/products/café"},
+		{escaped: "/products/%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse("http://backend/old?q=1")
+		err := setEscapedPath(u, tt.escaped)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("setEscapedPath(%q): err = nil, want an error", tt.escaped)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The decoded path and the escaped one must agree, or the proxy re-escapes
+		if u.Path != tt.path || u.EscapedPath() != tt.escaped || u.RawQuery != "q=1" {
+			t.Errorf("setEscapedPath(%q): path %q, escaped %q, query %q, want %q, %q and q=1", tt.escaped, u.Path, u.EscapedPath(), u.RawQuery, tt.path, tt.escaped)
+		}
+	}
+}
+
+func TestTrailingSlashFromEnv(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    trailingSlash
+		wantErr bool
+	}{
+		{raw: "", want: slashPreserve},
+		{raw: "preserve", want: slashPreserve},
+		{raw: "Strip", want: slashStrip},
+		{raw: "ADD", want: slashAdd},
+		{raw: "remove", want: slashPreserve, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRAILING_SLASH", tt.raw)
+		got, err := trailingSlashFromEnv()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("TRAILING_SLASH=%q: got %s, %v, want %s (error %v)", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestRouteRequestForwardsCleanPath calls routeRequest without normalizeAPIPaths in
+// front, so the rewrite itself is shown to normalize. A malformed escape never gets
+// this far: the server refuses the request line.
+func TestRouteRequestForwardsCleanPath(t *testing.T) {
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.EscapedPath()
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name, path string
+		slash      trailingSlash
+		want       string // what the backend receives; empty when the gateway answers 400
+	}{
+		{name: "doubled slash", path: "/api/users//5", slash: slashPreserve, want: "/users/5"},
+		{name: "doubled slash after prefix", path: "/api//users/5", slash: slashPreserve, want: "/users/5"},
+		{name: "dot segment", path: "/api/users/./5", slash: slashPreserve, want: "/users/5"},
+		{name: "encoded dot segment", path: "/api/users/%2E/5", slash: slashPreserve, want: "/users/5"},
+		{name: "encoded slash", path: "/api/products/a%2Fb", slash: slashPreserve, want: "/products/a%2Fb"},
+		{name: "strip", path: "/api/users/5//", slash: slashStrip, want: "/users/5"},
+		{name: "add", path: "/api/users/5", slash: slashAdd, want: "/users/5/"},
+		{name: "preserve", path: "/api/users/5/", slash: slashPreserve, want: "/users/5/"},
+		{name: "dot-dot", path: "/api/users/../admin", slash: slashPreserve},
+		{name: "encoded dot-dot", path: "/api/users/%2E%2E/admin", slash: slashPreserve},
+		{name: "dot-dot behind encoded slash", path: "/api/users/..%2Fadmin", slash: slashPreserve},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(map[string]string{"users": backend.URL, "products": backend.URL})
+			g.slashPolicy = tt.slash
+			got = ""
+
+			w := httptest.NewRecorder()
+			g.routeRequest(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if tt.want == "" {
+				if w.Code != http.StatusBadRequest || got != "" {
+					t.Errorf("got %d with backend path %q, want 400 and nothing proxied", w.Code, got)
+				}
+				return
+			}
+			if w.Code != http.StatusOK || got != tt.want {
+				t.Errorf("got %d with backend path %q, want 200 with %q", w.Code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNastyPathsReachBackendAsExpected(t *testing.T) {
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.EscapedPath()
+	}))
+	defer backend.Close()
+
+	g := newTestGateway(map[string]string{"users": backend.URL, "products": backend.URL})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", g.routeRequest)
+	handler := g.normalizeAPIPaths(mux)
+
+	long := strings.Repeat("a", 4096)
+
+	tests := []struct {
+		name, path string
+		want       string // what the backend receives; empty when the gateway answers 400
+	}{
+		{name: "plain", path: "/api/users/5", want: "/users/5"},
+		{name: "trailing slash kept", path: "/api/users/", want: "/users/"},
+		{name: "duplicate slash in path", path: "/api/users//5", want: "/users/5"},
+		{name: "duplicate slash after prefix", path: "/api//users/5", want: "/users/5"},
+		{name: "many slashes", path: "/api///users///5//", want: "/users/5/"},
+		{name: "dot segment", path: "/api/users/./5", want: "/users/5"},
+		{name: "encoded dot segment", path: "/api/users/%2E/5", want: "/users/5"},
+		{name: "encoded slash in ID", path: "/api/products/a%2Fb", want: "/products/a%2Fb"},
+		{name: "encoded space", path: "/api/products/a%20b", want: "/products/a%20b"},
+		{name: "unicode", path: "/api/products/caf%C3%A9", want: "/products/caf%C3%A9"},
+		{name: "dots inside a segment", path: "/api/products/v1..2", want: "/products/v1..2"},
+		{name: "overlong segment", path: "/api/products/" + long, want: "/products/" + long},
+		{name: "dot-dot segment", path: "/api/users/../admin", want: ""},
+		{name: "encoded dot-dot", path: "/api/users/%2E%2E/admin", want: ""},
+		{name: "dot-dot behind encoded slash", path: "/api/users/..%2Fadmin", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if tt.want == "" {
+				if w.Code != http.StatusBadRequest || got != "" {
+					t.Errorf("got %d with backend path %q, want 400 and nothing proxied", w.Code, got)
+				}
+				return
+			}
+			if w.Code != http.StatusOK || got != tt.want {
+				t.Errorf("got %d with backend path %q, want 200 with %q", w.Code, got, tt.want)
+			}
+		})
+	}
+}
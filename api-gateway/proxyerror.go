@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Proxy error classes, returned to clients as the error code
+const (
+	classUnreachable    = "upstream_unreachable"
+	classConnectTimeout = "upstream_connect_timeout"
+	classTLSTimeout     = "upstream_tls_timeout"
+	classHeaderTimeout  = "upstream_header_timeout"
+	classTimeout        = "upstream_timeout"
+	classTLS            = "upstream_tls_error"
+	classReset          = "upstream_connection_reset"
+	classBody           = "upstream_body_error"
+	classCanceled       = "client_canceled"
+	classEjected        = "upstream_ejected" // failing passive health checks; see outlier.go
+	classOther          = "upstream_error"
+)
+
+// proxyErrors counts failed proxied requests by backend and class
+var proxyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_upstream_errors_total",
+	Help: "Proxied requests that failed, by service and error class.",
+}, []string{"service", "class"})
+
+// proxyFailure is how the gateway answers a class of proxy error
+type proxyFailure struct {
+	class  string
+	status int // 0 if nothing can be sent, e.g. the client is gone or headers were already written
+	msg    string
+}
+
+// classifyProxyError decides how to answer a reverse proxy error. headersSent
+// reports whether the backend's response had already started reaching the client.
+//
+// The status tells clients whether a retry is safe: 503 means the request never
+// reached the backend, while 502 (the connection broke) and 504 (no answer in
+// time) mean it may have been processed.
+func classifyProxyError(err error, headersSent bool) proxyFailure {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case headersSent:
+		return proxyFailure{class: classBody}
+	case errors.Is(err, context.Canceled):
+		return proxyFailure{class: classCanceled}
+	case errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout():
+		return proxyFailure{class: classConnectTimeout, status: http.StatusServiceUnavailable, msg: "Service unavailable"}
+	// net/http doesn't export its TLS handshake and response header timeout errors
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return proxyFailure{class: classTLSTimeout, status: http.StatusServiceUnavailable, msg: "Service unavailable"}
+	case strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return proxyFailure{class: classHeaderTimeout, status: http.StatusGatewayTimeout, msg: "Service timed out"}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return proxyFailure{class: classTimeout, status: http.StatusGatewayTimeout, msg: "Service timed out"}
+	case errors.As(err, &recordErr), errors.As(err, &verifyErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return proxyFailure{class: classTLS, status: http.StatusBadGateway, msg: "Service TLS handshake failed"}
+	case errors.As(err, &dnsErr), errors.Is(err, syscall.ECONNREFUSED),
+		errors.As(err, &opErr) && opErr.Op == "dial":
+		return proxyFailure{class: classUnreachable, status: http.StatusServiceUnavailable, msg: "Service unavailable"}
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return proxyFailure{class: classReset, status: http.StatusBadGateway, msg: "Service closed the connection"}
+	default:
+		return proxyFailure{class: classOther, status: http.StatusBadGateway, msg: "Bad gateway"}
+	}
+}
+
+// headerTracker records whether a response has started, so proxy errors that
+// happen mid-response are only logged rather than written over the body
+type headerTracker struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (t *headerTracker) WriteHeader(code int) {
+	t.wroteHeader = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *headerTracker) Write(b []byte) (int, error) {
+	t.wroteHeader = true
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *headerTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
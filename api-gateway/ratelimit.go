@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"shared/jwt"
+	"shared/logging"
+	"shared/redis"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_rate_limited_total",
+	Help: "Requests rejected with 429 by the rate limiter, by backend and principal type (user or ip).",
+}, []string{"backend", "principal"})
+
+// Principal types requests are counted against
+const (
+	principalUser = "user" // the subject of a verified bearer token, or DEV_PRINCIPAL
+	principalIP   = "ip"   // anonymous traffic, by httpx.ClientIP
+)
+
+// quota is a token bucket refilled at Rate tokens per second and holding up to Burst
+type quota struct {
+	Rate  float64 `json:"rate"` // 0 means unlimited
+	Burst int     `json:"burst"`
+}
+
+// rateLimiter decides whether a client may make another request. Each key gets
+// its own bucket, sized by the quota passed with it.
+type rateLimiter interface {
+	// Allow takes a token for key. If none is left it reports how long until one is.
+	Allow(ctx context.Context, key string, q quota) (ok bool, retryAfter time.Duration, err error)
+	// Name identifies the backend in logs and metrics
+	Name() string
+}
+
+// rateLimitConfig is read from the environment:
+//
+//	RATE_LIMIT_RPS         requests per second per anonymous client IP; unset or 0 leaves them unlimited
+//	RATE_LIMIT_BURST       bucket size, default RATE_LIMIT_RPS rounded up
+//	RATE_LIMIT_USER_RPS    requests per second per authenticated user, default RATE_LIMIT_RPS;
+//	                       users behind one NAT address no longer share a bucket
+//	RATE_LIMIT_USER_BURST  bucket size, default RATE_LIMIT_USER_RPS rounded up
+//	RATE_LIMIT_OVERRIDES   quotas for particular principals, e.g. "user:42=50/100,ip:198.51.100.7=20"
+//	                       (rate per second, optionally /burst)
+//	RATE_LIMIT_BACKEND     memory (default, per replica) or redis (shared by all replicas)
+//	REDIS_URL              redis://[user:password@]host:port[/db], required for the redis backend
+type rateLimitConfig struct {
+	rateLimitQuotas
+	Backend  string
+	RedisURL string
+}
+
+// rateLimitQuotas are the quotas of rateLimitConfig; unlike the backend they can
+// be replaced while the gateway runs, through /admin/config/import
+type rateLimitQuotas struct {
+	IP        quota            `json:"ip"`
+	User      quota            `json:"user"`
+	Overrides map[string]quota `json:"overrides"` // by key, "user:<id>" or "ip:<address>"
+}
+
+func rateLimitConfigFromEnv() (rateLimitConfig, error) {
+	cfg := rateLimitConfig{Backend: os.Getenv("RATE_LIMIT_BACKEND"), RedisURL: os.Getenv("REDIS_URL")}
+
+	var err error
+	if cfg.IP, err = quotaFromEnv("RATE_LIMIT_RPS", "RATE_LIMIT_BURST", quota{}); err != nil {
+		return cfg, err
+	}
+	if cfg.User, err = quotaFromEnv("RATE_LIMIT_USER_RPS", "RATE_LIMIT_USER_BURST", cfg.IP); err != nil {
+		return cfg, err
+	}
+	if cfg.Overrides, err = parseQuotaOverrides(os.Getenv("RATE_LIMIT_OVERRIDES")); err != nil {
+		return cfg, err
+	}
+
+	switch cfg.Backend {
+	case "":
+		cfg.Backend = "memory"
+	case "memory", "redis":
+	default:
+		return cfg, fmt.Errorf("invalid RATE_LIMIT_BACKEND %q (want memory or redis)", cfg.Backend)
+	}
+	return cfg, nil
+}
+
+// quotaFromEnv reads a rate and burst pair. Without the rate variable the
+// fallback quota applies; without the burst one it is the rate rounded up.
+func quotaFromEnv(rateEnv, burstEnv string, fallback quota) (quota, error) {
+	q := fallback
+	if raw := os.Getenv(rateEnv); raw != "" {
+		rate, err := parseRate(raw)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s %q", rateEnv, raw)
+		}
+		q = quota{Rate: rate, Burst: int(math.Ceil(rate))}
+	}
+	if raw := os.Getenv(burstEnv); raw != "" {
+		burst, err := strconv.Atoi(raw)
+		if err != nil || burst < 1 {
+			return q, fmt.Errorf("invalid %s %q", burstEnv, raw)
+		}
+		q.Burst = burst
+	}
+	return q, nil
+}
+
+// parseQuotaOverrides parses RATE_LIMIT_OVERRIDES
+func parseQuotaOverrides(raw string) (map[string]quota, error) {
+	overrides := map[string]quota{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		kind, id, _ := strings.Cut(key, ":")
+		if !ok || id == "" || (kind != principalUser && kind != principalIP) {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_OVERRIDES entry %q (want user:<id>=rate[/burst] or ip:<address>=rate[/burst])", entry)
+		}
+		rawRate, rawBurst, hasBurst := strings.Cut(value, "/")
+		rate, err := parseRate(rawRate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate in RATE_LIMIT_OVERRIDES entry %q", entry)
+		}
+		q := quota{Rate: rate, Burst: int(math.Ceil(rate))}
+		if hasBurst {
+			if q.Burst, err = strconv.Atoi(rawBurst); err != nil || q.Burst < 1 {
+				return nil, fmt.Errorf("invalid burst in RATE_LIMIT_OVERRIDES entry %q", entry)
+			}
+		}
+		overrides[key] = q
+	}
+	return overrides, nil
+}
+
+func parseRate(raw string) (float64, error) {
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return 0, fmt.Errorf("invalid rate %q", raw)
+	}
+	return rate, nil
+}
+
+// enabled reports whether any principal is limited
+func (c rateLimitQuotas) enabled() bool {
+	return c.IP.Rate > 0 || c.User.Rate > 0 || len(c.Overrides) > 0
+}
+
+// quotaFor returns the quota of the principal counted under key, of the given type
+func (c rateLimitQuotas) quotaFor(kind, key string) quota {
+	if q, ok := c.Overrides[key]; ok {
+		return q
+	}
+	if kind == principalUser {
+		return c.User
+	}
+	return c.IP
+}
+
+// newRateLimiter builds the configured limiter, or returns nil if limiting is off.
+// The redis backend falls back to memory when REDIS_URL is unset.
+func newRateLimiter(cfg rateLimitConfig) (rateLimiter, error) {
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	if cfg.Backend == "redis" {
+		if cfg.RedisURL == "" {
+			log.Printf("WARNING: RATE_LIMIT_BACKEND=redis but REDIS_URL is unset; rate limits apply per replica")
+		} else {
+			client, err := redis.New(cfg.RedisURL)
+			if err != nil {
+				return nil, err
+			}
+			return newRedisLimiter(client), nil
+		}
+	}
+	return newMemoryLimiter(), nil
+}
+
+// rateLimit answers 429 with Retry-After once a client has used up its bucket.
+// The response names the quota that was hit in X-RateLimit-Limit (requests per
+// second) and X-RateLimit-Burst. If the limiter itself fails the request is let
+// through: an unreachable Redis shouldn't take the API down with it.
+func (g *Gateway) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	if g.limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		kind, key := g.rateKey(r)
+		q := g.config.rateLimits().quotaFor(kind, key)
+		if q.Rate == 0 {
+			next(w, r)
+			return
+		}
+
+		ok, retryAfter, err := g.limiter.Allow(r.Context(), key, q)
+		if err != nil {
+			g.logs.Printf(logging.Key{Message: "rate limiter error", Service: g.limiter.Name(), Class: logging.ErrorClass(err)},
+				"[RateLimit] %s limiter failed, allowing request: %v", g.limiter.Name(), err)
+			next(w, r)
+			return
+		}
+		if !ok {
+			rateLimited.WithLabelValues(g.limiter.Name(), kind).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatFloat(q.Rate, 'f', -1, 64))
+			w.Header().Set("X-RateLimit-Burst", strconv.Itoa(q.Burst))
+			httpx.ErrorCode(w, http.StatusTooManyRequests, "rate_limited", "Too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// rateKey picks the principal a request is counted against, returning its type
+// and key: the user of a verified bearer token (or DEV_PRINCIPAL), otherwise the
+// client address from httpx.ClientIP. A token that doesn't verify counts against
+// the address, since anyone can send one; the request is refused later anyway.
+func (g *Gateway) rateKey(r *http.Request) (kind, key string) {
+	if token, ok := bearerToken(r); ok && g.jwtKey != nil {
+		if claims, err := jwt.Verify(token, g.jwtKey, time.Now()); err == nil && claims.Subject != "" {
+			return principalUser, principalUser + ":" + claims.Subject
+		}
+	} else if id, _, _ := strings.Cut(g.devPrincipal, ":"); id != "" {
+		return principalUser, principalUser + ":" + id
+	}
+	return principalIP, principalIP + ":" + httpx.ClientIP(r)
+}
+
+// memoryLimiter keeps buckets in this process, so each replica limits separately
+type memoryLimiter struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time // when tokens was last brought up to date
+	q      quota     // as last used, for sweep
+}
+
+func newMemoryLimiter() *memoryLimiter {
+	return &memoryLimiter{now: time.Now, buckets: map[string]*bucket{}}
+}
+
+func (l *memoryLimiter) Name() string { return "memory" }
+
+func (l *memoryLimiter) Allow(_ context.Context, key string, q quota) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	burst := float64(q.Burst)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.at).Seconds()*q.Rate)
+	b.at = now
+	b.q = q
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / q.Rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// sweep drops buckets that have refilled completely, since they are the same as
+// no bucket at all; it runs at most once a minute. l.mu must be held.
+func (l *memoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*b.q.Rate >= float64(b.q.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// tokenBucketScript takes a token from the bucket at KEYS[1] atomically, using the
+// server's clock so replicas with skewed clocks agree. ARGV is rate (tokens per
+// second) and burst. It returns {allowed, milliseconds until the next token}.
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1]) or burst
+local at = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + (now - at) * rate / 1000)
+
+local allowed, wait = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`
+
+// redisLimiter keeps buckets in Redis, so limits hold across every gateway replica
+type redisLimiter struct {
+	client *redis.Client
+	sha    string // SHA1 of tokenBucketScript, for EVALSHA
+}
+
+func newRedisLimiter(client *redis.Client) *redisLimiter {
+	return &redisLimiter{client: client, sha: redis.ScriptSHA(tokenBucketScript)}
+}
+
+func (l *redisLimiter) Name() string { return "redis" }
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, q quota) (bool, time.Duration, error) {
+	rate, burst := strconv.FormatFloat(q.Rate, 'f', -1, 64), strconv.Itoa(q.Burst)
+	reply, err := l.client.Do(ctx, "EVALSHA", l.sha, "1", "ratelimit:"+key, rate, burst)
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) && replyErr.NoScript() {
+		// First use on this server (or after a restart); EVAL also caches the script
+		reply, err = l.client.Do(ctx, "EVAL", tokenBucketScript, "1", "ratelimit:"+key, rate, burst)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
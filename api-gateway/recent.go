@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentRequest summarises one request for GET /admin/recent
+type recentRequest struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"` // without the query, which may carry tokens or personal data
+	Service    string    `json:"service"`
+	Status     int       `json:"status"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// recentRequests keeps the last few requests in a fixed-size ring, so a live
+// problem can be looked at without any log infrastructure. Adding overwrites the
+// oldest entry in place; the lock is held only to copy one struct in or out.
+type recentRequests struct {
+	mu    sync.Mutex
+	ring  []recentRequest
+	next  int // where the next entry goes
+	count int // entries held, up to len(ring)
+}
+
+// recentSizeFromEnv reads RECENT_REQUESTS_SIZE, the number of requests kept for
+// /admin/recent; default 200, 0 turns it off
+func recentSizeFromEnv() (int, error) {
+	raw := os.Getenv("RECENT_REQUESTS_SIZE")
+	if raw == "" {
+		return 200, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid RECENT_REQUESTS_SIZE %q", raw)
+	}
+	return n, nil
+}
+
+func newRecentRequests(size int) *recentRequests {
+	if size == 0 {
+		return nil
+	}
+	return &recentRequests{ring: make([]recentRequest, size)}
+}
+
+func (rr *recentRequests) add(req recentRequest) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.ring[rr.next] = req
+	rr.next = (rr.next + 1) % len(rr.ring)
+	rr.count = min(rr.count+1, len(rr.ring))
+}
+
+// snapshot returns the requests held, newest first
+func (rr *recentRequests) snapshot() []recentRequest {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	out := make([]recentRequest, rr.count)
+	for i := range out {
+		out[i] = rr.ring[(rr.next-1-i+len(rr.ring))%len(rr.ring)]
+	}
+	return out
+}
+
+// statusRecorder remembers the status a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// recordRecent adds every request through next to the recent requests ring
+func (g *Gateway) recordRecent(next http.HandlerFunc) http.HandlerFunc {
+	if g.recent == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			// Nothing written is the server's implicit 200, unless the client went
+			// away first, in which case it gets no status
+			status := rec.status
+			if status == 0 && r.Context().Err() == nil {
+				status = http.StatusOK
+			}
+			g.recent.add(recentRequest{
+				Time:       start.UTC(),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Service:    serviceSegment(r.URL.Path),
+				Status:     status,
+				DurationMS: time.Since(start).Milliseconds(),
+			})
+		}()
+		next(rec, r)
+	}
+}
+
+// serviceSegment returns the service named by an /api/ path, e.g. users for /api/users/1
+func serviceSegment(path string) string {
+	service, _, _ := strings.Cut(strings.TrimPrefix(path, "/api/"), "/")
+	return service
+}
+
+// recentHandler serves GET /admin/recent
+func (g *Gateway) recentHandler(w http.ResponseWriter, r *http.Request) {
+	requests := []recentRequest{}
+	if g.recent != nil {
+		requests = g.recent.snapshot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"requests": requests})
+}
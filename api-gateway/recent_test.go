@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// paths returns the paths of requests, in order
+func paths(requests []recentRequest) []string {
+	out := make([]string, len(requests))
+	for i, r := range requests {
+		out[i] = r.Path
+	}
+	return out
+}
+
+func TestRecentRequestsKeepsNewestN(t *testing.T) {
+	rr := newRecentRequests(3)
+	if got := rr.snapshot(); len(got) != 0 {
+		t.Fatalf("empty ring holds %v", paths(got))
+	}
+
+	tests := []struct {
+		add  int
+		want string
+	}{
+		{add: 1, want: "[/1]"},
+		{add: 2, want: "[/2 /1]"},
+		{add: 3, want: "[/3 /2 /1]"},
+		// Full: each add evicts the oldest
+		{add: 4, want: "[/4 /3 /2]"},
+		{add: 5, want: "[/5 /4 /3]"},
+		{add: 6, want: "[/6 /5 /4]"},
+		{add: 7, want: "[/7 /6 /5]"},
+	}
+	for _, tt := range tests {
+		rr.add(recentRequest{Path: fmt.Sprintf("/%d", tt.add)})
+		if got := fmt.Sprint(paths(rr.snapshot())); got != tt.want {
+			t.Errorf("after adding /%d: holds %s, want %s", tt.add, got, tt.want)
+		}
+	}
+}
+
+func TestRecentRequestsOfSizeOne(t *testing.T) {
+	rr := newRecentRequests(1)
+	rr.add(recentRequest{Path: "/1"})
+	rr.add(recentRequest{Path: "/2"})
+	if got := fmt.Sprint(paths(rr.snapshot())); got != "[/2]" {
+		t.Errorf("holds %s, want [/2]", got)
+	}
+}
+
+func TestRecentRequestsConcurrentAdds(t *testing.T) {
+	rr := newRecentRequests(50)
+	var wg sync.WaitGroup
+	for i := range 500 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr.add(recentRequest{Path: fmt.Sprintf("/%d", i)})
+			rr.snapshot()
+		}()
+	}
+	wg.Wait()
+	if got := len(rr.snapshot()); got != 50 {
+		t.Errorf("holds %d requests, want 50", got)
+	}
+}
+
+func TestRecordRecentAndHandler(t *testing.T) {
+	g := &Gateway{recent: newRecentRequests(2)}
+	handler := g.recordRecent(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/users/404" {
+			http.NotFound(w, r)
+		}
+	})
+	for _, target := range []string{"/api/users/1?email=ann@example.com", "/api/products/2", "/api/users/404"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	w := httptest.NewRecorder()
+	g.recentHandler(w, httptest.NewRequest(http.MethodGet, "/admin/recent", nil))
+	var body struct {
+		Requests []recentRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	// The first request was evicted, and its query would never have been kept
+	got := body.Requests
+	if len(got) != 2 || got[0].Path != "/api/users/404" || got[1].Path != "/api/products/2" {
+		t.Fatalf("requests = %+v, want /api/users/404 then /api/products/2", got)
+	}
+	if got[0].Status != http.StatusNotFound || got[0].Service != "users" || got[1].Status != http.StatusOK || got[1].Service != "products" {
+		t.Errorf("requests = %+v, want users 404 and products 200", got)
+	}
+}
+
+func TestRecentHandlerWhenOff(t *testing.T) {
+	g := &Gateway{recent: newRecentRequests(0)}
+	w := httptest.NewRecorder()
+	g.recentHandler(w, httptest.NewRequest(http.MethodGet, "/admin/recent", nil))
+	if w.Body.String() != "{\"requests\":[]}\n" {
+		t.Errorf("body = %s, want an empty list", w.Body)
+	}
+}
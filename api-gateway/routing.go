@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"shared/httpx"
+	"slices"
+	"strings"
+)
+
+// routeDecision is where the gateway sends a request, and why. It is produced
+// without any I/O so it can be shown by /admin/route-test as well as acted on.
+type routeDecision struct {
+	Method        string   `json:"method"`
+	Path          string   `json:"path"`
+	Host          string   `json:"host"`
+	Service       string   `json:"service,omitempty"`
+	Tenant        string   `json:"tenant,omitempty"`
+	Upstream      string   `json:"upstream,omitempty"`
+	RewrittenPath string   `json:"rewritten_path,omitempty"`
+	Status        int      `json:"status"`          // 200 if routable, otherwise what the gateway answers
+	Error         string   `json:"error,omitempty"` // message returned to the client when not routable
+	Trace         []string `json:"trace"`
+
+	target *url.URL
+}
+
+func (d *routeDecision) tracef(format string, args ...any) {
+	d.Trace = append(d.Trace, fmt.Sprintf(format, args...))
+}
+
+func (d *routeDecision) fail(status int, msg string) routeDecision {
+	d.Status = status
+	d.Error = msg
+	return *d
+}
+
+// decideRoute runs the routing rules for a request: path normalization, prefix
+// match, service lookup, tenant resolution from the host, and the path rewrite.
+// path is the escaped request path, so encoded characters survive the rewrite.
+func (g *Gateway) decideRoute(method, host, path string) routeDecision {
+	d := routeDecision{Method: method, Path: path, Host: host, Trace: []string{}}
+
+	normalized, err := normalizePath(path, g.slashPolicy)
+	if err != nil {
+		d.tracef("path %q rejected: %v", path, err)
+		return d.fail(http.StatusBadRequest, err.Error())
+	}
+	if normalized != path {
+		d.tracef("normalized path to %s (trailing slash: %s)", normalized, g.slashPolicy)
+		path = normalized
+	}
+
+	// Path validation - should already start with /api/ due to HandleFunc pattern
+	if !strings.HasPrefix(path, "/api/") {
+		d.tracef("path %q is missing the /api/ prefix", path)
+		return d.fail(http.StatusNotFound, "Invalid path")
+	}
+	d.tracef("matched prefix /api/")
+
+	// Extract service name from path
+	// Example: /api/users/123 → service = "users"
+	pathParts := strings.Split(path, "/")
+	if len(pathParts) < 3 || pathParts[2] == "" {
+		d.tracef("path %q has no service segment", path)
+		return d.fail(http.StatusNotFound, "Invalid path")
+	}
+	d.Service = pathParts[2]
+	d.tracef("service segment is %q", d.Service)
+
+	// Look up service URL
+	targetURL, exists := g.services.lookup(d.Service)
+	if !exists {
+		d.tracef("no service named %q (available: %s)", d.Service, strings.Join(g.serviceNames(), ", "))
+		return d.fail(http.StatusNotFound, "Service not found")
+	}
+	target, err := url.Parse(targetURL)
+	if err != nil || targetURL == "" {
+		d.tracef("upstream URL %q for %s is invalid: %v", targetURL, d.Service, err)
+		return d.fail(http.StatusNotFound, "Invalid URL")
+	}
+	d.Upstream = targetURL
+	d.target = target
+	d.tracef("upstream for %s is %s", d.Service, targetURL)
+	d.tracef("version resolution: not configured")
+	d.tracef("canary weighting: not configured")
+
+	tenantID, mapped := g.config.tenantForHost(host)
+	d.Tenant = tenantID
+	if mapped {
+		d.tracef("host %q maps to tenant %q", host, d.Tenant)
+	} else {
+		d.tracef("host %q has no tenant mapping; using default tenant %q", host, d.Tenant)
+	}
+
+	// Strip /api so the backend gets its own path
+	// Example: /api/users/123 → backend should see /users/123
+	d.RewrittenPath = "/" + strings.TrimPrefix(path, "/api/")
+	d.tracef("rewrote path to %s", d.RewrittenPath)
+
+	d.Status = http.StatusOK
+	return d
+}
+
+// serviceNames lists the configured services, for messages
+func (g *Gateway) serviceNames() []string {
+	return slices.Sorted(maps.Keys(g.services.snapshot()))
+}
+
+// routeTest serves GET /admin/route-test?method=GET&path=/api/users/5[&host=...],
+// showing how a request would be routed without proxying it. path is the escaped
+// request path, so an encoded slash is sent as %252F.
+func (g *Gateway) routeTest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	path := q.Get("path")
+	if path == "" {
+		httpx.Error(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	method := strings.ToUpper(q.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	host := q.Get("host")
+	if host == "" {
+		host = r.Host
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(g.decideRoute(method, host, path))
+}
+
+// normalizeHost lowercases a host and strips any port
+func normalizeHost(host string) string {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
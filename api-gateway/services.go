@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// serviceTable is the routing table, service name -> backend URL. It can be
+// replaced while the gateway runs, so readers never range over it in place:
+// anything that makes network calls works from a snapshot and holds no lock
+// while it waits, so a replacement never stalls routing behind a slow backend.
+type serviceTable struct {
+	mu   sync.RWMutex
+	urls map[string]string
+}
+
+// lookup returns the backend URL of a service
+func (t *serviceTable) lookup(name string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	url, ok := t.urls[name]
+	return url, ok
+}
+
+// snapshot returns a copy of the table that stays valid after a replacement
+func (t *serviceTable) snapshot() map[string]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return maps.Clone(t.urls)
+}
+
+// replace swaps in a new table; the map must not be modified afterwards
+func (t *serviceTable) replace(urls map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.urls = urls
+}
+
+// probeAll probes every backend in a snapshot of the table concurrently and
+// returns the results keyed by service name
+func (g *Gateway) probeAll(ctx context.Context, services map[string]string) map[string]serviceHealth {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]serviceHealth, len(services))
+	for name, url := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health := g.probe(ctx, name, url)
+			mu.Lock()
+			results[name] = health
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// mergeHealth matches probe results against the table as it is now, which may
+// have changed while the probes ran. Services removed since are dropped; services
+// added, or moved to another URL, are reported as unknown until the next check.
+func mergeHealth(results map[string]serviceHealth, current map[string]string) []serviceHealth {
+	merged := make([]serviceHealth, 0, len(current))
+	for _, name := range slices.Sorted(maps.Keys(current)) {
+		health, ok := results[name]
+		if !ok || health.URL != current[name] {
+			health = serviceHealth{Name: name, Status: "unknown", URL: current[name]}
+		}
+		merged = append(merged, health)
+	}
+	return merged
+}
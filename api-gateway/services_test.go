@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readyBackend answers /readyz with ok
+func readyBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"status":"ok"}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// healthReport calls healthCheck and returns each service's status by name, in order
+func healthReport(t *testing.T, g *Gateway) []string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	g.healthCheck(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var body struct {
+		Services []serviceHealth `json:"services"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, len(body.Services))
+	for i, s := range body.Services {
+		out[i] = s.Name + " " + s.Status
+	}
+	return out
+}
+
+func TestMergeHealth(t *testing.T) {
+	results := map[string]serviceHealth{
+		"users":    {Name: "users", Status: "healthy", URL: "http://users"},
+		"products": {Name: "products", Status: "degraded", URL: "http://products"},
+		"orders":   {Name: "orders", Status: "unhealthy", URL: "http://orders"},
+	}
+	// orders was removed, carts added and products moved while the probes ran
+	current := map[string]string{"users": "http://users", "products": "http://products-2", "carts": "http://carts"}
+
+	got := mergeHealth(results, current)
+	want := []serviceHealth{
+		{Name: "carts", Status: "unknown", URL: "http://carts"},
+		{Name: "products", Status: "unknown", URL: "http://products-2"},
+		{Name: "users", Status: "healthy", URL: "http://users"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("mergeHealth = %+v, want %+v", got, want)
+	}
+}
+
+func TestReloadDuringSlowHealthCheck(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		io.WriteString(w, `{"status":"ok"}`)
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := readyBackend(t)
+
+	g := newTestGateway(map[string]string{"users": slow.URL, "products": fast.URL})
+	report := make(chan []string)
+	go func() { report <- healthReport(t, g) }()
+	<-arrived
+
+	// The check is blocked on users; a reload and routing must not wait for it
+	reloaded := make(chan struct{})
+	go func() {
+		g.applyConfig(runtimeConfig{Services: map[string]string{"users": slow.URL, "orders": fast.URL}, DefaultTenant: "default"}, "test", "test", "")
+		close(reloaded)
+	}()
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("config reload waited for the health check")
+	}
+	if d := g.decideRoute(http.MethodGet, "example.com", "/api/orders/1"); d.Status != http.StatusOK || d.Upstream != fast.URL {
+		t.Errorf("orders routes to %q with %d, want %s", d.Upstream, d.Status, fast.URL)
+	}
+	if d := g.decideRoute(http.MethodGet, "example.com", "/api/products/1"); d.Status == http.StatusOK {
+		t.Error("products still routes after being removed")
+	}
+
+	release <- struct{}{}
+	// products was removed mid-check and is dropped; orders was added and isn't checked yet
+	if got := fmt.Sprint(<-report); got != "[orders unknown users healthy]" {
+		t.Errorf("health = %s, want [orders unknown users healthy]", got)
+	}
+}
+
+func TestReloadRacesHealthChecksAndRouting(t *testing.T) {
+	// Run with go test -race: reloads swap the table while it is read
+	a, b := readyBackend(t), readyBackend(t)
+	configs := []map[string]string{
+		{"users": a.URL, "products": b.URL},
+		{"users": b.URL, "orders": a.URL},
+		{"products": a.URL},
+	}
+	g := newTestGateway(configs[0])
+
+	var wg sync.WaitGroup
+	run := func(n int, f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range n {
+				f(i)
+			}
+		}()
+	}
+	run(100, func(i int) {
+		g.applyConfig(runtimeConfig{Services: configs[i%len(configs)], DefaultTenant: "default"}, "test", "test", "")
+	})
+	run(20, func(int) {
+		for _, line := range healthReport(t, g) {
+			if line == "" {
+				t.Error("health report has an unnamed service")
+			}
+		}
+	})
+	run(20, func(int) {
+		w := httptest.NewRecorder()
+		g.serviceHealthCheck(w, httptest.NewRequest(http.MethodGet, "/health/users", nil))
+	})
+	run(200, func(i int) {
+		for _, service := range []string{"users", "products", "orders"} {
+			g.decideRoute(http.MethodGet, "example.com", "/api/"+service+"/1")
+		}
+		g.serviceNames()
+	})
+	run(50, func(int) {
+		g.routeRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/products/1", nil))
+	})
+	wg.Wait()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"shared/health"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Startup probe modes, set by STARTUP_PROBE
+const (
+	startupProbeOff      = "off"       // no probe; ready as soon as the configuration is valid
+	startupProbeFailFast = "fail-fast" // probe before serving and exit if a required service never answers
+	startupProbeDegrade  = "degrade"   // serve at once, report starting until required services answer or STARTUP_WAIT passes
+)
+
+// upstream is one configured backend, as checked at startup
+type upstream struct {
+	Name     string
+	Env      string // the variable its URL was read from
+	URL      string
+	Required bool
+	Problem  string // why the URL can't be used; the service is left out of routing
+	Probe    string // result of the startup probe, or why there was none
+}
+
+// startupProbeFromEnv reads STARTUP_PROBE: off (default), fail-fast or degrade
+func startupProbeFromEnv() (string, error) {
+	switch raw := os.Getenv("STARTUP_PROBE"); raw {
+	case "":
+		return startupProbeOff, nil
+	case startupProbeOff, startupProbeFailFast, startupProbeDegrade:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("invalid STARTUP_PROBE %q (want off, fail-fast or degrade)", raw)
+	}
+}
+
+// requiredServicesFromEnv reads REQUIRED_SERVICES, a comma-separated list of the
+// services the gateway can't do without; default every configured service
+func requiredServicesFromEnv(serviceMap map[string]string) (map[string]bool, error) {
+	required := map[string]bool{}
+	raw := os.Getenv("REQUIRED_SERVICES")
+	if raw == "" {
+		for name := range serviceMap {
+			required[name] = true
+		}
+		return required, nil
+	}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := serviceMap[name]; !ok {
+			return nil, fmt.Errorf("invalid REQUIRED_SERVICES entry %q (want one of %s)",
+				name, strings.Join(slices.Sorted(maps.Keys(serviceMap)), ", "))
+		}
+		required[name] = true
+	}
+	return required, nil
+}
+
+// checkUpstreams checks that every service has an absolute http(s) URL, so a
+// missing or mistyped setting is caught at startup instead of failing every
+// request. A misconfigured required service is an error listing each of them;
+// an optional one is only marked with its Problem.
+func checkUpstreams(serviceMap, envNames map[string]string, required map[string]bool) ([]upstream, error) {
+	var upstreams []upstream
+	var problems []string
+	for _, name := range slices.Sorted(maps.Keys(serviceMap)) {
+		u := upstream{Name: name, Env: envNames[name], URL: serviceMap[name], Required: required[name]}
+		switch {
+		case u.URL == "":
+			u.Problem = u.Env + " is not set"
+		case !isBackendURL(u.URL):
+			u.Problem = fmt.Sprintf("%s=%q is not an http(s) URL", u.Env, u.URL)
+		}
+		if u.Problem != "" {
+			u.Probe = "skipped: misconfigured"
+			if u.Required {
+				problems = append(problems, name+": "+u.Problem)
+			}
+		}
+		upstreams = append(upstreams, u)
+	}
+	if len(problems) > 0 {
+		return upstreams, fmt.Errorf("misconfigured services: %s", strings.Join(problems, "; "))
+	}
+	return upstreams, nil
+}
+
+// usableServices is the routing table of the upstreams without a Problem
+func usableServices(upstreams []upstream) map[string]string {
+	services := map[string]string{}
+	for _, u := range upstreams {
+		if u.Problem == "" {
+			services[u.Name] = u.URL
+		}
+	}
+	return services
+}
+
+// probeUpstreams probes the usable upstreams, retrying with backoff until every
+// required one answers or wait passes, and fills in each one's Probe. The error
+// lists the required services still down.
+func (g *Gateway) probeUpstreams(ctx context.Context, upstreams []upstream, wait time.Duration) error {
+	var mu sync.Mutex
+	results := map[string]string{}
+
+	deps := health.New()
+	for _, u := range upstreams {
+		if u.Problem != "" {
+			continue
+		}
+		deps.Register(health.Dependency{Name: u.Name, Required: u.Required, Check: func(ctx context.Context) error {
+			start := time.Now()
+			status := g.probe(ctx, u.Name, u.URL).Status
+			mu.Lock()
+			results[u.Name] = fmt.Sprintf("%s (%dms)", status, time.Since(start).Milliseconds())
+			mu.Unlock()
+			if status == "unhealthy" {
+				return fmt.Errorf("%s is unhealthy", u.URL)
+			}
+			return nil
+		}})
+	}
+	err := deps.WaitForRequired(ctx, wait)
+
+	for i := range upstreams {
+		if result, ok := results[upstreams[i].Name]; ok {
+			upstreams[i].Probe = result
+		}
+	}
+	return err
+}
+
+// logUpstreams prints the startup table of services, their URLs and probe results
+func logUpstreams(upstreams []upstream) {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tREQUIRED\tURL\tPROBE")
+	for _, u := range upstreams {
+		required := "no"
+		if u.Required {
+			required = "yes"
+		}
+		url := u.URL
+		if u.Problem != "" {
+			url = u.Problem
+		}
+		probe := u.Probe
+		if probe == "" {
+			probe = "not probed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u.Name, required, url, probe)
+	}
+	tw.Flush()
+
+	log.Printf("=== Upstream services ===")
+	for _, line := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n") {
+		log.Print(line)
+	}
+	for _, u := range upstreams {
+		if u.Problem != "" && !u.Required {
+			log.Printf("WARNING: optional service %s is left out of routing: %s", u.Name, u.Problem)
+		}
+	}
+}
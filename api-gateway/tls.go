@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tlsVersions are the versions TLS_MIN_VERSION accepts; older ones are not offered
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsConfigFromEnv reads the listener's TLS settings, returning nil when TLS is off:
+//
+//	TLS_CERT_FILE      PEM certificate chain; with TLS_KEY_FILE, turns TLS on
+//	TLS_KEY_FILE       PEM private key
+//	TLS_MIN_VERSION    1.2 (default) or 1.3
+//	TLS_CIPHER_SUITES  comma-separated suite names for TLS 1.2, e.g.
+//	                   TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; default Go's secure set.
+//	                   TLS 1.3 suites are not configurable.
+func tlsConfigFromEnv() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if raw := os.Getenv("TLS_MIN_VERSION"); raw != "" {
+		version, ok := tlsVersions[raw]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS_MIN_VERSION %q (want 1.2 or 1.3)", raw)
+		}
+		cfg.MinVersion = version
+	}
+	if raw := os.Getenv("TLS_CIPHER_SUITES"); raw != "" {
+		byName := map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			byName[suite.Name] = suite.ID
+		}
+		for _, name := range strings.Split(raw, ",") {
+			id, ok := byName[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("invalid TLS_CIPHER_SUITES entry %q (unknown or insecure suite)", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	return cfg, nil
+}
+
+// tlsInfo is the response of GET /admin/tls-info
+type tlsInfo struct {
+	MinVersion   string            `json:"min_version"`
+	CipherSuites []string          `json:"cipher_suites"` // the TLS 1.2 suites offered, then Go's fixed TLS 1.3 set
+	Certificates []certificateInfo `json:"certificates"`
+}
+
+// certificateInfo describes the leaf of one loaded certificate chain
+type certificateInfo struct {
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	DNSNames      []string  `json:"dns_names"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"` // negative once expired
+}
+
+// describeTLS reports the posture of cfg as of now
+func describeTLS(cfg *tls.Config, now time.Time) (tlsInfo, error) {
+	info := tlsInfo{MinVersion: tls.VersionName(cfg.MinVersion), Certificates: []certificateInfo{}}
+	if cfg.MinVersion < tls.VersionTLS13 {
+		if cfg.CipherSuites != nil {
+			for _, id := range cfg.CipherSuites {
+				info.CipherSuites = append(info.CipherSuites, tls.CipherSuiteName(id))
+			}
+		} else {
+			info.CipherSuites = append(info.CipherSuites, suiteNames(tls.VersionTLS12)...)
+		}
+	}
+	info.CipherSuites = append(info.CipherSuites, suiteNames(tls.VersionTLS13)...)
+
+	for _, cert := range cfg.Certificates {
+		leaf := cert.Leaf
+		if leaf == nil {
+			if len(cert.Certificate) == 0 {
+				continue
+			}
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return info, fmt.Errorf("could not parse certificate: %w", err)
+			}
+		}
+		info.Certificates = append(info.Certificates, certificateInfo{
+			Subject:       leaf.Subject.String(),
+			Issuer:        leaf.Issuer.String(),
+			DNSNames:      leaf.DNSNames,
+			NotBefore:     leaf.NotBefore.UTC(),
+			NotAfter:      leaf.NotAfter.UTC(),
+			DaysRemaining: int(math.Floor(leaf.NotAfter.Sub(now).Hours() / 24)),
+		})
+	}
+	return info, nil
+}
+
+// suiteNames lists the secure suites Go implements for a TLS version
+func suiteNames(version uint16) []string {
+	var names []string
+	for _, suite := range tls.CipherSuites() {
+		if slices.Contains(suite.SupportedVersions, version) {
+			names = append(names, suite.Name)
+		}
+	}
+	return names
+}
+
+// tlsInfoHandler serves GET /admin/tls-info, so scanners and operators can check
+// the minimum version, suites and certificate expiry without a handshake
+func (g *Gateway) tlsInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if g.tls == nil {
+		httpx.Error(w, http.StatusNotFound, "TLS is not enabled; set TLS_CERT_FILE and TLS_KEY_FILE")
+		return
+	}
+	info, err := describeTLS(g.tls, time.Now())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.WriteJSON(w, r, http.StatusOK, info)
+}
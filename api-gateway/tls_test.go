@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for api.example.com, valid until
+// notAfter, and its key to a temporary directory, and sets TLS_CERT_FILE and
+// TLS_KEY_FILE to them
+func writeTestCert(t *testing.T, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "api.example.com"},
+		DNSNames:     []string{"api.example.com", "www.example.com"},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+}
+
+func TestTLSInfoReportsCertificateExpiry(t *testing.T) {
+	// Whole seconds, as the certificate stores them; an hour past 30 days is still 30
+	notAfter := time.Now().Add(30*24*time.Hour + time.Hour).UTC().Truncate(time.Second)
+	writeTestCert(t, notAfter)
+	t.Setenv("TLS_MIN_VERSION", "1.2")
+	t.Setenv("TLS_CIPHER_SUITES", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+
+	cfg, err := tlsConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &Gateway{tls: cfg}
+
+	w := httptest.NewRecorder()
+	g.tlsInfoHandler(w, httptest.NewRequest(http.MethodGet, "/admin/tls-info", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var info tlsInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.MinVersion != "TLS 1.2" {
+		t.Errorf("min_version = %q, want TLS 1.2", info.MinVersion)
+	}
+	if len(info.CipherSuites) == 0 || info.CipherSuites[0] != "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256" {
+		t.Errorf("cipher_suites = %v, want the configured suite first", info.CipherSuites)
+	}
+	if !slices.Contains(info.CipherSuites, "TLS_AES_128_GCM_SHA256") {
+		t.Errorf("cipher_suites = %v, want the TLS 1.3 suites after it", info.CipherSuites)
+	}
+
+	if len(info.Certificates) != 1 {
+		t.Fatalf("got %d certificates, want 1", len(info.Certificates))
+	}
+	cert := info.Certificates[0]
+	if !cert.NotAfter.Equal(notAfter) {
+		t.Errorf("not_after = %s, want %s", cert.NotAfter, notAfter)
+	}
+	if cert.DaysRemaining != 30 {
+		t.Errorf("days_remaining = %d, want 30", cert.DaysRemaining)
+	}
+	if cert.Subject != "CN=api.example.com" || !slices.Equal(cert.DNSNames, []string{"api.example.com", "www.example.com"}) {
+		t.Errorf("subject = %q, dns_names = %v, want api.example.com and its SANs", cert.Subject, cert.DNSNames)
+	}
+}
+
+func TestDescribeTLSDaysRemaining(t *testing.T) {
+	notAfter := time.Date(2026, 6, 30, 12, 0, 0, 0, time.UTC)
+	writeTestCert(t, notAfter)
+	cfg, err := tlsConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want int
+	}{
+		{name: "ten days left", now: notAfter.Add(-10 * 24 * time.Hour), want: 10},
+		{name: "part of a day left", now: notAfter.Add(-23 * time.Hour), want: 0},
+		{name: "expiring now", now: notAfter, want: 0},
+		// An expired certificate counts down past zero, so alerts keep firing
+		{name: "expired an hour ago", now: notAfter.Add(time.Hour), want: -1},
+		{name: "expired five days ago", now: notAfter.Add(5 * 24 * time.Hour), want: -5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := describeTLS(cfg, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Certificates[0].DaysRemaining; got != tt.want {
+				t.Errorf("days_remaining = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeTLSWithMinimumVersion13(t *testing.T) {
+	info, err := describeTLS(&tls.Config{MinVersion: tls.VersionTLS13}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// TLS 1.2 suites aren't offered, so aren't listed
+	if want := suiteNames(tls.VersionTLS13); !slices.Equal(info.CipherSuites, want) {
+		t.Errorf("cipher_suites = %v, want only %v", info.CipherSuites, want)
+	}
+}
+
+func TestTLSInfoWithoutTLSIsNotFound(t *testing.T) {
+	g := &Gateway{}
+	w := httptest.NewRecorder()
+	g.tlsInfoHandler(w, httptest.NewRequest(http.MethodGet, "/admin/tls-info", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestTLSConfigFromEnvErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{name: "cert without key", env: map[string]string{"TLS_KEY_FILE": ""}},
+		{name: "unreadable cert", env: map[string]string{"TLS_CERT_FILE": "/nonexistent/cert.pem"}},
+		{name: "unknown version", env: map[string]string{"TLS_MIN_VERSION": "1.1"}},
+		{name: "unknown suite", env: map[string]string{"TLS_CIPHER_SUITES": "TLS_RSA_WITH_RC4_128_SHA"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTestCert(t, time.Now().Add(24*time.Hour))
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if cfg, err := tlsConfigFromEnv(); err == nil {
+				t.Errorf("got %+v, want an error", cfg)
+			}
+		})
+	}
+}
+
+func TestTLSConfigFromEnvOff(t *testing.T) {
+	t.Setenv("TLS_CERT_FILE", "")
+	t.Setenv("TLS_KEY_FILE", "")
+	if cfg, err := tlsConfigFromEnv(); cfg != nil || err != nil {
+		t.Errorf("got %v, %v, want TLS off", cfg, err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// upstreamTimeouts bound each phase of a proxied request
+type upstreamTimeouts struct {
+	Connect        time.Duration // UPSTREAM_CONNECT_TIMEOUT; establishing the TCP connection
+	TLSHandshake   time.Duration // UPSTREAM_TLS_TIMEOUT; TLS handshake with https backends
+	ResponseHeader time.Duration // UPSTREAM_HEADER_TIMEOUT; from sending the request to the first response byte
+	Total          time.Duration // UPSTREAM_TIMEOUT; the whole request, passed to backends as X-Request-Deadline
+}
+
+// upstreamTimeoutsFromEnv reads the proxy timeouts, applying defaults for unset ones
+func upstreamTimeoutsFromEnv() (upstreamTimeouts, error) {
+	t := upstreamTimeouts{
+		Connect:        5 * time.Second,
+		TLSHandshake:   10 * time.Second,
+		ResponseHeader: 20 * time.Second,
+		Total:          30 * time.Second,
+	}
+	for _, setting := range []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"UPSTREAM_CONNECT_TIMEOUT", &t.Connect},
+		{"UPSTREAM_TLS_TIMEOUT", &t.TLSHandshake},
+		{"UPSTREAM_HEADER_TIMEOUT", &t.ResponseHeader},
+		{"UPSTREAM_TIMEOUT", &t.Total},
+	} {
+		raw := os.Getenv(setting.env)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return t, fmt.Errorf("invalid %s %q", setting.env, raw)
+		}
+		*setting.dst = d
+	}
+	return t, nil
+}
+
+// newUpstreamTransport creates the transport shared by every proxied request
+func newUpstreamTransport(t upstreamTimeouts) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   t.Connect,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = t.TLSHandshake
+	transport.ResponseHeaderTimeout = t.ResponseHeader
+	return transport
+}
@@ -43,8 +43,9 @@ async function loadUsers() {
   const result = await apiCall('/api/users');
   const el = document.getElementById('users-list');
   if (result.success) {
-    if (result.data && result.data.length > 0) {
-      el.innerHTML = '<pre>' + JSON.stringify(result.data, null, 2) + '</pre>';
+    // List endpoints return { data, limit, offset, links }
+    if (result.data && result.data.data.length > 0) {
+      el.innerHTML = '<pre>' + JSON.stringify(result.data.data, null, 2) + '</pre>';
     } else {
       el.innerHTML = '<em>No users found</em>';
     }
@@ -104,8 +105,9 @@ async function loadProducts() {
   const result = await apiCall('/api/products');
   const el = document.getElementById('products-list');
   if (result.success) {
-    if (result.data && result.data.length > 0) {
-      el.innerHTML = '<pre>' + JSON.stringify(result.data, null, 2) + '</pre>';
+    // List endpoints return { data, limit, offset, links }
+    if (result.data && result.data.data.length > 0) {
+      el.innerHTML = '<pre>' + JSON.stringify(result.data.data, null, 2) + '</pre>';
     } else {
       el.innerHTML = '<em>No products found</em>';
     }
@@ -3,52 +3,62 @@ package db
 import (
 	"fmt"
 	"log"
-	"os"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jmoiron/sqlx"
 )
 
-// Connect opens a Postgres connection using DATABASE_URL
-func Connect() (*sqlx.DB, error) {
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		return nil, fmt.Errorf("DATABASE_URL not set")
+// UniqueProductNameIndex is the unique index backing PRODUCT_UNIQUE_NAMES; names are unique per tenant
+const UniqueProductNameIndex = "products_name_unique"
+
+// EnforceUniqueNames creates or drops the unique product name index.
+// Some catalogs allow duplicate names, so this is applied at startup from
+// PRODUCT_UNIQUE_NAMES rather than as a versioned migration.
+func EnforceUniqueNames(conn *sqlx.DB, enabled bool) error {
+	if !enabled {
+		if _, err := conn.Exec("DROP INDEX IF EXISTS " + UniqueProductNameIndex); err != nil {
+			return fmt.Errorf("could not drop unique name index: %w", err)
+		}
+		return nil
 	}
 
-	conn, err := sqlx.Connect("postgres", dbURL)
+	_, err := conn.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + UniqueProductNameIndex + " ON products (tenant_id, name)")
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		return fmt.Errorf("could not enforce unique product names (are there existing duplicates?): %w", err)
 	}
-
-	log.Println("Connected to Postgres")
-	return conn, nil
+	log.Println("Unique product names enforced.")
+	return nil
 }
 
-// Migrate runs all pending migrations in /migrations
-func Migrate(conn *sqlx.DB) error {
-	log.Println("Running migrations...")
-
-	driver, err := postgres.WithInstance(conn.DB, &postgres.Config{})
+// EnforcePriceScale sets the decimal places of products.price to PRICE_SCALE,
+// keeping the eight digits before the point it was created with. Like
+// EnforceUniqueNames it is applied at startup, as the scale depends on the
+// currency a deployment sells in. Lowering the scale is refused while a price
+// would be rounded by it.
+func EnforcePriceScale(conn *sqlx.DB, scale int) error {
+	var current int
+	err := conn.Get(&current, `
+		SELECT numeric_scale FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'products' AND column_name = 'price'`)
 	if err != nil {
-		return fmt.Errorf("could not start postgres driver: %w", err)
+		return fmt.Errorf("could not read price scale: %w", err)
 	}
-
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
-		"postgres",
-		driver,
-	)
-	if err != nil {
-		return fmt.Errorf("could not create migrate instance: %w", err)
+	if current == scale {
+		return nil
 	}
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("migration failed: %w", err)
+	if scale < current {
+		var rounded int
+		if err := conn.Get(&rounded, "SELECT count(*) FROM products WHERE price <> round(price, $1)", scale); err != nil {
+			return fmt.Errorf("could not check prices against scale %d: %w", scale, err)
+		}
+		if rounded > 0 {
+			return fmt.Errorf("cannot lower the price scale from %d to %d: %d products have prices with more decimal places", current, scale, rounded)
+		}
 	}
 
-	log.Println("Migrations complete.")
+	if _, err := conn.Exec(fmt.Sprintf("ALTER TABLE products ALTER COLUMN price TYPE DECIMAL(%d, %d)", 8+scale, scale)); err != nil {
+		return fmt.Errorf("could not change price scale to %d: %w", scale, err)
+	}
+	log.Printf("Price scale changed from %d to %d decimal places.", current, scale)
 	return nil
 }
@@ -6,13 +6,75 @@ package generated
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
 )
 
+type Category struct {
+	Slug       string
+	Name       string
+	CreatedAt  sql.NullTime
+	ParentSlug sql.NullString
+	TenantID   string
+}
+
 type Product struct {
-	ID          int32
-	Name        string
-	Description sql.NullString
-	Price       string
-	Stock       int32
-	CreatedAt   sql.NullTime
+	ID                 int32
+	Name               string
+	Description        sql.NullString
+	Price              string
+	Stock              int32
+	CreatedAt          sql.NullTime
+	TenantID           string
+	Category           sql.NullString
+	ArchivedAt         sql.NullTime
+	DescriptionFormat  string
+	AvailableFrom      sql.NullTime
+	AvailableUntil     sql.NullTime
+	AnnouncedAvailable bool
+}
+
+type ProductHistory struct {
+	ID        int64
+	TenantID  string
+	ProductID int32
+	Action    string
+	Detail    json.RawMessage
+	CreatedAt time.Time
+}
+
+type ProductTranslation struct {
+	ProductID         int32
+	Locale            string
+	Name              string
+	Description       sql.NullString
+	DescriptionFormat string
+	UpdatedAt         time.Time
+}
+
+type StockMovement struct {
+	ID        int64
+	TenantID  string
+	ProductID int32
+	Quantity  int32
+	Reason    string
+	Note      sql.NullString
+	CreatedAt time.Time
+}
+
+type StockReservation struct {
+	ID         string
+	TenantID   string
+	ProductID  int32
+	Quantity   int32
+	Status     string
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	ReleasedAt sql.NullTime
+}
+
+type Tenant struct {
+	ID        string
+	Name      string
+	CreatedAt sql.NullTime
 }
@@ -8,27 +8,204 @@ package generated
 import (
 	"context"
 	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+const announceAvailability = `-- name: AnnounceAvailability :many
+UPDATE products SET announced_available = NOT announced_available
+WHERE id IN (
+  SELECT id FROM products
+  WHERE (available_from IS NOT NULL OR available_until IS NOT NULL OR NOT announced_available)
+    AND archived_at IS NULL
+    AND announced_available <> ((available_from IS NULL OR available_from <= $1::timestamptz) AND (available_until IS NULL OR available_until > $1::timestamptz))
+  ORDER BY id
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available
+`
+
+type AnnounceAvailabilityParams struct {
+	Now   time.Time
+	Limit int32
+}
+
+// Claims a batch of products, in every tenant, whose availability at now differs
+// from the one last announced, and records the new one. SKIP LOCKED lets several
+// replicas run it at once without announcing a change twice.
+func (q *Queries) AnnounceAvailability(ctx context.Context, arg AnnounceAvailabilityParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, announceAvailability, arg.Now, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Price,
+			&i.Stock,
+			&i.CreatedAt,
+			&i.TenantID,
+			&i.Category,
+			&i.ArchivedAt,
+			&i.DescriptionFormat,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AnnouncedAvailable,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const archiveProducts = `-- name: ArchiveProducts :many
+UPDATE products SET archived_at = COALESCE(archived_at, $1)
+WHERE id = ANY($2::int[]) AND tenant_id = $3
+RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available
+`
+
+type ArchiveProductsParams struct {
+	ArchivedAt sql.NullTime
+	Ids        []int32
+	TenantID   string
+}
+
+func (q *Queries) ArchiveProducts(ctx context.Context, arg ArchiveProductsParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, archiveProducts, arg.ArchivedAt, pq.Array(arg.Ids), arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Price,
+			&i.Stock,
+			&i.CreatedAt,
+			&i.TenantID,
+			&i.Category,
+			&i.ArchivedAt,
+			&i.DescriptionFormat,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AnnouncedAvailable,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const categorizeProducts = `-- name: CategorizeProducts :many
+UPDATE products SET category = $1
+WHERE id = ANY($2::int[]) AND tenant_id = $3
+RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available
+`
+
+type CategorizeProductsParams struct {
+	Category sql.NullString
+	Ids      []int32
+	TenantID string
+}
+
+func (q *Queries) CategorizeProducts(ctx context.Context, arg CategorizeProductsParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, categorizeProducts, arg.Category, pq.Array(arg.Ids), arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Price,
+			&i.Stock,
+			&i.CreatedAt,
+			&i.TenantID,
+			&i.Category,
+			&i.ArchivedAt,
+			&i.DescriptionFormat,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AnnouncedAvailable,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createProduct = `-- name: CreateProduct :one
-INSERT INTO products (name, description, price, stock)
-VALUES ($1, $2, $3, $4)
-RETURNING id, name, description, price, stock, created_at
+WITH created AS (
+  INSERT INTO products (tenant_id, name, description, price, stock, category, description_format, available_from, available_until)
+  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+  RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available
+), movement AS (
+  INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+  SELECT tenant_id, id, stock, 'created' FROM created WHERE stock <> 0
+)
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM created
 `
 
 type CreateProductParams struct {
-	Name        string
-	Description sql.NullString
-	Price       string
-	Stock       int32
+	TenantID          string
+	Name              string
+	Description       sql.NullString
+	Price             string
+	Stock             int32
+	Category          sql.NullString
+	DescriptionFormat string
+	AvailableFrom     sql.NullTime
+	AvailableUntil    sql.NullTime
 }
 
+// The opening stock is the product's first movement
 func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (Product, error) {
 	row := q.db.QueryRowContext(ctx, createProduct,
+		arg.TenantID,
 		arg.Name,
 		arg.Description,
 		arg.Price,
 		arg.Stock,
+		arg.Category,
+		arg.DescriptionFormat,
+		arg.AvailableFrom,
+		arg.AvailableUntil,
 	)
 	var i Product
 	err := row.Scan(
@@ -38,25 +215,80 @@ func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (P
 		&i.Price,
 		&i.Stock,
 		&i.CreatedAt,
+		&i.TenantID,
+		&i.Category,
+		&i.ArchivedAt,
+		&i.DescriptionFormat,
+		&i.AvailableFrom,
+		&i.AvailableUntil,
+		&i.AnnouncedAvailable,
 	)
 	return i, err
 }
 
-const deleteProduct = `-- name: DeleteProduct :exec
-DELETE FROM products WHERE id = $1
+const deleteProduct = `-- name: DeleteProduct :execrows
+DELETE FROM products WHERE id = $1 AND tenant_id = $2
 `
 
-func (q *Queries) DeleteProduct(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, deleteProduct, id)
-	return err
+type DeleteProductParams struct {
+	ID       int32
+	TenantID string
+}
+
+func (q *Queries) DeleteProduct(ctx context.Context, arg DeleteProductParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteProduct, arg.ID, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteProducts = `-- name: DeleteProducts :many
+DELETE FROM products
+WHERE id = ANY($1::int[]) AND tenant_id = $2
+RETURNING id
+`
+
+type DeleteProductsParams struct {
+	Ids      []int32
+	TenantID string
+}
+
+func (q *Queries) DeleteProducts(ctx context.Context, arg DeleteProductsParams) ([]int32, error) {
+	rows, err := q.db.QueryContext(ctx, deleteProducts, pq.Array(arg.Ids), arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getProduct = `-- name: GetProduct :one
-SELECT id, name, description, price, stock, created_at FROM products WHERE id = $1
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE id = $1 AND tenant_id = $2
 `
 
-func (q *Queries) GetProduct(ctx context.Context, id int32) (Product, error) {
-	row := q.db.QueryRowContext(ctx, getProduct, id)
+type GetProductParams struct {
+	ID       int32
+	TenantID string
+}
+
+func (q *Queries) GetProduct(ctx context.Context, arg GetProductParams) (Product, error) {
+	row := q.db.QueryRowContext(ctx, getProduct, arg.ID, arg.TenantID)
 	var i Product
 	err := row.Scan(
 		&i.ID,
@@ -65,16 +297,83 @@ func (q *Queries) GetProduct(ctx context.Context, id int32) (Product, error) {
 		&i.Price,
 		&i.Stock,
 		&i.CreatedAt,
+		&i.TenantID,
+		&i.Category,
+		&i.ArchivedAt,
+		&i.DescriptionFormat,
+		&i.AvailableFrom,
+		&i.AvailableUntil,
+		&i.AnnouncedAvailable,
 	)
 	return i, err
 }
 
+const listProductStock = `-- name: ListProductStock :many
+SELECT p.id, p.stock, COALESCE(SUM(r.quantity), 0)::int AS reserved
+FROM products p
+LEFT JOIN stock_reservations r ON r.product_id = p.id AND r.status = 'active'
+GROUP BY p.id
+ORDER BY p.id
+`
+
+type ListProductStockRow struct {
+	ID       int32
+	Stock    int32
+	Reserved int32
+}
+
+// Inventory sync works on warehouse product IDs, which are global across tenants.
+// Reserved is the quantity held by active reservations, already taken out of stock.
+func (q *Queries) ListProductStock(ctx context.Context) ([]ListProductStockRow, error) {
+	rows, err := q.db.QueryContext(ctx, listProductStock)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListProductStockRow
+	for rows.Next() {
+		var i ListProductStockRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Stock,
+			&i.Reserved,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listProducts = `-- name: ListProducts :many
-SELECT id, name, description, price, stock, created_at FROM products ORDER BY id
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1 AND archived_at IS NULL
+  AND ($4::timestamptz IS NULL OR ((available_from IS NULL OR available_from <= $4::timestamptz) AND (available_until IS NULL OR available_until > $4::timestamptz)))
+ORDER BY id
+LIMIT $2 OFFSET $3
 `
 
-func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
-	rows, err := q.db.QueryContext(ctx, listProducts)
+type ListProductsParams struct {
+	TenantID    string
+	Limit       int32
+	Offset      int32
+	AvailableAt sql.NullTime
+}
+
+// With available_at, products outside their availability window at that time are left out
+func (q *Queries) ListProducts(ctx context.Context, arg ListProductsParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, listProducts,
+		arg.TenantID,
+		arg.Limit,
+		arg.Offset,
+		arg.AvailableAt,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +388,13 @@ func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
 			&i.Price,
 			&i.Stock,
 			&i.CreatedAt,
+			&i.TenantID,
+			&i.Category,
+			&i.ArchivedAt,
+			&i.DescriptionFormat,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AnnouncedAvailable,
 		); err != nil {
 			return nil, err
 		}
@@ -103,28 +409,244 @@ func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
 	return items, nil
 }
 
+const listProductsAfter = `-- name: ListProductsAfter :many
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1 AND archived_at IS NULL AND id > $2
+  AND ($4::timestamptz IS NULL OR created_at IS NULL OR created_at <= $4::timestamptz)
+  AND ($5::timestamptz IS NULL OR ((available_from IS NULL OR available_from <= $5::timestamptz) AND (available_until IS NULL OR available_until > $5::timestamptz)))
+ORDER BY id
+LIMIT $3
+`
+
+type ListProductsAfterParams struct {
+	TenantID    string
+	ID          int32
+	Limit       int32
+	Snapshot    sql.NullTime
+	AvailableAt sql.NullTime
+}
+
+// Keyset page after an ID. With a snapshot, rows created after it are left out, so
+// products added mid-scroll don't show up in a listing that started before them.
+func (q *Queries) ListProductsAfter(ctx context.Context, arg ListProductsAfterParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, listProductsAfter,
+		arg.TenantID,
+		arg.ID,
+		arg.Limit,
+		arg.Snapshot,
+		arg.AvailableAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Price,
+			&i.Stock,
+			&i.CreatedAt,
+			&i.TenantID,
+			&i.Category,
+			&i.ArchivedAt,
+			&i.DescriptionFormat,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AnnouncedAvailable,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProductsByCategory = `-- name: ListProductsByCategory :many
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1 AND category = $2 AND archived_at IS NULL
+  AND ($5::timestamptz IS NULL OR ((available_from IS NULL OR available_from <= $5::timestamptz) AND (available_until IS NULL OR available_until > $5::timestamptz)))
+ORDER BY id
+LIMIT $3 OFFSET $4
+`
+
+type ListProductsByCategoryParams struct {
+	TenantID    string
+	Category    sql.NullString
+	Limit       int32
+	Offset      int32
+	AvailableAt sql.NullTime
+}
+
+func (q *Queries) ListProductsByCategory(ctx context.Context, arg ListProductsByCategoryParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, listProductsByCategory,
+		arg.TenantID,
+		arg.Category,
+		arg.Limit,
+		arg.Offset,
+		arg.AvailableAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Price,
+			&i.Stock,
+			&i.CreatedAt,
+			&i.TenantID,
+			&i.Category,
+			&i.ArchivedAt,
+			&i.DescriptionFormat,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AnnouncedAvailable,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProductsByCategoryAfter = `-- name: ListProductsByCategoryAfter :many
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1 AND category = $2 AND archived_at IS NULL AND id > $3
+  AND ($5::timestamptz IS NULL OR created_at IS NULL OR created_at <= $5::timestamptz)
+  AND ($6::timestamptz IS NULL OR ((available_from IS NULL OR available_from <= $6::timestamptz) AND (available_until IS NULL OR available_until > $6::timestamptz)))
+ORDER BY id
+LIMIT $4
+`
+
+type ListProductsByCategoryAfterParams struct {
+	TenantID    string
+	Category    sql.NullString
+	ID          int32
+	Limit       int32
+	Snapshot    sql.NullTime
+	AvailableAt sql.NullTime
+}
+
+func (q *Queries) ListProductsByCategoryAfter(ctx context.Context, arg ListProductsByCategoryAfterParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, listProductsByCategoryAfter,
+		arg.TenantID,
+		arg.Category,
+		arg.ID,
+		arg.Limit,
+		arg.Snapshot,
+		arg.AvailableAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Price,
+			&i.Stock,
+			&i.CreatedAt,
+			&i.TenantID,
+			&i.Category,
+			&i.ArchivedAt,
+			&i.DescriptionFormat,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AnnouncedAvailable,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockProductStock = `-- name: LockProductStock :exec
+SELECT id FROM products WHERE id = $1 FOR UPDATE
+`
+
+// Holds a product's row until the transaction ends, so no reservation can take or
+// return its stock meanwhile
+func (q *Queries) LockProductStock(ctx context.Context, id int32) error {
+	_, err := q.db.ExecContext(ctx, lockProductStock, id)
+	return err
+}
+
 const updateProduct = `-- name: UpdateProduct :one
-UPDATE products
-SET name = $2, description = $3, price = $4, stock = $5
-WHERE id = $1
-RETURNING id, name, description, price, stock, created_at
+WITH old AS (
+  SELECT id, stock FROM products WHERE id = $1 AND tenant_id = $2 FOR UPDATE
+), updated AS (
+  UPDATE products
+  SET name = $3, description = $4, price = $5, stock = $6, category = $7, description_format = $8,
+      available_from = $9, available_until = $10
+  WHERE id = $1 AND tenant_id = $2
+  RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available
+), movement AS (
+  INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+  SELECT updated.tenant_id, updated.id, updated.stock - old.stock, 'updated'
+  FROM updated JOIN old ON old.id = updated.id
+  WHERE updated.stock <> old.stock
+)
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM updated
 `
 
 type UpdateProductParams struct {
-	ID          int32
-	Name        string
-	Description sql.NullString
-	Price       string
-	Stock       int32
+	ID                int32
+	TenantID          string
+	Name              string
+	Description       sql.NullString
+	Price             string
+	Stock             int32
+	Category          sql.NullString
+	DescriptionFormat string
+	AvailableFrom     sql.NullTime
+	AvailableUntil    sql.NullTime
 }
 
+// A change of stock is recorded as a movement of the difference
 func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) (Product, error) {
 	row := q.db.QueryRowContext(ctx, updateProduct,
 		arg.ID,
+		arg.TenantID,
 		arg.Name,
 		arg.Description,
 		arg.Price,
 		arg.Stock,
+		arg.Category,
+		arg.DescriptionFormat,
+		arg.AvailableFrom,
+		arg.AvailableUntil,
 	)
 	var i Product
 	err := row.Scan(
@@ -134,6 +656,36 @@ func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) (P
 		&i.Price,
 		&i.Stock,
 		&i.CreatedAt,
+		&i.TenantID,
+		&i.Category,
+		&i.ArchivedAt,
+		&i.DescriptionFormat,
+		&i.AvailableFrom,
+		&i.AvailableUntil,
+		&i.AnnouncedAvailable,
 	)
 	return i, err
 }
+
+const updateProductStock = `-- name: UpdateProductStock :exec
+WITH old AS (
+  SELECT id, stock FROM products WHERE id = $1 FOR UPDATE
+), updated AS (
+  UPDATE products SET stock = $2 WHERE id = $1
+  RETURNING id, tenant_id, stock
+)
+INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+SELECT updated.tenant_id, updated.id, updated.stock - old.stock, 'inventory_sync'
+FROM updated JOIN old ON old.id = updated.id
+WHERE updated.stock <> old.stock
+`
+
+type UpdateProductStockParams struct {
+	ID    int32
+	Stock int32
+}
+
+func (q *Queries) UpdateProductStock(ctx context.Context, arg UpdateProductStockParams) error {
+	_, err := q.db.ExecContext(ctx, updateProductStock, arg.ID, arg.Stock)
+	return err
+}
@@ -0,0 +1,56 @@
+package product
+
+import (
+	"shared/auth"
+	"shared/oplog"
+)
+
+// Roles recognised by the product service
+const (
+	RoleAdmin     = "admin"
+	RoleInventory = "inventory"
+)
+
+// Access is the product service's authorization matrix, evaluated by auth.Authorize
+var Access = auth.Matrix{
+	"GET /products":            auth.AnyPrincipal,
+	"POST /products":           {RoleAdmin, RoleInventory},
+	"GET /products/{id}":       auth.AnyPrincipal,
+	"PUT /products/{id}":       {RoleAdmin, RoleInventory},
+	"DELETE /products/{id}":    {RoleAdmin, RoleInventory},
+	"GET /products/events":     auth.AnyPrincipal,
+	"GET /products/categories": auth.AnyPrincipal,
+
+	"GET /products/categories/tree":      auth.AnyPrincipal,
+	"PUT /products/categories/{slug}":    {RoleAdmin},
+	"DELETE /products/categories/{slug}": {RoleAdmin},
+
+	"POST /products/{id}/{action}": auth.AnyPrincipal, // reserve and release
+
+	"GET /products/{id}/translations/{locale}": auth.AnyPrincipal,
+	"PUT /products/{id}/translations/{locale}": {RoleAdmin, RoleInventory},
+
+	"GET /products/export":           {RoleAdmin},
+	"POST /products/import":          {RoleAdmin},
+	"GET /products/import/{id}":      {RoleAdmin},
+	"POST /products/bulk-delete":     {RoleAdmin},
+	"POST /products/bulk-archive":    {RoleAdmin},
+	"POST /products/bulk-categorize": {RoleAdmin},
+	"GET /jobs/{id}":                 {RoleAdmin},
+	"GET /products/jobs/{id}":        {RoleAdmin},
+}
+
+// Operations are the routes recorded in the operation log, when OPLOG_SINK is set
+var Operations = oplog.Routes{
+	"POST /products":                           {},
+	"PUT /products/{id}":                       {},
+	"DELETE /products/{id}":                    {},
+	"POST /products/{id}/{action}":             {},
+	"PUT /products/{id}/translations/{locale}": {},
+	"PUT /products/categories/{slug}":          {},
+	"DELETE /products/categories/{slug}":       {},
+	"POST /products/import":                    {},
+	"POST /products/bulk-delete":               {},
+	"POST /products/bulk-archive":              {},
+	"POST /products/bulk-categorize":           {},
+}
@@ -0,0 +1,81 @@
+package product
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"shared/auth"
+	"strings"
+	"testing"
+)
+
+// Expected statuses for anonymous, customer, inventory and admin callers
+var (
+	open      = [4]int{http.StatusNoContent, http.StatusNoContent, http.StatusNoContent, http.StatusNoContent}
+	staff     = [4]int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNoContent, http.StatusNoContent}
+	adminOnly = [4]int{http.StatusUnauthorized, http.StatusForbidden, http.StatusForbidden, http.StatusNoContent}
+)
+
+func TestAccessMatrix(t *testing.T) {
+	callers := []struct {
+		name  string
+		roles []string // nil for anonymous
+	}{
+		{name: "anonymous"},
+		{name: "customer", roles: []string{"customer"}},
+		{name: "inventory", roles: []string{RoleInventory}},
+		{name: "admin", roles: []string{RoleAdmin}},
+	}
+
+	cells := []struct {
+		method, pattern, target string
+		want                    [4]int
+	}{
+		{http.MethodGet, "/products", "/products", open},
+		{http.MethodPost, "/products", "/products", staff},
+		{http.MethodGet, "/products/{id}", "/products/5", open},
+		{http.MethodPut, "/products/{id}", "/products/5", staff},
+		{http.MethodDelete, "/products/{id}", "/products/5", staff},
+		{http.MethodGet, "/products/events", "/products/events", open},
+		{http.MethodGet, "/products/categories", "/products/categories", open},
+		{http.MethodGet, "/products/categories/tree", "/products/categories/tree", open},
+		{http.MethodPut, "/products/categories/{slug}", "/products/categories/shoes", adminOnly},
+		{http.MethodDelete, "/products/categories/{slug}", "/products/categories/shoes", adminOnly},
+		{http.MethodPost, "/products/{id}/{action}", "/products/5/reserve", open},
+		{http.MethodGet, "/products/{id}/translations/{locale}", "/products/5/translations/de", open},
+		{http.MethodPut, "/products/{id}/translations/{locale}", "/products/5/translations/de", staff},
+		{http.MethodGet, "/products/export", "/products/export", adminOnly},
+		{http.MethodPost, "/products/import", "/products/import", adminOnly},
+		{http.MethodGet, "/products/import/{id}", "/products/import/9", adminOnly},
+		{http.MethodPost, "/products/bulk-delete", "/products/bulk-delete", adminOnly},
+		{http.MethodPost, "/products/bulk-archive", "/products/bulk-archive", adminOnly},
+		{http.MethodPost, "/products/bulk-categorize", "/products/bulk-categorize", adminOnly},
+		{http.MethodGet, "/jobs/{id}", "/jobs/9", adminOnly},
+		{http.MethodGet, "/products/jobs/{id}", "/products/jobs/9", adminOnly},
+	}
+	if len(cells) != len(Access) {
+		t.Errorf("the table has %d routes, Access has %d; add the new ones here", len(cells), len(Access))
+	}
+
+	for _, cell := range cells {
+		mux := http.NewServeMux()
+		mux.Handle(cell.pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		handler := auth.Authorize(mux, Access, false)(mux)
+
+		for i, caller := range callers {
+			t.Run(cell.method+" "+cell.pattern+" as "+caller.name, func(t *testing.T) {
+				r := httptest.NewRequest(cell.method, cell.target, nil)
+				if caller.roles != nil {
+					r.Header.Set(auth.HeaderUserID, "7")
+					r.Header.Set(auth.HeaderRoles, strings.Join(caller.roles, ","))
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if w.Code != cell.want[i] {
+					t.Errorf("status = %d, want %d", w.Code, cell.want[i])
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package product
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"product-service/internal/db/generated"
+	"shared/auth"
+	"shared/httpx"
+	"shared/tenant"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// availabilityBatch is how many changed products one watcher statement announces
+const availabilityBatch = 500
+
+var availabilityChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "product_availability_changes_total",
+	Help: "Products that entered (available) or left (unavailable) their availability window, as announced by the watcher.",
+}, []string{"change"})
+
+// Window is when a product can be bought. Either end may be open; a product
+// without one is always available.
+type Window struct {
+	From  *time.Time // inclusive
+	Until *time.Time // exclusive
+}
+
+// Contains reports whether t is inside the window
+func (w Window) Contains(t time.Time) bool {
+	return (w.From == nil || !t.Before(*w.From)) && (w.Until == nil || t.Before(*w.Until))
+}
+
+func windowOf(p generated.Product) Window {
+	var w Window
+	if p.AvailableFrom.Valid {
+		w.From = &p.AvailableFrom.Time
+	}
+	if p.AvailableUntil.Valid {
+		w.Until = &p.AvailableUntil.Time
+	}
+	return w
+}
+
+// checkWindow rejects a window that ends before it starts
+func checkWindow(v *httpx.Validation, w Window) {
+	if w.From != nil && w.Until != nil && !w.From.Before(*w.Until) {
+		v.Add("available_until", "must be after available_from")
+	}
+}
+
+// availableFilter returns the time GET /products filters availability windows
+// at: now, unless an admin asked for ?include_unavailable=true, in which case nil
+func (h *Handler) availableFilter(r *http.Request) (*time.Time, error) {
+	now := h.clock.Now().UTC()
+	raw := r.URL.Query().Get("include_unavailable")
+	if raw == "" {
+		return &now, nil
+	}
+	include, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, errors.New("include_unavailable must be true or false")
+	}
+	if !include {
+		return &now, nil
+	}
+	if !auth.FromContext(r.Context()).HasRole(RoleAdmin) {
+		return nil, errIncludeUnavailable
+	}
+	return nil, nil
+}
+
+// errIncludeUnavailable is returned by availableFilter for a caller who isn't an admin
+var errIncludeUnavailable = errors.New("include_unavailable requires the admin role")
+
+// AvailabilityIntervalFromEnv reads AVAILABILITY_CHECK_INTERVAL, how often the
+// availability watcher looks for products entering or leaving their window; default 1m
+func AvailabilityIntervalFromEnv() (time.Duration, error) {
+	raw := os.Getenv("AVAILABILITY_CHECK_INTERVAL")
+	if raw == "" {
+		return time.Minute, nil
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid AVAILABILITY_CHECK_INTERVAL %q", raw)
+	}
+	return interval, nil
+}
+
+// AvailabilityWatcher publishes product.available and product.unavailable when a
+// product's availability changes, whether because its window opened or closed or
+// because it was edited, so caches such as the storefront's can be invalidated.
+// Changes are seen on the first check after they happen.
+type AvailabilityWatcher struct {
+	handler  *Handler
+	interval time.Duration
+}
+
+// NewAvailabilityWatcher creates a watcher that publishes through handler
+func NewAvailabilityWatcher(handler *Handler, interval time.Duration) *AvailabilityWatcher {
+	return &AvailabilityWatcher{handler: handler, interval: interval}
+}
+
+// Run checks on every interval until ctx is cancelled
+func (w *AvailabilityWatcher) Run(ctx context.Context) {
+	log.Printf("Availability watcher started (interval: %s)", w.interval)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.Check(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Availability check failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Availability watcher stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check announces every product whose availability has changed and returns how many it announced
+func (w *AvailabilityWatcher) Check(ctx context.Context) (int, error) {
+	h := w.handler
+	now := h.clock.Now().UTC()
+
+	var total int
+	for {
+		changed, err := h.repo.AnnounceAvailability(ctx, now, availabilityBatch)
+		if err != nil {
+			return total, err
+		}
+		total += len(changed)
+
+		for _, p := range changed {
+			eventType, change := EventProductUnavailable, "unavailable"
+			if p.AnnouncedAvailable {
+				eventType, change = EventProductAvailable, "available"
+			}
+			availabilityChanges.WithLabelValues(change).Inc()
+			// Events go to the subscribers of the product's tenant
+			h.publish(tenant.WithTenant(ctx, p.TenantID), eventType, NewProductResponse(p, now))
+		}
+
+		if len(changed) < availabilityBatch || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Printf("Availability check announced %d products entering or leaving availability", total)
+	}
+	return total, nil
+}
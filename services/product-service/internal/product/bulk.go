@@ -0,0 +1,106 @@
+package product
+
+import (
+	"errors"
+	"net/http"
+	"product-service/internal/db/generated"
+	"shared/api"
+	"shared/httpx"
+	"slices"
+)
+
+// BulkResult reports which of the requested products a bulk operation changed
+type BulkResult = api.BulkResult
+
+func newBulkResult(requested, affected []int32) BulkResult {
+	result := BulkResult{Affected: affected, NotFound: []int32{}}
+	for _, id := range requested {
+		if !slices.Contains(affected, id) {
+			result.NotFound = append(result.NotFound, id)
+		}
+	}
+	return result
+}
+
+// BulkDeleteProducts deletes the products listed in {"ids":[...]}
+func (h *Handler) BulkDeleteProducts(w http.ResponseWriter, r *http.Request) {
+	ids, err := httpx.DecodeIDs(w, r, h.maxBatchSize)
+	if err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+
+	deleted, err := h.repo.DeleteProducts(r.Context(), ids)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, id := range deleted {
+		h.publish(r.Context(), EventProductDeleted, map[string]int32{"id": id})
+	}
+	writeBulkResult(w, r, newBulkResult(ids, deleted))
+}
+
+// BulkArchiveProducts archives the products listed in {"ids":[...]}, hiding them from listings
+func (h *Handler) BulkArchiveProducts(w http.ResponseWriter, r *http.Request) {
+	ids, err := httpx.DecodeIDs(w, r, h.maxBatchSize)
+	if err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+
+	archived, err := h.repo.ArchiveProducts(r.Context(), ids)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.publishUpdated(r, archived)
+	writeBulkResult(w, r, newBulkResult(ids, productIDs(archived)))
+}
+
+// BulkCategorizeProducts moves the products listed in {"ids":[...],"category":"..."}
+// into a category; an empty category removes them from their category
+func (h *Handler) BulkCategorizeProducts(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IDs      []int32 `json:"ids"`
+		Category *string `json:"category"`
+	}
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+	if input.Category == nil {
+		httpx.ValidationFailed(w, []httpx.FieldError{{Field: "category", Message: "is required"}})
+		return
+	}
+	ids, err := httpx.CheckIDs(input.IDs, h.maxBatchSize)
+	if err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+
+	updated, err := h.repo.CategorizeProducts(r.Context(), ids, *input.Category)
+	if errors.Is(err, ErrUnknownCategory) {
+		httpx.ValidationFailed(w, []httpx.FieldError{{Field: "category", Message: "is not a known category"}})
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.publishUpdated(r, updated)
+	writeBulkResult(w, r, newBulkResult(ids, productIDs(updated)))
+}
+
+func (h *Handler) publishUpdated(r *http.Request, products []generated.Product) {
+	for _, p := range products {
+		h.publish(r.Context(), EventProductUpdated, NewProductResponse(p, h.clock.Now()))
+	}
+}
+
+func writeBulkResult(w http.ResponseWriter, r *http.Request, result BulkResult) {
+	httpx.WriteJSON(w, r, http.StatusOK, result)
+}
@@ -0,0 +1,85 @@
+package product
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDeleteProductsReturnsOnlyExistingIDs(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM products")).
+		WithArgs("{1,2,3,4}", testTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO product_history")).
+		WithArgs(testTenant, "{1,3}", HistoryDeleted, []byte("{}")).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	deleted, err := repo.DeleteProducts(tenantContext(), []int32{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(deleted, []int32{1, 3}) {
+		t.Errorf("deleted = %v, want [1 3]", deleted)
+	}
+}
+
+func TestDeleteProductsNoneExisting(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Nothing was deleted, so there is no history to record
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM products")).
+		WithArgs("{7,8}", testTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	deleted, err := repo.DeleteProducts(tenantContext(), []int32{7, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted == nil || len(deleted) != 0 {
+		t.Errorf("deleted = %#v, want an empty slice", deleted)
+	}
+}
+
+func TestBulkDeleteProductsReportsDeletedAndMissing(t *testing.T) {
+	h, mock := newMockHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM products")).
+		WithArgs("{1,2,3}", testTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO product_history")).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	// The duplicate 3 is removed before the delete
+	w := serve(h.BulkDeleteProducts, http.MethodPost, "/products/bulk-delete", `{"ids":[1,2,3,3]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var result BulkResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Affected, []int32{1, 3}) || !slices.Equal(result.NotFound, []int32{2}) {
+		t.Errorf("result = %+v, want affected [1 3] and not_found [2]", result)
+	}
+}
+
+func TestBulkDeleteProductsRejectsTooManyIDs(t *testing.T) {
+	h, _ := newMockHandler(t, WithMaxBatchSize(2))
+
+	w := serve(h.BulkDeleteProducts, http.MethodPost, "/products/bulk-delete", `{"ids":[1,2,3]}`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+}
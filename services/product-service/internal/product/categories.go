@@ -0,0 +1,388 @@
+package product
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"product-service/internal/db/generated"
+	"regexp"
+	"shared/api"
+	"shared/clock"
+	"shared/httpx"
+	"shared/tenant"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrCategoryNotFound is returned when a category doesn't exist
+var ErrCategoryNotFound = errors.New("category not found")
+
+// ErrUnknownParent is returned when a category's parent doesn't exist
+var ErrUnknownParent = errors.New("parent category does not exist")
+
+// ErrCategoryCycle is returned when a category would become its own ancestor
+var ErrCategoryCycle = errors.New("category can't be moved under itself or one of its subcategories")
+
+// ErrCategoryInUse is returned when deleting a category that has subcategories
+// or products filed under it
+var ErrCategoryInUse = errors.New("category has subcategories or products")
+
+// categorySlugPattern is what a category slug may look like, e.g. home-garden
+var categorySlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// reservedCategorySlugs are taken by other routes under /products/categories/, so
+// a category with one of them could be listed but never changed or deleted
+var reservedCategorySlugs = []string{"tree"}
+
+// categoryNameMaxLen matches the categories.name column
+const categoryNameMaxLen = 255
+
+// categoryTreeMaxAge is how long a built tree is served before it is rebuilt in
+// the background, so changes made through another replica show up
+const categoryTreeMaxAge = time.Minute
+
+// categoryTree caches each tenant's category tree, which every storefront page
+// asks for and which rarely changes. A write invalidates the tenant's tree, and
+// it is rebuilt in the background while the previous tree is still served; only
+// the very first request for a tenant waits for a build.
+type categoryTree struct {
+	repo  *Repository
+	clock clock.Clock
+
+	mu      sync.Mutex
+	tenants map[string]*tenantCategoryTree // by tenant ID
+}
+
+// tenantCategoryTree is the cache state of one tenant's tree, guarded by categoryTree.mu
+type tenantCategoryTree struct {
+	current    *builtCategoryTree
+	generation int // bumped on every write; a tree built before it is stale
+	rebuilding bool
+}
+
+// builtCategoryTree is one build of the tree, never modified once stored
+type builtCategoryTree struct {
+	nodes      []CategoryNode
+	etag       string
+	builtAt    time.Time
+	generation int
+}
+
+func newCategoryTree(repo *Repository, c clock.Clock) *categoryTree {
+	return &categoryTree{repo: repo, clock: c, tenants: map[string]*tenantCategoryTree{}}
+}
+
+// stateLocked returns the cache state of tenantID, creating it on first use
+func (t *categoryTree) stateLocked(tenantID string) *tenantCategoryTree {
+	state, ok := t.tenants[tenantID]
+	if !ok {
+		state = &tenantCategoryTree{}
+		t.tenants[tenantID] = state
+	}
+	return state
+}
+
+// get returns the cached tree of the caller's tenant, starting a rebuild if it is stale
+func (t *categoryTree) get(ctx context.Context) (*builtCategoryTree, error) {
+	tenantID := tenant.FromContext(ctx)
+
+	t.mu.Lock()
+	state := t.stateLocked(tenantID)
+	current := state.current
+	if current != nil && (current.generation != state.generation || t.clock.Now().Sub(current.builtAt) > categoryTreeMaxAge) {
+		t.rebuildLocked(tenantID)
+	}
+	t.mu.Unlock()
+	if current != nil {
+		return current, nil
+	}
+
+	built, err := t.build(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	t.store(tenantID, built)
+	return built, nil
+}
+
+// invalidate marks the tree of the caller's tenant stale after a write and rebuilds it
+func (t *categoryTree) invalidate(ctx context.Context) {
+	tenantID := tenant.FromContext(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stateLocked(tenantID).generation++
+	t.rebuildLocked(tenantID)
+}
+
+// rebuildLocked starts a background build of tenantID's tree unless one is
+// running; a write during it leaves the result stale, and the next request
+// starts another
+func (t *categoryTree) rebuildLocked(tenantID string) {
+	state := t.stateLocked(tenantID)
+	if state.rebuilding {
+		return
+	}
+	state.rebuilding = true
+	go func() {
+		ctx, cancel := context.WithTimeout(tenant.WithTenant(context.Background(), tenantID), 30*time.Second)
+		defer cancel()
+		built, err := t.build(ctx, tenantID)
+
+		t.mu.Lock()
+		state.rebuilding = false
+		t.mu.Unlock()
+		if err != nil {
+			log.Printf("Could not rebuild the category tree of tenant %s; serving the previous one: %v", tenantID, err)
+			return
+		}
+		t.store(tenantID, built)
+	}()
+}
+
+func (t *categoryTree) build(ctx context.Context, tenantID string) (*builtCategoryTree, error) {
+	t.mu.Lock()
+	generation := t.stateLocked(tenantID).generation
+	t.mu.Unlock()
+
+	rows, err := t.repo.ListCategoryTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes := nestCategories(rows)
+	body, err := json.Marshal(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode category tree: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	return &builtCategoryTree{
+		nodes:      nodes,
+		etag:       `"` + hex.EncodeToString(sum[:16]) + `"`,
+		builtAt:    t.clock.Now(),
+		generation: generation,
+	}, nil
+}
+
+// store keeps built as tenantID's tree unless a build that started later is already stored
+func (t *categoryTree) store(tenantID string, built *builtCategoryTree) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.stateLocked(tenantID)
+	if state.current == nil || built.generation >= state.current.generation {
+		state.current = built
+	}
+}
+
+// nestCategories turns the rows of ListCategoryTree into the top-level categories
+// with their subcategories nested below them, in slug order at every level
+func nestCategories(rows []generated.ListCategoryTreeRow) []CategoryNode {
+	children := map[string][]generated.ListCategoryTreeRow{}
+	for _, row := range rows {
+		children[row.ParentSlug.String] = append(children[row.ParentSlug.String], row)
+	}
+	var nest func(parent string) []CategoryNode
+	nest = func(parent string) []CategoryNode {
+		nodes := make([]CategoryNode, 0, len(children[parent]))
+		for _, row := range children[parent] {
+			nodes = append(nodes, CategoryNode{Slug: row.Slug, Name: row.Name, Children: nest(row.Slug)})
+		}
+		return nodes
+	}
+	// Top-level categories have no parent, which groups them under ""
+	return nest("")
+}
+
+// CategoryTree serves GET /products/categories/tree: every category of the
+// caller's tenant, nested under its parent. The tree is cached, and a client sending back the ETag in
+// If-None-Match gets a 304 while it is unchanged.
+func (h *Handler) CategoryTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := h.categoryTree.get(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", tree.etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), tree.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	httpx.WriteJSON(w, r, http.StatusOK, tree.nodes)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, compared weakly
+// as RFC 9110 asks for GET
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// PutCategory serves PUT /products/categories/{slug}, creating the category or
+// replacing its name and parent. A parent that doesn't exist, or that would make
+// the category its own ancestor, is refused.
+func (h *Handler) PutCategory(w http.ResponseWriter, r *http.Request) {
+	var input api.CategoryInput
+
+	slug := r.PathValue("slug")
+	if !categorySlugPattern.MatchString(slug) {
+		httpx.Error(w, http.StatusBadRequest, "slug must be lowercase letters, digits and hyphens")
+		return
+	}
+	if slices.Contains(reservedCategorySlugs, slug) {
+		httpx.Error(w, http.StatusBadRequest, fmt.Sprintf("slug %q is reserved", slug))
+		return
+	}
+
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+
+	var v httpx.Validation
+	v.Required("name", &input.Name)
+	v.Name("name", &input.Name, categoryNameMaxLen)
+	parent := ""
+	if input.Parent != nil {
+		parent = *input.Parent
+		if !categorySlugPattern.MatchString(parent) {
+			v.Add("parent", "must be the slug of a category")
+		}
+	}
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	category, created, err := h.repo.PutCategory(r.Context(), slug, input.Name, parent)
+	if errors.Is(err, ErrUnknownParent) || errors.Is(err, ErrCategoryCycle) {
+		httpx.ValidationFailed(w, []httpx.FieldError{{Field: "parent", Message: err.Error()}})
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.categoryTree.invalidate(r.Context())
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	httpx.WriteJSON(w, r, status, NewCategoryResponse(category))
+}
+
+// DeleteCategory serves DELETE /products/categories/{slug}. A category with
+// subcategories or products is kept, and the request is a 409.
+func (h *Handler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	err := h.repo.DeleteCategory(r.Context(), r.PathValue("slug"))
+	if errors.Is(err, ErrCategoryNotFound) {
+		httpx.DeleteNotFound(w, r, err.Error())
+		return
+	}
+	if errors.Is(err, ErrCategoryInUse) {
+		httpx.Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.categoryTree.invalidate(r.Context())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListCategoryTree returns every category of the caller's tenant reachable from a
+// top-level one, parents before their children
+func (r *Repository) ListCategoryTree(ctx context.Context) ([]generated.ListCategoryTreeRow, error) {
+	rows, err := r.q.ListCategoryTree(ctx, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not list categories: %w", err)
+	}
+	return rows, nil
+}
+
+// PutCategory creates or replaces a category in the caller's tenant, reporting
+// whether it was created. A tenant's category writes are serialized, so the
+// cycle check holds until commit.
+func (r *Repository) PutCategory(ctx context.Context, slug, name, parent string) (generated.Category, bool, error) {
+	tenantID := tenant.FromContext(ctx)
+
+	var row generated.UpsertCategoryRow
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		if err := q.LockCategories(ctx, tenantID); err != nil {
+			return fmt.Errorf("could not lock categories: %w", err)
+		}
+		if parent != "" {
+			exists, err := q.CategoryExists(ctx, generated.CategoryExistsParams{TenantID: tenantID, Slug: parent})
+			if err != nil {
+				return fmt.Errorf("could not check parent category: %w", err)
+			}
+			if !exists {
+				return ErrUnknownParent
+			}
+			cycle, err := q.CategoryHasAncestor(ctx, generated.CategoryHasAncestorParams{TenantID: tenantID, Slug: parent, Ancestor: slug})
+			if err != nil {
+				return fmt.Errorf("could not check parent category: %w", err)
+			}
+			if cycle {
+				return ErrCategoryCycle
+			}
+		}
+
+		var err error
+		row, err = q.UpsertCategory(ctx, generated.UpsertCategoryParams{TenantID: tenantID, Slug: slug, Name: name, ParentSlug: nullString(parent)})
+		return err
+	})
+	if errors.Is(err, ErrUnknownParent) || errors.Is(err, ErrCategoryCycle) {
+		return generated.Category{}, false, err
+	}
+	if err != nil {
+		return generated.Category{}, false, fmt.Errorf("could not save category: %w", err)
+	}
+	return generated.Category{
+		Slug:       row.Slug,
+		Name:       row.Name,
+		CreatedAt:  row.CreatedAt,
+		ParentSlug: row.ParentSlug,
+		TenantID:   row.TenantID,
+	}, row.Inserted, nil
+}
+
+// DeleteCategory deletes a category of the caller's tenant that has no
+// subcategories or products
+func (r *Repository) DeleteCategory(ctx context.Context, slug string) error {
+	deleted, err := r.q.DeleteCategory(ctx, generated.DeleteCategoryParams{TenantID: tenant.FromContext(ctx), Slug: slug})
+	if isCategoryInUse(err) {
+		return ErrCategoryInUse
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete category: %w", err)
+	}
+	if deleted == 0 {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// isCategoryInUse reports whether err is a foreign key violation (23503), from a
+// product or subcategory still pointing at the category
+func isCategoryInUse(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
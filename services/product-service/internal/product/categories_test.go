@@ -0,0 +1,163 @@
+package product
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPutCategoryRefusesReservedSlug(t *testing.T) {
+	// No query is expected: GET /products/categories/tree is the category tree, so
+	// a category called tree could never be read, changed or deleted by slug
+	h, _ := newMockHandler(t)
+
+	w := serve(h.PutCategory, http.MethodPut, "/products/categories/tree", `{"name":"Trees"}`, "slug", "tree")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "reserved") {
+		t.Errorf("got %d %s, want 400 for a reserved slug", w.Code, w.Body)
+	}
+}
+
+// expectCategoryLock expects PutCategory to open its transaction and serialize
+// the tenant's category writes
+func expectCategoryLock(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("-- name: LockCategories")).
+		WithArgs(testTenant).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectParentCheck expects PutCategory to find parent and ask whether slug is
+// among its ancestors, answering cycle
+func expectParentCheck(mock sqlmock.Sqlmock, slug, parent string, cycle bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("-- name: CategoryExists")).
+		WithArgs(testTenant, parent).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("-- name: CategoryHasAncestor")).
+		WithArgs(testTenant, parent, slug).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(cycle))
+}
+
+// treeColumns are the columns ListCategoryTree returns
+var treeColumns = []string{"slug", "name", "parent_slug"}
+
+// waitForTree waits for the background rebuild a write starts to store a tree
+func waitForTree(t *testing.T, h *Handler) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		h.categoryTree.mu.Lock()
+		state := h.categoryTree.stateLocked(testTenant)
+		done := state.current != nil && !state.rebuilding
+		h.categoryTree.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("the category tree was not rebuilt")
+}
+
+func TestPutCategoryRefusesCycles(t *testing.T) {
+	// home > lamps > desk-lamps
+	tests := []struct {
+		name, slug, parent string
+	}{
+		{name: "own parent", slug: "lamps", parent: "lamps"},
+		{name: "under its child", slug: "lamps", parent: "desk-lamps"},
+		{name: "under its grandchild", slug: "home", parent: "desk-lamps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newMockHandler(t)
+			expectCategoryLock(mock)
+			expectParentCheck(mock, tt.slug, tt.parent, true)
+			// Nothing is written
+			mock.ExpectRollback()
+
+			w := serve(h.PutCategory, http.MethodPut, "/products/categories/"+tt.slug,
+				`{"name":"Lamps","parent":"`+tt.parent+`"}`, "slug", tt.slug)
+			if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), ErrCategoryCycle.Error()) {
+				t.Errorf("got %d %s, want 422 with %q", w.Code, w.Body, ErrCategoryCycle)
+			}
+		})
+	}
+}
+
+func TestPutCategoryRefusesUnknownParent(t *testing.T) {
+	h, mock := newMockHandler(t)
+	expectCategoryLock(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("-- name: CategoryExists")).
+		WithArgs(testTenant, "furniture").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	w := serve(h.PutCategory, http.MethodPut, "/products/categories/lamps", `{"name":"Lamps","parent":"furniture"}`, "slug", "lamps")
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), ErrUnknownParent.Error()) {
+		t.Errorf("got %d %s, want 422 with %q", w.Code, w.Body, ErrUnknownParent)
+	}
+}
+
+func TestPutCategoryRefusesMalformedParent(t *testing.T) {
+	// No query is expected: the parent can't be a slug, so isn't looked up
+	h, _ := newMockHandler(t)
+
+	for _, parent := range []string{"", "Home", "home/lamps", "../home"} {
+		w := serve(h.PutCategory, http.MethodPut, "/products/categories/lamps", `{"name":"Lamps","parent":"`+parent+`"}`, "slug", "lamps")
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "must be the slug of a category") {
+			t.Errorf("parent %q: got %d %s, want 422", parent, w.Code, w.Body)
+		}
+	}
+}
+
+func TestPutCategoryMovesUnderParent(t *testing.T) {
+	h, mock := newMockHandler(t)
+	expectCategoryLock(mock)
+	expectParentCheck(mock, "lamps", "home", false)
+	mock.ExpectQuery(regexp.QuoteMeta("-- name: UpsertCategory")).
+		WithArgs(testTenant, "lamps", "Lamps", "home").
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "name", "created_at", "parent_slug", "tenant_id", "inserted"}).
+			AddRow("lamps", "Lamps", nil, "home", testTenant, false))
+	mock.ExpectCommit()
+	// The write starts a rebuild of the tree
+	mock.ExpectQuery(regexp.QuoteMeta("-- name: ListCategoryTree")).
+		WithArgs(testTenant).
+		WillReturnRows(sqlmock.NewRows(treeColumns).AddRow("home", "Home", nil).AddRow("lamps", "Lamps", "home"))
+
+	w := serve(h.PutCategory, http.MethodPut, "/products/categories/lamps", `{"name":"Lamps","parent":"home"}`, "slug", "lamps")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	waitForTree(t, h)
+}
+
+func TestCategoryTreeIsCachedWithETag(t *testing.T) {
+	h, mock := newMockHandler(t)
+	// Built once; the requests after it are served from the cache
+	mock.ExpectQuery(regexp.QuoteMeta("-- name: ListCategoryTree")).
+		WithArgs(testTenant).
+		WillReturnRows(sqlmock.NewRows(treeColumns).
+			AddRow("home", "Home", nil).
+			AddRow("lamps", "Lamps", "home").
+			AddRow("desk-lamps", "Desk lamps", "lamps").
+			AddRow("garden", "Garden", nil))
+
+	w := serve(h.CategoryTree, http.MethodGet, "/products/categories/tree", "")
+	want := `[{"slug":"home","name":"Home","children":[{"slug":"lamps","name":"Lamps","children":[{"slug":"desk-lamps","name":"Desk lamps","children":[]}]}]},{"slug":"garden","name":"Garden","children":[]}]`
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != want {
+		t.Fatalf("got %d %s, want 200 %s", w.Code, w.Body, want)
+	}
+	etag := w.Header().Get("ETag")
+
+	r := httptest.NewRequest(http.MethodGet, "/products/categories/tree", nil).WithContext(tenantContext())
+	r.Header.Set("If-None-Match", "W/"+etag)
+	w = httptest.NewRecorder()
+	h.CategoryTree(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("got %d with %d body bytes, want 304 for the current ETag", w.Code, w.Body.Len())
+	}
+}
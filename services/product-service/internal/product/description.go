@@ -0,0 +1,83 @@
+package product
+
+import (
+	"fmt"
+	"net/http"
+	"product-service/internal/db/generated"
+	"shared/httpx"
+	"shared/sanitize"
+	"time"
+	"unicode/utf8"
+)
+
+// Description formats. Descriptions come from back-office users, so HTML is
+// sanitized before it is stored; markdown and plain text are stored as written
+// and only ever reach a page through the rendered description_html field.
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+	FormatPlain    = "plain"
+)
+
+// maxDescriptionLength is the longest description accepted, in characters
+const maxDescriptionLength = 5000
+
+// prepareDescription checks a description and its format, recording problems in v,
+// and returns what to store. An empty format means html.
+func prepareDescription(v *httpx.Validation, description, format string) (string, string) {
+	if format == "" {
+		format = FormatHTML
+	}
+	switch format {
+	case FormatHTML:
+		description = sanitize.HTML(description)
+	case FormatMarkdown, FormatPlain:
+	default:
+		v.Add("description_format", "must be html, markdown or plain")
+	}
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		v.Addf("description", "must be at most %d characters", maxDescriptionLength)
+	}
+	return description, format
+}
+
+// wantsRenderedHTML reports whether the request asked for ?render=html
+func wantsRenderedHTML(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("render") {
+	case "":
+		return false, nil
+	case "html":
+		return true, nil
+	default:
+		return false, fmt.Errorf("render must be html")
+	}
+}
+
+// renderDescription renders a product's description to sanitized HTML
+func renderDescription(p generated.Product) *string {
+	if !p.Description.Valid {
+		return nil
+	}
+	var out string
+	switch p.DescriptionFormat {
+	case FormatMarkdown:
+		out = sanitize.Markdown(p.Description.String)
+	case FormatPlain:
+		out = sanitize.Plain(p.Description.String)
+	default:
+		// Sanitized when saved, but rows written before that was done weren't
+		out = sanitize.HTML(p.Description.String)
+	}
+	return &out
+}
+
+// newRenderedResponses maps product rows, adding description_html when render is set
+func newRenderedResponses(products []generated.Product, now time.Time, render bool) []ProductResponse {
+	out := NewProductResponses(products, now)
+	if render {
+		for i, p := range products {
+			out[i].DescriptionHTML = renderDescription(p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package product
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"shared/jobqueue"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// xssDescription carries a script, an event handler and a javascript: link around
+// formatting that should survive
+const xssDescription = `<script>alert(1)</script><b onmouseover="alert(2)">Bright</b> <a href="javascript:alert(3)">lamp</a><img src=x onerror=alert(4)>`
+
+// sanitizedDescription is xssDescription as it must be stored
+const sanitizedDescription = `<b>Bright</b> <a rel="nofollow noopener">lamp</a>`
+
+// expectCreate expects a product to be created with name and the stored description
+func expectCreate(mock sqlmock.Sqlmock, id int32, name, description string) {
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO products")).
+		WithArgs(testTenant, name, description, "9.99", 5, nil, FormatHTML, nil, nil).
+		WillReturnRows(sqlmock.NewRows(productColumns).
+			AddRow(id, name, description, "9.99", 5, nil, testTenant, nil, nil, FormatHTML, nil, nil, true))
+}
+
+func TestCreateProductSanitizesDescription(t *testing.T) {
+	h, mock := newMockHandler(t)
+	expectCreate(mock, 1, "Lamp", sanitizedDescription)
+
+	body, _ := json.Marshal(map[string]any{"name": "Lamp", "description": xssDescription, "price": 9.99, "stock": 5})
+	w := serve(h.CreateProduct, http.MethodPost, "/products", string(body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var got ProductResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Description == nil || *got.Description != sanitizedDescription {
+		t.Errorf("description = %v, want %q", got.Description, sanitizedDescription)
+	}
+}
+
+func TestImportSanitizesDescription(t *testing.T) {
+	h, mock := newMockHandler(t, WithImportConcurrency(1))
+	expectCreate(mock, 1, "Lamp", sanitizedDescription)
+	// Markdown is stored as written; it is only rendered, and sanitized, on GET
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO products")).
+		WithArgs(testTenant, "Shade", "[x](javascript:alert(1))", "9.99", 5, nil, FormatMarkdown, nil, nil).
+		WillReturnRows(productRow(2, 5))
+
+	payload, _ := json.Marshal([]ImportRow{
+		{Name: "Lamp", Description: xssDescription, Price: 9.99, Stock: 5},
+		{Name: "Shade", Description: "[x](javascript:alert(1))", DescriptionFormat: FormatMarkdown, Price: 9.99, Stock: 5},
+	})
+	result, err := h.RunImportJob(tenantContext(), jobqueue.Job{ID: "job-1", TenantID: testTenant, Payload: payload}, func(jobqueue.Progress) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.(ImportResult); got.Created != 2 || len(got.Failed) != 0 {
+		t.Errorf("result = %+v, want both rows created", got)
+	}
+}
+
+func TestRenderedDescriptionIsSanitized(t *testing.T) {
+	tests := []struct {
+		name, format, stored, want string
+	}{
+		// Rows from before descriptions were sanitized on write
+		{name: "legacy html", format: FormatHTML, stored: xssDescription, want: sanitizedDescription},
+		{name: "markdown", format: FormatMarkdown, stored: "[x](javascript:alert(1)) <script>alert(1)</script>",
+			want: `<p><a rel="nofollow noopener">x</a>) &lt;script&gt;alert(1)&lt;/script&gt;</p>`},
+		{name: "plain", format: FormatPlain, stored: "<script>alert(1)</script>", want: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newMockHandler(t)
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE id = $1 AND tenant_id = $2")).
+				WithArgs(1, testTenant).
+				WillReturnRows(sqlmock.NewRows(productColumns).
+					AddRow(1, "Lamp", tt.stored, "9.99", 5, nil, testTenant, nil, nil, tt.format, nil, nil, true))
+
+			w := serve(h.GetProduct, http.MethodGet, "/products/1?render=html", "", "id", "1")
+			var got ProductResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("status %d: %v", w.Code, err)
+			}
+			if got.DescriptionHTML == nil || *got.DescriptionHTML != tt.want {
+				t.Errorf("description_html = %v, want %q", got.DescriptionHTML, tt.want)
+			}
+		})
+	}
+}
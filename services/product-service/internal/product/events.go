@@ -0,0 +1,47 @@
+package product
+
+import (
+	"context"
+	"log"
+	"shared/events"
+	"time"
+)
+
+// Product event types published on every change
+const (
+	EventProductCreated = "product.created"
+	EventProductUpdated = "product.updated"
+	EventProductDeleted = "product.deleted"
+
+	// A product entered or left its availability window; data is the product
+	EventProductAvailable   = "product.available"
+	EventProductUnavailable = "product.unavailable"
+
+	EventStockChanged = "stock.changed"
+	EventPriceChanged = "price.changed"
+)
+
+// Change is the data of stock.changed and price.changed events
+type Change[T any] struct {
+	ID  int32 `json:"id"`
+	Old T     `json:"old"`
+	New T     `json:"new"`
+}
+
+// publish sends a product event. The database change is already committed by the
+// time this runs, so a failed publish is logged rather than failing the request.
+func (h *Handler) publish(ctx context.Context, eventType string, data any) {
+	event := events.Event{
+		ID:         h.ids.NewID(),
+		Type:       eventType,
+		OccurredAt: h.clock.Now().UTC(),
+		Data:       data,
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := h.publisher.Publish(ctx, event); err != nil {
+		log.Printf("Could not publish %s event: %v", eventType, err)
+	}
+}
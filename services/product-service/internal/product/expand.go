@@ -0,0 +1,119 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"product-service/internal/db/generated"
+	"slices"
+	"strings"
+)
+
+// Related collections a product read can embed, named in ?expand
+const (
+	ExpandCategories   = "categories"
+	ExpandTranslations = "translations"
+)
+
+// maxListExpansions caps how many collections a listing may embed, since each
+// one is another query for every page
+const maxListExpansions = 1
+
+// expanders maps each ?expand value to the function embedding it. Each loads its
+// collection for every product in one query, never one per product. A new
+// expansion adds an entry here and a field to api.Product.
+var expanders = map[string]func(h *Handler, ctx context.Context, products []ProductResponse) error{
+	ExpandCategories:   (*Handler).expandCategories,
+	ExpandTranslations: (*Handler).expandTranslations,
+}
+
+// parseExpand reads the comma-separated ?expand of a product read. An unknown
+// value is an error, as is a listing naming more than maxListExpansions.
+func parseExpand(r *http.Request, listing bool) ([]string, error) {
+	raw := r.URL.Query().Get("expand")
+	if raw == "" {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := expanders[name]; !ok {
+			return nil, fmt.Errorf("unknown expand value %q (supported: %s)", name, strings.Join(slices.Sorted(maps.Keys(expanders)), ", "))
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if listing && len(names) > maxListExpansions {
+		return nil, fmt.Errorf("a listing can expand at most %d of %s", maxListExpansions, strings.Join(names, ", "))
+	}
+	return names, nil
+}
+
+// expand embeds the named collections in products
+func (h *Handler) expand(ctx context.Context, names []string, products []ProductResponse) error {
+	if len(products) == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if err := expanders[name](h, ctx, products); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandCategories embeds each product's category with its ancestors, so a page
+// can show a breadcrumb. A tenant has few categories, so all of them are read at
+// once.
+func (h *Handler) expandCategories(ctx context.Context, products []ProductResponse) error {
+	categories, err := h.repo.ListCategories(ctx)
+	if err != nil {
+		return err
+	}
+	bySlug := make(map[string]generated.Category, len(categories))
+	for _, c := range categories {
+		bySlug[c.Slug] = c
+	}
+
+	for i, p := range products {
+		path := []CategoryResponse{}
+		if p.Category != nil {
+			// A path can't be longer than there are categories, which also stops
+			// at a cycle should one get past PutCategory
+			for slug := *p.Category; slug != "" && len(path) < len(categories); {
+				c, ok := bySlug[slug]
+				if !ok {
+					break
+				}
+				path = append(path, NewCategoryResponse(c))
+				slug = c.ParentSlug.String
+			}
+			slices.Reverse(path)
+		}
+		products[i].Categories = &path
+	}
+	return nil
+}
+
+// expandTranslations embeds every translation of each product
+func (h *Handler) expandTranslations(ctx context.Context, products []ProductResponse) error {
+	ids := make([]int32, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	translations, err := h.repo.ListAllTranslations(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range products {
+		out := make([]TranslationResponse, 0, len(translations[p.ID]))
+		for _, t := range translations[p.ID] {
+			out = append(out, NewTranslationResponse(t))
+		}
+		products[i].Translations = &out
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package product
+
+import "shared/featureflag"
+
+// Feature flags understood by the product service
+const (
+	FlagBulkOperations = "bulk-operations"
+)
+
+// Flags declares the feature flags understood by the product service.
+// Each can be overridden with FEATURE_<NAME>=true|false or at runtime via /admin/flags.
+var Flags = []featureflag.Flag{
+	{
+		Name:        FlagBulkOperations,
+		Description: "Serve the /products/bulk-* endpoints; turn off to hide them",
+		Default:     true,
+	},
+}
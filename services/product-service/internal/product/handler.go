@@ -1,96 +1,327 @@
 package product
 
 import (
-	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"product-service/internal/db/generated"
+	"shared/featureflag"
+	"shared/httpx"
 	"strconv"
+	"time"
 )
 
 type Handler struct {
-	repo *Repository
+	repo         *Repository
+	flags        *featureflag.Set
+	categoryTree *categoryTree
+	options
 }
 
-func NewHandler(repo *Repository) *Handler {
-	return &Handler{repo: repo}
+func NewHandler(repo *Repository, flags *featureflag.Set, opts ...Option) *Handler {
+	o := newOptions(opts)
+	return &Handler{repo: repo, flags: flags, categoryTree: newCategoryTree(repo, o.clock), options: o}
 }
 
 func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.repo.ListProducts(r.Context())
+	page, err := httpx.ParsePage(r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	render, err := wantsRenderedHTML(r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	locale, err := h.requestLocale(w, r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expand, err := parseExpand(r, true)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Products outside their availability window are only listed for admins who ask
+	availableAt, err := h.availableFilter(r)
+	if errors.Is(err, errIncludeUnavailable) {
+		httpx.Error(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// MAX_RESULT_ROWS is a hard cap on top of the page size
+	truncated := page.Limit > h.maxResultRows
+	if truncated {
+		page.Limit = h.maxResultRows
+	}
+
+	q := r.URL.Query()
+	snapshot := false
+	if raw := q.Get("snapshot"); raw != "" {
+		if snapshot, err = strconv.ParseBool(raw); err != nil {
+			httpx.Error(w, http.StatusBadRequest, "snapshot must be true or false")
+			return
+		}
+	}
+	if q.Has("cursor") || snapshot {
+		if q.Has("offset") {
+			httpx.Error(w, http.StatusBadRequest, "offset can't be combined with cursor or snapshot")
+			return
+		}
+		h.listProductsByCursor(w, r, page.Limit, locale, expand, availableAt, snapshot, render, truncated)
+		return
+	}
+
+	// Fetch one extra row to find out whether there is a next page
+	products, err := h.repo.ListProducts(r.Context(), r.URL.Query().Get("category"), availableAt, int32(page.Limit+1), int32(page.Offset))
+	if errors.Is(err, ErrUnknownCategory) {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	hasNext := len(products) > page.Limit
+	if hasNext {
+		products = products[:page.Limit]
+	}
+
+	data, err := h.localize(r.Context(), locale, products, render)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.expand(r.Context(), expand, data); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := httpx.NewListResponse(r, page, data, hasNext)
+	response.Truncated = truncated
+	httpx.WriteJSON(w, r, http.StatusOK, response)
+}
+
+// listProductsByCursor serves a keyset page of GET /products. Positions are IDs,
+// so products created or archived between pages don't shift the ones after them.
+// A snapshot listing also leaves out products created after it started; its
+// cursors are refused with 410 once they are older than CURSOR_MAX_AGE, and the
+// client starts over.
+func (h *Handler) listProductsByCursor(w http.ResponseWriter, r *http.Request, limit int, locale string, expand []string, availableAt *time.Time, snapshot, render, truncated bool) {
+	var cursor httpx.Cursor
+	if raw := r.URL.Query().Get("cursor"); raw != "" {
+		var err error
+		cursor, err = httpx.ParseCursor(raw, h.clock.Now(), h.cursorMaxAge)
+		if errors.Is(err, httpx.ErrCursorExpired) {
+			httpx.ErrorCode(w, http.StatusGone, "cursor_expired", err.Error())
+			return
+		}
+		if err != nil || cursor.After > math.MaxInt32 {
+			httpx.Error(w, http.StatusBadRequest, "cursor is invalid")
+			return
+		}
+	} else if snapshot {
+		now := h.clock.Now().UTC()
+		cursor.Snapshot = &now
+	}
+
+	products, err := h.repo.ListProductsAfter(r.Context(), r.URL.Query().Get("category"), int32(cursor.After), cursor.Snapshot, availableAt, int32(limit+1))
+	if errors.Is(err, ErrUnknownCategory) {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	next := ""
+	if len(products) > limit {
+		products = products[:limit]
+		next = httpx.Cursor{After: int64(products[limit-1].ID), Snapshot: cursor.Snapshot}.Encode()
+	}
+
+	data, err := h.localize(r.Context(), locale, products, render)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.expand(r.Context(), expand, data); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httpx.WriteJSON(w, r, http.StatusOK, httpx.ListResponse[ProductResponse]{
+		Version:    httpx.EnvelopeVersion,
+		Data:       data,
+		Limit:      limit,
+		Links:      httpx.CursorLinks(r, limit, next),
+		Truncated:  truncated,
+		NextCursor: next,
+	})
+}
 
+// ListCategories lists the categories products can be filed under
+func (h *Handler) ListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.repo.ListCategories(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(products)
+	httpx.WriteJSON(w, r, http.StatusOK, NewCategoryResponses(categories))
+}
+
+// ExportProducts streams every product in the caller's tenant, up to MAX_RESULT_ROWS
+func (h *Handler) ExportProducts(w http.ResponseWriter, r *http.Request) {
+	out := httpx.NewListWriter(w)
+	written, truncated := 0, false
+
+	// Read one row past the cap to find out whether there were more
+	err := h.repo.EachProduct(r.Context(), int32(h.maxResultRows+1), func(p generated.Product) error {
+		if written == h.maxResultRows {
+			truncated = true
+			return nil
+		}
+		written++
+		return out.Write(NewProductResponse(p, h.clock.Now()))
+	})
+	out.Close(truncated, err)
 }
 
+// CreateProduct creates a product from a body in any version of the schema; see decodeProduct
 func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name        string  `json:"name"`
-		Description string  `json:"description"`
-		Price       float64 `json:"price"`
-		Stock       int32   `json:"stock"`
+	input, err := decodeProduct(w, r)
+	if err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "name, description, price, and stock are required", http.StatusBadRequest)
+	var v httpx.Validation
+	v.Required("name", input.Name)
+	v.Name("name", input.Name, h.nameMaxLen)
+	description, format := prepareDescription(&v, input.Description, input.DescriptionFormat)
+	checkPrice(&v, "price", input.Price, h.priceScale)
+	window := Window{From: input.AvailableFrom, Until: input.AvailableUntil}
+	checkWindow(&v, window)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
 		return
 	}
 
 	// Convert price to string for repository (to maintain precision with DECIMAL)
-	priceStr := strconv.FormatFloat(input.Price, 'f', 2, 64)
-	product, err := h.repo.CreateProduct(r.Context(), input.Name, input.Description, priceStr, input.Stock)
+	priceStr := formatPrice(input.Price, h.priceScale)
+	product, err := h.repo.CreateProduct(r.Context(), *input.Name, description, format, priceStr, input.Stock, input.Category, window)
+	if errors.Is(err, ErrDuplicateName) {
+		httpx.Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, ErrUnknownCategory) {
+		httpx.ValidationFailed(w, []httpx.FieldError{{Field: "category", Message: "is not a known category"}})
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(product)
+	h.publish(r.Context(), EventProductCreated, NewProductResponse(product, h.clock.Now()))
+
+	httpx.WriteJSON(w, r, http.StatusCreated, NewProductResponse(product, h.clock.Now()))
 }
 
 // UpdateProduct updates a product in the database
 func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name        string  `json:"name"`
-		Description string  `json:"description"`
-		Price       float64 `json:"price"`
-		Stock       int32   `json:"stock"`
+		Name              *string    `json:"name"`
+		Description       string     `json:"description"`
+		DescriptionFormat string     `json:"description_format"`
+		Price             float64    `json:"price"`
+		Stock             int32      `json:"stock"`
+		Category          string     `json:"category"`
+		AvailableFrom     *time.Time `json:"available_from"`
+		AvailableUntil    *time.Time `json:"available_until"`
 	}
 
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id is required")
 		return
 	}
 
 	// Parse id to int32
 	idInt, err := strconv.ParseInt(id, 10, 32)
 	if err != nil {
-		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "name, description, price, and stock are required", http.StatusBadRequest)
+	var v httpx.Validation
+	v.Required("name", input.Name)
+	v.Name("name", input.Name, h.nameMaxLen)
+	description, format := prepareDescription(&v, input.Description, input.DescriptionFormat)
+	checkPrice(&v, "price", input.Price, h.priceScale)
+	window := Window{From: input.AvailableFrom, Until: input.AvailableUntil}
+	checkWindow(&v, window)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	// Read the current values so stock and price changes can be announced
+	before, err := h.repo.GetProduct(r.Context(), int32(idInt))
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Convert price to string for repository (to maintain precision with DECIMAL)
-	priceStr := strconv.FormatFloat(input.Price, 'f', 2, 64)
-	product, err := h.repo.UpdateProduct(r.Context(), int32(idInt), input.Name, input.Description, priceStr, input.Stock)
+	priceStr := formatPrice(input.Price, h.priceScale)
+	product, err := h.repo.UpdateProduct(r.Context(), int32(idInt), *input.Name, description, format, priceStr, input.Stock, input.Category, window)
+	if errors.Is(err, ErrDuplicateName) {
+		httpx.Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, ErrUnknownCategory) {
+		httpx.ValidationFailed(w, []httpx.FieldError{{Field: "category", Message: "is not a known category"}})
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(product)
+	h.publish(r.Context(), EventProductUpdated, NewProductResponse(product, h.clock.Now()))
+	if product.Stock != before.Stock {
+		h.publish(r.Context(), EventStockChanged, Change[int32]{ID: product.ID, Old: before.Stock, New: product.Stock})
+	}
+	if product.Price != before.Price {
+		oldPrice, _ := parsePrice(before.Price)
+		newPrice, _ := parsePrice(product.Price)
+		h.publish(r.Context(), EventPriceChanged, Change[float64]{ID: product.ID, Old: oldPrice, New: newPrice})
+	}
+
+	httpx.WriteJSON(w, r, http.StatusOK, NewProductResponse(product, h.clock.Now()))
 }
 
 // DeleteProduct deletes a product from the database
@@ -98,23 +329,29 @@ func (h *Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id is required")
 		return
 	}
 
 	// Parse id to int32
 	idInt, err := strconv.ParseInt(id, 10, 32)
 	if err != nil {
-		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
 		return
 	}
 
 	err = h.repo.DeleteProduct(r.Context(), int32(idInt))
+	if errors.Is(err, ErrNotFound) {
+		httpx.DeleteNotFound(w, r, err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	h.publish(r.Context(), EventProductDeleted, map[string]int32{"id": int32(idInt)})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -123,24 +360,51 @@ func (h *Handler) GetProduct(w http.ResponseWriter, r *http.Request) {
 
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id is required")
 		return
 	}
 
 	// Parse id to int32
 	idInt, err := strconv.ParseInt(id, 10, 32)
 	if err != nil {
-		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+
+	render, err := wantsRenderedHTML(r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	locale, err := h.requestLocale(w, r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expand, err := parseExpand(r, false)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	product, err := h.repo.GetProduct(r.Context(), int32(idInt))
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(product)
+	responses, err := h.localize(r.Context(), locale, []generated.Product{product}, render)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.expand(r.Context(), expand, responses); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.WriteJSON(w, r, http.StatusOK, responses[0])
 }
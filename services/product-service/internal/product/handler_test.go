@@ -0,0 +1,203 @@
+package product
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"shared/clock"
+	"shared/httpx"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// listingStart is when the listings below start
+var listingStart = time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+// catalog stands in for the products table while a listing pages through it
+type catalog struct {
+	created map[int32]time.Time // by ID
+	lastID  int32
+}
+
+// newCatalog returns a catalog of n products created an hour before listingStart
+func newCatalog(n int) *catalog {
+	c := &catalog{created: map[int32]time.Time{}}
+	for range n {
+		c.insert(listingStart.Add(-time.Hour))
+	}
+	return c
+}
+
+// insert adds a product created at, taking the next ID as the sequence would
+func (c *catalog) insert(at time.Time) {
+	c.lastID++
+	c.created[c.lastID] = at
+}
+
+// expectPage expects one keyset query after afterID with snapshot, available at
+// now, and answers it as ListProductsAfter would from the catalog as it stands
+func (c *catalog) expectPage(mock sqlmock.Sqlmock, afterID int32, snapshot any, now time.Time, limit int) {
+	rows := sqlmock.NewRows(productColumns)
+	found := 0
+	for id := afterID + 1; id <= c.lastID && found < limit+1; id++ {
+		created := c.created[id]
+		if s, ok := snapshot.(time.Time); ok && created.After(s) {
+			continue
+		}
+		rows.AddRow(id, "Product", nil, "9.99", 5, created, testTenant, nil, nil, FormatPlain, nil, nil, true)
+		found++
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("-- name: ListProductsAfter")).
+		WithArgs(testTenant, afterID, limit+1, snapshot, now).
+		WillReturnRows(rows)
+}
+
+// listPage gets target from ListProducts and decodes the page
+func listPage(t *testing.T, h *Handler, target string) httpx.ListResponse[ProductResponse] {
+	t.Helper()
+	w := serve(h.ListProducts, http.MethodGet, target, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d, want 200: %s", target, w.Code, w.Body)
+	}
+	var page httpx.ListResponse[ProductResponse]
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestSnapshotListingIgnoresConcurrentInserts(t *testing.T) {
+	clk := clock.NewFake(listingStart)
+	h, mock := newMockHandler(t, WithClock(clk))
+	products := newCatalog(10)
+
+	seen := map[int32]int{}
+	target := "/products?snapshot=true&limit=4"
+	for page, after := 0, int32(0); ; page++ {
+		products.expectPage(mock, after, listingStart, clk.Now(), 4)
+		got := listPage(t, h, target)
+		for _, p := range got.Data {
+			seen[p.ID]++
+			after = p.ID
+		}
+
+		// While the client reads the page, other writers add products
+		clk.Advance(10 * time.Minute)
+		products.insert(clk.Now())
+		products.insert(clk.Now())
+
+		if got.NextCursor == "" {
+			if page != 2 {
+				t.Errorf("listing ended after %d pages, want 3", page+1)
+			}
+			break
+		}
+		if page == 5 {
+			t.Fatal("listing did not end")
+		}
+		target = "/products?limit=4&cursor=" + url.QueryEscape(got.NextCursor)
+	}
+
+	// Every product that existed when the listing started, once, and none added since
+	for id := int32(1); id <= 10; id++ {
+		if seen[id] != 1 {
+			t.Errorf("product %d listed %d times, want once", id, seen[id])
+		}
+	}
+	if len(seen) != 10 {
+		t.Errorf("listed %d products, want the 10 from before the snapshot", len(seen))
+	}
+}
+
+func TestCursorListingDoesNotRepeatRowsAfterInserts(t *testing.T) {
+	clk := clock.NewFake(listingStart)
+	h, mock := newMockHandler(t, WithClock(clk))
+	products := newCatalog(6)
+
+	// Without a snapshot, rows added mid-listing show up at its end, but none shift
+	seen := map[int32]int{}
+	products.expectPage(mock, 0, nil, clk.Now(), 4)
+	first := listPage(t, h, "/products?cursor=&limit=4")
+	products.insert(clk.Now())
+	products.expectPage(mock, 4, nil, clk.Now(), 4)
+	second := listPage(t, h, "/products?limit=4&cursor="+url.QueryEscape(first.NextCursor))
+	for _, p := range append(first.Data, second.Data...) {
+		seen[p.ID]++
+	}
+
+	if len(seen) != 7 || second.NextCursor != "" {
+		t.Errorf("listed %v (next %q), want products 1 to 7 and no next page", seen, second.NextCursor)
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("product %d listed %d times, want once", id, n)
+		}
+	}
+}
+
+func TestStaleSnapshotCursorIsGone(t *testing.T) {
+	clk := clock.NewFake(listingStart)
+	h, mock := newMockHandler(t, WithClock(clk), WithCursorMaxAge(30*time.Minute))
+	cursor := httpx.Cursor{After: 4, Snapshot: &listingStart}.Encode()
+
+	// At the edge of the window the listing can still be continued
+	clk.Advance(30 * time.Minute)
+	newCatalog(10).expectPage(mock, 4, listingStart, clk.Now(), 20)
+	listPage(t, h, "/products?cursor="+cursor)
+
+	// Past it, the client is told to start over, and the database isn't asked
+	clk.Advance(time.Second)
+	w := serve(h.ListProducts, http.MethodGet, "/products?cursor="+cursor, "")
+	if w.Code != http.StatusGone {
+		t.Fatalf("status = %d, want 410: %s", w.Code, w.Body)
+	}
+	var body httpx.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != "cursor_expired" {
+		t.Errorf("code = %q, want cursor_expired", body.Code)
+	}
+}
+
+func TestPlainCursorDoesNotExpire(t *testing.T) {
+	clk := clock.NewFake(listingStart)
+	h, mock := newMockHandler(t, WithClock(clk), WithCursorMaxAge(30*time.Minute))
+	cursor := httpx.Cursor{After: 4}.Encode()
+
+	clk.Advance(24 * time.Hour)
+	newCatalog(10).expectPage(mock, 4, nil, clk.Now(), 20)
+	listPage(t, h, "/products?cursor="+cursor)
+}
+
+func TestListProductsRejectsBadCursor(t *testing.T) {
+	// No query is expected: each request is refused first
+	h, _ := newMockHandler(t)
+
+	tests := []struct {
+		name, query string
+	}{
+		{name: "not base64", query: "cursor=%25%25"},
+		{name: "not json", query: "cursor=" + "bm90IGpzb24"},
+		{name: "negative", query: "cursor=" + httpx.Cursor{After: -1}.Encode()},
+		{name: "beyond int32", query: "cursor=" + httpx.Cursor{After: 1 << 40}.Encode()},
+		{name: "with offset", query: "cursor=" + httpx.Cursor{After: 4}.Encode() + "&offset=20"},
+		{name: "snapshot with offset", query: "snapshot=true&offset=20"},
+		{name: "bad snapshot", query: "snapshot=maybe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h.ListProducts, http.MethodGet, "/products?"+tt.query, "")
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400: %s", w.Code, w.Body)
+			}
+			if strings.Contains(w.Body.String(), "cursor_expired") {
+				t.Errorf("body = %s, want a plain 400", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package product
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"shared/jobqueue"
+	"shared/tenant"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JobImport is the job kind for bulk product imports
+const JobImport = "product.import"
+
+// Import limits; larger catalogs should be split into several imports
+const (
+	maxImportBytes = 50 << 20 // 50MB
+	maxImportRows  = 50000
+)
+
+// progressEvery is how many rows are imported between progress updates. Rows
+// are created concurrently within such a chunk, and a chunk is finished before
+// its progress is reported, so a retry can resume after it.
+const progressEvery = 100
+
+// ImportConcurrencyFromEnv reads IMPORT_CONCURRENCY, how many rows of one import
+// are created at once; default 4. Each takes a database connection.
+func ImportConcurrencyFromEnv() (int, error) {
+	raw := os.Getenv("IMPORT_CONCURRENCY")
+	if raw == "" {
+		return 4, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid IMPORT_CONCURRENCY %q", raw)
+	}
+	return n, nil
+}
+
+// ImportRow is one product in an import request
+type ImportRow struct {
+	Name              string  `json:"name"`
+	Description       string  `json:"description"`
+	DescriptionFormat string  `json:"description_format"`
+	Price             float64 `json:"price"`
+	Stock             int32   `json:"stock"`
+	Category          string  `json:"category"`
+
+	// The availability window; either end may be left open
+	AvailableFrom  *time.Time `json:"available_from,omitempty"`
+	AvailableUntil *time.Time `json:"available_until,omitempty"`
+
+	// Translations of name and description, by locale; each locale must be in PRODUCT_LOCALES
+	Translations map[string]TranslationInput `json:"translations,omitempty"`
+}
+
+// ImportFailure reports a row that could not be imported
+type ImportFailure struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportResult is stored as the result of a finished import job
+type ImportResult struct {
+	Created int             `json:"created"`
+	Failed  []ImportFailure `json:"failed"`
+}
+
+// ImportProducts enqueues a bulk import of a JSON array of products and answers
+// 202 with the job, whose progress can be polled at the URL in the Location header
+func (h *Handler) ImportProducts(w http.ResponseWriter, r *http.Request) {
+	var rows []ImportRow
+	if err := httpx.DecodeJSONLimit(w, r, &rows, maxImportBytes); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		httpx.Error(w, http.StatusBadRequest, "at least one product is required")
+		return
+	}
+	if len(rows) > maxImportRows {
+		httpx.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d products can be imported at once", maxImportRows))
+		return
+	}
+
+	job, err := h.jobs.Enqueue(r.Context(), tenant.FromContext(r.Context()), JobImport, rows)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	location := httpx.BaseURL(r)
+	location.Path += "/products/import/" + job.ID
+	w.Header().Set("Location", location.String())
+	httpx.WriteJSON(w, r, http.StatusAccepted, job)
+}
+
+// GetJob reports the status, progress and result of a background job
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(r.Context(), tenant.FromContext(r.Context()), r.PathValue("id"))
+	if errors.Is(err, jobqueue.ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httpx.WriteJSON(w, r, http.StatusOK, job)
+}
+
+// GetImport reports the progress of an import job: rows processed, the total and
+// how many failed so far, and once it has finished the ImportResult
+func (h *Handler) GetImport(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(r.Context(), tenant.FromContext(r.Context()), r.PathValue("id"))
+	if errors.Is(err, jobqueue.ErrNotFound) || (err == nil && job.Kind != JobImport) {
+		httpx.Error(w, http.StatusNotFound, "import not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httpx.WriteJSON(w, r, http.StatusOK, job)
+}
+
+// RunImportJob is the jobqueue.Handler for JobImport. Rows that fail validation or
+// collide with an existing name are reported in the result; any other error fails the
+// attempt, and the retry resumes after the last reported progress, so the result of a
+// retried job only counts rows from the final attempt.
+func (h *Handler) RunImportJob(ctx context.Context, job jobqueue.Job, progress jobqueue.ReportProgress) (any, error) {
+	var rows []ImportRow
+	if err := json.Unmarshal(job.Payload, &rows); err != nil {
+		return nil, fmt.Errorf("could not decode import payload: %w", err)
+	}
+
+	ctx = tenant.WithTenant(ctx, job.TenantID)
+
+	start := 0
+	if job.Progress != nil {
+		start = job.Progress.Processed
+		log.Printf("Import job %s resuming at row %d", job.ID, start)
+	}
+
+	result := ImportResult{Failed: []ImportFailure{}}
+	for from := start; from < len(rows); from += progressEvery {
+		to := min(from+progressEvery, len(rows))
+		failed, err := h.importChunk(ctx, rows, from, to)
+		if err != nil {
+			return nil, err
+		}
+		result.Created += to - from - len(failed)
+		result.Failed = append(result.Failed, failed...)
+		progress(jobqueue.Progress{Processed: to, Total: len(rows), Failed: len(result.Failed)})
+	}
+	if start >= len(rows) {
+		progress(jobqueue.Progress{Processed: len(rows), Total: len(rows)})
+	}
+
+	return result, nil
+}
+
+// importChunk creates rows[from:to] with up to importWorkers rows in flight and
+// returns the rows that failed, in order. Any error other than a row's own stops
+// the chunk and is returned.
+func (h *Handler) importChunk(ctx context.Context, rows []ImportRow, from, to int) ([]ImportFailure, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := []ImportFailure{}
+	next := make(chan int)
+	for range min(h.importWorkers, to-from) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				err := h.importRow(ctx, rows[i])
+				var rowErr rowError
+				switch {
+				case err == nil:
+				case errors.As(err, &rowErr):
+					mu.Lock()
+					failed = append(failed, ImportFailure{Row: i, Error: err.Error()})
+					mu.Unlock()
+				default:
+					cancel(err)
+				}
+			}
+		}()
+	}
+	for i := from; i < to && ctx.Err() == nil; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(failed, func(a, b ImportFailure) int { return a.Row - b.Row })
+	return failed, nil
+}
+
+// rowError is a problem with a single import row that should not fail the whole job
+type rowError struct {
+	msg string
+}
+
+func (e rowError) Error() string {
+	return e.msg
+}
+
+// importRow creates one imported product and publishes its event
+func (h *Handler) importRow(ctx context.Context, row ImportRow) error {
+	// Imported rows get the same checks, sanitizing and limits as ones sent to CreateProduct
+	var v httpx.Validation
+	v.Required("name", &row.Name)
+	v.Name("name", &row.Name, h.nameMaxLen)
+	description, format := prepareDescription(&v, row.Description, row.DescriptionFormat)
+	checkPrice(&v, "price", row.Price, h.priceScale)
+	window := Window{From: row.AvailableFrom, Until: row.AvailableUntil}
+	checkWindow(&v, window)
+	translations := make([]Translation, 0, len(row.Translations))
+	for _, locale := range slices.Sorted(maps.Keys(row.Translations)) {
+		translations = append(translations, h.prepareTranslation(&v, "translations."+locale+".", locale, row.Translations[locale]))
+	}
+	if !v.Valid() {
+		problem := v.Errors()[0]
+		return rowError{problem.Field + " " + problem.Message}
+	}
+
+	priceStr := formatPrice(row.Price, h.priceScale)
+	product, err := h.repo.CreateProduct(ctx, row.Name, description, format, priceStr, row.Stock, row.Category, window, translations...)
+	if errors.Is(err, ErrDuplicateName) || errors.Is(err, ErrUnknownCategory) {
+		return rowError{err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+
+	h.publish(ctx, EventProductCreated, NewProductResponse(product, h.clock.Now()))
+	return nil
+}
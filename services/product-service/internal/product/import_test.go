@@ -0,0 +1,222 @@
+package product
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"shared/clock"
+	"shared/featureflag"
+	"shared/ids"
+	"shared/jobqueue"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newImportHandler returns a Handler over newMockRepository whose job queue shares
+// the mock database
+func newImportHandler(t *testing.T, opts ...Option) (*Handler, sqlmock.Sqlmock) {
+	t.Helper()
+	repo, mock := newMockRepository(t, opts...)
+	q := jobqueue.New(repo.db, jobqueue.DefaultConfig(), clock.NewFake(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)), ids.NewSequence())
+	return NewHandler(repo, featureflag.New(Flags...), append(opts, WithJobQueue(q))...), mock
+}
+
+// jobColumns are the columns the job queries return, in order
+var jobColumns = []string{"id", "tenant_id", "kind", "status", "payload", "attempts", "max_attempts",
+	"progress", "result", "last_error", "run_at", "created_at", "started_at", "finished_at"}
+
+// importRows returns n valid rows named Product 0, Product 1, ...
+func importRows(n int) []ImportRow {
+	rows := make([]ImportRow, n)
+	for i := range rows {
+		rows[i] = ImportRow{Name: fmt.Sprintf("Product %d", i), Price: 9.99, Stock: 5}
+	}
+	return rows
+}
+
+// expectImported expects the named rows of rows[from:to] to be created, in any
+// order; rows without a name fail validation before the database
+func expectImported(mock sqlmock.Sqlmock, rows []ImportRow, from, to int) {
+	mock.MatchExpectationsInOrder(false)
+	for i := from; i < to; i++ {
+		if rows[i].Name == "" {
+			continue
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO products")).
+			WithArgs(testTenant, rows[i].Name, nil, "9.99", 5, nil, FormatHTML, nil, nil).
+			WillReturnRows(productRow(int32(i+1), 5))
+	}
+}
+
+// progressLog collects the progress a job reports
+type progressLog struct {
+	mu      sync.Mutex
+	reports []jobqueue.Progress
+}
+
+func (l *progressLog) report(p jobqueue.Progress) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reports = append(l.reports, p)
+}
+
+func TestImportProductsCreatesJob(t *testing.T) {
+	h, mock := newImportHandler(t)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO jobs")).
+		WithArgs("00000000-0000-0000-0000-000000000001", testTenant, JobImport, jobqueue.StatusQueued, sqlmock.AnyArg(), 5, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	body, _ := json.Marshal(importRows(3))
+	w := serve(h.ImportProducts, http.MethodPost, "/products/import", string(body))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Location"); !strings.HasSuffix(got, "/products/import/00000000-0000-0000-0000-000000000001") {
+		t.Errorf("Location = %q, want the job's progress URL", got)
+	}
+	var job jobqueue.Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Kind != JobImport || job.Status != jobqueue.StatusQueued {
+		t.Errorf("job = %+v, want a queued import", job)
+	}
+}
+
+func TestImportProductsRejectsBadPayload(t *testing.T) {
+	// No job is expected: the payload is refused before it is queued
+	h, _ := newImportHandler(t)
+
+	tests := []struct {
+		name, body string
+		want       int
+	}{
+		{name: "no rows", body: "[]", want: http.StatusBadRequest},
+		{name: "not an array", body: `{"name":"Lamp"}`, want: http.StatusBadRequest},
+		{name: "too many rows", body: "[" + strings.Repeat("{},", maxImportRows) + "{}]", want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h.ImportProducts, http.MethodPost, "/products/import", tt.body); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestRunImportJobReportsProgress(t *testing.T) {
+	h, mock := newImportHandler(t, WithImportConcurrency(4))
+	rows := importRows(250)
+	// Rows without a name fail on their own, without failing the job
+	for _, i := range []int{7, 150, 249} {
+		rows[i].Name = ""
+	}
+	expectImported(mock, rows, 0, 250)
+
+	payload, _ := json.Marshal(rows)
+	var progress progressLog
+	result, err := h.RunImportJob(tenantContext(), jobqueue.Job{ID: "job-1", TenantID: testTenant, Payload: payload}, progress.report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One report per chunk of progressEvery rows, after the chunk is done
+	want := []jobqueue.Progress{
+		{Processed: 100, Total: 250, Failed: 1},
+		{Processed: 200, Total: 250, Failed: 2},
+		{Processed: 250, Total: 250, Failed: 3},
+	}
+	if fmt.Sprint(progress.reports) != fmt.Sprint(want) {
+		t.Errorf("progress = %+v, want %+v", progress.reports, want)
+	}
+	got := result.(ImportResult)
+	if got.Created != 247 || len(got.Failed) != 3 || got.Failed[0].Row != 7 || got.Failed[2].Row != 249 {
+		t.Errorf("result = %d created, failed %+v, want 247 and rows 7, 150 and 249", got.Created, got.Failed)
+	}
+}
+
+func TestRunImportJobResumesAfterReportedProgress(t *testing.T) {
+	h, mock := newImportHandler(t)
+	rows := importRows(250)
+	// Rows before the saved progress were created by the failed attempt
+	expectImported(mock, rows, 200, 250)
+
+	payload, _ := json.Marshal(rows)
+	job := jobqueue.Job{ID: "job-1", TenantID: testTenant, Payload: payload, Progress: &jobqueue.Progress{Processed: 200, Total: 250}}
+	var progress progressLog
+	result, err := h.RunImportJob(tenantContext(), job, progress.report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []jobqueue.Progress{{Processed: 250, Total: 250}}; fmt.Sprint(progress.reports) != fmt.Sprint(want) {
+		t.Errorf("progress = %+v, want %+v", progress.reports, want)
+	}
+	if got := result.(ImportResult); got.Created != 50 {
+		t.Errorf("created = %d, want the 50 remaining rows", got.Created)
+	}
+}
+
+func TestRunImportJobFailsAttemptOnDatabaseError(t *testing.T) {
+	h, mock := newImportHandler(t, WithImportConcurrency(1))
+	rows := importRows(150)
+	expectImported(mock, rows, 0, 100)
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO products")).
+		WithArgs(testTenant, "Product 100", nil, "9.99", 5, nil, FormatHTML, nil, nil).
+		WillReturnError(errors.New("connection refused"))
+
+	payload, _ := json.Marshal(rows)
+	var progress progressLog
+	if _, err := h.RunImportJob(tenantContext(), jobqueue.Job{ID: "job-1", TenantID: testTenant, Payload: payload}, progress.report); err == nil {
+		t.Fatal("err = nil, want the database error so the job is retried")
+	}
+	// The first chunk was saved, so the retry starts at row 100
+	if want := []jobqueue.Progress{{Processed: 100, Total: 150}}; fmt.Sprint(progress.reports) != fmt.Sprint(want) {
+		t.Errorf("progress = %+v, want %+v", progress.reports, want)
+	}
+}
+
+func TestGetImportReportsCompletion(t *testing.T) {
+	h, mock := newImportHandler(t)
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM jobs WHERE id = $1 AND tenant_id = $2")).
+		WithArgs("job-1", testTenant).
+		WillReturnRows(sqlmock.NewRows(jobColumns).
+			AddRow("job-1", testTenant, JobImport, jobqueue.StatusSucceeded, []byte(`[]`), 1, 5,
+				[]byte(`{"processed":250,"total":250,"failed":3}`), []byte(`{"created":247,"failed":[]}`), nil,
+				now, now, now, now.Add(time.Minute)))
+
+	w := serve(h.GetImport, http.MethodGet, "/products/import/job-1", "", "id", "job-1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var got struct {
+		Status   string            `json:"status"`
+		Progress jobqueue.Progress `json:"progress"`
+		Result   ImportResult      `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != jobqueue.StatusSucceeded || got.Progress != (jobqueue.Progress{Processed: 250, Total: 250, Failed: 3}) || got.Result.Created != 247 {
+		t.Errorf("got %s, want the finished import with its progress and result", w.Body)
+	}
+}
+
+func TestGetImportOfOtherJobKindIsNotFound(t *testing.T) {
+	h, mock := newImportHandler(t)
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM jobs WHERE id = $1 AND tenant_id = $2")).
+		WithArgs("job-1", testTenant).
+		WillReturnRows(sqlmock.NewRows(jobColumns).
+			AddRow("job-1", testTenant, "user.export", jobqueue.StatusRunning, []byte(`{}`), 1, 5, nil, nil, nil, now, now, now, nil))
+
+	if w := serve(h.GetImport, http.MethodGet, "/products/import/job-1", "", "id", "job-1"); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"product-service/internal/db/generated"
+	"shared/httpx"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var stockDiscrepancies = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "product_stock_discrepancies_total",
+	Help: "Products whose stock differed from the sum of their stock movements when reconciled, by whether the stock was corrected.",
+}, []string{"fixed"})
+
+// LedgerDiscrepancy is a product whose stock column differs from the sum of its
+// stock movements
+type LedgerDiscrepancy struct {
+	ProductID int32  `json:"product_id"`
+	TenantID  string `json:"tenant_id"`
+	Stock     int32  `json:"stock"`
+	Ledger    int32  `json:"ledger"`
+	Fixed     bool   `json:"fixed"`
+}
+
+// LedgerReport is the outcome of ReconcileLedger
+type LedgerReport struct {
+	Checked       int                 `json:"checked"`
+	Discrepancies []LedgerDiscrepancy `json:"discrepancies"`
+}
+
+// ReconcileLedger compares each product's stock with the sum of its movements,
+// across all tenants, or only the products in ids if any are given. The ledger is
+// taken to be right: with fix, a product's stock is set to its balance and a
+// reconciliation movement notes the stock it had. Products are checked in
+// batches of ledgerBatch, each in its own transaction, so a large catalog is
+// never locked at once; batches already committed stay fixed if a later one fails.
+func (r *Repository) ReconcileLedger(ctx context.Context, ids []int32, fix bool) (LedgerReport, error) {
+	report := LedgerReport{Discrepancies: []LedgerDiscrepancy{}}
+	for after := int32(0); ; {
+		var batch []LedgerDiscrepancy
+		var checked int
+		err := r.inTx(ctx, func(q *generated.Queries) error {
+			balances, err := q.ListStockBalances(ctx, generated.ListStockBalancesParams{AfterID: after, Ids: ids, Limit: int32(r.ledgerBatch)})
+			if err != nil {
+				return err
+			}
+			checked = len(balances)
+			for _, b := range balances {
+				after = b.ID
+				if b.Stock == b.Balance {
+					continue
+				}
+				d := LedgerDiscrepancy{ProductID: b.ID, TenantID: b.TenantID, Stock: b.Stock, Ledger: b.Balance}
+				if fix {
+					err := q.CorrectStock(ctx, generated.CorrectStockParams{Balance: b.Balance, ID: b.ID, Note: fmt.Sprintf("stock was %d", b.Stock)})
+					if err != nil {
+						return err
+					}
+					d.Fixed = true
+				}
+				batch = append(batch, d)
+			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("could not reconcile stock ledger: %w", err)
+		}
+
+		report.Checked += checked
+		for _, d := range batch {
+			stockDiscrepancies.WithLabelValues(strconv.FormatBool(d.Fixed)).Inc()
+		}
+		report.Discrepancies = append(report.Discrepancies, batch...)
+		if checked < r.ledgerBatch {
+			return report, nil
+		}
+	}
+}
+
+// ReconcileStock serves ReconcileLedger for the products in an optional
+// {"ids":[...]} body, or every product without one, correcting them with
+// ?fix=true. Like IntegrityCheck it is for an operator, not for monitoring.
+func (h *Handler) ReconcileStock(w http.ResponseWriter, r *http.Request) {
+	fix := false
+	if raw := r.URL.Query().Get("fix"); raw != "" {
+		var err error
+		if fix, err = strconv.ParseBool(raw); err != nil {
+			httpx.Error(w, http.StatusBadRequest, "fix must be true or false")
+			return
+		}
+	}
+
+	var ids []int32
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		var err error
+		if ids, err = httpx.DecodeIDs(w, r, h.maxBatchSize); err != nil {
+			httpx.Error(w, httpx.StatusCode(err), err.Error())
+			return
+		}
+	}
+
+	report, err := h.repo.ReconcileLedger(r.Context(), ids, fix)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	httpx.WriteJSON(w, r, http.StatusOK, report)
+}
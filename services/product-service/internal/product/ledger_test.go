@@ -0,0 +1,152 @@
+package product
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// balanceColumns are the columns ListStockBalances returns, in order
+var balanceColumns = []string{"id", "tenant_id", "stock", "balance"}
+
+// expectBalances expects one batch of products after afterID, ids given as Postgres
+// would see them (NULL for every product), answered with rows
+func expectBalances(mock sqlmock.Sqlmock, afterID int32, ids any, limit int, rows *sqlmock.Rows) {
+	mock.ExpectQuery(regexp.QuoteMeta("-- name: ListStockBalances")).
+		WithArgs(afterID, ids, limit).
+		WillReturnRows(rows)
+}
+
+// expectCorrection expects a product's stock to be set to its ledger balance
+func expectCorrection(mock sqlmock.Sqlmock, id, balance int32, note string) {
+	mock.ExpectExec(regexp.QuoteMeta("-- name: CorrectStock")).
+		WithArgs(balance, id, note).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// reconcile posts body to ReconcileStock at target and decodes the report
+func reconcile(t *testing.T, h *Handler, target, body string) LedgerReport {
+	t.Helper()
+	w := serve(h.ReconcileStock, http.MethodPost, target, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST %s: status = %d, want 200: %s", target, w.Code, w.Body)
+	}
+	var report LedgerReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestReconcileStockReportsCorruptedStock(t *testing.T) {
+	h, mock := newMockHandler(t)
+	before := testutil.ToFloat64(stockDiscrepancies.WithLabelValues("false"))
+
+	// Product 2's stock was overwritten by hand, and product 3's never came back
+	// from a reservation; product 1 agrees with its ledger
+	mock.ExpectBegin()
+	expectBalances(mock, 0, nil, 500, sqlmock.NewRows(balanceColumns).
+		AddRow(1, testTenant, 5, 5).
+		AddRow(2, testTenant, 9, 4).
+		AddRow(3, "other", 0, 2))
+	mock.ExpectCommit()
+
+	report := reconcile(t, h, "/admin/products/reconcile", "")
+	want := []LedgerDiscrepancy{
+		{ProductID: 2, TenantID: testTenant, Stock: 9, Ledger: 4},
+		{ProductID: 3, TenantID: "other", Stock: 0, Ledger: 2},
+	}
+	if report.Checked != 3 || len(report.Discrepancies) != 2 || report.Discrepancies[0] != want[0] || report.Discrepancies[1] != want[1] {
+		t.Errorf("report = %+v, want 3 checked and %+v", report, want)
+	}
+	if got := testutil.ToFloat64(stockDiscrepancies.WithLabelValues("false")) - before; got != 2 {
+		t.Errorf("product_stock_discrepancies_total{fixed=false} rose by %v, want 2", got)
+	}
+}
+
+func TestReconcileStockFixesInBatches(t *testing.T) {
+	h, mock := newMockHandler(t, WithLedgerBatch(2))
+	before := testutil.ToFloat64(stockDiscrepancies.WithLabelValues("true"))
+
+	// Each batch is corrected and committed on its own; the short one is the last
+	mock.ExpectBegin()
+	expectBalances(mock, 0, nil, 2, sqlmock.NewRows(balanceColumns).
+		AddRow(1, testTenant, 5, 5).
+		AddRow(2, testTenant, 9, 4))
+	expectCorrection(mock, 2, 4, "stock was 9")
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	expectBalances(mock, 2, nil, 2, sqlmock.NewRows(balanceColumns).
+		AddRow(3, "other", -1, 2))
+	expectCorrection(mock, 3, 2, "stock was -1")
+	mock.ExpectCommit()
+
+	report := reconcile(t, h, "/admin/products/reconcile?fix=true", "")
+	if report.Checked != 3 || len(report.Discrepancies) != 2 {
+		t.Fatalf("report = %+v, want 3 checked and 2 discrepancies", report)
+	}
+	for _, d := range report.Discrepancies {
+		if !d.Fixed {
+			t.Errorf("product %d not fixed", d.ProductID)
+		}
+	}
+	if got := testutil.ToFloat64(stockDiscrepancies.WithLabelValues("true")) - before; got != 2 {
+		t.Errorf("product_stock_discrepancies_total{fixed=true} rose by %v, want 2", got)
+	}
+}
+
+func TestReconcileStockChecksOnlyListedProducts(t *testing.T) {
+	h, mock := newMockHandler(t)
+
+	mock.ExpectBegin()
+	expectBalances(mock, 0, "{7,3}", 500, sqlmock.NewRows(balanceColumns).
+		AddRow(3, testTenant, 1, 1).
+		AddRow(7, testTenant, 4, 4))
+	mock.ExpectCommit()
+
+	if report := reconcile(t, h, "/admin/products/reconcile", `{"ids":[7,3,7]}`); report.Checked != 2 || len(report.Discrepancies) != 0 {
+		t.Errorf("report = %+v, want 2 checked and no discrepancies", report)
+	}
+}
+
+func TestReconcileStockRollsBackFailedBatch(t *testing.T) {
+	h, mock := newMockHandler(t)
+
+	// The correction fails, so the batch's lock is released without a change
+	mock.ExpectBegin()
+	expectBalances(mock, 0, nil, 500, sqlmock.NewRows(balanceColumns).AddRow(2, testTenant, 9, 4))
+	mock.ExpectExec(regexp.QuoteMeta("-- name: CorrectStock")).
+		WithArgs(4, 2, "stock was 9").
+		WillReturnError(errors.New("connection refused"))
+	mock.ExpectRollback()
+
+	if w := serve(h.ReconcileStock, http.MethodPost, "/admin/products/reconcile?fix=true", ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500: %s", w.Code, w.Body)
+	}
+}
+
+func TestReconcileStockRejectsBadRequest(t *testing.T) {
+	// No query is expected: each request is refused first
+	h, _ := newMockHandler(t)
+
+	tests := []struct {
+		name, target, body string
+		want               int
+	}{
+		{name: "bad fix", target: "/admin/products/reconcile?fix=maybe", want: http.StatusBadRequest},
+		{name: "no ids", target: "/admin/products/reconcile", body: `{"ids":[]}`, want: http.StatusUnprocessableEntity},
+		{name: "not json", target: "/admin/products/reconcile", body: `ids=1`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h.ReconcileStock, http.MethodPost, tt.target, tt.body); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"shared/featureflag"
+	"shared/tenant"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+// testTenant is the tenant every test request is made in
+const testTenant = "acme"
+
+// newMockRepository returns a Repository backed by sqlmock. Read retries are off,
+// so a failed expectation isn't retried, and unmet expectations fail the test.
+func newMockRepository(t *testing.T, opts ...Option) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return NewRepository(sqlx.NewDb(db, "postgres"), append([]Option{WithReadRetry(false)}, opts...)...), mock
+}
+
+// newMockHandler returns a Handler over newMockRepository
+func newMockHandler(t *testing.T, opts ...Option) (*Handler, sqlmock.Sqlmock) {
+	t.Helper()
+	repo, mock := newMockRepository(t, opts...)
+	return NewHandler(repo, featureflag.New(Flags...), opts...), mock
+}
+
+// tenantContext returns a context in testTenant
+func tenantContext() context.Context {
+	return tenant.WithTenant(context.Background(), testTenant)
+}
+
+// serve sends a request with body to handler in testTenant; pathValues are the
+// wildcards the mux would have matched, as name, value pairs
+func serve(handler http.HandlerFunc, method, target, body string, pathValues ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(tenantContext())
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// productColumns are the columns the product queries return, in order
+var productColumns = []string{"id", "name", "description", "price", "stock", "created_at", "tenant_id", "category",
+	"archived_at", "description_format", "available_from", "available_until", "announced_available"}
+
+// productRow returns a row of an active product in testTenant with stock
+func productRow(id, stock int32) *sqlmock.Rows {
+	return sqlmock.NewRows(productColumns).
+		AddRow(id, fmt.Sprintf("Product %d", id), nil, "9.99", stock, nil, testTenant, nil, nil, FormatPlain, nil, nil, true)
+}
+
+// reservationColumns are the columns the reservation queries return, in order
+var reservationColumns = []string{"id", "tenant_id", "product_id", "quantity", "status", "expires_at", "created_at", "released_at"}
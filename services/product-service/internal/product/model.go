@@ -1 +1,108 @@
 package product
+
+import (
+	"database/sql"
+	"math"
+	"product-service/internal/db/generated"
+	"shared/api"
+	"strconv"
+	"time"
+)
+
+// ProductResponse is the JSON representation of a product. Handlers and events
+// always send this rather than the generated struct, so the wire format doesn't
+// change when the schema does. It is defined in shared/api, which the Go client shares.
+type ProductResponse = api.Product
+
+// CategoryResponse is the JSON representation of a category
+type CategoryResponse = api.Category
+
+// CategoryNode is a category in the tree, with its subcategories below it
+type CategoryNode = api.CategoryNode
+
+// TranslationResponse is the JSON representation of a product translation
+type TranslationResponse = api.ProductTranslation
+
+// TranslationInput is a translation sent to PutTranslation or with an imported row
+type TranslationInput = api.ProductTranslationInput
+
+// NewProductResponse maps a product row to its JSON representation, with its
+// availability at now
+func NewProductResponse(p generated.Product, now time.Time) ProductResponse {
+	price, cents := parsePrice(p.Price)
+	return ProductResponse{
+		ID:                p.ID,
+		Name:              p.Name,
+		Description:       nullableString(p.Description),
+		DescriptionFormat: p.DescriptionFormat,
+		Price:             price,
+		PriceCents:        cents,
+		Stock:             p.Stock,
+		Category:          nullableString(p.Category),
+		CreatedAt:         nullableTime(p.CreatedAt),
+		ArchivedAt:        nullableTime(p.ArchivedAt),
+		AvailableFrom:     nullableTime(p.AvailableFrom),
+		AvailableUntil:    nullableTime(p.AvailableUntil),
+		Available:         windowOf(p).Contains(now),
+	}
+}
+
+// NewProductResponses maps a list of product rows
+func NewProductResponses(products []generated.Product, now time.Time) []ProductResponse {
+	out := make([]ProductResponse, len(products))
+	for i, p := range products {
+		out[i] = NewProductResponse(p, now)
+	}
+	return out
+}
+
+// NewCategoryResponse maps a category row to its JSON representation
+func NewCategoryResponse(c generated.Category) CategoryResponse {
+	return CategoryResponse{Slug: c.Slug, Name: c.Name, Parent: nullableString(c.ParentSlug)}
+}
+
+// NewCategoryResponses maps a list of category rows
+func NewCategoryResponses(categories []generated.Category) []CategoryResponse {
+	out := make([]CategoryResponse, len(categories))
+	for i, c := range categories {
+		out[i] = NewCategoryResponse(c)
+	}
+	return out
+}
+
+// NewTranslationResponse maps a translation row to its JSON representation
+func NewTranslationResponse(t generated.ProductTranslation) TranslationResponse {
+	return TranslationResponse{
+		ProductID:         t.ProductID,
+		Locale:            t.Locale,
+		Name:              t.Name,
+		Description:       nullableString(t.Description),
+		DescriptionFormat: t.DescriptionFormat,
+		UpdatedAt:         t.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// parsePrice converts a DECIMAL price to a number and whole cents; with a
+// PRICE_SCALE above 2 the cents are rounded
+func parsePrice(s string) (float64, int64) {
+	price, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, 0
+	}
+	return price, int64(math.Round(price * 100))
+}
+
+func nullableString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func nullableTime(t sql.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+	formatted := t.Time.UTC().Format(time.RFC3339)
+	return &formatted
+}
@@ -0,0 +1,238 @@
+package product
+
+import (
+	"shared/api"
+	"shared/httpx"
+	"shared/jobqueue"
+	"shared/openapi"
+)
+
+// OpenAPI describes the product routes registered in main, for GET /openapi.json.
+// Operational routes (health, metrics, /admin) are left out. Keep it in step with
+// the mux: a route added there should be added here.
+func OpenAPI() *openapi.Document {
+	d := openapi.New("Product Service", "1")
+	d.Info.Description = "Products, categories and stock reservations. Field names are snake_case; timestamps are RFC3339 in UTC."
+
+	id := openapi.Path("id", openapi.Integer())
+	page := []openapi.Parameter{
+		openapi.Query("limit", "Page size, 1 to 100", openapi.Integer()),
+		openapi.Query("offset", "Rows to skip", openapi.Integer()),
+	}
+	render := openapi.Query("render", "Add the description rendered to sanitized HTML as description_html", openapi.String("html"))
+	locale := openapi.Query("locale", "Translate name and description into this locale where a translation exists; overrides Accept-Language. The locale used is in Content-Language and each translated product's locale.", openapi.String())
+	expand := openapi.Query("expand", "Comma-separated related collections to embed: categories (the category and its ancestors, top-level first) and translations. A listing may expand one.", openapi.String())
+	idempotent := openapi.Query("idempotent", "Answer 204 with X-Already-Deleted: true instead of 404 if there is nothing to delete, so a retried delete succeeds", &openapi.Schema{Type: "boolean"})
+	idempotencyKey := openapi.Header(httpx.IdempotencyKeyHeader, "Any value has the same effect as idempotent=true", openapi.String())
+	ids := struct {
+		IDs []int32 `json:"ids"`
+	}{}
+
+	d.Add("GET /products", openapi.Operation{
+		Summary:     "List products",
+		Description: "Paginated by limit and offset, or by cursor: pass next_cursor back as cursor for the next page. snapshot=true starts a cursor listing that leaves out products created after it started.",
+		Parameters: append(page,
+			openapi.Query("category", "Only products in this category", openapi.String()),
+			openapi.Query("cursor", "Continue a cursor listing from the previous page's next_cursor", openapi.String()),
+			openapi.Query("snapshot", "Start a cursor listing pinned to the current time", openapi.String("true", "false")),
+			openapi.Query("include_unavailable", "Also list products outside their availability window; admin only", openapi.String("true", "false")),
+			render, locale, expand),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("A page of products, leaving out archived ones and those outside their availability window", httpx.ListResponse[api.Product]{}),
+			"400": d.Error("Invalid paging, cursor, category, render, locale, expand or include_unavailable"),
+			"403": d.Error("include_unavailable=true without the admin role"),
+			"410": d.Error("The snapshot cursor is older than CURSOR_MAX_AGE (code cursor_expired); start again"),
+		},
+	})
+	d.Add("POST /products", openapi.Operation{
+		Summary:     "Create a product",
+		Description: "The body is in schema version 2 unless its schema_version field or the X-Schema-Version header says otherwise. Version 1 bodies have name, description, stock and price in whole cents.",
+		Parameters: []openapi.Parameter{
+			openapi.Header(SchemaVersionHeader, "Version of the body's schema; must match schema_version if both are set", openapi.String("1", "2")),
+		},
+		RequestBody: d.Body(api.ProductInput{}),
+		Responses: map[string]openapi.Response{
+			"201": d.JSON("The created product", api.Product{}),
+			"400": d.Error("Malformed body, or an unsupported or conflicting schema version"),
+			"409": d.Error("A product with this name already exists"),
+			"415": d.Error("The body isn't JSON"),
+			"422": d.Error("Validation failed; fields lists the problems"),
+		},
+	})
+	d.Add("GET /products/{id}", openapi.Operation{
+		Summary:    "Get a product",
+		Parameters: []openapi.Parameter{id, render, locale, expand},
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The product, archived or not", api.Product{}),
+			"400": d.Error("Invalid render, locale or expand"),
+			"404": d.Error("No such product"),
+		},
+	})
+	d.Add("PUT /products/{id}", openapi.Operation{
+		Summary:     "Replace a product",
+		Parameters:  []openapi.Parameter{id},
+		RequestBody: d.Body(api.ProductInput{}),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The updated product", api.Product{}),
+			"404": d.Error("No such product"),
+			"409": d.Error("Another product has this name"),
+			"422": d.Error("Validation failed; fields lists the problems"),
+		},
+	})
+	d.Add("DELETE /products/{id}", openapi.Operation{
+		Summary:    "Delete a product",
+		Parameters: []openapi.Parameter{id, idempotent, idempotencyKey},
+		Responses: map[string]openapi.Response{
+			"204": openapi.Empty("Deleted, along with its reservations"),
+			"404": d.Error("No such product"),
+		},
+	})
+	d.Add("GET /products/{id}/translations/{locale}", openapi.Operation{
+		Summary:    "Get a product's translation",
+		Parameters: []openapi.Parameter{id, openapi.Path("locale", openapi.String())},
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The translated name and description", api.ProductTranslation{}),
+			"400": d.Error("locale isn't a code such as de or de-DE"),
+			"404": d.Error("No such product, or no translation into this locale"),
+		},
+	})
+	d.Add("PUT /products/{id}/translations/{locale}", openapi.Operation{
+		Summary:     "Create or replace a product's translation",
+		Description: "locale must be one of PRODUCT_LOCALES.",
+		Parameters:  []openapi.Parameter{id, openapi.Path("locale", openapi.String())},
+		RequestBody: d.Body(api.ProductTranslationInput{}),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The replaced translation", api.ProductTranslation{}),
+			"201": d.JSON("The created translation", api.ProductTranslation{}),
+			"404": d.Error("No such product"),
+			"422": d.Error("Validation failed, e.g. an unsupported locale; fields lists the problems"),
+		},
+	})
+	d.Add("POST /products/{id}/reserve", openapi.Operation{
+		Summary:    "Reserve stock",
+		Parameters: []openapi.Parameter{id},
+		RequestBody: d.Body(struct {
+			Quantity int32 `json:"quantity"`
+		}{}),
+		Responses: map[string]openapi.Response{
+			"201": d.JSON("The reservation, holding the stock until it expires", api.Reservation{}),
+			"404": d.Error("No such product, or it is archived"),
+			"409": d.Error("Not enough stock (code insufficient_stock), or the product is outside its availability window (code product_unavailable)"),
+			"422": d.Error("quantity must be positive"),
+		},
+	})
+	d.Add("POST /products/{id}/release", openapi.Operation{
+		Summary:    "Release a reservation",
+		Parameters: []openapi.Parameter{id},
+		RequestBody: d.Body(struct {
+			ReservationID string `json:"reservation_id"`
+		}{}),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The released reservation, with its stock returned", api.Reservation{}),
+			"404": d.Error("No such reservation"),
+			"409": d.Error("The reservation was already released or has expired (code reservation_inactive)"),
+		},
+	})
+	d.Add("GET /products/export", openapi.Operation{
+		Summary: "Export every product in the tenant, up to MAX_RESULT_ROWS",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The products, streamed", struct {
+				Version   int           `json:"version"`
+				Data      []api.Product `json:"data"`
+				Truncated bool          `json:"truncated"`
+			}{}),
+		},
+	})
+	d.Add("GET /products/categories", openapi.Operation{
+		Summary: "List categories",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("Every category of the tenant", []api.Category{}),
+		},
+	})
+	d.Add("GET /products/categories/tree", openapi.Operation{
+		Summary: "Get the category tree",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The top-level categories, each with its subcategories nested below it", []api.CategoryNode{}),
+			"304": openapi.Empty("The tree still matches the ETag sent in If-None-Match"),
+		},
+	})
+	d.Add("PUT /products/categories/{slug}", openapi.Operation{
+		Summary:     "Create or replace a category",
+		RequestBody: d.Body(api.CategoryInput{}),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The category was updated", api.Category{}),
+			"201": d.JSON("The category was created", api.Category{}),
+			"400": d.Error("The slug isn't lowercase letters, digits and hyphens, or is the reserved \"tree\""),
+			"422": d.Error("The name is missing, or the parent doesn't exist or is the category or one of its subcategories"),
+		},
+	})
+	d.Add("DELETE /products/categories/{slug}", openapi.Operation{
+		Summary:    "Delete a category",
+		Parameters: []openapi.Parameter{idempotent, idempotencyKey},
+		Responses: map[string]openapi.Response{
+			"204": openapi.Empty("The category was deleted"),
+			"404": d.Error("No such category"),
+			"409": d.Error("The category has subcategories or products"),
+		},
+	})
+	d.Add("GET /products/events", openapi.Operation{
+		Summary: "Stream product events",
+		Responses: map[string]openapi.Response{
+			"200": openapi.Stream("Server-sent events; reconnect with Last-Event-ID to catch up", "text/event-stream"),
+		},
+	})
+	d.Add("POST /products/bulk-delete", openapi.Operation{
+		Summary:     "Delete several products",
+		RequestBody: d.Body(ids),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("Which products were deleted", api.BulkResult{}),
+			"413": d.Error("Too many IDs"),
+			"422": d.Error("ids is missing or empty"),
+		},
+	})
+	d.Add("POST /products/bulk-archive", openapi.Operation{
+		Summary:     "Archive several products",
+		RequestBody: d.Body(ids),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("Which products were archived", api.BulkResult{}),
+			"413": d.Error("Too many IDs"),
+			"422": d.Error("ids is missing or empty"),
+		},
+	})
+	d.Add("POST /products/bulk-categorize", openapi.Operation{
+		Summary: "Move several products into a category",
+		RequestBody: d.Body(struct {
+			IDs      []int32 `json:"ids"`
+			Category *string `json:"category"`
+		}{}),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("Which products were moved", api.BulkResult{}),
+			"413": d.Error("Too many IDs"),
+			"422": d.Error("Validation failed; fields lists the problems"),
+		},
+	})
+	d.Add("POST /products/import", openapi.Operation{
+		Summary:     "Import products in the background",
+		RequestBody: d.Body([]ImportRow{}),
+		Responses: map[string]openapi.Response{
+			"202": d.JSON("The queued job; poll the Location header for its progress and ImportResult", jobqueue.Job{}),
+			"413": d.Error("Too many products"),
+		},
+	})
+	d.Add("GET /products/import/{id}", openapi.Operation{
+		Summary: "Get the progress of an import",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The import job; progress counts rows processed, the total and failures so far", jobqueue.Job{}),
+			"404": d.Error("No such import"),
+		},
+	})
+	d.Add("GET /products/jobs/{id}", openapi.Operation{
+		Summary: "Get a background job",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("The job's status, progress and result", jobqueue.Job{}),
+			"404": d.Error("No such job"),
+		},
+	})
+	d.SchemaOf(ImportResult{})
+	return d
+}
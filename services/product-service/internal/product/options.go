@@ -0,0 +1,138 @@
+package product
+
+import (
+	"shared/clock"
+	"shared/events"
+	"shared/httpx"
+	"shared/ids"
+	"shared/jobqueue"
+	"shared/querylog"
+	"time"
+)
+
+// Option customises a Handler or Repository, mainly so tests can control time and IDs
+type Option func(*options)
+
+type options struct {
+	clock          clock.Clock
+	ids            ids.Generator
+	publisher      events.Publisher
+	jobs           *jobqueue.Queue
+	hub            *events.Hub
+	maxBatchSize   int
+	maxResultRows  int
+	reservationTTL time.Duration
+	slowQueries    querylog.Config
+	readRetry      bool
+	importWorkers  int
+	cursorMaxAge   time.Duration
+	nameMaxLen     int
+	locales        Locales
+	priceScale     int
+	ledgerBatch    int
+}
+
+// WithClock replaces the real clock
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithIDGenerator replaces the random ID/token generator
+func WithIDGenerator(g ids.Generator) Option {
+	return func(o *options) { o.ids = g }
+}
+
+// WithPublisher sets where product.* events are sent; events are discarded by default
+func WithPublisher(p events.Publisher) Option {
+	return func(o *options) { o.publisher = p }
+}
+
+// WithJobQueue sets the queue used for background work such as bulk imports
+func WithJobQueue(q *jobqueue.Queue) Option {
+	return func(o *options) { o.jobs = q }
+}
+
+// WithEventHub sets the hub that /products/events streams from. Events must also be
+// published to it, e.g. by including it in the publisher passed to WithPublisher.
+func WithEventHub(hub *events.Hub) Option {
+	return func(o *options) { o.hub = hub }
+}
+
+// WithMaxBatchSize caps how many IDs a bulk request may name
+func WithMaxBatchSize(n int) Option {
+	return func(o *options) { o.maxBatchSize = n }
+}
+
+// WithMaxResultRows caps the rows a single response may return
+func WithMaxResultRows(n int) Option {
+	return func(o *options) { o.maxResultRows = n }
+}
+
+// WithReservationTTL sets how long ReserveStock holds stock before it expires
+func WithReservationTTL(ttl time.Duration) Option {
+	return func(o *options) { o.reservationTTL = ttl }
+}
+
+// WithSlowQueryLog logs repository queries slower than cfg.Threshold, with the
+// plans of slow reads when cfg.Explain is set; slow queries aren't logged by default
+func WithSlowQueryLog(cfg querylog.Config) Option {
+	return func(o *options) { o.slowQueries = cfg }
+}
+
+// WithReadRetry sets whether repository reads that lose their database
+// connection are retried once; they are by default
+func WithReadRetry(enabled bool) Option {
+	return func(o *options) { o.readRetry = enabled }
+}
+
+// WithCursorMaxAge sets how long a snapshot listing of products can be continued
+func WithCursorMaxAge(d time.Duration) Option {
+	return func(o *options) { o.cursorMaxAge = d }
+}
+
+// WithImportConcurrency sets how many rows of one import are created at once; default 4
+func WithImportConcurrency(n int) Option {
+	return func(o *options) { o.importWorkers = n }
+}
+
+// WithLedgerBatch sets how many products ReconcileLedger checks per transaction; default 500
+func WithLedgerBatch(n int) Option {
+	return func(o *options) { o.ledgerBatch = n }
+}
+
+// WithNameMaxLen sets the longest name accepted, in characters; default httpx.DefaultNameMaxLen
+func WithNameMaxLen(n int) Option {
+	return func(o *options) { o.nameMaxLen = n }
+}
+
+// WithPriceScale sets the decimal places a price may have; default DefaultPriceScale
+func WithPriceScale(n int) Option {
+	return func(o *options) { o.priceScale = n }
+}
+
+// WithLocales sets the locales products can be translated into; default DefaultLocales
+func WithLocales(l Locales) Option {
+	return func(o *options) { o.locales = l }
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		clock:          clock.Real(),
+		readRetry:      true,
+		ids:            ids.Random(),
+		publisher:      events.Nop{},
+		maxBatchSize:   httpx.DefaultMaxBatchSize,
+		maxResultRows:  httpx.DefaultMaxResultRows,
+		reservationTTL: 15 * time.Minute,
+		importWorkers:  4,
+		cursorMaxAge:   httpx.DefaultCursorMaxAge,
+		nameMaxLen:     httpx.DefaultNameMaxLen,
+		locales:        DefaultLocales,
+		priceScale:     DefaultPriceScale,
+		ledgerBatch:    500,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
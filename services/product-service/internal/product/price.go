@@ -0,0 +1,46 @@
+package product
+
+import (
+	"fmt"
+	"os"
+	"shared/httpx"
+	"strconv"
+	"strings"
+)
+
+// DefaultPriceScale is the number of decimal places prices are kept to, as in
+// most currencies; MaxPriceScale allows for currencies such as KWD with three
+// and for unit prices quoted in fractions of a cent
+const (
+	DefaultPriceScale = 2
+	MaxPriceScale     = 4
+)
+
+// PriceScaleFromEnv reads PRICE_SCALE, the decimal places of a price, default DefaultPriceScale
+func PriceScaleFromEnv() (int, error) {
+	raw := os.Getenv("PRICE_SCALE")
+	if raw == "" {
+		return DefaultPriceScale, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 || n > MaxPriceScale {
+		return 0, fmt.Errorf("invalid PRICE_SCALE %q: expected 0 to %d", raw, MaxPriceScale)
+	}
+	return n, nil
+}
+
+// checkPrice rejects a price with more decimal places than scale, which the
+// DECIMAL column would otherwise round away without telling anyone
+func checkPrice(v *httpx.Validation, field string, price float64, scale int) {
+	// The shortest representation is what the client sent, e.g. 1.005 rather than 1.00499...
+	_, decimals, _ := strings.Cut(strconv.FormatFloat(price, 'f', -1, 64), ".")
+	if len(decimals) > scale {
+		v.Addf(field, "must have at most %d decimal places", scale)
+	}
+}
+
+// formatPrice converts a price to the string the DECIMAL column is written from.
+// It is only exact once checkPrice has passed.
+func formatPrice(price float64, scale int) string {
+	return strconv.FormatFloat(price, 'f', scale, 64)
+}
@@ -0,0 +1,91 @@
+package product
+
+import (
+	"shared/httpx"
+	"testing"
+)
+
+func TestCheckPrice(t *testing.T) {
+	tests := []struct {
+		scale int
+		price float64
+		valid bool
+	}{
+		{scale: 2, price: 19.99, valid: true},
+		{scale: 2, price: 20, valid: true},
+		{scale: 2, price: 0.1, valid: true},
+		{scale: 2, price: 19.999, valid: false},
+		{scale: 2, price: 1.005, valid: false},
+		{scale: 3, price: 1.005, valid: true},
+		{scale: 3, price: 12.345, valid: true},
+		{scale: 3, price: 12.3456, valid: false},
+	}
+	for _, tt := range tests {
+		var v httpx.Validation
+		checkPrice(&v, "price", tt.price, tt.scale)
+		if v.Valid() != tt.valid {
+			t.Errorf("checkPrice(%v, scale %d) valid = %t, want %t", tt.price, tt.scale, v.Valid(), tt.valid)
+		}
+	}
+}
+
+func TestFormatPrice(t *testing.T) {
+	tests := []struct {
+		scale int
+		price float64
+		want  string
+	}{
+		{scale: 2, price: 19.99, want: "19.99"},
+		{scale: 2, price: 20, want: "20.00"},
+		{scale: 2, price: 0.1, want: "0.10"},
+		{scale: 3, price: 1.005, want: "1.005"},
+		{scale: 3, price: 7, want: "7.000"},
+
+		// Prices checkPrice rejects are rounded, which is why it must run first
+		{scale: 2, price: 19.999, want: "20.00"},
+		{scale: 2, price: 19.994, want: "19.99"},
+		{scale: 3, price: 12.3456, want: "12.346"},
+		{scale: 3, price: 12.3454, want: "12.345"},
+	}
+	for _, tt := range tests {
+		if got := formatPrice(tt.price, tt.scale); got != tt.want {
+			t.Errorf("formatPrice(%v, %d) = %q, want %q", tt.price, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestPriceScaleFromEnv(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		{raw: "", want: DefaultPriceScale},
+		{raw: "2", want: 2},
+		{raw: "3", want: 3},
+		{raw: "0", want: 0},
+		{raw: "4", want: MaxPriceScale},
+		{raw: "5", wantErr: true},
+		{raw: "-1", wantErr: true},
+		{raw: "two", wantErr: true},
+		{raw: "2.5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			t.Setenv("PRICE_SCALE", tt.raw)
+			got, err := PriceScaleFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("PriceScaleFromEnv() = %d, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("PriceScaleFromEnv() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package product
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"product-service/internal/db/generated"
+	"shared/svcclient"
+	"strconv"
+	"time"
+)
+
+// InventoryLevel is a single product's stock as reported by the warehouse system
+type InventoryLevel struct {
+	ProductID int32 `json:"product_id"`
+	Stock     int32 `json:"stock"`
+}
+
+// ReconcilerConfig configures the inventory reconciler
+type ReconcilerConfig struct {
+	URL      string        // INVENTORY_SYNC_URL; empty disables the reconciler
+	Apply    bool          // INVENTORY_SYNC_APPLY; when false discrepancies are only logged
+	Interval time.Duration // INVENTORY_SYNC_INTERVAL
+}
+
+// ReconcilerConfigFromEnv reads the reconciler configuration from the environment
+func ReconcilerConfigFromEnv() (ReconcilerConfig, error) {
+	cfg := ReconcilerConfig{
+		URL:      os.Getenv("INVENTORY_SYNC_URL"),
+		Interval: 5 * time.Minute,
+	}
+
+	if raw := os.Getenv("INVENTORY_SYNC_APPLY"); raw != "" {
+		apply, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid INVENTORY_SYNC_APPLY %q: %w", raw, err)
+		}
+		cfg.Apply = apply
+	}
+
+	if raw := os.Getenv("INVENTORY_SYNC_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return cfg, fmt.Errorf("invalid INVENTORY_SYNC_INTERVAL %q", raw)
+		}
+		cfg.Interval = interval
+	}
+
+	return cfg, nil
+}
+
+// Reconciler periodically compares product stock against the external inventory API
+type Reconciler struct {
+	repo   *Repository
+	cfg    ReconcilerConfig
+	client *http.Client
+}
+
+// NewReconciler creates a Reconciler for the given repository
+func NewReconciler(repo *Repository, cfg ReconcilerConfig) *Reconciler {
+	return &Reconciler{
+		repo:   repo,
+		cfg:    cfg,
+		client: svcclient.New(10 * time.Second),
+	}
+}
+
+// Run reconciles on every interval until ctx is cancelled
+func (rc *Reconciler) Run(ctx context.Context) {
+	log.Printf("Inventory reconciler started (url: %s, interval: %s, apply: %t)", rc.cfg.URL, rc.cfg.Interval, rc.cfg.Apply)
+
+	ticker := time.NewTicker(rc.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := rc.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Inventory reconciliation failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Inventory reconciler stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile runs a single reconciliation pass, correcting stock when Apply is set
+func (rc *Reconciler) Reconcile(ctx context.Context) error {
+	levels, err := rc.fetchLevels(ctx)
+	if err != nil {
+		return err
+	}
+
+	products, err := rc.repo.ListStockLevels(ctx)
+	if err != nil {
+		return err
+	}
+
+	local := make(map[int32]generated.ListProductStockRow, len(products))
+	for _, p := range products {
+		local[p.ID] = p
+	}
+
+	discrepancies := 0
+	for _, level := range levels {
+		product, exists := local[level.ProductID]
+		if !exists {
+			log.Printf("Inventory reconciliation: product %d reported by warehouse but not found", level.ProductID)
+			continue
+		}
+		// The warehouse still counts reserved units, which are already out of stock
+		if product.Stock+product.Reserved == level.Stock {
+			continue
+		}
+
+		discrepancies++
+		log.Printf("Inventory discrepancy: product %d has stock %d with %d reserved, warehouse reports %d",
+			level.ProductID, product.Stock, product.Reserved, level.Stock)
+
+		if rc.cfg.Apply {
+			if err := rc.repo.SetStock(ctx, level.ProductID, level.Stock); err != nil {
+				return err
+			}
+			log.Printf("Inventory reconciliation: product %d stock corrected to %d on hand less its reservations", level.ProductID, level.Stock)
+		}
+	}
+
+	log.Printf("Inventory reconciliation complete: %d products checked, %d discrepancies", len(levels), discrepancies)
+	return nil
+}
+
+func (rc *Reconciler) fetchLevels(ctx context.Context) ([]InventoryLevel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rc.cfg.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build inventory request: %w", err)
+	}
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach inventory API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("inventory API returned status %d", resp.StatusCode)
+	}
+
+	var levels []InventoryLevel
+	if err := json.NewDecoder(resp.Body).Decode(&levels); err != nil {
+		return nil, fmt.Errorf("could not decode inventory levels: %w", err)
+	}
+	return levels, nil
+}
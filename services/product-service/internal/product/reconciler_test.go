@@ -0,0 +1,83 @@
+package product
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// warehouse serves levels as the inventory API does
+func warehouse(t *testing.T, levels string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(levels))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestReconcileLeavesReservedUnitsOutOfStock(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	url := warehouse(t, `[{"product_id":1,"stock":10},{"product_id":2,"stock":7}]`)
+
+	// Product 1 has 8 in stock and 2 reserved, which is the warehouse's 10. Product 2
+	// has 3 in stock and 2 reserved, but the warehouse counts 7 on hand.
+	mock.ExpectQuery(regexp.QuoteMeta("FROM products p")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "stock", "reserved"}).AddRow(1, 8, 2).AddRow(2, 3, 2))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("FOR UPDATE")).
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM stock_reservations")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE products SET stock = $2")).
+		WithArgs(2, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rc := NewReconciler(repo, ReconcilerConfig{URL: url, Apply: true})
+	if err := rc.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileWithoutApplyOnlyReports(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	url := warehouse(t, `[{"product_id":1,"stock":4}]`)
+
+	// No write is expected
+	mock.ExpectQuery(regexp.QuoteMeta("FROM products p")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "stock", "reserved"}).AddRow(1, 8, 2))
+
+	rc := NewReconciler(repo, ReconcilerConfig{URL: url})
+	if err := rc.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetStockCountsReservationsAtWriteTime(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// A reservation taken since the levels were listed is still left out
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("FOR UPDATE")).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM stock_reservations")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(4))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE products SET stock = $2")).
+		WithArgs(3, 6).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := repo.SetStock(context.Background(), 3, 10); err != nil {
+		t.Fatal(err)
+	}
+}
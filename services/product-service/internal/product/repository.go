@@ -3,25 +3,124 @@ package product
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"product-service/internal/db"
 	"product-service/internal/db/generated"
+	"shared/dbretry"
+	"shared/querylog"
+	"shared/tenant"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// ErrNotFound is returned when a product does not exist in the caller's tenant
+var ErrNotFound = errors.New("product not found")
+
+// ErrDuplicateName is returned when unique product names are enforced and the name is taken
+var ErrDuplicateName = errors.New("a product with this name already exists")
+
+// ErrUnknownCategory is returned when a category is not in the categories table
+var ErrUnknownCategory = errors.New("unknown category")
+
+// ErrInsufficientStock is returned when a reservation asks for more than is in stock
+var ErrInsufficientStock = errors.New("not enough stock")
+
+// ErrUnavailable is returned when stock is reserved outside a product's availability window
+var ErrUnavailable = errors.New("product is not available")
+
+// ErrReservationNotFound is returned when a reservation does not exist for the product
+var ErrReservationNotFound = errors.New("reservation not found")
+
+// ErrReservationInactive is returned when a reservation was already released or has expired
+var ErrReservationInactive = errors.New("reservation is no longer active")
+
 // Repository provides access to product data via sqlc-generated queries
 type Repository struct {
-	q *generated.Queries
+	db *sql.DB
+	q  *generated.Queries
+	options
 }
 
 // NewRepository creates a new Repository with a connected database
-func NewRepository(db *sqlx.DB) *Repository {
-	return &Repository{q: generated.New(db.DB)}
+func NewRepository(db *sqlx.DB, opts ...Option) *Repository {
+	o := newOptions(opts)
+	return &Repository{db: db.DB, q: generated.New(dbretry.Wrap(querylog.Wrap(db.DB, o.slowQueries), o.readRetry)), options: o}
+}
+
+// ListProducts retrieves a page of products in the caller's tenant, optionally only
+// those in category. With availableAt, products outside their availability window
+// at that time are left out.
+func (r *Repository) ListProducts(ctx context.Context, category string, availableAt *time.Time, limit, offset int32) ([]generated.Product, error) {
+	var products []generated.Product
+	var err error
+	if category == "" {
+		products, err = r.q.ListProducts(ctx, generated.ListProductsParams{
+			TenantID:    tenant.FromContext(ctx),
+			Limit:       limit,
+			Offset:      offset,
+			AvailableAt: nullTime(availableAt),
+		})
+	} else {
+		exists, existsErr := r.q.CategoryExists(ctx, generated.CategoryExistsParams{TenantID: tenant.FromContext(ctx), Slug: category})
+		if existsErr != nil {
+			return nil, fmt.Errorf("could not check category: %w", existsErr)
+		}
+		if !exists {
+			return nil, ErrUnknownCategory
+		}
+		products, err = r.q.ListProductsByCategory(ctx, generated.ListProductsByCategoryParams{
+			TenantID:    tenant.FromContext(ctx),
+			Category:    nullString(category),
+			Limit:       limit,
+			Offset:      offset,
+			AvailableAt: nullTime(availableAt),
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list products: %w", err)
+	}
+	if products == nil {
+		products = []generated.Product{}
+	}
+	return products, nil
 }
 
-// ListProducts retrieves all products from the database
-func (r *Repository) ListProducts(ctx context.Context) ([]generated.Product, error) {
-	products, err := r.q.ListProducts(ctx)
+// ListProductsAfter retrieves a keyset page of products in the caller's tenant:
+// those with an ID above afterID, optionally only in category. With a snapshot,
+// products created after it are left out; with availableAt, so are products
+// outside their availability window at that time.
+func (r *Repository) ListProductsAfter(ctx context.Context, category string, afterID int32, snapshot, availableAt *time.Time, limit int32) ([]generated.Product, error) {
+	var products []generated.Product
+	var err error
+	if category == "" {
+		products, err = r.q.ListProductsAfter(ctx, generated.ListProductsAfterParams{
+			TenantID:    tenant.FromContext(ctx),
+			ID:          afterID,
+			Limit:       limit,
+			Snapshot:    nullTime(snapshot),
+			AvailableAt: nullTime(availableAt),
+		})
+	} else {
+		exists, existsErr := r.q.CategoryExists(ctx, generated.CategoryExistsParams{TenantID: tenant.FromContext(ctx), Slug: category})
+		if existsErr != nil {
+			return nil, fmt.Errorf("could not check category: %w", existsErr)
+		}
+		if !exists {
+			return nil, ErrUnknownCategory
+		}
+		products, err = r.q.ListProductsByCategoryAfter(ctx, generated.ListProductsByCategoryAfterParams{
+			TenantID:    tenant.FromContext(ctx),
+			Category:    nullString(category),
+			ID:          afterID,
+			Limit:       limit,
+			Snapshot:    nullTime(snapshot),
+			AvailableAt: nullTime(availableAt),
+		})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("could not list products: %w", err)
 	}
@@ -31,28 +130,109 @@ func (r *Repository) ListProducts(ctx context.Context) ([]generated.Product, err
 	return products, nil
 }
 
-// CreateProduct creates a product in the database
-func (r *Repository) CreateProduct(ctx context.Context, name, description string, price string, stock int32) (generated.Product, error) {
+// ListCategories returns every category products in the caller's tenant can be filed under
+func (r *Repository) ListCategories(ctx context.Context) ([]generated.Category, error) {
+	categories, err := r.q.ListCategories(ctx, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not list categories: %w", err)
+	}
+	if categories == nil {
+		categories = []generated.Category{}
+	}
+	return categories, nil
+}
+
+// exportProducts lists every product in a tenant; rows are read one at a time by EachProduct
+const exportProducts = `SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1
+ORDER BY id
+LIMIT $2`
+
+// EachProduct calls fn for up to limit products in the caller's tenant in ID order, reading
+// rows as they arrive instead of loading them all. It stops at the first error from fn.
+func (r *Repository) EachProduct(ctx context.Context, limit int32, fn func(generated.Product) error) error {
+	rows, err := r.db.QueryContext(ctx, exportProducts, tenant.FromContext(ctx), limit)
+	if err != nil {
+		return fmt.Errorf("could not export products: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p generated.Product
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.CreatedAt, &p.TenantID, &p.Category, &p.ArchivedAt, &p.DescriptionFormat,
+			&p.AvailableFrom, &p.AvailableUntil, &p.AnnouncedAvailable); err != nil {
+			return fmt.Errorf("could not export products: %w", err)
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not export products: %w", err)
+	}
+	return nil
+}
+
+// CreateProduct creates a product in the database, along with any translations of
+// it. The product is only created if all of them are.
+func (r *Repository) CreateProduct(ctx context.Context, name, description, format string, price string, stock int32, category string, window Window, translations ...Translation) (generated.Product, error) {
 	createProductParams := generated.CreateProductParams{
-		Name: name,
+		TenantID: tenant.FromContext(ctx),
+		Name:     name,
 		Description: sql.NullString{
 			String: description,
 			Valid:  description != "",
 		},
-		Price: price,
-		Stock: stock,
+		Price:             price,
+		Stock:             stock,
+		Category:          nullString(category),
+		DescriptionFormat: format,
+		AvailableFrom:     nullTime(window.From),
+		AvailableUntil:    nullTime(window.Until),
 	}
-	product, err := r.q.CreateProduct(ctx, createProductParams)
+	if len(translations) == 0 {
+		return r.createProduct(ctx, r.q, createProductParams)
+	}
+
+	var product generated.Product
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		var err error
+		if product, err = r.createProduct(ctx, q, createProductParams); err != nil {
+			return err
+		}
+		for _, t := range translations {
+			if _, _, err := r.putTranslation(ctx, q, product.ID, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return generated.Product{}, fmt.Errorf("could not create product: %w", err)
+		return generated.Product{}, err
 	}
+	return product, nil
+}
 
+func (r *Repository) createProduct(ctx context.Context, q *generated.Queries, params generated.CreateProductParams) (generated.Product, error) {
+	product, err := q.CreateProduct(ctx, params)
+	if err != nil {
+		if isDuplicateName(err) {
+			return generated.Product{}, ErrDuplicateName
+		}
+		if isUnknownCategory(err) {
+			return generated.Product{}, ErrUnknownCategory
+		}
+		return generated.Product{}, fmt.Errorf("could not create product: %w", err)
+	}
 	return product, nil
 }
 
 // GetProduct retrieves a product from the database
 func (r *Repository) GetProduct(ctx context.Context, id int32) (generated.Product, error) {
-	product, err := r.q.GetProduct(ctx, id)
+	product, err := r.q.GetProduct(ctx, generated.GetProductParams{ID: id, TenantID: tenant.FromContext(ctx)})
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.Product{}, ErrNotFound
+	}
 	if err != nil {
 		return generated.Product{}, fmt.Errorf("could not get product: %w", err)
 	}
@@ -60,29 +240,364 @@ func (r *Repository) GetProduct(ctx context.Context, id int32) (generated.Produc
 }
 
 // UpdateProduct updates a product in the database
-func (r *Repository) UpdateProduct(ctx context.Context, id int32, name, description string, price string, stock int32) (generated.Product, error) {
+func (r *Repository) UpdateProduct(ctx context.Context, id int32, name, description, format string, price string, stock int32, category string, window Window) (generated.Product, error) {
 	updateProductParams := generated.UpdateProductParams{
-		ID:   id,
-		Name: name,
+		ID:       id,
+		TenantID: tenant.FromContext(ctx),
+		Name:     name,
 		Description: sql.NullString{
 			String: description,
 			Valid:  description != "",
 		},
-		Price: price,
-		Stock: stock,
+		Price:             price,
+		Stock:             stock,
+		Category:          nullString(category),
+		DescriptionFormat: format,
+		AvailableFrom:     nullTime(window.From),
+		AvailableUntil:    nullTime(window.Until),
 	}
 	product, err := r.q.UpdateProduct(ctx, updateProductParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.Product{}, ErrNotFound
+	}
 	if err != nil {
+		if isDuplicateName(err) {
+			return generated.Product{}, ErrDuplicateName
+		}
+		if isUnknownCategory(err) {
+			return generated.Product{}, ErrUnknownCategory
+		}
 		return generated.Product{}, fmt.Errorf("could not update product: %w", err)
 	}
 	return product, nil
 }
 
-// DeleteProduct deletes a product from the database
+// DeleteProduct deletes a product from the database. It goes through
+// DeleteProducts so the delete is recorded in its history like a bulk one.
 func (r *Repository) DeleteProduct(ctx context.Context, id int32) error {
-	err := r.q.DeleteProduct(ctx, id)
+	deleted, err := r.DeleteProducts(ctx, []int32{id})
 	if err != nil {
-		return fmt.Errorf("could not delete product: %w", err)
+		return err
+	}
+	if len(deleted) == 0 {
+		return ErrNotFound
 	}
 	return nil
 }
+
+// Product history actions recorded by bulk operations
+const (
+	HistoryDeleted     = "deleted"
+	HistoryArchived    = "archived"
+	HistoryCategorized = "categorized"
+)
+
+// DeleteProducts deletes the products with the given IDs in the caller's tenant in one
+// statement, returning the IDs that existed and were deleted. Their reservations
+// go with them (ON DELETE CASCADE); their history stays, ending in a deleted entry
+// written in the same transaction, so CheckIntegrity can tell it from an orphan.
+func (r *Repository) DeleteProducts(ctx context.Context, ids []int32) ([]int32, error) {
+	var deleted []int32
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		var err error
+		deleted, err = q.DeleteProducts(ctx, generated.DeleteProductsParams{Ids: ids, TenantID: tenant.FromContext(ctx)})
+		if err != nil {
+			return err
+		}
+		return r.recordHistory(ctx, q, deleted, HistoryDeleted, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not delete products: %w", err)
+	}
+	if deleted == nil {
+		deleted = []int32{}
+	}
+	return deleted, nil
+}
+
+// ArchiveProducts archives the products with the given IDs in the caller's tenant,
+// returning those that exist. Products that were already archived keep their original time.
+// Nothing else changes: an archived product can't be reserved, but reservations
+// already holding its stock can still be released or expire.
+func (r *Repository) ArchiveProducts(ctx context.Context, ids []int32) ([]generated.Product, error) {
+	var archived []generated.Product
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		var err error
+		archived, err = q.ArchiveProducts(ctx, generated.ArchiveProductsParams{
+			ArchivedAt: sql.NullTime{Time: r.clock.Now().UTC(), Valid: true},
+			Ids:        ids,
+			TenantID:   tenant.FromContext(ctx),
+		})
+		if err != nil {
+			return err
+		}
+		return r.recordHistory(ctx, q, productIDs(archived), HistoryArchived, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not archive products: %w", err)
+	}
+	return archived, nil
+}
+
+// CategorizeProducts moves the products with the given IDs in the caller's tenant into
+// category (or out of any category if it is empty), returning those that exist
+func (r *Repository) CategorizeProducts(ctx context.Context, ids []int32, category string) ([]generated.Product, error) {
+	var updated []generated.Product
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		var err error
+		updated, err = q.CategorizeProducts(ctx, generated.CategorizeProductsParams{
+			Category: nullString(category),
+			Ids:      ids,
+			TenantID: tenant.FromContext(ctx),
+		})
+		if err != nil {
+			return err
+		}
+		return r.recordHistory(ctx, q, productIDs(updated), HistoryCategorized, map[string]string{"category": category})
+	})
+	if isUnknownCategory(err) {
+		return nil, ErrUnknownCategory
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not categorize products: %w", err)
+	}
+	return updated, nil
+}
+
+// recordHistory writes one product_history row per product
+func (r *Repository) recordHistory(ctx context.Context, q *generated.Queries, ids []int32, action string, detail any) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	body := []byte("{}")
+	if detail != nil {
+		var err error
+		if body, err = json.Marshal(detail); err != nil {
+			return err
+		}
+	}
+	return q.RecordProductHistory(ctx, generated.RecordProductHistoryParams{
+		TenantID:   tenant.FromContext(ctx),
+		ProductIds: ids,
+		Action:     action,
+		Detail:     body,
+	})
+}
+
+// inTx runs fn with queries bound to a transaction, committing if fn succeeds
+func (r *Repository) inTx(ctx context.Context, fn func(q *generated.Queries) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(r.q.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func productIDs(products []generated.Product) []int32 {
+	ids := make([]int32, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+// ListStockLevels returns the stock of every product across all tenants, and the
+// quantity active reservations hold, for inventory sync
+func (r *Repository) ListStockLevels(ctx context.Context) ([]generated.ListProductStockRow, error) {
+	levels, err := r.q.ListProductStock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list product stock: %w", err)
+	}
+	return levels, nil
+}
+
+// SetStock sets a product's stock from the number of units on hand. Units held by
+// active reservations are still on hand but were taken out of stock when they were
+// reserved, and are put back when they are released or expire, so they are left
+// out. The product is locked first, so the reservations counted are exactly those
+// whose stock will come back.
+func (r *Repository) SetStock(ctx context.Context, id int32, onHand int32) error {
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		if err := q.LockProductStock(ctx, id); err != nil {
+			return err
+		}
+		reserved, err := q.ReservedStock(ctx, id)
+		if err != nil {
+			return err
+		}
+		return q.UpdateProductStock(ctx, generated.UpdateProductStockParams{ID: id, Stock: onHand - reserved})
+	})
+	if err != nil {
+		return fmt.Errorf("could not set product stock: %w", err)
+	}
+	return nil
+}
+
+// ReserveStock takes quantity out of a product's stock and records a reservation
+// that holds it until expiresAt, returning the reservation and the stock left.
+// Both happen in one transaction, so stock never goes negative however many
+// checkouts race for the last units. A product outside its availability window
+// at now can't be reserved.
+func (r *Repository) ReserveStock(ctx context.Context, productID, quantity int32, now, expiresAt time.Time) (generated.StockReservation, int32, error) {
+	var reservation generated.StockReservation
+	var stock int32
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		var err error
+		stock, err = q.TakeStock(ctx, generated.TakeStockParams{ID: productID, TenantID: tenant.FromContext(ctx), Stock: quantity, Now: now})
+		if errors.Is(err, sql.ErrNoRows) {
+			// The product doesn't exist (or is archived), isn't available now, or
+			// there isn't enough of it
+			product, getErr := q.GetProduct(ctx, generated.GetProductParams{ID: productID, TenantID: tenant.FromContext(ctx)})
+			if errors.Is(getErr, sql.ErrNoRows) || (getErr == nil && product.ArchivedAt.Valid) {
+				return ErrNotFound
+			}
+			if getErr != nil {
+				return getErr
+			}
+			if !windowOf(product).Contains(now) {
+				return ErrUnavailable
+			}
+			return ErrInsufficientStock
+		}
+		if err != nil {
+			return err
+		}
+
+		reservation, err = q.CreateReservation(ctx, generated.CreateReservationParams{
+			ID:        r.ids.NewID(),
+			TenantID:  tenant.FromContext(ctx),
+			ProductID: productID,
+			Quantity:  quantity,
+			ExpiresAt: expiresAt,
+		})
+		return err
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrInsufficientStock) {
+		return generated.StockReservation{}, 0, err
+	}
+	if err != nil {
+		return generated.StockReservation{}, 0, fmt.Errorf("could not reserve stock: %w", err)
+	}
+	return reservation, stock, nil
+}
+
+// ReleaseReservation returns an active reservation's stock to the product,
+// returning the released reservation and the stock now available. A reservation
+// that was already released, or has been expired by the sweeper, yields
+// ErrReservationInactive.
+func (r *Repository) ReleaseReservation(ctx context.Context, productID int32, id string) (generated.StockReservation, int32, error) {
+	var reservation generated.StockReservation
+	var stock int32
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		var err error
+		reservation, err = q.ReleaseReservation(ctx, generated.ReleaseReservationParams{
+			ID:         id,
+			TenantID:   tenant.FromContext(ctx),
+			ProductID:  productID,
+			ReleasedAt: sql.NullTime{Time: r.clock.Now().UTC(), Valid: true},
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			_, getErr := q.GetReservation(ctx, generated.GetReservationParams{ID: id, TenantID: tenant.FromContext(ctx), ProductID: productID})
+			if errors.Is(getErr, sql.ErrNoRows) {
+				return ErrReservationNotFound
+			}
+			if getErr != nil {
+				return getErr
+			}
+			return ErrReservationInactive
+		}
+		if err != nil {
+			return err
+		}
+
+		stock, err = q.RestoreStock(ctx, generated.RestoreStockParams{ID: productID, Stock: reservation.Quantity, Reason: "released"})
+		return err
+	})
+	if errors.Is(err, ErrReservationNotFound) || errors.Is(err, ErrReservationInactive) {
+		return generated.StockReservation{}, 0, err
+	}
+	if err != nil {
+		return generated.StockReservation{}, 0, fmt.Errorf("could not release reservation: %w", err)
+	}
+	return reservation, stock, nil
+}
+
+// ExpiredReservation is a lapsed reservation whose stock has been returned
+type ExpiredReservation struct {
+	generated.StockReservation
+	Stock int32 // the product's stock after the return
+}
+
+// ExpireReservations marks up to limit reservations that lapsed before now as
+// expired and returns their stock, across all tenants
+func (r *Repository) ExpireReservations(ctx context.Context, now time.Time, limit int32) ([]ExpiredReservation, error) {
+	var expired []ExpiredReservation
+	err := r.inTx(ctx, func(q *generated.Queries) error {
+		reservations, err := q.ExpireReservations(ctx, generated.ExpireReservationsParams{Now: now, Limit: limit})
+		if err != nil {
+			return err
+		}
+		for _, reservation := range reservations {
+			stock, err := q.RestoreStock(ctx, generated.RestoreStockParams{ID: reservation.ProductID, Stock: reservation.Quantity, Reason: "expired"})
+			if err != nil {
+				return err
+			}
+			expired = append(expired, ExpiredReservation{StockReservation: reservation, Stock: stock})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not expire reservations: %w", err)
+	}
+	return expired, nil
+}
+
+// AnnounceAvailability records the availability at now of up to limit products,
+// across all tenants, whose availability has changed since it was last announced,
+// and returns them
+func (r *Repository) AnnounceAvailability(ctx context.Context, now time.Time, limit int32) ([]generated.Product, error) {
+	products, err := r.q.AnnounceAvailability(ctx, generated.AnnounceAvailabilityParams{Now: now, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("could not announce product availability: %w", err)
+	}
+	return products, nil
+}
+
+// TenantExists reports whether a tenant is registered
+func (r *Repository) TenantExists(ctx context.Context, id string) (bool, error) {
+	exists, err := r.q.TenantExists(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("could not check tenant: %w", err)
+	}
+	return exists, nil
+}
+
+// isDuplicateName reports whether err is a unique violation (23505) on the product name index
+func isDuplicateName(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == db.UniqueProductNameIndex
+}
+
+// isUnknownCategory reports whether err is a foreign key violation (23503) on the product category
+func isUnknownCategory(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503" && pqErr.Constraint == "products_category_fkey"
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime stores a nil time as NULL
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
@@ -0,0 +1,86 @@
+package product
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestListProductsIsScopedToTenant(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// The caller's tenant is bound as $1, so other tenants' rows can't be listed
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE tenant_id = $1 AND archived_at IS NULL")).
+		WithArgs(testTenant, 21, 0, nil).
+		WillReturnRows(productRow(1, 5))
+
+	products, err := repo.ListProducts(tenantContext(), "", nil, 21, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 {
+		t.Errorf("got %d products, want 1", len(products))
+	}
+}
+
+func TestListProductsByCategoryOfAnotherTenant(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// The category exists in another tenant only, which looks the same as not at all
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM categories")).
+		WithArgs(testTenant, "shoes").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	if _, err := repo.ListProducts(tenantContext(), "shoes", nil, 21, 0); !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("err = %v, want ErrUnknownCategory", err)
+	}
+}
+
+func TestGetProductInAnotherTenantIsNotFound(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Product 5 exists, but in another tenant, so the scoped query finds nothing
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE id = $1 AND tenant_id = $2")).
+		WithArgs(5, testTenant).
+		WillReturnError(sql.ErrNoRows)
+
+	if _, err := repo.GetProduct(tenantContext(), 5); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound, which the handlers answer with 404", err)
+	}
+}
+
+func TestGetProductRecoversFromLostConnection(t *testing.T) {
+	repo, mock := newMockRepository(t, WithReadRetry(true))
+
+	// Postgres restarted under the first attempt; the retry gets a new connection
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE id = $1 AND tenant_id = $2")).
+		WithArgs(1, testTenant).
+		WillReturnError(&pq.Error{Code: "57P01", Message: "terminating connection due to administrator command"})
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE id = $1 AND tenant_id = $2")).
+		WithArgs(1, testTenant).
+		WillReturnRows(productRow(1, 5))
+
+	product, err := repo.GetProduct(tenantContext(), 1)
+	if err != nil {
+		t.Fatalf("err = %v, want the retry to succeed", err)
+	}
+	if product.ID != 1 {
+		t.Errorf("got product %d, want 1", product.ID)
+	}
+}
+
+func TestCreateProductIsNotRetried(t *testing.T) {
+	repo, mock := newMockRepository(t, WithReadRetry(true))
+
+	// The insert may have been applied before the connection went, so it fails as is
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO products")).
+		WillReturnError(&pq.Error{Code: "57P01"})
+
+	if _, err := repo.CreateProduct(tenantContext(), "Lamp", "", FormatPlain, "9.99", 5, "", Window{}); err == nil {
+		t.Error("err = nil, want the lost connection reported")
+	}
+}
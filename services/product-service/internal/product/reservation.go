@@ -0,0 +1,233 @@
+package product
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"product-service/internal/db/generated"
+	"shared/api"
+	"shared/httpx"
+	"shared/tenant"
+	"strconv"
+	"time"
+)
+
+// reservationSweepBatch is how many lapsed reservations one sweep statement expires
+const reservationSweepBatch = 500
+
+// ReservationConfig configures stock reservations
+type ReservationConfig struct {
+	TTL           time.Duration // RESERVATION_TTL; how long reserved stock is held
+	SweepInterval time.Duration // RESERVATION_SWEEP_INTERVAL; how often lapsed reservations are returned to stock
+}
+
+// ReservationConfigFromEnv reads the reservation configuration from the environment
+func ReservationConfigFromEnv() (ReservationConfig, error) {
+	cfg := ReservationConfig{TTL: 15 * time.Minute, SweepInterval: time.Minute}
+
+	if raw := os.Getenv("RESERVATION_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl <= 0 {
+			return cfg, fmt.Errorf("invalid RESERVATION_TTL %q", raw)
+		}
+		cfg.TTL = ttl
+	}
+
+	if raw := os.Getenv("RESERVATION_SWEEP_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return cfg, fmt.Errorf("invalid RESERVATION_SWEEP_INTERVAL %q", raw)
+		}
+		cfg.SweepInterval = interval
+	}
+
+	return cfg, nil
+}
+
+// ReservationResponse is the JSON representation of a stock reservation
+type ReservationResponse = api.Reservation
+
+func newReservationResponse(r generated.StockReservation, stock int32) ReservationResponse {
+	return ReservationResponse{
+		ID:         r.ID,
+		ProductID:  r.ProductID,
+		Quantity:   r.Quantity,
+		Status:     r.Status,
+		ExpiresAt:  r.ExpiresAt.UTC().Format(time.RFC3339),
+		ReleasedAt: nullableTime(r.ReleasedAt),
+		Stock:      stock,
+	}
+}
+
+// StockAction serves POST /products/{id}/reserve and /products/{id}/release
+func (h *Handler) StockAction(w http.ResponseWriter, r *http.Request) {
+	switch r.PathValue("action") {
+	case "reserve":
+		h.ReserveStock(w, r)
+	case "release":
+		h.ReleaseStock(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ReserveStock holds {"quantity":n} units of a product for checkout. The stock is
+// taken straight away and returned by ReleaseStock, or by the sweeper once the
+// reservation expires. A product outside its availability window can't be
+// reserved, but stock already reserved can still be released.
+func (h *Handler) ReserveStock(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Quantity *int32 `json:"quantity"`
+	}
+
+	idInt, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+	if input.Quantity == nil || *input.Quantity <= 0 {
+		httpx.ValidationFailed(w, []httpx.FieldError{{Field: "quantity", Message: "must be a positive integer"}})
+		return
+	}
+
+	now := h.clock.Now().UTC()
+	reservation, stock, err := h.repo.ReserveStock(r.Context(), int32(idInt), *input.Quantity, now, now.Add(h.reservationTTL))
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, ErrUnavailable) {
+		httpx.ErrorCode(w, http.StatusConflict, "product_unavailable", err.Error())
+		return
+	}
+	if errors.Is(err, ErrInsufficientStock) {
+		httpx.ErrorCode(w, http.StatusConflict, "insufficient_stock", err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.publish(r.Context(), EventStockChanged, Change[int32]{ID: reservation.ProductID, Old: stock + reservation.Quantity, New: stock})
+
+	httpx.WriteJSON(w, r, http.StatusCreated, newReservationResponse(reservation, stock))
+}
+
+// ReleaseStock cancels the reservation in {"reservation_id":"..."}, returning its
+// stock. Releasing a reservation that was already released or has expired is a conflict.
+func (h *Handler) ReleaseStock(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ReservationID *string `json:"reservation_id"`
+	}
+
+	idInt, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+	var v httpx.Validation
+	v.Required("reservation_id", input.ReservationID)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	reservation, stock, err := h.repo.ReleaseReservation(r.Context(), int32(idInt), *input.ReservationID)
+	if errors.Is(err, ErrReservationNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, ErrReservationInactive) {
+		httpx.ErrorCode(w, http.StatusConflict, "reservation_inactive", err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.publish(r.Context(), EventStockChanged, Change[int32]{ID: reservation.ProductID, Old: stock - reservation.Quantity, New: stock})
+
+	httpx.WriteJSON(w, r, http.StatusOK, newReservationResponse(reservation, stock))
+}
+
+// ReservationSweeper returns the stock of reservations that expired without
+// being released
+type ReservationSweeper struct {
+	handler *Handler
+	cfg     ReservationConfig
+}
+
+// NewReservationSweeper creates a sweeper that expires reservations through handler,
+// so stock.changed events are published for the stock it returns
+func NewReservationSweeper(handler *Handler, cfg ReservationConfig) *ReservationSweeper {
+	return &ReservationSweeper{handler: handler, cfg: cfg}
+}
+
+// Run sweeps on every interval until ctx is cancelled
+func (s *ReservationSweeper) Run(ctx context.Context) {
+	log.Printf("Reservation sweeper started (ttl: %s, interval: %s)", s.cfg.TTL, s.cfg.SweepInterval)
+
+	ticker := time.NewTicker(s.cfg.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Reservation sweep failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Reservation sweeper stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep expires lapsed reservations in batches and returns how many it expired
+func (s *ReservationSweeper) Sweep(ctx context.Context) (int, error) {
+	h := s.handler
+	now := h.clock.Now().UTC()
+
+	var total int
+	for {
+		expired, err := h.repo.ExpireReservations(ctx, now, reservationSweepBatch)
+		if err != nil {
+			return total, err
+		}
+		total += len(expired)
+
+		for _, reservation := range expired {
+			// Events go to the subscribers of the reservation's tenant
+			h.publish(tenant.WithTenant(ctx, reservation.TenantID), EventStockChanged, Change[int32]{
+				ID:  reservation.ProductID,
+				Old: reservation.Stock - reservation.Quantity,
+				New: reservation.Stock,
+			})
+		}
+
+		if len(expired) < reservationSweepBatch || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Printf("Reservation sweep returned the stock of %d expired reservations", total)
+	}
+	return total, nil
+}
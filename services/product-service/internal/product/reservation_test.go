@@ -0,0 +1,205 @@
+package product
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"shared/clock"
+	"shared/events"
+	"shared/ids"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var reservationNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newReservationHandler(t *testing.T) (*Handler, *events.Memory, sqlmock.Sqlmock) {
+	t.Helper()
+	published := events.NewMemory()
+	h, mock := newMockHandler(t,
+		WithClock(clock.NewFake(reservationNow)),
+		WithIDGenerator(ids.NewSequence()),
+		WithPublisher(published),
+		WithReservationTTL(10*time.Minute),
+	)
+	return h, published, mock
+}
+
+func decodeReservation(t *testing.T, body []byte) ReservationResponse {
+	t.Helper()
+	var got ReservationResponse
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestReserveStockTakesStock(t *testing.T) {
+	h, published, mock := newReservationHandler(t)
+	expiresAt := reservationNow.Add(10 * time.Minute)
+	id := "00000000-0000-0000-0000-000000000001"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE products SET stock = stock - $3")).
+		WithArgs(5, testTenant, 2, reservationNow).
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(8))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO stock_reservations")).
+		WithArgs(id, testTenant, 5, 2, expiresAt).
+		WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(id, testTenant, 5, 2, "active", expiresAt, reservationNow, nil))
+	mock.ExpectCommit()
+
+	w := serve(h.StockAction, http.MethodPost, "/products/5/reserve", `{"quantity":2}`, "id", "5", "action", "reserve")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", w.Code, w.Body)
+	}
+	got := decodeReservation(t, w.Body.Bytes())
+	if got.ID != id || got.Status != "active" || got.Stock != 8 || got.ExpiresAt != expiresAt.Format(time.RFC3339) {
+		t.Errorf("reservation = %+v, want %s active with stock 8 until %s", got, id, expiresAt.Format(time.RFC3339))
+	}
+
+	changes := published.Events()
+	if len(changes) != 1 || changes[0].Type != EventStockChanged || changes[0].Data != (Change[int32]{ID: 5, Old: 10, New: 8}) {
+		t.Errorf("events = %+v, want one stock.changed from 10 to 8", changes)
+	}
+}
+
+func TestReserveStockInsufficient(t *testing.T) {
+	h, _, mock := newReservationHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE products SET stock = stock - $3")).
+		WithArgs(5, testTenant, 3, reservationNow).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM products")).
+		WithArgs(5, testTenant).
+		WillReturnRows(productRow(5, 2))
+	mock.ExpectRollback()
+
+	w := serve(h.StockAction, http.MethodPost, "/products/5/reserve", `{"quantity":3}`, "id", "5", "action", "reserve")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	if code := errorCode(t, w.Body.Bytes()); code != "insufficient_stock" {
+		t.Errorf("code = %q, want insufficient_stock", code)
+	}
+}
+
+func TestReleaseStockReturnsStock(t *testing.T) {
+	h, published, mock := newReservationHandler(t)
+	id := "00000000-0000-0000-0000-000000000009"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE stock_reservations SET status = 'released'")).
+		WithArgs(id, testTenant, 5, reservationNow).
+		WillReturnRows(sqlmock.NewRows(reservationColumns).
+			AddRow(id, testTenant, 5, 2, "released", reservationNow.Add(5*time.Minute), reservationNow.Add(-5*time.Minute), reservationNow))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE products SET stock = stock + $2")).
+		WithArgs(5, 2, "released").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(10))
+	mock.ExpectCommit()
+
+	w := serve(h.StockAction, http.MethodPost, "/products/5/release", `{"reservation_id":"`+id+`"}`, "id", "5", "action", "release")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if got := decodeReservation(t, w.Body.Bytes()); got.Status != "released" || got.Stock != 10 || got.ReleasedAt == nil {
+		t.Errorf("reservation = %+v, want released with stock 10", got)
+	}
+	if changes := published.Events(); len(changes) != 1 || changes[0].Data != (Change[int32]{ID: 5, Old: 8, New: 10}) {
+		t.Errorf("events = %+v, want one stock.changed from 8 to 10", changes)
+	}
+}
+
+func TestReleaseStockOnlyOnce(t *testing.T) {
+	h, _, mock := newReservationHandler(t)
+	id := "00000000-0000-0000-0000-000000000009"
+
+	// The reservation exists but is no longer active, so no stock is returned
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE stock_reservations SET status = 'released'")).
+		WithArgs(id, testTenant, 5, reservationNow).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM stock_reservations")).
+		WithArgs(id, testTenant, 5).
+		WillReturnRows(sqlmock.NewRows(reservationColumns).
+			AddRow(id, testTenant, 5, 2, "expired", reservationNow.Add(-time.Minute), reservationNow.Add(-11*time.Minute), reservationNow.Add(-time.Minute)))
+	mock.ExpectRollback()
+
+	w := serve(h.StockAction, http.MethodPost, "/products/5/release", `{"reservation_id":"`+id+`"}`, "id", "5", "action", "release")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	if code := errorCode(t, w.Body.Bytes()); code != "reservation_inactive" {
+		t.Errorf("code = %q, want reservation_inactive", code)
+	}
+}
+
+func TestSweepReturnsStockOfExpiredReservations(t *testing.T) {
+	h, published, mock := newReservationHandler(t)
+	lapsed := reservationNow.Add(-time.Minute)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE stock_reservations SET status = 'expired'")).
+		WithArgs(reservationNow, reservationSweepBatch).
+		WillReturnRows(sqlmock.NewRows(reservationColumns).
+			AddRow("r1", testTenant, 5, 2, "expired", lapsed, lapsed.Add(-10*time.Minute), reservationNow).
+			AddRow("r2", "other", 6, 1, "expired", lapsed, lapsed.Add(-10*time.Minute), reservationNow))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE products SET stock = stock + $2")).
+		WithArgs(5, 2, "expired").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(10))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE products SET stock = stock + $2")).
+		WithArgs(6, 1, "expired").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(4))
+	mock.ExpectCommit()
+
+	expired, err := NewReservationSweeper(h, ReservationConfig{}).Sweep(tenantContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 2 {
+		t.Errorf("expired = %d, want 2", expired)
+	}
+
+	want := []Change[int32]{{ID: 5, Old: 8, New: 10}, {ID: 6, Old: 3, New: 4}}
+	changes := published.Events()
+	if len(changes) != len(want) {
+		t.Fatalf("events = %+v, want %d", changes, len(want))
+	}
+	for i, event := range changes {
+		if event.Data != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, event.Data, want[i])
+		}
+	}
+}
+
+func TestSweepWithNothingExpired(t *testing.T) {
+	h, published, mock := newReservationHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE stock_reservations SET status = 'expired'")).
+		WillReturnRows(sqlmock.NewRows(reservationColumns))
+	mock.ExpectCommit()
+
+	expired, err := NewReservationSweeper(h, ReservationConfig{}).Sweep(tenantContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 0 || len(published.Events()) != 0 {
+		t.Errorf("expired %d with events %+v, want none", expired, published.Events())
+	}
+}
+
+// errorCode returns the code of an error response
+func errorCode(t *testing.T, body []byte) string {
+	t.Helper()
+	var got struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	return got.Code
+}
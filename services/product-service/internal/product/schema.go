@@ -0,0 +1,143 @@
+package product
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"shared/httpx"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Versions of the POST /products body. Version 1 is what clients sent before
+// prices were decimal: price is in whole cents, and there is no description
+// format or category.
+const (
+	SchemaV1             = 1
+	SchemaV2             = 2
+	CurrentSchemaVersion = SchemaV2
+)
+
+// SchemaVersionHeader names the version of a request body, as an alternative to
+// its schema_version field
+const SchemaVersionHeader = "X-Schema-Version"
+
+// productInput is a create body mapped to the current schema
+type productInput struct {
+	Name              *string
+	Description       string
+	DescriptionFormat string
+	Price             float64
+	Stock             int32
+	Category          string
+	AvailableFrom     *time.Time
+	AvailableUntil    *time.Time
+}
+
+// productDecoders maps each body version to its decoder. A new version adds an
+// entry here, and the older ones keep mapping their bodies to the current model.
+var productDecoders = map[int]func(body json.RawMessage) (productInput, error){
+	SchemaV1: decodeProductV1,
+	SchemaV2: decodeProductV2,
+}
+
+func decodeProductV1(body json.RawMessage) (productInput, error) {
+	var input struct {
+		SchemaVersion int     `json:"schema_version"`
+		Name          *string `json:"name"`
+		Description   string  `json:"description"`
+		PriceCents    int64   `json:"price"`
+		Stock         int32   `json:"stock"`
+	}
+	if err := httpx.DecodeRaw(body, &input); err != nil {
+		return productInput{}, err
+	}
+	return productInput{
+		Name:        input.Name,
+		Description: input.Description,
+		Price:       float64(input.PriceCents) / 100,
+		Stock:       input.Stock,
+	}, nil
+}
+
+func decodeProductV2(body json.RawMessage) (productInput, error) {
+	var input struct {
+		SchemaVersion     int        `json:"schema_version"`
+		Name              *string    `json:"name"`
+		Description       string     `json:"description"`
+		DescriptionFormat string     `json:"description_format"`
+		Price             float64    `json:"price"`
+		Stock             int32      `json:"stock"`
+		Category          string     `json:"category"`
+		AvailableFrom     *time.Time `json:"available_from"`
+		AvailableUntil    *time.Time `json:"available_until"`
+	}
+	if err := httpx.DecodeRaw(body, &input); err != nil {
+		return productInput{}, err
+	}
+	return productInput{
+		Name:              input.Name,
+		Description:       input.Description,
+		DescriptionFormat: input.DescriptionFormat,
+		Price:             input.Price,
+		Stock:             input.Stock,
+		Category:          input.Category,
+		AvailableFrom:     input.AvailableFrom,
+		AvailableUntil:    input.AvailableUntil,
+	}, nil
+}
+
+// decodeProduct reads a create body in the version named by its schema_version
+// field or the X-Schema-Version header, the current one if neither is set. An
+// unknown version, or a header and field that disagree, is a *httpx.DecodeError.
+func decodeProduct(w http.ResponseWriter, r *http.Request) (productInput, error) {
+	var body json.RawMessage
+	if err := httpx.DecodeJSON(w, r, &body); err != nil {
+		return productInput{}, err
+	}
+
+	// A body that isn't an object is left for the decoder to reject
+	var fields map[string]json.RawMessage
+	var field *int
+	if json.Unmarshal(body, &fields) == nil && fields["schema_version"] != nil {
+		if err := json.Unmarshal(fields["schema_version"], &field); err != nil {
+			return productInput{}, badBody("schema_version must be an integer")
+		}
+	}
+
+	version := CurrentSchemaVersion
+	if raw := r.Header.Get(SchemaVersionHeader); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return productInput{}, badBody(SchemaVersionHeader + " must be an integer")
+		}
+		if field != nil && *field != n {
+			return productInput{}, badBody(fmt.Sprintf("schema_version %d doesn't match %s %d", *field, SchemaVersionHeader, n))
+		}
+		version = n
+	} else if field != nil {
+		version = *field
+	}
+
+	decode, ok := productDecoders[version]
+	if !ok {
+		return productInput{}, badBody(fmt.Sprintf("unsupported schema_version %d (supported: %s)", version, supportedSchemaVersions()))
+	}
+	return decode(body)
+}
+
+func supportedSchemaVersions() string {
+	versions := slices.Sorted(maps.Keys(productDecoders))
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		names[i] = strconv.Itoa(v)
+	}
+	return strings.Join(names, ", ")
+}
+
+func badBody(msg string) error {
+	return &httpx.DecodeError{Status: http.StatusBadRequest, Msg: msg}
+}
@@ -0,0 +1,83 @@
+package product
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"shared/httpx"
+	"shared/tenant"
+	"strconv"
+	"time"
+)
+
+// heartbeatInterval keeps idle event streams open through proxies
+const heartbeatInterval = 15 * time.Second
+
+// StreamEvents streams product events for the caller's tenant as server-sent events.
+// Clients reconnecting with Last-Event-ID first receive any remembered events they missed.
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if h.hub == nil {
+		httpx.Error(w, http.StatusNotFound, "event streaming is not enabled")
+		return
+	}
+
+	var after uint64
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		after, _ = strconv.ParseUint(raw, 10, 64)
+	}
+
+	sub, backlog := h.hub.Subscribe(tenant.FromContext(r.Context()), after)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, d := range backlog {
+		if err := writeEvent(w, d.Seq, d.Event.Type, d.Event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		log.Printf("Event stream cannot be flushed: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case d, ok := <-sub.C:
+			if !ok {
+				// The hub closed (shutdown) or we fell too far behind; the client reconnects and catches up
+				return
+			}
+			if err := writeEvent(w, d.Seq, d.Event.Type, d.Event); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes one server-sent event
+func writeEvent(w http.ResponseWriter, id uint64, name string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, name, body)
+	return err
+}
@@ -0,0 +1,339 @@
+package product
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"product-service/internal/db/generated"
+	"regexp"
+	"shared/httpx"
+	"shared/tenant"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrTranslationNotFound is returned when a product has no translation into a locale
+var ErrTranslationNotFound = errors.New("translation not found")
+
+// Locales are the locales products can be translated into, as language or
+// language-REGION codes such as de or de-DE
+type Locales []string
+
+// DefaultLocales are the translation locales unless PRODUCT_LOCALES says otherwise
+var DefaultLocales = Locales{"de-DE", "fr-FR"}
+
+// localePattern is the part of BCP 47 accepted: a language, optionally with a region.
+// An underscore is taken for the hyphen, as in de_DE.
+var localePattern = regexp.MustCompile(`^([A-Za-z]{2,3})(?:[-_]([A-Za-z]{2}))?$`)
+
+// LocalesFromEnv reads PRODUCT_LOCALES, the comma-separated locales products can
+// be translated into; default de-DE,fr-FR
+func LocalesFromEnv() (Locales, error) {
+	raw := os.Getenv("PRODUCT_LOCALES")
+	if raw == "" {
+		return DefaultLocales, nil
+	}
+	var locales Locales
+	for _, entry := range strings.Split(raw, ",") {
+		locale, ok := normalizeLocale(entry)
+		if !ok {
+			return nil, fmt.Errorf("invalid PRODUCT_LOCALES entry %q (want a code such as de or de-DE)", entry)
+		}
+		if !slices.Contains(locales, locale) {
+			locales = append(locales, locale)
+		}
+	}
+	return locales, nil
+}
+
+// normalizeLocale returns raw in its canonical form, e.g. de_de as de-DE, and
+// whether it is a well-formed locale at all
+func normalizeLocale(raw string) (string, bool) {
+	m := localePattern.FindStringSubmatch(strings.TrimSpace(raw))
+	if m == nil {
+		return "", false
+	}
+	if m[2] == "" {
+		return strings.ToLower(m[1]), true
+	}
+	return strings.ToLower(m[1]) + "-" + strings.ToUpper(m[2]), true
+}
+
+// match returns the supported locale to answer in, given candidates in order of
+// preference. Each candidate is tried as it is and then by its language alone, so
+// de-AT is answered in de-DE; it returns "" when none is supported.
+func (l Locales) match(candidates ...string) string {
+	for _, candidate := range candidates {
+		if slices.Contains(l, candidate) {
+			return candidate
+		}
+		language, _, _ := strings.Cut(candidate, "-")
+		for _, supported := range l {
+			if s, _, _ := strings.Cut(supported, "-"); s == language {
+				return supported
+			}
+		}
+	}
+	return ""
+}
+
+// acceptLanguages lists the well-formed locales of an Accept-Language header, most
+// preferred first. Wildcards and tags with q=0 are left out.
+func acceptLanguages(header string) []string {
+	type weighted struct {
+		locale string
+		q      float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				continue
+			}
+		}
+		if locale, ok := normalizeLocale(tag); ok && q > 0 {
+			tags = append(tags, weighted{locale, q})
+		}
+	}
+	slices.SortStableFunc(tags, func(a, b weighted) int { return cmp.Compare(b.q, a.q) })
+
+	locales := make([]string, len(tags))
+	for i, t := range tags {
+		locales[i] = t.locale
+	}
+	return locales
+}
+
+// requestLocale resolves the locale a product read is answered in: ?locale when
+// given, otherwise the Accept-Language header. It is "" when no supported locale
+// was asked for, and the base record is returned. A malformed ?locale is an error.
+func (h *Handler) requestLocale(w http.ResponseWriter, r *http.Request) (string, error) {
+	w.Header().Add("Vary", "Accept-Language")
+
+	var locale string
+	if raw := r.URL.Query().Get("locale"); raw != "" {
+		tag, ok := normalizeLocale(raw)
+		if !ok {
+			return "", errors.New("locale must be a code such as de or de-DE")
+		}
+		locale = h.locales.match(tag)
+	} else {
+		locale = h.locales.match(acceptLanguages(r.Header.Get("Accept-Language"))...)
+	}
+	if locale != "" {
+		w.Header().Set("Content-Language", locale)
+	}
+	return locale, nil
+}
+
+// localize maps product rows like newRenderedResponses, with the name and
+// description of those translated into locale replaced by the translation.
+// Translated products have Locale set; the rest are the base record.
+func (h *Handler) localize(ctx context.Context, locale string, products []generated.Product, render bool) ([]ProductResponse, error) {
+	translated := map[int32]bool{}
+	if locale != "" && len(products) > 0 {
+		translations, err := h.repo.ListTranslations(ctx, locale, productIDs(products))
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range products {
+			if t, ok := translations[p.ID]; ok {
+				products[i].Name = t.Name
+				products[i].Description = t.Description
+				products[i].DescriptionFormat = t.DescriptionFormat
+				translated[p.ID] = true
+			}
+		}
+	}
+
+	out := newRenderedResponses(products, h.clock.Now(), render)
+	for i := range out {
+		if translated[out[i].ID] {
+			out[i].Locale = &locale
+		}
+	}
+	return out, nil
+}
+
+// Translation is a product's name and description in one locale, ready to store
+type Translation struct {
+	Locale            string
+	Name              string
+	Description       string
+	DescriptionFormat string
+}
+
+// prepareTranslation checks a translation sent for locale, recording problems in v
+// under prefix, and returns what to store
+func (h *Handler) prepareTranslation(v *httpx.Validation, prefix, locale string, input TranslationInput) Translation {
+	t := Translation{Name: input.Name}
+	var ok bool
+	if t.Locale, ok = normalizeLocale(locale); !ok || !slices.Contains(h.locales, t.Locale) {
+		v.Addf(prefix+"locale", "must be one of %s", strings.Join(h.locales, ", "))
+	}
+
+	var field httpx.Validation
+	field.Required("name", &t.Name)
+	field.Name("name", &t.Name, h.nameMaxLen)
+	t.Description, t.DescriptionFormat = prepareDescription(&field, input.Description, input.DescriptionFormat)
+	for _, problem := range field.Errors() {
+		v.Add(prefix+problem.Field, problem.Message)
+	}
+	return t
+}
+
+// GetTranslation serves GET /products/{id}/translations/{locale}
+func (h *Handler) GetTranslation(w http.ResponseWriter, r *http.Request) {
+	idInt, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+	locale, ok := normalizeLocale(r.PathValue("locale"))
+	if !ok {
+		httpx.Error(w, http.StatusBadRequest, "locale must be a code such as de or de-DE")
+		return
+	}
+
+	translation, err := h.repo.GetTranslation(r.Context(), int32(idInt), locale)
+	if errors.Is(err, ErrTranslationNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httpx.WriteJSON(w, r, http.StatusOK, NewTranslationResponse(translation))
+}
+
+// PutTranslation serves PUT /products/{id}/translations/{locale}, creating or
+// replacing the product's name and description in a locale of PRODUCT_LOCALES
+func (h *Handler) PutTranslation(w http.ResponseWriter, r *http.Request) {
+	var input TranslationInput
+
+	idInt, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+
+	var v httpx.Validation
+	translation := h.prepareTranslation(&v, "", r.PathValue("locale"), input)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	stored, created, err := h.repo.PutTranslation(r.Context(), int32(idInt), translation)
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	httpx.WriteJSON(w, r, status, NewTranslationResponse(stored))
+}
+
+// PutTranslation creates or replaces a product's translation, reporting whether it
+// was created
+func (r *Repository) PutTranslation(ctx context.Context, productID int32, t Translation) (generated.ProductTranslation, bool, error) {
+	return r.putTranslation(ctx, r.q, productID, t)
+}
+
+func (r *Repository) putTranslation(ctx context.Context, q *generated.Queries, productID int32, t Translation) (generated.ProductTranslation, bool, error) {
+	row, err := q.UpsertProductTranslation(ctx, generated.UpsertProductTranslationParams{
+		ID:                productID,
+		TenantID:          tenant.FromContext(ctx),
+		Locale:            t.Locale,
+		Name:              t.Name,
+		Description:       nullString(t.Description),
+		DescriptionFormat: t.DescriptionFormat,
+		UpdatedAt:         r.clock.Now().UTC(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.ProductTranslation{}, false, ErrNotFound
+	}
+	if err != nil {
+		return generated.ProductTranslation{}, false, fmt.Errorf("could not save translation: %w", err)
+	}
+	return generated.ProductTranslation{
+		ProductID:         row.ProductID,
+		Locale:            row.Locale,
+		Name:              row.Name,
+		Description:       row.Description,
+		DescriptionFormat: row.DescriptionFormat,
+		UpdatedAt:         row.UpdatedAt,
+	}, row.Inserted, nil
+}
+
+// GetTranslation retrieves a product's translation into locale
+func (r *Repository) GetTranslation(ctx context.Context, productID int32, locale string) (generated.ProductTranslation, error) {
+	t, err := r.q.GetProductTranslation(ctx, generated.GetProductTranslationParams{
+		ProductID: productID,
+		TenantID:  tenant.FromContext(ctx),
+		Locale:    locale,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.ProductTranslation{}, ErrTranslationNotFound
+	}
+	if err != nil {
+		return generated.ProductTranslation{}, fmt.Errorf("could not get translation: %w", err)
+	}
+	return t, nil
+}
+
+// ListTranslations retrieves the translations into locale of the given products, by product ID
+func (r *Repository) ListTranslations(ctx context.Context, locale string, ids []int32) (map[int32]generated.ProductTranslation, error) {
+	translations, err := r.q.ListProductTranslations(ctx, generated.ListProductTranslationsParams{
+		TenantID:   tenant.FromContext(ctx),
+		Locale:     locale,
+		ProductIds: ids,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list translations: %w", err)
+	}
+	byProduct := make(map[int32]generated.ProductTranslation, len(translations))
+	for _, t := range translations {
+		byProduct[t.ProductID] = t
+	}
+	return byProduct, nil
+}
+
+// ListAllTranslations returns every translation of the given products in the
+// request's tenant, by product, in locale order
+func (r *Repository) ListAllTranslations(ctx context.Context, ids []int32) (map[int32][]generated.ProductTranslation, error) {
+	translations, err := r.q.ListAllProductTranslations(ctx, generated.ListAllProductTranslationsParams{
+		TenantID:   tenant.FromContext(ctx),
+		ProductIds: ids,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list translations: %w", err)
+	}
+	byProduct := make(map[int32][]generated.ProductTranslation, len(ids))
+	for _, t := range translations {
+		byProduct[t.ProductID] = append(byProduct[t.ProductID], t)
+	}
+	return byProduct, nil
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,57 +9,343 @@ import (
 	"os/signal"
 	"product-service/internal/db"
 	"product-service/internal/product"
+	"product-service/migrations"
+	"shared/admin"
+	"shared/auth"
+	"shared/chaos"
+	"shared/clock"
+	"shared/database"
+	"shared/dbretry"
+	"shared/events"
+	"shared/featureflag"
+	"shared/health"
+	"shared/httpx"
+	"shared/ids"
+	"shared/jobqueue"
+	"shared/openapi"
+	"shared/oplog"
+	"shared/querylog"
+	"shared/shutdown"
+	"shared/statsz"
+	"shared/tenant"
+	"strconv"
+	"sync"
 	"syscall"
-	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
 	_ = godotenv.Load()
 
-	conn, err := db.Connect()
+	dbConfig, err := database.ConfigFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer conn.Close()
+	conn, err := database.Connect(dbConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Readiness is reported on /readyz; required dependencies must be up before we start
+	deps := health.New(health.Dependency{Name: "postgres", Required: true, Check: conn.PingContext})
+
+	startupWait, err := health.StartupWaitFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := deps.WaitForRequired(context.Background(), startupWait); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Connected to Postgres")
+	database.Warmup(context.Background(), conn, dbConfig)
 
-	if err := db.Migrate(conn); err != nil {
+	// Migrations are embedded in the binary; MIGRATIONS_DIR reads them from disk in development
+	migrationsFS, err := database.MigrationsFromEnv(migrations.FS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Versions are kept in products_schema_migrations
+	if err := database.Migrate(conn, database.Migrations{FS: migrationsFS, Service: "products"}); err != nil {
 		log.Fatal(err)
 	}
 
+	uniqueNames, _ := strconv.ParseBool(os.Getenv("PRODUCT_UNIQUE_NAMES"))
+	if err := db.EnforceUniqueNames(conn, uniqueNames); err != nil {
+		log.Fatal(err)
+	}
+
+	priceScale, err := product.PriceScaleFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := db.EnforcePriceScale(conn, priceScale); err != nil {
+		log.Fatal(err)
+	}
+
+	slowQueries, err := querylog.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	readRetry, err := dbretry.EnabledFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Request counts behind /admin/statsz, for environments without Prometheus
+	stats := statsz.New()
+
 	// Create a multiplexer (router)
 	mux := http.NewServeMux()
-	repo := product.NewRepository(conn)
-	handler := product.NewHandler(repo)
+	repo := product.NewRepository(conn, product.WithSlowQueryLog(slowQueries), product.WithReadRetry(readRetry))
+	flags := featureflag.New(product.Flags...)
+
+	publisher, err := events.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pinger, ok := publisher.(events.Pinger); ok {
+		deps.Register(health.Dependency{Name: "events", Required: false, Check: pinger.Ping})
+	}
+
+	queueCfg, err := jobqueue.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue := jobqueue.New(conn.DB, queueCfg, clock.Real(), ids.Random())
+
+	// Events also go to the in-process hub behind /products/events
+	hub := events.NewHub(256, 64)
+
+	maxBatchSize, err := httpx.MaxBatchSizeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxResultRows, err := httpx.MaxResultRowsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	serveSpec, err := openapi.EnabledFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reservationCfg, err := product.ReservationConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	importConcurrency, err := product.ImportConcurrencyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cursorMaxAge, err := httpx.CursorMaxAgeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nameMaxLen, err := httpx.NameMaxLenFromEnv("PRODUCT_NAME_MAX_LEN")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Names and descriptions can be translated into these; reads pick one with
+	// ?locale or Accept-Language
+	locales, err := product.LocalesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handler := product.NewHandler(repo, flags,
+		product.WithPublisher(events.Fanout{publisher, hub}),
+		product.WithEventHub(hub),
+		product.WithJobQueue(queue),
+		product.WithMaxBatchSize(maxBatchSize),
+		product.WithMaxResultRows(maxResultRows),
+		product.WithReservationTTL(reservationCfg.TTL),
+		product.WithImportConcurrency(importConcurrency),
+		product.WithCursorMaxAge(cursorMaxAge),
+		product.WithNameMaxLen(nameMaxLen),
+		product.WithPriceScale(priceScale),
+		product.WithLocales(locales),
+	)
+	queue.Register(product.JobImport, handler.RunImportJob)
+
+	// Background jobs stop when jobsCtx is cancelled during shutdown
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	var jobs sync.WaitGroup
+
+	jobs.Go(func() { queue.Run(jobsCtx) })
+	jobs.Go(func() { product.NewReservationSweeper(handler, reservationCfg).Run(jobsCtx) })
+
+	// Products entering or leaving their availability window are announced as events
+	availabilityInterval, err := product.AvailabilityIntervalFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobs.Go(func() { product.NewAvailabilityWatcher(handler, availabilityInterval).Run(jobsCtx) })
+
+	reconcilerCfg, err := product.ReconcilerConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if reconcilerCfg.URL != "" {
+		jobs.Go(func() { product.NewReconciler(repo, reconcilerCfg).Run(jobsCtx) })
+	}
 
 	// Add route handlers
-	mux.HandleFunc("/health", healthHandler(conn))
-	mux.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			handler.ListProducts(w, r)
-		case http.MethodPost:
-			handler.CreateProduct(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	mux.HandleFunc("/health", healthHandler(conn, flags))
+	mux.HandleFunc("/readyz", deps.ReadyHandler())
+	mux.Handle("/admin/flags", admin.RequireToken(admin.TokenFromEnv(), flags.Handler()))
+	mux.Handle("GET /admin/statsz", admin.RequireToken(admin.TokenFromEnv(), stats.Handler(conn.DB)))
 
-	mux.HandleFunc("/products/{id}", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			handler.GetProduct(w, r)
-		case http.MethodPut:
-			handler.UpdateProduct(w, r)
-		case http.MethodDelete:
-			handler.DeleteProduct(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
+	// Fault injection for resilience testing; never on in production
+	chaosEnabled, err := chaos.EnabledFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var injector *chaos.Injector
+	if chaosEnabled {
+		injector = chaos.New(mux)
+		mux.Handle("/admin/chaos", admin.RequireToken(admin.TokenFromEnv(), injector.Handler()))
+		log.Printf("WARNING: CHAOS_ENABLED is set; faults can be injected through /admin/chaos")
+	}
+	mux.Handle("GET /admin/integrity-check", admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(handler.IntegrityCheck)))
+	mux.Handle("POST /admin/products/reconcile", admin.RequireToken(admin.TokenFromEnv(), http.HandlerFunc(handler.ReconcileStock)))
+	mux.Handle("/metrics", promhttp.Handler())
+	if serveSpec {
+		mux.Handle("GET /openapi.json", product.OpenAPI().Handler())
+	}
+
+	// Resource routes are scoped to the tenant set by the gateway
+	withTenant := tenant.Middleware(repo.TenantExists)
+
+	mux.Handle("/products", withTenant(httpx.Methods{
+		http.MethodGet:  handler.ListProducts,
+		http.MethodPost: handler.CreateProduct,
+	}))
+
+	mux.Handle("/products/{id}", withTenant(httpx.Methods{
+		http.MethodGet:    handler.GetProduct,
+		http.MethodPut:    handler.UpdateProduct,
+		http.MethodDelete: handler.DeleteProduct,
+	}))
+
+	mux.Handle("/products/{id}/translations/{locale}", withTenant(httpx.Methods{
+		http.MethodGet: handler.GetTranslation,
+		http.MethodPut: handler.PutTranslation,
+	}))
+
+	// Checkout holds stock with a reservation, which expires if it isn't released.
+	// /products/{id}/reserve and /release share one pattern because on their own
+	// they would conflict with /products/jobs/{id}.
+	mux.Handle("/products/{id}/{action}", withTenant(httpx.Methods{
+		http.MethodPost: handler.StockAction,
+	}))
+
+	mux.Handle("/products/export", withTenant(httpx.Methods{
+		http.MethodGet: handler.ExportProducts,
+	}))
+
+	mux.Handle("/products/categories", withTenant(httpx.Methods{
+		http.MethodGet: handler.ListCategories,
+	}))
+	mux.Handle("/products/categories/tree", withTenant(httpx.Methods{
+		http.MethodGet: handler.CategoryTree,
+	}))
+	mux.Handle("/products/categories/{slug}", withTenant(httpx.Methods{
+		http.MethodPut:    handler.PutCategory,
+		http.MethodDelete: handler.DeleteCategory,
+	}))
+
+	mux.Handle("/products/events", withTenant(httpx.Methods{
+		http.MethodGet: handler.StreamEvents,
+	}))
+
+	// Bulk operations can be switched off at runtime via /admin/flags
+	bulk := func(h http.HandlerFunc) http.Handler {
+		return featureflag.Gate(flags, product.FlagBulkOperations, withTenant(httpx.Methods{http.MethodPost: h}))
+	}
+	mux.Handle("/products/bulk-delete", bulk(handler.BulkDeleteProducts))
+	mux.Handle("/products/bulk-archive", bulk(handler.BulkArchiveProducts))
+	mux.Handle("/products/bulk-categorize", bulk(handler.BulkCategorizeProducts))
+
+	mux.Handle("/products/import", withTenant(httpx.Methods{
+		http.MethodPost: handler.ImportProducts,
+	}))
+
+	mux.Handle("/products/import/{id}", withTenant(httpx.Methods{
+		http.MethodGet: handler.GetImport,
+	}))
+
+	// Jobs are also served under /products so they are reachable through the gateway
+	jobRoute := withTenant(httpx.Methods{
+		http.MethodGet: handler.GetJob,
 	})
+	mux.Handle("/jobs/{id}", jobRoute)
+	mux.Handle("/products/jobs/{id}", jobRoute)
+
+	// Mutating requests are recorded before they run, for support investigations
+	oplogCfg, err := oplog.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	operations := oplog.FromEnv(oplogCfg, conn.DB)
+	if db, ok := operations.(*oplog.DBStore); ok {
+		jobs.Go(func() { db.RunPruner(jobsCtx) })
+	}
+	mux.Handle("GET /admin/operations", admin.RequireToken(admin.TokenFromEnv(), oplog.Handler(operations)))
+
+	routeTimeouts, err := httpx.RouteTimeoutsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The event stream stays open for as long as the client is connected
+	routeTimeouts.Routes["GET /products/events"] = 0
+
+	shutdownBudget, err := shutdown.BudgetFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequired, err := auth.RequiredFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	contentTypes, err := httpx.ContentTypeModeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errorLanguages, err := httpx.LanguagesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The gateway's address belongs in TRUSTED_PROXIES, or every request's client is the gateway
+	trustedProxies, err := httpx.TrustedProxiesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Authorization and injected faults run inside the route deadline
+	var root http.Handler = mux
+	root = injector.Middleware(root)
+	root = oplog.New(operations, product.Operations, oplogCfg).Middleware(mux)(root)
+	root = auth.Authorize(mux, product.Access, authRequired)(root)
+	root = httpx.Timeouts(mux, routeTimeouts)(root)
+	root = httpx.ContentTypes(contentTypes)(root)
+	root = httpx.ErrorLanguages(errorLanguages)(root)
+	root = stats.Middleware(root)
+	root = httpx.ClientIPs(trustedProxies)(root)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -73,9 +358,13 @@ func main() {
 	// Http server struct
 	server := &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: root,
 	}
 
+	// Event streams only end when the hub closes, so close it as soon as shutdown
+	// starts rather than waiting for them to drain
+	server.RegisterOnShutdown(func() { hub.Close() })
+
 	// Channel to listen for OS signals
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
@@ -92,33 +381,39 @@ func main() {
 	<-stop
 	log.Println("Shutting down server...")
 
-	// Give outstanding requests time to finish
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Stop accepting requests and drain in-flight ones first, then stop background
+	// jobs, so nothing is still writing when events are flushed and the database closes
+	var seq shutdown.Sequence
+	seq.Add("http server", server.Shutdown)
+	seq.Add("background jobs", func(ctx context.Context) error {
+		stopJobs()
+		return shutdown.Wait(jobs.Wait)(ctx)
+	})
+	seq.AddCloser("event publisher", publisher.Close)
+	seq.AddCloser("database", conn.Close)
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Error During shutdown: %v", err)
+	if err := seq.Run(shutdownBudget); err != nil {
+		log.Fatalf("Error during shutdown: %v", err)
 	}
 
 	log.Println("Server gracefully stopped.")
 }
 
-func healthHandler(db *sqlx.DB) http.HandlerFunc {
+func healthHandler(db *sqlx.DB, flags *featureflag.Set) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Set response type to JSON
-		w.Header().Set("Content-Type", "Application/json")
-
 		// Check database connectivity
 		err := db.Ping()
-		status := "ok"
+		status, code := "ok", http.StatusOK
 		if err != nil {
-			status = "db_unreachable"
+			status, code = "db_unreachable", http.StatusServiceUnavailable
 			log.Printf("Health check failed: %v", err)
-			w.WriteHeader(http.StatusServiceUnavailable)
 		}
 
 		// Write JSON response
-		json.NewEncoder(w).Encode(map[string]string{"status": status})
+		httpx.WriteJSON(w, r, code, map[string]any{
+			"status": status,
+			"flags":  flags.Snapshot(),
+		})
 	}
 }
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS products_name_unique;
+DROP INDEX IF EXISTS products_tenant_id_idx;
+
+ALTER TABLE products DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
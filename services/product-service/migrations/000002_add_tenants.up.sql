@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS tenants (
+  id VARCHAR(64) PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO tenants (id, name) VALUES ('default', 'Default') ON CONFLICT (id) DO NOTHING;
+
+-- Backfill existing products to the default tenant, then require an explicit tenant
+ALTER TABLE products ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' REFERENCES tenants (id);
+ALTER TABLE products ALTER COLUMN tenant_id DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS products_tenant_id_idx ON products (tenant_id, id);
+
+-- The optional unique name index is recreated per tenant at startup
+DROP INDEX IF EXISTS products_name_unique;
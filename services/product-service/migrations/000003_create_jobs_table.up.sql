@@ -0,0 +1,20 @@
+-- Background jobs run by shared/jobqueue
+CREATE TABLE IF NOT EXISTS jobs (
+  id UUID PRIMARY KEY,
+  tenant_id VARCHAR(64) NOT NULL REFERENCES tenants (id),
+  kind VARCHAR(64) NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  payload JSONB NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  max_attempts INT NOT NULL,
+  progress JSONB,
+  result JSONB,
+  last_error TEXT,
+  run_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  started_at TIMESTAMPTZ,
+  finished_at TIMESTAMPTZ
+);
+
+-- Workers only ever look for runnable jobs
+CREATE INDEX IF NOT EXISTS jobs_queued_idx ON jobs (run_at) WHERE status = 'queued';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS products_category_idx;
+
+ALTER TABLE products DROP COLUMN IF EXISTS category;
+
+DROP TABLE IF EXISTS categories;
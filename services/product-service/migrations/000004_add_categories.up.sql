@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS categories (
+  slug VARCHAR(64) PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO categories (slug, name) VALUES
+  ('electronics', 'Electronics'),
+  ('clothing', 'Clothing'),
+  ('home', 'Home & Garden'),
+  ('books', 'Books')
+ON CONFLICT (slug) DO NOTHING;
+
+-- Existing products stay uncategorised
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category VARCHAR(64) REFERENCES categories (slug);
+
+CREATE INDEX IF NOT EXISTS products_category_idx ON products (tenant_id, category, id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS product_history;
+
+ALTER TABLE products DROP COLUMN IF EXISTS archived_at;
@@ -0,0 +1,14 @@
+-- Archived products are hidden from listings but can still be fetched by ID
+ALTER TABLE products ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+
+-- One row per change made by bulk operations
+CREATE TABLE IF NOT EXISTS product_history (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id VARCHAR(64) NOT NULL REFERENCES tenants (id),
+  product_id INT NOT NULL,
+  action VARCHAR(32) NOT NULL,
+  detail JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS product_history_product_idx ON product_history (tenant_id, product_id, id);
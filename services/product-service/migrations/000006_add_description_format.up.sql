@@ -0,0 +1,4 @@
+-- How the description source is written: html is sanitized when saved, markdown
+-- and plain are stored as written and rendered to sanitized HTML on request
+ALTER TABLE products ADD COLUMN IF NOT EXISTS description_format VARCHAR(16) NOT NULL DEFAULT 'html'
+  CHECK (description_format IN ('html', 'markdown', 'plain'));
@@ -0,0 +1,14 @@
+-- Stock held for a checkout. Reserving takes the quantity out of products.stock;
+-- releasing or expiring puts it back, exactly once, by moving the row out of active.
+CREATE TABLE IF NOT EXISTS stock_reservations (
+  id VARCHAR(64) PRIMARY KEY,
+  tenant_id VARCHAR(64) NOT NULL REFERENCES tenants (id),
+  product_id INT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+  quantity INT NOT NULL CHECK (quantity > 0),
+  status VARCHAR(16) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'released', 'expired')),
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  released_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_expiry_idx ON stock_reservations (expires_at) WHERE status = 'active';
@@ -0,0 +1,2 @@
+-- Finished jobs are deleted once they are older than JOB_RETENTION
+CREATE INDEX IF NOT EXISTS jobs_finished_idx ON jobs (finished_at) WHERE status IN ('succeeded', 'dead');
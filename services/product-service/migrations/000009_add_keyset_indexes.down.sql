@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS products_active_category_keyset_idx;
+DROP INDEX IF EXISTS products_active_keyset_idx;
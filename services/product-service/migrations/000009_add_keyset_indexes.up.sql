@@ -0,0 +1,4 @@
+-- Keyset pages of active products (cursor pagination on GET /products) read only
+-- these indexes; created_at is included for the snapshot filter
+CREATE INDEX IF NOT EXISTS products_active_keyset_idx ON products (tenant_id, id) INCLUDE (created_at) WHERE archived_at IS NULL;
+CREATE INDEX IF NOT EXISTS products_active_category_keyset_idx ON products (tenant_id, category, id) INCLUDE (created_at) WHERE archived_at IS NULL;
@@ -0,0 +1,15 @@
+-- A product's name and description in another locale. Reads fall back to the
+-- product's own name and description for locales without a row here.
+CREATE TABLE IF NOT EXISTS product_translations (
+  product_id INTEGER NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+  locale VARCHAR(16) NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  description TEXT,
+  description_format VARCHAR(16) NOT NULL DEFAULT 'html'
+    CHECK (description_format IN ('html', 'markdown', 'plain')),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (product_id, locale)
+);
+
+-- Pages are localized one locale at a time
+CREATE INDEX IF NOT EXISTS product_translations_locale_idx ON product_translations (locale, product_id);
@@ -0,0 +1,7 @@
+-- Categories form a tree: a category without a parent is a top-level one. Cycles
+-- are refused by the service when a parent is set, under a lock; the constraint
+-- only catches the simplest one.
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_slug VARCHAR(64) REFERENCES categories (slug);
+ALTER TABLE categories ADD CONSTRAINT categories_not_own_parent CHECK (parent_slug <> slug);
+
+CREATE INDEX IF NOT EXISTS categories_parent_slug_idx ON categories (parent_slug);
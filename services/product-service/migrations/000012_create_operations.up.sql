@@ -0,0 +1,22 @@
+-- Mutating requests recorded by shared/oplog when OPLOG_SINK=db, written before
+-- the request is handled; status stays NULL if it never finished
+CREATE TABLE IF NOT EXISTS operations (
+  id UUID PRIMARY KEY,
+  method VARCHAR(16) NOT NULL,
+  path TEXT NOT NULL,
+  route TEXT NOT NULL,
+  actor_id VARCHAR(64) NOT NULL DEFAULT '',
+  impersonated_by VARCHAR(64) NOT NULL DEFAULT '',
+  tenant_id VARCHAR(64) NOT NULL DEFAULT '',
+  request_id VARCHAR(128) NOT NULL DEFAULT '',
+  body TEXT NOT NULL DEFAULT '',
+  body_omitted VARCHAR(32) NOT NULL DEFAULT '',
+  status INT,
+  started_at TIMESTAMPTZ NOT NULL,
+  finished_at TIMESTAMPTZ
+);
+
+-- /admin/operations?user_id= lists a user's most recent operations
+CREATE INDEX IF NOT EXISTS operations_actor_idx ON operations (actor_id, started_at DESC);
+-- Operations older than OPLOG_RETENTION are deleted by age
+CREATE INDEX IF NOT EXISTS operations_started_at_idx ON operations (started_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS products_availability_idx;
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_availability_window;
+ALTER TABLE products DROP COLUMN IF EXISTS announced_available;
+ALTER TABLE products DROP COLUMN IF EXISTS available_until;
+ALTER TABLE products DROP COLUMN IF EXISTS available_from;
@@ -0,0 +1,12 @@
+-- Seasonal products can only be bought between available_from and available_until;
+-- either end may be open. announced_available is the availability last published
+-- by the availability watcher, which announces every change to it.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS available_from TIMESTAMPTZ;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS available_until TIMESTAMPTZ;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS announced_available BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE products ADD CONSTRAINT products_availability_window CHECK (available_from < available_until);
+
+-- The watcher only looks at products with a window, or that were announced as
+-- unavailable and may have lost theirs
+CREATE INDEX IF NOT EXISTS products_availability_idx ON products (id)
+  WHERE available_from IS NOT NULL OR available_until IS NOT NULL OR NOT announced_available;
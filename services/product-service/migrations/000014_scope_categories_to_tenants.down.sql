@@ -0,0 +1,20 @@
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_category_fkey;
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_parent_slug_fkey;
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_pkey;
+
+-- Keep one category per slug, the default tenant's where it has one
+DELETE FROM categories c
+WHERE c.tenant_id <> (
+  SELECT k.tenant_id FROM categories k WHERE k.slug = c.slug
+  ORDER BY k.tenant_id <> 'default', k.tenant_id
+  LIMIT 1
+);
+
+DROP INDEX IF EXISTS categories_parent_slug_idx;
+ALTER TABLE categories DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE categories ADD CONSTRAINT categories_pkey PRIMARY KEY (slug);
+ALTER TABLE categories ADD CONSTRAINT categories_parent_slug_fkey FOREIGN KEY (parent_slug) REFERENCES categories (slug);
+ALTER TABLE products ADD CONSTRAINT products_category_fkey FOREIGN KEY (category) REFERENCES categories (slug);
+
+CREATE INDEX IF NOT EXISTS categories_parent_slug_idx ON categories (parent_slug);
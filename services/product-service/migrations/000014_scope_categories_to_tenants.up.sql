@@ -0,0 +1,22 @@
+-- Categories belong to a tenant, like the products filed under them. Every tenant
+-- could use every category until now, so each one gets its own copy.
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_category_fkey;
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_parent_slug_fkey;
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_pkey;
+
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' REFERENCES tenants (id);
+ALTER TABLE categories ALTER COLUMN tenant_id DROP DEFAULT;
+
+INSERT INTO categories (tenant_id, slug, name, created_at, parent_slug)
+SELECT t.id, c.slug, c.name, c.created_at, c.parent_slug
+FROM tenants t CROSS JOIN categories c
+WHERE t.id <> 'default' AND c.tenant_id = 'default';
+
+ALTER TABLE categories ADD CONSTRAINT categories_pkey PRIMARY KEY (tenant_id, slug);
+ALTER TABLE categories ADD CONSTRAINT categories_parent_slug_fkey
+  FOREIGN KEY (tenant_id, parent_slug) REFERENCES categories (tenant_id, slug);
+ALTER TABLE products ADD CONSTRAINT products_category_fkey
+  FOREIGN KEY (tenant_id, category) REFERENCES categories (tenant_id, slug);
+
+DROP INDEX IF EXISTS categories_parent_slug_idx;
+CREATE INDEX IF NOT EXISTS categories_parent_slug_idx ON categories (tenant_id, parent_slug);
@@ -0,0 +1,17 @@
+-- Every change to products.stock, signed. The sum of a product's movements is
+-- what its stock should be; reconciliation compares the two.
+CREATE TABLE IF NOT EXISTS stock_movements (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id VARCHAR(64) NOT NULL REFERENCES tenants (id),
+  product_id INT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+  quantity INT NOT NULL,
+  reason VARCHAR(32) NOT NULL,
+  note TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_product_idx ON stock_movements (product_id, id);
+
+-- Stock from before the ledger is taken as it stands
+INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+SELECT tenant_id, id, stock, 'opening' FROM products WHERE stock <> 0;
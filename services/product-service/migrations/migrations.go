@@ -0,0 +1,10 @@
+// Package migrations embeds the service's schema migrations, applied at startup
+// by database.Migrate
+package migrations
+
+import "embed"
+
+// FS holds the .up.sql and .down.sql files
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,44 @@
+-- name: ListCategories :many
+SELECT slug, name, created_at, parent_slug, tenant_id FROM categories WHERE tenant_id = $1 ORDER BY slug;
+
+-- name: ListCategoryTree :many
+-- Walks the tree from the top-level categories in one query. Rows come depth
+-- first, each after its parent and siblings in slug order. A category in a cycle
+-- has no top-level ancestor, so it is never reached.
+WITH RECURSIVE tree AS (
+  SELECT slug, name, parent_slug, ARRAY[slug::text] AS path
+  FROM categories WHERE tenant_id = $1 AND parent_slug IS NULL
+  UNION ALL
+  SELECT c.slug, c.name, c.parent_slug, t.path || c.slug::text
+  FROM categories c JOIN tree t ON c.tenant_id = $1 AND c.parent_slug = t.slug
+)
+SELECT slug, name, parent_slug FROM tree
+ORDER BY path;
+
+-- name: LockCategories :exec
+-- Serializes a tenant's category writes until the transaction ends, so two
+-- moves can't together make a cycle that neither makes alone
+SELECT pg_advisory_xact_lock(hashtext('categories:' || sqlc.arg(tenant_id)::text));
+
+-- name: CategoryHasAncestor :one
+-- Reports whether ancestor is slug or one of its ancestors. UNION rather than
+-- UNION ALL stops at a cycle already in the table.
+WITH RECURSIVE ancestors AS (
+  SELECT slug, parent_slug FROM categories WHERE tenant_id = sqlc.arg(tenant_id) AND slug = sqlc.arg(slug)
+  UNION
+  SELECT c.slug, c.parent_slug FROM categories c JOIN ancestors a ON c.tenant_id = sqlc.arg(tenant_id) AND c.slug = a.parent_slug
+)
+SELECT EXISTS (SELECT 1 FROM ancestors WHERE slug = sqlc.arg(ancestor));
+
+-- name: UpsertCategory :one
+INSERT INTO categories (tenant_id, slug, name, parent_slug)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (tenant_id, slug) DO UPDATE
+SET name = EXCLUDED.name, parent_slug = EXCLUDED.parent_slug
+RETURNING slug, name, created_at, parent_slug, tenant_id, (xmax = 0) AS inserted;
+
+-- name: DeleteCategory :execrows
+DELETE FROM categories WHERE tenant_id = $1 AND slug = $2;
+
+-- name: CategoryExists :one
+SELECT EXISTS (SELECT 1 FROM categories WHERE tenant_id = $1 AND slug = $2);
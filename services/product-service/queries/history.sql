@@ -0,0 +1,3 @@
+-- name: RecordProductHistory :exec
+INSERT INTO product_history (tenant_id, product_id, action, detail)
+SELECT sqlc.arg(tenant_id), unnest(sqlc.arg(product_ids)::int[]), sqlc.arg(action), sqlc.arg(detail)::jsonb;
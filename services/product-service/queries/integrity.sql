@@ -0,0 +1,13 @@
+-- name: CountOrphans :one
+-- Rows left behind by products that no longer exist, across all tenants. History
+-- outlives its product on purpose, but only once the delete itself is recorded.
+SELECT
+  (SELECT count(*) FROM stock_reservations r
+   WHERE NOT EXISTS (SELECT 1 FROM products p WHERE p.id = r.product_id)) AS reservations_without_product,
+  (SELECT count(*) FROM stock_reservations r JOIN products p ON p.id = r.product_id
+   WHERE p.tenant_id <> r.tenant_id) AS reservations_in_wrong_tenant,
+  (SELECT count(*) FROM product_history h
+   WHERE NOT EXISTS (SELECT 1 FROM products p WHERE p.id = h.product_id AND p.tenant_id = h.tenant_id)
+     AND NOT EXISTS (SELECT 1 FROM product_history d WHERE d.tenant_id = h.tenant_id AND d.product_id = h.product_id AND d.action = 'deleted')) AS history_without_product,
+  (SELECT count(*) FROM products p
+   WHERE p.category IS NOT NULL AND NOT EXISTS (SELECT 1 FROM categories c WHERE c.tenant_id = p.tenant_id AND c.slug = p.category)) AS products_in_unknown_category;
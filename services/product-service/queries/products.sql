@@ -1,19 +1,126 @@
 -- name: ListProducts :many
-SELECT id, name, description, price, stock, created_at FROM products ORDER BY id;
+-- With available_at, products outside their availability window at that time are left out
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1 AND archived_at IS NULL
+  AND (sqlc.narg('available_at')::timestamptz IS NULL OR ((available_from IS NULL OR available_from <= sqlc.narg('available_at')::timestamptz) AND (available_until IS NULL OR available_until > sqlc.narg('available_at')::timestamptz)))
+ORDER BY id
+LIMIT $2 OFFSET $3;
+
+-- name: ListProductsByCategory :many
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1 AND category = $2 AND archived_at IS NULL
+  AND (sqlc.narg('available_at')::timestamptz IS NULL OR ((available_from IS NULL OR available_from <= sqlc.narg('available_at')::timestamptz) AND (available_until IS NULL OR available_until > sqlc.narg('available_at')::timestamptz)))
+ORDER BY id
+LIMIT $3 OFFSET $4;
+
+-- name: ListProductsAfter :many
+-- Keyset page after an ID. With a snapshot, rows created after it are left out, so
+-- products added mid-scroll don't show up in a listing that started before them.
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1 AND archived_at IS NULL AND id > $2
+  AND (sqlc.narg('snapshot')::timestamptz IS NULL OR created_at IS NULL OR created_at <= sqlc.narg('snapshot')::timestamptz)
+  AND (sqlc.narg('available_at')::timestamptz IS NULL OR ((available_from IS NULL OR available_from <= sqlc.narg('available_at')::timestamptz) AND (available_until IS NULL OR available_until > sqlc.narg('available_at')::timestamptz)))
+ORDER BY id
+LIMIT $3;
+
+-- name: ListProductsByCategoryAfter :many
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE tenant_id = $1 AND category = $2 AND archived_at IS NULL AND id > $3
+  AND (sqlc.narg('snapshot')::timestamptz IS NULL OR created_at IS NULL OR created_at <= sqlc.narg('snapshot')::timestamptz)
+  AND (sqlc.narg('available_at')::timestamptz IS NULL OR ((available_from IS NULL OR available_from <= sqlc.narg('available_at')::timestamptz) AND (available_until IS NULL OR available_until > sqlc.narg('available_at')::timestamptz)))
+ORDER BY id
+LIMIT $4;
 
 -- name: GetProduct :one
-SELECT id, name, description, price, stock, created_at FROM products WHERE id = $1;
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM products
+WHERE id = $1 AND tenant_id = $2;
 
 -- name: CreateProduct :one
-INSERT INTO products (name, description, price, stock)
-VALUES ($1, $2, $3, $4)
-RETURNING id, name, description, price, stock, created_at;
+-- The opening stock is the product's first movement
+WITH created AS (
+  INSERT INTO products (tenant_id, name, description, price, stock, category, description_format, available_from, available_until)
+  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+  RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available
+), movement AS (
+  INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+  SELECT tenant_id, id, stock, 'created' FROM created WHERE stock <> 0
+)
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM created;
 
 -- name: UpdateProduct :one
-UPDATE products
-SET name = $2, description = $3, price = $4, stock = $5
-WHERE id = $1
-RETURNING id, name, description, price, stock, created_at;
+-- A change of stock is recorded as a movement of the difference
+WITH old AS (
+  SELECT id, stock FROM products WHERE id = $1 AND tenant_id = $2 FOR UPDATE
+), updated AS (
+  UPDATE products
+  SET name = $3, description = $4, price = $5, stock = $6, category = $7, description_format = $8,
+      available_from = $9, available_until = $10
+  WHERE id = $1 AND tenant_id = $2
+  RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available
+), movement AS (
+  INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+  SELECT updated.tenant_id, updated.id, updated.stock - old.stock, 'updated'
+  FROM updated JOIN old ON old.id = updated.id
+  WHERE updated.stock <> old.stock
+)
+SELECT id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available FROM updated;
+
+-- name: DeleteProduct :execrows
+DELETE FROM products WHERE id = $1 AND tenant_id = $2;
+
+-- name: AnnounceAvailability :many
+-- Claims a batch of products, in every tenant, whose availability at now differs
+-- from the one last announced, and records the new one. SKIP LOCKED lets several
+-- replicas run it at once without announcing a change twice.
+UPDATE products SET announced_available = NOT announced_available
+WHERE id IN (
+  SELECT id FROM products
+  WHERE (available_from IS NOT NULL OR available_until IS NOT NULL OR NOT announced_available)
+    AND archived_at IS NULL
+    AND announced_available <> ((available_from IS NULL OR available_from <= sqlc.arg(now)::timestamptz) AND (available_until IS NULL OR available_until > sqlc.arg(now)::timestamptz))
+  ORDER BY id
+  LIMIT sqlc.arg(limit)
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available;
+
+-- name: ListProductStock :many
+-- Inventory sync works on warehouse product IDs, which are global across tenants.
+-- Reserved is the quantity held by active reservations, already taken out of stock.
+SELECT p.id, p.stock, COALESCE(SUM(r.quantity), 0)::int AS reserved
+FROM products p
+LEFT JOIN stock_reservations r ON r.product_id = p.id AND r.status = 'active'
+GROUP BY p.id
+ORDER BY p.id;
+
+-- name: LockProductStock :exec
+-- Holds a product's row until the transaction ends, so no reservation can take or
+-- return its stock meanwhile
+SELECT id FROM products WHERE id = $1 FOR UPDATE;
+
+-- name: UpdateProductStock :exec
+WITH old AS (
+  SELECT id, stock FROM products WHERE id = $1 FOR UPDATE
+), updated AS (
+  UPDATE products SET stock = $2 WHERE id = $1
+  RETURNING id, tenant_id, stock
+)
+INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+SELECT updated.tenant_id, updated.id, updated.stock - old.stock, 'inventory_sync'
+FROM updated JOIN old ON old.id = updated.id
+WHERE updated.stock <> old.stock;
+
+-- name: DeleteProducts :many
+DELETE FROM products
+WHERE id = ANY(sqlc.arg(ids)::int[]) AND tenant_id = sqlc.arg(tenant_id)
+RETURNING id;
+
+-- name: ArchiveProducts :many
+UPDATE products SET archived_at = COALESCE(archived_at, sqlc.arg(archived_at))
+WHERE id = ANY(sqlc.arg(ids)::int[]) AND tenant_id = sqlc.arg(tenant_id)
+RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available;
 
--- name: DeleteProduct :exec
-DELETE FROM products WHERE id = $1;
+-- name: CategorizeProducts :many
+UPDATE products SET category = sqlc.arg(category)
+WHERE id = ANY(sqlc.arg(ids)::int[]) AND tenant_id = sqlc.arg(tenant_id)
+RETURNING id, name, description, price, stock, created_at, tenant_id, category, archived_at, description_format, available_from, available_until, announced_available;
@@ -0,0 +1,54 @@
+-- name: TakeStock :one
+-- Takes stock only if enough is left and the product is available now; the row
+-- lock serialises concurrent reservations
+WITH taken AS (
+  UPDATE products SET stock = stock - $3
+  WHERE id = $1 AND tenant_id = $2 AND archived_at IS NULL AND stock >= $3
+    AND (available_from IS NULL OR available_from <= sqlc.arg(now)::timestamptz) AND (available_until IS NULL OR available_until > sqlc.arg(now)::timestamptz)
+  RETURNING id, tenant_id, stock
+), movement AS (
+  INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+  SELECT tenant_id, id, -$3, 'reserved' FROM taken
+)
+SELECT stock FROM taken;
+
+-- name: ReservedStock :one
+SELECT COALESCE(SUM(quantity), 0)::int AS reserved FROM stock_reservations
+WHERE product_id = $1 AND status = 'active';
+
+-- name: RestoreStock :one
+-- Reason is why the stock came back: released or expired
+WITH restored AS (
+  UPDATE products SET stock = stock + $2 WHERE id = $1
+  RETURNING id, tenant_id, stock
+), movement AS (
+  INSERT INTO stock_movements (tenant_id, product_id, quantity, reason)
+  SELECT tenant_id, id, $2, sqlc.arg(reason)::varchar FROM restored
+)
+SELECT stock FROM restored;
+
+-- name: CreateReservation :one
+INSERT INTO stock_reservations (id, tenant_id, product_id, quantity, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, tenant_id, product_id, quantity, status, expires_at, created_at, released_at;
+
+-- name: GetReservation :one
+SELECT id, tenant_id, product_id, quantity, status, expires_at, created_at, released_at FROM stock_reservations
+WHERE id = $1 AND tenant_id = $2 AND product_id = $3;
+
+-- name: ReleaseReservation :one
+UPDATE stock_reservations SET status = 'released', released_at = $4
+WHERE id = $1 AND tenant_id = $2 AND product_id = $3 AND status = 'active'
+RETURNING id, tenant_id, product_id, quantity, status, expires_at, created_at, released_at;
+
+-- name: ExpireReservations :many
+-- Claims a batch of lapsed reservations; SKIP LOCKED lets several replicas sweep at once
+UPDATE stock_reservations SET status = 'expired', released_at = sqlc.arg(now)::timestamptz
+WHERE id IN (
+  SELECT id FROM stock_reservations
+  WHERE status = 'active' AND expires_at <= sqlc.arg(now)::timestamptz
+  ORDER BY expires_at
+  LIMIT sqlc.arg(limit)
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, tenant_id, product_id, quantity, status, expires_at, created_at, released_at;
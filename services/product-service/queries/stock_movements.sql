@@ -0,0 +1,24 @@
+-- name: ListStockBalances :many
+-- A batch of products after an ID, in every tenant and optionally only those in
+-- ids, with the stock their movements add up to. The rows stay locked until the
+-- transaction ends, so no movement lands between comparing and fixing them.
+SELECT p.id, p.tenant_id, p.stock,
+  (SELECT COALESCE(SUM(m.quantity), 0) FROM stock_movements m WHERE m.product_id = p.id)::int AS balance
+FROM products p
+WHERE p.id > sqlc.arg(after_id)
+  AND (COALESCE(cardinality(sqlc.arg(ids)::int[]), 0) = 0 OR p.id = ANY(sqlc.arg(ids)::int[]))
+ORDER BY p.id
+LIMIT sqlc.arg(limit)
+FOR UPDATE OF p;
+
+-- name: CorrectStock :exec
+-- Sets a product's stock to its ledger balance and records the correction. The
+-- movement is of nothing, as the balance was already right; its note keeps the
+-- stock that was overwritten.
+WITH corrected AS (
+  UPDATE products SET stock = sqlc.arg(balance)
+  WHERE id = sqlc.arg(id)
+  RETURNING id, tenant_id
+)
+INSERT INTO stock_movements (tenant_id, product_id, quantity, reason, note)
+SELECT tenant_id, id, 0, 'reconciliation', sqlc.arg(note)::text FROM corrected;
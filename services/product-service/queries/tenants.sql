@@ -0,0 +1,2 @@
+-- name: TenantExists :one
+SELECT EXISTS (SELECT 1 FROM tenants WHERE id = $1);
@@ -0,0 +1,28 @@
+-- name: UpsertProductTranslation :one
+-- Returns no row when the product is not in the tenant. inserted is true when the
+-- translation was created rather than replaced.
+INSERT INTO product_translations (product_id, locale, name, description, description_format, updated_at)
+SELECT id, $3, $4, $5, $6, $7 FROM products
+WHERE id = $1 AND tenant_id = $2
+ON CONFLICT (product_id, locale) DO UPDATE
+SET name = EXCLUDED.name, description = EXCLUDED.description,
+    description_format = EXCLUDED.description_format, updated_at = EXCLUDED.updated_at
+RETURNING product_id, locale, name, description, description_format, updated_at, (xmax = 0) AS inserted;
+
+-- name: GetProductTranslation :one
+SELECT t.product_id, t.locale, t.name, t.description, t.description_format, t.updated_at FROM product_translations t
+JOIN products p ON p.id = t.product_id
+WHERE t.product_id = $1 AND p.tenant_id = $2 AND t.locale = $3;
+
+-- name: ListProductTranslations :many
+-- Translations into one locale of the given products, for localizing a page of them
+SELECT t.product_id, t.locale, t.name, t.description, t.description_format, t.updated_at FROM product_translations t
+JOIN products p ON p.id = t.product_id
+WHERE p.tenant_id = $1 AND t.locale = $2 AND t.product_id = ANY(sqlc.arg(product_ids)::int[]);
+
+-- name: ListAllProductTranslations :many
+-- Every translation of the given products, for ?expand=translations
+SELECT t.product_id, t.locale, t.name, t.description, t.description_format, t.updated_at FROM product_translations t
+JOIN products p ON p.id = t.product_id
+WHERE p.tenant_id = $1 AND t.product_id = ANY(sqlc.arg(product_ids)::int[])
+ORDER BY t.product_id, t.locale;
@@ -0,0 +1,79 @@
+// Command encrypt-pii encrypts the user emails still stored in plaintext from
+// before application-level encryption. It works in batches, logs its progress and
+// is safe to stop and rerun: each run picks up the rows that are left.
+//
+//	go run ./cmd/encrypt-pii                  # encrypt remaining rows
+//	go run ./cmd/encrypt-pii -batch 1000      # rows per batch, default 500
+//	go run ./cmd/encrypt-pii -generate-keys   # print a new PII_MASTER_KEY and PII_DATA_KEY
+//
+// It reads DATABASE_URL, PII_MASTER_KEY and PII_DATA_KEY like the service does,
+// and expects the service's migrations to have been applied.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"shared/database"
+	"syscall"
+	"user-service/internal/pii"
+	"user-service/internal/user"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	batch := flag.Int("batch", 500, "rows encrypted per batch")
+	generateKeys := flag.Bool("generate-keys", false, "print a new master key and wrapped data key, then exit")
+	flag.Parse()
+
+	if *generateKeys {
+		masterKey, dataKey, err := pii.GenerateKeys()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("PII_MASTER_KEY=%s\nPII_DATA_KEY=%s\n", masterKey, dataKey)
+		return
+	}
+	if *batch <= 0 {
+		log.Fatalf("invalid -batch %d", *batch)
+	}
+
+	_ = godotenv.Load()
+
+	// Stop after the current batch on Ctrl-C; the next run carries on from there
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cipher, err := pii.FromEnv(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dbConfig, err := database.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := database.Connect(dbConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	repo := user.NewRepository(conn, cipher)
+	result, err := repo.EncryptEmails(ctx, int32(*batch), func(p user.EncryptionProgress) {
+		log.Printf("Encrypted %d emails (up to user %d), %d left", p.Encrypted, p.LastID, p.Remaining)
+	})
+	if err != nil {
+		log.Fatalf("Stopped after encrypting %d emails (up to user %d): %v", result.Encrypted, result.LastID, err)
+	}
+
+	if len(result.Conflicts) > 0 {
+		log.Fatalf("Encrypted %d emails; users %v have emails that duplicate another user's once lowercased and were left unencrypted. "+
+			"Resolve the duplicates and run again.", result.Encrypted, result.Conflicts)
+	}
+	log.Printf("Done: encrypted %d emails", result.Encrypted)
+}
@@ -1,55 +0,0 @@
-package db
-
-import (
-	"fmt"
-	"log"
-	"os"
-
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/jmoiron/sqlx"
-)
-
-// Connect opens a Postgres connection using DATABASE_URL
-func Connect() (*sqlx.DB, error) {
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		return nil, fmt.Errorf("DATABASE_URL not set")
-	}
-
-	conn, err := sqlx.Connect("postgres", dbURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
-	}
-
-	log.Println("Connected to Postgres")
-	return conn, nil
-}
-
-// Migrate runs all pending migrations in /migrations
-func Migrate(conn *sqlx.DB) error {
-	log.Println("Running migrations...")
-
-	driver, err := postgres.WithInstance(conn.DB, &postgres.Config{})
-	if err != nil {
-		return fmt.Errorf("could not start postgres driver: %w", err)
-	}
-
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
-		"postgres",
-		driver,
-	)
-	if err != nil {
-		return fmt.Errorf("could not create migrate instance: %w", err)
-	}
-
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("migration failed: %w", err)
-	}
-
-	log.Println("Migrations complete.")
-	return nil
-}
-
@@ -6,11 +6,46 @@ package generated
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
 )
 
-type User struct {
-	ID        int32
+type AuditChainHead struct {
+	TenantID string
+	Seq      int64
+	Hash     []byte
+}
+
+type AuditLog struct {
+	ID        int64
+	TenantID  string
+	ActorID   string
+	Action    string
+	TargetID  string
+	Detail    json.RawMessage
+	CreatedAt time.Time
+	Seq       sql.NullInt64
+	PrevHash  []byte
+	Hash      []byte
+}
+
+type Tenant struct {
+	ID        string
 	Name      string
-	Email     string
 	CreatedAt sql.NullTime
 }
+
+type User struct {
+	ID                    int32
+	Name                  string
+	Email                 string
+	CreatedAt             sql.NullTime
+	DeletedAt             sql.NullTime
+	TenantID              string
+	EmailHash             sql.NullString
+	PendingEmail          sql.NullString
+	PendingEmailHash      sql.NullString
+	EmailTokenHash        sql.NullString
+	PendingEmailExpiresAt sql.NullTime
+	IsActive              bool
+}
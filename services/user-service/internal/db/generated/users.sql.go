@@ -7,62 +7,239 @@ package generated
 
 import (
 	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
 )
 
+const cancelPendingEmail = `-- name: CancelPendingEmail :execrows
+UPDATE users
+SET pending_email = NULL, pending_email_hash = NULL, email_token_hash = NULL, pending_email_expires_at = NULL
+WHERE id = $1 AND tenant_id = $2 AND pending_email IS NOT NULL AND deleted_at IS NULL
+`
+
+type CancelPendingEmailParams struct {
+	ID       int32
+	TenantID string
+}
+
+func (q *Queries) CancelPendingEmail(ctx context.Context, arg CancelPendingEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelPendingEmail, arg.ID, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const confirmEmail = `-- name: ConfirmEmail :one
+UPDATE users
+SET email = pending_email, email_hash = pending_email_hash,
+    pending_email = NULL, pending_email_hash = NULL, email_token_hash = NULL, pending_email_expires_at = NULL
+WHERE tenant_id = $1 AND email_token_hash = $2 AND pending_email_expires_at > $3 AND deleted_at IS NULL
+RETURNING id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active
+`
+
+type ConfirmEmailParams struct {
+	TenantID       string
+	EmailTokenHash sql.NullString
+	Now            sql.NullTime
+}
+
+// Applies the pending change whose token hashes to email_token_hash, unless it has expired
+func (q *Queries) ConfirmEmail(ctx context.Context, arg ConfirmEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, confirmEmail, arg.TenantID, arg.EmailTokenHash, arg.Now)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.TenantID,
+		&i.EmailHash,
+		&i.PendingEmail,
+		&i.PendingEmailHash,
+		&i.EmailTokenHash,
+		&i.PendingEmailExpiresAt,
+		&i.IsActive,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (name, email)
-VALUES ($1, $2)
-RETURNING id, name, email, created_at
+INSERT INTO users (tenant_id, name, email, email_hash)
+VALUES ($1, $2, $3, $4)
+RETURNING id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active
 `
 
 type CreateUserParams struct {
-	Name  string
-	Email string
+	TenantID  string
+	Name      string
+	Email     string
+	EmailHash sql.NullString
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Name, arg.Email)
+	row := q.db.QueryRowContext(ctx, createUser,
+		arg.TenantID,
+		arg.Name,
+		arg.Email,
+		arg.EmailHash,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Name,
 		&i.Email,
 		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.TenantID,
+		&i.EmailHash,
+		&i.PendingEmail,
+		&i.PendingEmailHash,
+		&i.EmailTokenHash,
+		&i.PendingEmailExpiresAt,
+		&i.IsActive,
 	)
 	return i, err
 }
 
-const deleteUser = `-- name: DeleteUser :exec
-DELETE FROM users WHERE id = $1
+const deleteUser = `-- name: DeleteUser :execrows
+UPDATE users SET deleted_at = $3
+WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id int32) error {
-	_, err := q.db.ExecContext(ctx, deleteUser, id)
-	return err
+type DeleteUserParams struct {
+	ID        int32
+	TenantID  string
+	DeletedAt sql.NullTime
+}
+
+func (q *Queries) DeleteUser(ctx context.Context, arg DeleteUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, arg.ID, arg.TenantID, arg.DeletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUsers = `-- name: DeleteUsers :many
+UPDATE users SET deleted_at = $1
+WHERE id = ANY($2::int[]) AND tenant_id = $3 AND deleted_at IS NULL
+RETURNING id
+`
+
+type DeleteUsersParams struct {
+	DeletedAt sql.NullTime
+	Ids       []int32
+	TenantID  string
+}
+
+func (q *Queries) DeleteUsers(ctx context.Context, arg DeleteUsersParams) ([]int32, error) {
+	rows, err := q.db.QueryContext(ctx, deleteUsers, arg.DeletedAt, pq.Array(arg.Ids), arg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const emailClaimed = `-- name: EmailClaimed :one
+SELECT EXISTS (
+  SELECT 1 FROM users
+  WHERE tenant_id = $1 AND id <> $2
+    AND (email_hash = $3 OR (pending_email_hash = $3 AND pending_email_expires_at > $4 AND deleted_at IS NULL))
+)
+`
+
+type EmailClaimedParams struct {
+	TenantID  string
+	ID        int32
+	EmailHash sql.NullString
+	Now       sql.NullTime
+}
+
+// Reports whether another user has the email, or has asked to change to it and
+// may still confirm. Call it after LockEmail so the answer holds until commit.
+func (q *Queries) EmailClaimed(ctx context.Context, arg EmailClaimedParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, emailClaimed,
+		arg.TenantID,
+		arg.ID,
+		arg.EmailHash,
+		arg.Now,
+	)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
 }
 
 const getUser = `-- name: GetUser :one
-SELECT id, name, email, created_at FROM users WHERE id = $1
+SELECT id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active FROM users
+WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
 `
 
-func (q *Queries) GetUser(ctx context.Context, id int32) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUser, id)
+type GetUserParams struct {
+	ID       int32
+	TenantID string
+}
+
+func (q *Queries) GetUser(ctx context.Context, arg GetUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, arg.ID, arg.TenantID)
 	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Name,
 		&i.Email,
 		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.TenantID,
+		&i.EmailHash,
+		&i.PendingEmail,
+		&i.PendingEmailHash,
+		&i.EmailTokenHash,
+		&i.PendingEmailExpiresAt,
+		&i.IsActive,
 	)
 	return i, err
 }
 
 const listUsers = `-- name: ListUsers :many
-SELECT id, name, email, created_at FROM users ORDER BY id
+SELECT id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active FROM users
+WHERE tenant_id = $1 AND deleted_at IS NULL
+  AND ($2::boolean IS NULL OR is_active = $2)
+ORDER BY id
+LIMIT $3 OFFSET $4
 `
 
-func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
-	rows, err := q.db.QueryContext(ctx, listUsers)
+type ListUsersParams struct {
+	TenantID string
+	Active   sql.NullBool
+	Limit    int32
+	Offset   int32
+}
+
+// Active NULL lists every user; true or false only those active or deactivated
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers,
+		arg.TenantID,
+		arg.Active,
+		arg.Limit,
+		arg.Offset,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +252,14 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 			&i.Name,
 			&i.Email,
 			&i.CreatedAt,
+			&i.DeletedAt,
+			&i.TenantID,
+			&i.EmailHash,
+			&i.PendingEmail,
+			&i.PendingEmailHash,
+			&i.EmailTokenHash,
+			&i.PendingEmailExpiresAt,
+			&i.IsActive,
 		); err != nil {
 			return nil, err
 		}
@@ -89,27 +274,241 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
-const updateUser = `-- name: UpdateUser :one
+const lockEmail = `-- name: LockEmail :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::text || ':' || $2::text, 0))
+`
+
+type LockEmailParams struct {
+	TenantID  string
+	EmailHash string
+}
+
+// Serializes transactions claiming the same email in a tenant until they end
+func (q *Queries) LockEmail(ctx context.Context, arg LockEmailParams) error {
+	_, err := q.db.ExecContext(ctx, lockEmail, arg.TenantID, arg.EmailHash)
+	return err
+}
+
+const patchUser = `-- name: PatchUser :one
 UPDATE users
-SET name = $2, email = $3
-WHERE id = $1
-RETURNING id, name, email, created_at
+SET name = COALESCE($1, name),
+    email = COALESCE($2, email),
+    email_hash = COALESCE($3, email_hash)
+WHERE id = $4 AND tenant_id = $5 AND deleted_at IS NULL
+RETURNING id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active
 `
 
-type UpdateUserParams struct {
-	ID    int32
-	Name  string
-	Email string
+type PatchUserParams struct {
+	Name      sql.NullString
+	Email     sql.NullString
+	EmailHash sql.NullString
+	ID        int32
+	TenantID  string
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Name, arg.Email)
+// Changes only the fields given; the others are passed as NULL
+func (q *Queries) PatchUser(ctx context.Context, arg PatchUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, patchUser,
+		arg.Name,
+		arg.Email,
+		arg.EmailHash,
+		arg.ID,
+		arg.TenantID,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Name,
 		&i.Email,
 		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.TenantID,
+		&i.EmailHash,
+		&i.PendingEmail,
+		&i.PendingEmailHash,
+		&i.EmailTokenHash,
+		&i.PendingEmailExpiresAt,
+		&i.IsActive,
+	)
+	return i, err
+}
+
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE id IN (
+  SELECT id FROM users WHERE deleted_at < $1 ORDER BY id LIMIT $2
+)
+`
+
+type PurgeDeletedUsersParams struct {
+	DeletedAt sql.NullTime
+	Limit     int32
+}
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, arg PurgeDeletedUsersParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedUsers, arg.DeletedAt, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setPendingEmail = `-- name: SetPendingEmail :execrows
+UPDATE users
+SET pending_email = $1, pending_email_hash = $2, email_token_hash = $3, pending_email_expires_at = $4
+WHERE id = $5 AND tenant_id = $6 AND deleted_at IS NULL
+`
+
+type SetPendingEmailParams struct {
+	PendingEmail          sql.NullString
+	PendingEmailHash      sql.NullString
+	EmailTokenHash        sql.NullString
+	PendingEmailExpiresAt sql.NullTime
+	ID                    int32
+	TenantID              string
+}
+
+// Replaces any earlier pending change, whose token stops working
+func (q *Queries) SetPendingEmail(ctx context.Context, arg SetPendingEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setPendingEmail,
+		arg.PendingEmail,
+		arg.PendingEmailHash,
+		arg.EmailTokenHash,
+		arg.PendingEmailExpiresAt,
+		arg.ID,
+		arg.TenantID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserActive = `-- name: SetUserActive :one
+UPDATE users u SET is_active = $3
+FROM (
+  SELECT id, is_active FROM users
+  WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+  FOR UPDATE
+) old
+WHERE u.id = old.id
+RETURNING u.id, u.name, u.email, u.created_at, u.deleted_at, u.tenant_id, u.email_hash, u.pending_email, u.pending_email_hash, u.email_token_hash, u.pending_email_expires_at, u.is_active, old.is_active AS was_active
+`
+
+type SetUserActiveParams struct {
+	ID       int32
+	TenantID string
+	IsActive bool
+}
+
+type SetUserActiveRow struct {
+	ID                    int32
+	Name                  string
+	Email                 string
+	CreatedAt             sql.NullTime
+	DeletedAt             sql.NullTime
+	TenantID              string
+	EmailHash             sql.NullString
+	PendingEmail          sql.NullString
+	PendingEmailHash      sql.NullString
+	EmailTokenHash        sql.NullString
+	PendingEmailExpiresAt sql.NullTime
+	IsActive              bool
+	WasActive             bool
+}
+
+// Returns no row for a missing or deleted user. was_active is the state before,
+// so a repeated call can be told apart from a change.
+func (q *Queries) SetUserActive(ctx context.Context, arg SetUserActiveParams) (SetUserActiveRow, error) {
+	row := q.db.QueryRowContext(ctx, setUserActive, arg.ID, arg.TenantID, arg.IsActive)
+	var i SetUserActiveRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.TenantID,
+		&i.EmailHash,
+		&i.PendingEmail,
+		&i.PendingEmailHash,
+		&i.EmailTokenHash,
+		&i.PendingEmailExpiresAt,
+		&i.IsActive,
+		&i.WasActive,
+	)
+	return i, err
+}
+
+const syncUserIDSequence = `-- name: SyncUserIDSequence :exec
+SELECT setval(pg_get_serial_sequence('users', 'id'), GREATEST((SELECT MAX(id) FROM users), nextval(pg_get_serial_sequence('users', 'id'))))
+`
+
+// Moves the ID sequence past IDs chosen by UpsertUser, so CreateUser never hands them out again
+func (q *Queries) SyncUserIDSequence(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, syncUserIDSequence)
+	return err
+}
+
+const upsertUser = `-- name: UpsertUser :one
+INSERT INTO users (id, tenant_id, name, email, email_hash)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE
+SET name = EXCLUDED.name
+WHERE users.tenant_id = EXCLUDED.tenant_id AND users.deleted_at IS NULL
+RETURNING id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active, (xmax = 0) AS inserted
+`
+
+type UpsertUserParams struct {
+	ID        int32
+	TenantID  string
+	Name      string
+	Email     string
+	EmailHash sql.NullString
+}
+
+type UpsertUserRow struct {
+	ID                    int32
+	Name                  string
+	Email                 string
+	CreatedAt             sql.NullTime
+	DeletedAt             sql.NullTime
+	TenantID              string
+	EmailHash             sql.NullString
+	PendingEmail          sql.NullString
+	PendingEmailHash      sql.NullString
+	EmailTokenHash        sql.NullString
+	PendingEmailExpiresAt sql.NullTime
+	IsActive              bool
+	Inserted              bool
+}
+
+// Returns no row when the ID belongs to another tenant or a deleted user, which
+// are never overwritten. inserted is true when the row was created. An existing
+// user keeps their email: changing it goes through SetPendingEmail.
+func (q *Queries) UpsertUser(ctx context.Context, arg UpsertUserParams) (UpsertUserRow, error) {
+	row := q.db.QueryRowContext(ctx, upsertUser,
+		arg.ID,
+		arg.TenantID,
+		arg.Name,
+		arg.Email,
+		arg.EmailHash,
+	)
+	var i UpsertUserRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.TenantID,
+		&i.EmailHash,
+		&i.PendingEmail,
+		&i.PendingEmailHash,
+		&i.EmailTokenHash,
+		&i.PendingEmailExpiresAt,
+		&i.IsActive,
+		&i.Inserted,
 	)
 	return i, err
 }
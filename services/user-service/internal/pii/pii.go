@@ -0,0 +1,231 @@
+// Package pii encrypts personal data before it is written to the database.
+//
+// Keys are handled by envelope encryption: fields are encrypted with a data key,
+// and only a wrapped (encrypted) copy of the data key is configured, which the
+// KMS unwraps at startup. Each field is sealed with AES-256-GCM under a fresh
+// random nonce. A field that must stay searchable, such as email, also gets a
+// blind index: an HMAC of the normalized value, which supports equality lookups
+// and unique constraints without revealing the value.
+package pii
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefix marks a value written by Encrypt; the version allows the format to change
+const prefix = "enc:v1:"
+
+// KeySize is the length of master and data keys in bytes (AES-256)
+const KeySize = 32
+
+// ErrDecrypt is returned when a stored value can't be decrypted, e.g. because it
+// was encrypted with another key or has been altered
+var ErrDecrypt = errors.New("could not decrypt field")
+
+// KMS unwraps data keys
+type KMS interface {
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// LocalKMS stands in for a key management service, wrapping data keys with a
+// master key held in the environment. A real KMS implements the same interface
+// and keeps the master key out of the process.
+type LocalKMS struct {
+	aead cipher.AEAD
+}
+
+// NewLocalKMS creates a LocalKMS from a KeySize-byte master key
+func NewLocalKMS(masterKey []byte) (*LocalKMS, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return &LocalKMS{aead: aead}, nil
+}
+
+// Wrap encrypts a data key under the master key
+func (k *LocalKMS) Wrap(dataKey []byte) ([]byte, error) {
+	return seal(k.aead, dataKey, []byte("data-key"))
+}
+
+// Unwrap decrypts a data key produced by Wrap
+func (k *LocalKMS) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	key, err := open(k.aead, wrapped, []byte("data-key"))
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap data key: %w", err)
+	}
+	return key, nil
+}
+
+// Cipher encrypts and decrypts fields with one data key
+type Cipher struct {
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+// NewCipher creates a Cipher from an unwrapped KeySize-byte data key. Separate
+// keys for encryption and for blind indexes are derived from it.
+func NewCipher(dataKey []byte) (*Cipher, error) {
+	if len(dataKey) != KeySize {
+		return nil, fmt.Errorf("data key must be %d bytes, got %d", KeySize, len(dataKey))
+	}
+	aead, err := newAEAD(derive(dataKey, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead, indexKey: derive(dataKey, "blind-index")}, nil
+}
+
+// Encrypt seals value for storage in field; the field name is authenticated, so a
+// ciphertext copied into another column won't decrypt
+func (c *Cipher) Encrypt(field, value string) (string, error) {
+	sealed, err := seal(c.aead, []byte(value), []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value written by Encrypt for field. Values without the
+// encryption prefix were written before encryption was introduced and are
+// returned as they are, so rows can be read while they are being migrated.
+func (c *Cipher) Decrypt(field, stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, prefix)
+	if !ok {
+		return stored, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	plain, err := open(c.aead, sealed, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return string(plain), nil
+}
+
+// BlindIndex returns the hex HMAC of an email address, normalized so addresses
+// that differ only in case or surrounding space share an index
+func (c *Cipher) BlindIndex(email string) string {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(NormalizeEmail(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NormalizeEmail lowercases and trims an email address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// IsEncrypted reports whether a stored value was written by Encrypt
+func IsEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, prefix)
+}
+
+// FromEnv builds the Cipher from the environment:
+//
+//	PII_MASTER_KEY  base64 master key for the local KMS
+//	PII_DATA_KEY    base64 data key wrapped by the master key
+//
+// Both are required; GenerateKeys creates a pair.
+func FromEnv(ctx context.Context) (*Cipher, error) {
+	masterKey, err := decodeEnv("PII_MASTER_KEY")
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := decodeEnv("PII_DATA_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	kms, err := NewLocalKMS(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := kms.Unwrap(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return NewCipher(dataKey)
+}
+
+// GenerateKeys returns a new base64 master key and a data key wrapped by it, for
+// PII_MASTER_KEY and PII_DATA_KEY
+func GenerateKeys() (masterKey, wrappedDataKey string, err error) {
+	master := make([]byte, KeySize)
+	data := make([]byte, KeySize)
+	if _, err := rand.Read(master); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(data); err != nil {
+		return "", "", err
+	}
+	kms, err := NewLocalKMS(master)
+	if err != nil {
+		return "", "", err
+	}
+	wrapped, err := kms.Wrap(data)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(master), base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+func decodeEnv(name string) ([]byte, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil, fmt.Errorf("%s not set", name)
+	}
+	b, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return b, nil
+}
+
+// derive returns a subkey of key for one purpose
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plain under a random nonce, returning nonce and ciphertext together
+func seal(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
@@ -0,0 +1,46 @@
+package user
+
+import (
+	"shared/auth"
+	"shared/oplog"
+)
+
+// RoleAdmin is the role allowed to use the user service's staff tools
+const RoleAdmin = "admin"
+
+// Access is the user service's authorization matrix, evaluated by auth.Authorize
+var Access = auth.Matrix{
+	"POST /users":             {RoleAdmin},
+	"DELETE /users/{id}":      {RoleAdmin},
+	"PUT /users/{id}":         auth.AnyPrincipal, // self or admin, and only admins create; see PutUser
+	"GET /users/export":       {RoleAdmin},
+	"POST /users/bulk-delete": {RoleAdmin},
+	"POST /users/bulk-update": {RoleAdmin},
+
+	"POST /users/{id}/deactivate": {RoleAdmin},
+	"POST /users/{id}/activate":   {RoleAdmin},
+
+	"POST /admin/impersonate/{userID}": {RoleAdmin},
+	"POST /users/{userID}/impersonate": {RoleAdmin},
+
+	"GET /admin/audit/export": {RoleAdmin},
+	"GET /admin/audit/verify": {RoleAdmin},
+}
+
+// personal is redacted from recorded user bodies, since emails are encrypted at rest
+var personal = oplog.Rule{Redact: []string{"email", "token"}}
+
+// Operations are the routes recorded in the operation log, when OPLOG_SINK is set
+var Operations = oplog.Routes{
+	"POST /users":                      personal,
+	"PUT /users/{id}":                  personal,
+	"DELETE /users/{id}":               personal,
+	"POST /users/bulk-delete":          personal,
+	"POST /users/bulk-update":          personal,
+	"POST /users/confirm-email":        personal,
+	"DELETE /users/{id}/pending-email": personal,
+	"POST /users/{id}/deactivate":      personal,
+	"POST /users/{id}/activate":        personal,
+	"POST /admin/impersonate/{userID}": personal,
+	"POST /users/{userID}/impersonate": personal,
+}
@@ -0,0 +1,73 @@
+package user
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"shared/auth"
+	"strings"
+	"testing"
+)
+
+// authorize runs a request through auth.Authorize with Access, against a mux
+// with pattern registered as the services register their routes
+func authorize(pattern, method, target string, principal *auth.Principal) int {
+	mux := http.NewServeMux()
+	mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	r := httptest.NewRequest(method, target, nil)
+	if principal != nil {
+		r.Header.Set(auth.HeaderUserID, principal.ID)
+		r.Header.Set(auth.HeaderRoles, strings.Join(principal.Roles, ","))
+	}
+	w := httptest.NewRecorder()
+	auth.Authorize(mux, Access, false)(mux).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestBulkDeleteRequiresAdmin(t *testing.T) {
+	customer := auth.Principal{ID: "7", Roles: []string{"customer"}}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		want      int
+	}{
+		{name: "anonymous", want: http.StatusUnauthorized},
+		{name: "customer", principal: &customer, want: http.StatusForbidden},
+		{name: "admin", principal: &admin, want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authorize("/users/bulk-delete", http.MethodPost, "/users/bulk-delete", tt.principal); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutatingAdminRoutesRequireAdmin(t *testing.T) {
+	customer := auth.Principal{ID: "7", Roles: []string{"customer"}}
+
+	routes := []struct{ method, pattern, target string }{
+		{http.MethodPost, "/users", "/users"},
+		{http.MethodDelete, "/users/{id}", "/users/42"},
+		{http.MethodPost, "/users/bulk-delete", "/users/bulk-delete"},
+		{http.MethodPost, "/users/bulk-update", "/users/bulk-update"},
+		{http.MethodPost, "/users/{id}/deactivate", "/users/42/deactivate"},
+		{http.MethodPost, "/users/{id}/activate", "/users/42/activate"},
+		{http.MethodPost, "/admin/impersonate/{userID}", "/admin/impersonate/42"},
+		{http.MethodPost, "/users/{userID}/impersonate", "/users/42/impersonate"},
+	}
+	for _, route := range routes {
+		t.Run(route.method+" "+route.pattern, func(t *testing.T) {
+			if got := authorize(route.pattern, route.method, route.target, &customer); got != http.StatusForbidden {
+				t.Errorf("customer got %d, want 403", got)
+			}
+			if got := authorize(route.pattern, route.method, route.target, &admin); got != http.StatusNoContent {
+				t.Errorf("admin got %d, want 204", got)
+			}
+		})
+	}
+}
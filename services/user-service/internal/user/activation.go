@@ -0,0 +1,141 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"shared/auth"
+	"shared/httpx"
+	"shared/tenant"
+	"strconv"
+	"user-service/internal/db/generated"
+)
+
+// Audit log actions recorded when an admin changes whether a user is active
+const (
+	AuditDeactivate = "user.deactivate"
+	AuditActivate   = "user.activate"
+)
+
+// Activation events, carrying the user as it is afterwards
+const (
+	EventUserDeactivated = "user.deactivated"
+	EventUserActivated   = "user.activated"
+)
+
+// CodeAccountDisabled is the error code of a request refused because the user
+// it acts as is deactivated
+const CodeAccountDisabled = "account_disabled"
+
+// ErrAccountDisabled is returned when acting as a deactivated user
+var ErrAccountDisabled = errors.New("account is deactivated")
+
+// DeactivateUser serves POST /users/{id}/deactivate. The user is kept and can
+// still be read, but can't sign in or be impersonated until activated again.
+// Only admins may call it (see Access).
+func (h *Handler) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	h.setActive(w, r, false)
+}
+
+// ActivateUser serves POST /users/{id}/activate, undoing DeactivateUser. Only
+// admins may call it (see Access).
+func (h *Handler) ActivateUser(w http.ResponseWriter, r *http.Request) {
+	h.setActive(w, r, true)
+}
+
+// setActive answers with the user either way; the audit entry and event are only
+// written when the user actually changed, so repeating a call is harmless
+func (h *Handler) setActive(w http.ResponseWriter, r *http.Request, active bool) {
+	idInt, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+
+	user, changed, err := h.repo.SetActive(r.Context(), auth.FromContext(r.Context()).ID, int32(idInt), active)
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if changed {
+		event := EventUserDeactivated
+		if active {
+			event = EventUserActivated
+		}
+		h.publish(r.Context(), event, NewUserResponse(user))
+	}
+
+	httpx.WriteJSON(w, r, http.StatusOK, userResponse(r, user))
+}
+
+// SetActive activates or deactivates a user, recording the change in the audit
+// log in the same transaction. It reports whether the user changed: one already
+// in that state is returned as it is, with no audit entry.
+func (r *Repository) SetActive(ctx context.Context, actorID string, id int32, active bool) (generated.User, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return generated.User{}, false, fmt.Errorf("could not update user %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	user, changed, err := r.changeActive(ctx, r.q.WithTx(tx), actorID, id, active)
+	if err != nil {
+		return generated.User{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return generated.User{}, false, fmt.Errorf("could not update user %d: %w", id, err)
+	}
+	return user, changed, nil
+}
+
+// changeActive is SetActive inside the caller's transaction, which bulk updates share
+func (r *Repository) changeActive(ctx context.Context, q *generated.Queries, actorID string, id int32, active bool) (generated.User, bool, error) {
+	row, err := q.SetUserActive(ctx, generated.SetUserActiveParams{
+		ID:       id,
+		TenantID: tenant.FromContext(ctx),
+		IsActive: active,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.User{}, false, ErrNotFound
+	}
+	if err != nil {
+		return generated.User{}, false, fmt.Errorf("could not update user %d: %w", id, err)
+	}
+
+	changed := row.WasActive != active
+	if changed {
+		action := AuditDeactivate
+		if active {
+			action = AuditActivate
+		}
+		if err := r.recordAudit(ctx, q, actorID, action, strconv.Itoa(int(id)), nil); err != nil {
+			return generated.User{}, false, err
+		}
+	}
+
+	user := generated.User{
+		ID:                    row.ID,
+		Name:                  row.Name,
+		Email:                 row.Email,
+		CreatedAt:             row.CreatedAt,
+		DeletedAt:             row.DeletedAt,
+		TenantID:              row.TenantID,
+		EmailHash:             row.EmailHash,
+		PendingEmail:          row.PendingEmail,
+		PendingEmailHash:      row.PendingEmailHash,
+		EmailTokenHash:        row.EmailTokenHash,
+		PendingEmailExpiresAt: row.PendingEmailExpiresAt,
+		IsActive:              row.IsActive,
+	}
+	if err := r.openUser(&user); err != nil {
+		return generated.User{}, false, err
+	}
+	return user, changed, nil
+}
@@ -0,0 +1,348 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"shared/tenant"
+	"strconv"
+	"time"
+	"user-service/internal/db/generated"
+)
+
+// Each tenant's audit log is a hash chain. An entry's hash is SHA-256 over the
+// previous entry's hash followed by the entry's canonical content, so editing or
+// removing an entry breaks every hash after it. The first entry has no previous
+// hash. Entries written before chaining started have no seq and aren't covered.
+
+// AuditSigningKeyFromEnv reads AUDIT_SIGNING_KEY, the HMAC key that signs audit
+// exports. It returns nil if unset, which disables GET /admin/audit/export.
+func AuditSigningKeyFromEnv() []byte {
+	if key := os.Getenv("AUDIT_SIGNING_KEY"); key != "" {
+		return []byte(key)
+	}
+	return nil
+}
+
+// auditContent is what an entry's hash covers. Its JSON encoding, with detail
+// re-encoded with sorted keys and no whitespace, is the canonical content.
+type auditContent struct {
+	Seq       int64           `json:"seq"`
+	TenantID  string          `json:"tenant_id"`
+	ActorID   string          `json:"actor_id"`
+	Action    string          `json:"action"`
+	TargetID  string          `json:"target_id"`
+	Detail    json.RawMessage `json:"detail"`
+	CreatedAt string          `json:"created_at"` // RFC3339 with microseconds, UTC
+}
+
+// canonicalAuditContent encodes the hashed fields of an entry. Postgres may
+// store detail differently from how it was sent, so it is normalized first.
+func canonicalAuditContent(e generated.AuditLog) ([]byte, error) {
+	var detail any
+	dec := json.NewDecoder(bytes.NewReader(e.Detail))
+	dec.UseNumber()
+	if err := dec.Decode(&detail); err != nil {
+		return nil, fmt.Errorf("could not decode audit detail: %w", err)
+	}
+	normalized, err := marshalCanonical(detail)
+	if err != nil {
+		return nil, err
+	}
+	return marshalCanonical(auditContent{
+		Seq:       e.Seq.Int64,
+		TenantID:  e.TenantID,
+		ActorID:   e.ActorID,
+		Action:    e.Action,
+		TargetID:  e.TargetID,
+		Detail:    normalized,
+		CreatedAt: e.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+	})
+}
+
+// marshalCanonical is json.Marshal without HTML escaping or the trailing newline
+func marshalCanonical(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("could not encode audit entry: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// auditHash chains content onto the hash of the entry before it
+func auditHash(prevHash, content []byte) []byte {
+	sum := sha256.New()
+	sum.Write(prevHash)
+	sum.Write(content)
+	return sum.Sum(nil)
+}
+
+// recordAudit appends an entry to the caller's tenant's chain through q, which
+// must be in a transaction: the chain head stays locked until it ends, so the
+// entry commits or rolls back together with the change it records.
+func (r *Repository) recordAudit(ctx context.Context, q *generated.Queries, actorID, action, targetID string, detail any) error {
+	body, err := json.Marshal(detail)
+	if err != nil {
+		return fmt.Errorf("could not encode audit detail: %w", err)
+	}
+
+	tenantID := tenant.FromContext(ctx)
+	head, err := q.LockAuditHead(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("could not lock audit chain: %w", err)
+	}
+
+	entry := generated.AuditLog{
+		TenantID: tenantID,
+		ActorID:  actorID,
+		Action:   action,
+		TargetID: targetID,
+		Detail:   body,
+		// Postgres keeps microseconds; the hash must cover what is stored
+		CreatedAt: r.clock.Now().UTC().Truncate(time.Microsecond),
+		Seq:       sql.NullInt64{Int64: head.Seq + 1, Valid: true},
+		PrevHash:  head.Hash,
+	}
+	content, err := canonicalAuditContent(entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = auditHash(entry.PrevHash, content)
+
+	err = q.RecordAudit(ctx, generated.RecordAuditParams{
+		TenantID:  entry.TenantID,
+		ActorID:   entry.ActorID,
+		Action:    entry.Action,
+		TargetID:  entry.TargetID,
+		Detail:    entry.Detail,
+		CreatedAt: entry.CreatedAt,
+		Seq:       entry.Seq,
+		PrevHash:  entry.PrevHash,
+		Hash:      entry.Hash,
+	})
+	if err != nil {
+		return fmt.Errorf("could not record audit entry: %w", err)
+	}
+	err = q.AdvanceAuditHead(ctx, generated.AdvanceAuditHeadParams{TenantID: tenantID, Seq: entry.Seq.Int64, Hash: entry.Hash})
+	if err != nil {
+		return fmt.Errorf("could not record audit entry: %w", err)
+	}
+	return nil
+}
+
+// auditChain lists a tenant's chained audit entries; rows are read one at a time by WalkAuditChain
+const auditChain = `SELECT id, tenant_id, actor_id, action, target_id, detail, created_at, seq, prev_hash, hash FROM audit_log
+WHERE tenant_id = $1 AND seq IS NOT NULL
+ORDER BY seq`
+
+// WalkAuditChain calls fn for every chained audit entry in the caller's tenant in
+// seq order, reading rows as they arrive. Everything is read from one snapshot, so
+// the head and the count of unchained entries it returns match the entries seen.
+// It stops at the first error from fn and returns it unwrapped.
+func (r *Repository) WalkAuditChain(ctx context.Context, fn func(generated.AuditLog) error) (generated.AuditChainHead, int64, error) {
+	tenantID := tenant.FromContext(ctx)
+	head := generated.AuditChainHead{TenantID: tenantID}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return head, 0, fmt.Errorf("could not read audit log: %w", err)
+	}
+	defer tx.Rollback()
+	q := r.q.WithTx(tx)
+
+	head, err = q.GetAuditHead(ctx, tenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		head, err = generated.AuditChainHead{TenantID: tenantID}, nil
+	}
+	if err != nil {
+		return head, 0, fmt.Errorf("could not read audit chain head: %w", err)
+	}
+	unchained, err := q.CountUnchainedAudit(ctx, tenantID)
+	if err != nil {
+		return head, 0, fmt.Errorf("could not read audit log: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, auditChain, tenantID)
+	if err != nil {
+		return head, unchained, fmt.Errorf("could not read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e generated.AuditLog
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.ActorID, &e.Action, &e.TargetID, &e.Detail, &e.CreatedAt,
+			&e.Seq, &e.PrevHash, &e.Hash); err != nil {
+			return head, unchained, fmt.Errorf("could not read audit log: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return head, unchained, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return head, unchained, fmt.Errorf("could not read audit log: %w", err)
+	}
+	return head, unchained, nil
+}
+
+// AuditEntry is one entry of GET /admin/audit/export. A verifier recomputes
+// hash as SHA-256 over the bytes of prev_hash followed by content, and checks
+// that prev_hash is the hash of the entry before.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Seq       int64           `json:"seq"`
+	ActorID   string          `json:"actor_id"`
+	Action    string          `json:"action"`
+	TargetID  string          `json:"target_id"`
+	Detail    json.RawMessage `json:"detail"`
+	CreatedAt string          `json:"created_at"` // RFC3339, UTC
+	Content   string          `json:"content"`    // the canonical content the hash covers
+	PrevHash  string          `json:"prev_hash"`  // hex; empty for the first entry
+	Hash      string          `json:"hash"`       // hex
+}
+
+// AuditExportSignature ends GET /admin/audit/export. Signature is the hex
+// HMAC-SHA256, keyed with AUDIT_SIGNING_KEY, of "tenant_id\nhead_seq\nhead_hash",
+// so a verifier holding the key can tell the export is complete and ours.
+type AuditExportSignature struct {
+	TenantID  string `json:"tenant_id"`
+	Entries   int64  `json:"entries"`
+	HeadSeq   int64  `json:"head_seq"`
+	HeadHash  string `json:"head_hash"` // hex hash of the last entry exported
+	Signature string `json:"signature"`
+}
+
+// signAuditExport signs the last entry of an export
+func signAuditExport(key []byte, tenantID string, headSeq int64, headHash string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%s", tenantID, headSeq, headHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ExportAudit streams the caller's tenant's audit chain in seq order, ending with
+// a signature over its last hash. Only admins may call it (see Access).
+func (h *Handler) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	if h.auditKey == nil {
+		httpx.Error(w, http.StatusNotFound, "audit export is not enabled; set AUDIT_SIGNING_KEY")
+		return
+	}
+
+	out := httpx.NewListWriter(w)
+	var entries, headSeq int64
+	var headHash string
+	_, _, err := h.repo.WalkAuditChain(r.Context(), func(e generated.AuditLog) error {
+		content, err := canonicalAuditContent(e)
+		if err != nil {
+			return err
+		}
+		entries++
+		headSeq, headHash = e.Seq.Int64, hex.EncodeToString(e.Hash)
+		return out.Write(AuditEntry{
+			ID:        e.ID,
+			Seq:       e.Seq.Int64,
+			ActorID:   e.ActorID,
+			Action:    e.Action,
+			TargetID:  e.TargetID,
+			Detail:    e.Detail,
+			CreatedAt: e.CreatedAt.UTC().Format(time.RFC3339Nano),
+			Content:   string(content),
+			PrevHash:  hex.EncodeToString(e.PrevHash),
+			Hash:      headHash,
+		})
+	})
+	if err != nil {
+		// A partial export is never signed
+		out.Close(true, err)
+		return
+	}
+
+	tenantID := tenant.FromContext(r.Context())
+	out.CloseWith(false, nil, AuditExportSignature{
+		TenantID:  tenantID,
+		Entries:   entries,
+		HeadSeq:   headSeq,
+		HeadHash:  headHash,
+		Signature: signAuditExport(h.auditKey, tenantID, headSeq, headHash),
+	})
+}
+
+// AuditVerification is the response of GET /admin/audit/verify
+type AuditVerification struct {
+	Valid     bool          `json:"valid"`
+	Entries   int64         `json:"entries"`   // chained entries checked before any problem
+	Unchained int64         `json:"unchained"` // written before hash chaining started; not covered
+	HeadSeq   int64         `json:"head_seq"`
+	HeadHash  string        `json:"head_hash"` // hex
+	Problem   *AuditProblem `json:"problem,omitempty"`
+}
+
+// AuditProblem is the first inconsistency found in an audit chain
+type AuditProblem struct {
+	Seq    int64  `json:"seq"`
+	ID     int64  `json:"id,omitempty"` // the entry at fault, if it still exists
+	Reason string `json:"reason"`
+}
+
+// errAuditBroken stops a walk at the first problem found
+var errAuditBroken = errors.New("audit chain is broken")
+
+// VerifyAudit walks the caller's tenant's audit chain, recomputing every hash, and
+// reports the first inconsistency: a gap in seq, a prev_hash that doesn't match
+// the entry before, content that doesn't match its hash, or a chain head that
+// isn't the last entry. Only admins may call it (see Access).
+func (h *Handler) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	var result AuditVerification
+	var prevHash []byte
+	var lastSeq int64
+
+	head, unchained, err := h.repo.WalkAuditChain(r.Context(), func(e generated.AuditLog) error {
+		problem := func(reason string) error {
+			result.Problem = &AuditProblem{Seq: e.Seq.Int64, ID: e.ID, Reason: reason}
+			return errAuditBroken
+		}
+		switch {
+		case e.Seq.Int64 != lastSeq+1:
+			result.Problem = &AuditProblem{Seq: lastSeq + 1, Reason: "entry is missing; the next one has seq " + strconv.FormatInt(e.Seq.Int64, 10)}
+			return errAuditBroken
+		case !bytes.Equal(e.PrevHash, prevHash):
+			return problem("prev_hash does not match the hash of the entry before")
+		}
+		content, err := canonicalAuditContent(e)
+		if err != nil {
+			return problem(err.Error())
+		}
+		if !bytes.Equal(auditHash(e.PrevHash, content), e.Hash) {
+			return problem("content does not match its hash; the entry was changed")
+		}
+		result.Entries++
+		prevHash, lastSeq = e.Hash, e.Seq.Int64
+		return nil
+	})
+	if err != nil && !errors.Is(err, errAuditBroken) {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result.Unchained = unchained
+	result.HeadSeq, result.HeadHash = head.Seq, hex.EncodeToString(head.Hash)
+	if result.Problem == nil {
+		switch {
+		case head.Seq != lastSeq:
+			result.Problem = &AuditProblem{Seq: lastSeq + 1, Reason: fmt.Sprintf("chain head is at seq %d but the last entry is %d", head.Seq, lastSeq)}
+		case !bytes.Equal(head.Hash, prevHash):
+			result.Problem = &AuditProblem{Seq: lastSeq, Reason: "chain head hash does not match the last entry"}
+		}
+	}
+	result.Valid = result.Problem == nil
+	httpx.WriteJSON(w, r, http.StatusOK, result)
+}
@@ -0,0 +1,84 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"shared/auth"
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var admin = auth.Principal{ID: "staff-1", Roles: []string{RoleAdmin}}
+
+func TestDeleteUsersReturnsOnlyExistingIDs(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE users SET deleted_at")).
+		WithArgs(sqlmock.AnyArg(), "{1,2,3,4}", testTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(4))
+	mock.ExpectCommit()
+
+	deleted, err := repo.DeleteUsers(tenantContext(admin), []int32{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(deleted, []int32{2, 4}) {
+		t.Errorf("deleted = %v, want [2 4]", deleted)
+	}
+}
+
+func TestBulkDeleteUsersCountsOnlyExistingUsers(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		ids      string
+		existing []int32
+		want     int
+	}{
+		{name: "mixed", body: `{"ids":[1,2,3]}`, ids: "{1,2,3}", existing: []int32{1, 3}, want: 2},
+		{name: "none exist", body: `{"ids":[8,9]}`, ids: "{8,9}", want: 0},
+		{name: "duplicates counted once", body: `{"ids":[5,5,6]}`, ids: "{5,6}", existing: []int32{5}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newMockHandler(t)
+
+			rows := sqlmock.NewRows([]string{"id"})
+			for _, id := range tt.existing {
+				rows.AddRow(id)
+			}
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("UPDATE users SET deleted_at")).
+				WithArgs(sqlmock.AnyArg(), tt.ids, testTenant).
+				WillReturnRows(rows)
+			mock.ExpectCommit()
+
+			w := serve(h.BulkDeleteUsers, admin, http.MethodPost, "/users/bulk-delete", tt.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			var got struct {
+				Deleted int `json:"deleted"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Deleted != tt.want {
+				t.Errorf("deleted = %d, want %d", got.Deleted, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkDeleteUsersRejectsEmptyIDs(t *testing.T) {
+	h, _ := newMockHandler(t)
+
+	w := serve(h.BulkDeleteUsers, admin, http.MethodPost, "/users/bulk-delete", `{"ids":[]}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422", w.Code)
+	}
+}
@@ -0,0 +1,141 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"shared/api"
+	"shared/auth"
+	"shared/httpx"
+)
+
+// AuditBulkUpdate is the audit log action recorded for each user changed by a bulk update
+const AuditBulkUpdate = "user.bulk_update"
+
+// BulkUpdateUsers applies a JSON array of patches, [{"id":1,"name":"..."},...], in
+// one transaction and answers 207 with the outcome of each. A patch that fails is
+// left out and the rest still apply; with ?atomic=true any failure rolls back the
+// whole batch. "active" activates or deactivates a user as ActivateUser and
+// DeactivateUser do, with the same audit entries and events. Roles can't be
+// patched: they aren't stored here but come from the identity provider's tokens.
+// Only admins may call it (see Access).
+func (h *Handler) BulkUpdateUsers(w http.ResponseWriter, r *http.Request) {
+	atomic := r.URL.Query().Get("atomic") == "true"
+
+	var input []struct {
+		ID     *int32  `json:"id"`
+		Name   *string `json:"name"`
+		Email  *string `json:"email"`
+		Active *bool   `json:"active"`
+	}
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+	if len(input) == 0 {
+		httpx.Error(w, http.StatusUnprocessableEntity, "at least one patch is required")
+		return
+	}
+	if len(input) > h.maxBatchSize {
+		httpx.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d patches can be given at once", h.maxBatchSize))
+		return
+	}
+
+	results := make([]api.UserPatchResult, len(input))
+	patches := make([]UserPatch, 0, len(input))
+	applied := make([]int, 0, len(input)) // index in results of each patch
+	for i, item := range input {
+		if item.Email != nil {
+			*item.Email = h.emailNorm.Normalize(*item.Email)
+		}
+
+		var v httpx.Validation
+		switch {
+		case item.ID == nil:
+			v.Add("id", "is required")
+		case *item.ID < 1:
+			v.Add("id", "must be positive")
+		}
+		if item.Name == nil && item.Email == nil && item.Active == nil {
+			v.Add("name", "one of name, email and active is required")
+		}
+		if item.Name != nil {
+			v.Required("name", item.Name)
+			v.Name("name", item.Name, h.nameMaxLen)
+		}
+		if item.Email != nil {
+			v.Required("email", item.Email)
+		}
+
+		if item.ID != nil {
+			results[i].ID = *item.ID
+		}
+		if !v.Valid() {
+			results[i].Status = api.PatchValidationFailed
+			results[i].Error = "validation failed"
+			results[i].Fields = v.Errors()
+			continue
+		}
+		patches = append(patches, UserPatch{ID: *item.ID, Name: item.Name, Email: item.Email, Active: item.Active})
+		applied = append(applied, i)
+	}
+
+	// An atomic batch with an invalid patch fails before touching the database
+	if atomic && len(patches) < len(input) {
+		markSkipped(results)
+		writeBulkUpdateResult(w, r, api.BulkUpdateResult{Committed: false, Results: results})
+		return
+	}
+
+	outcomes, committed, err := h.repo.PatchUsers(r.Context(), auth.FromContext(r.Context()).ID, patches, atomic)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for n, outcome := range outcomes {
+		result := &results[applied[n]]
+		switch {
+		case outcome.Err == nil && !committed:
+			result.Status = api.PatchRolledBack
+		case outcome.Err == nil:
+			user := NewUserResponse(outcome.User)
+			result.Status = api.PatchUpdated
+			result.User = &user
+		case errors.Is(outcome.Err, ErrNotFound):
+			result.Status = api.PatchNotFound
+			result.Error = outcome.Err.Error()
+		case errors.Is(outcome.Err, ErrDuplicateEmail):
+			result.Status = api.PatchConflict
+			result.Error = outcome.Err.Error()
+		}
+	}
+	markSkipped(results)
+
+	if committed {
+		for _, outcome := range outcomes {
+			if outcome.Err != nil || !outcome.ActiveChanged {
+				continue
+			}
+			event := EventUserDeactivated
+			if outcome.User.IsActive {
+				event = EventUserActivated
+			}
+			h.publish(r.Context(), event, NewUserResponse(outcome.User))
+		}
+	}
+	writeBulkUpdateResult(w, r, api.BulkUpdateResult{Committed: committed, Results: results})
+}
+
+// markSkipped gives the results without an outcome, those after an atomic batch failed, the status skipped
+func markSkipped(results []api.UserPatchResult) {
+	for i := range results {
+		if results[i].Status == "" {
+			results[i].Status = api.PatchSkipped
+		}
+	}
+}
+
+func writeBulkUpdateResult(w http.ResponseWriter, r *http.Request, result api.BulkUpdateResult) {
+	httpx.WriteJSON(w, r, http.StatusMultiStatus, result)
+}
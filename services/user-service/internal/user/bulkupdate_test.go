@@ -0,0 +1,142 @@
+package user
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"shared/api"
+	"shared/events"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// activeRow is the row SetUserActive returns for user id in testTenant
+func activeRow(id int32, active, wasActive bool) *sqlmock.Rows {
+	return sqlmock.NewRows(append(append([]string(nil), userColumns...), "was_active")).
+		AddRow(id, "User", "user@example.com", nil, nil, testTenant, nil, nil, nil, nil, nil, active, wasActive)
+}
+
+// expectPatch expects PatchUser to set the name of user id
+func expectPatch(mock sqlmock.Sqlmock, id int32, name string) *sqlmock.ExpectedQuery {
+	return mock.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET name")).
+		WithArgs(name, nil, nil, id, testTenant)
+}
+
+func bulkUpdate(t *testing.T, h *Handler, target, body string) api.BulkUpdateResult {
+	t.Helper()
+	w := serve(h.BulkUpdateUsers, admin, http.MethodPost, target, body)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207: %s", w.Code, w.Body)
+	}
+	var result api.BulkUpdateResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func checkStatuses(t *testing.T, result api.BulkUpdateResult, want ...string) {
+	t.Helper()
+	if len(result.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(result.Results), len(want))
+	}
+	for i, r := range result.Results {
+		if r.Status != want[i] {
+			t.Errorf("results[%d] (id %d) status = %q, want %q", i, r.ID, r.Status, want[i])
+		}
+	}
+}
+
+func TestBulkUpdateUsersReportsEachOutcome(t *testing.T) {
+	published := events.NewMemory()
+	h, mock := newMockHandler(t, WithPublisher(published))
+
+	mock.ExpectBegin()
+
+	mock.ExpectExec("SAVEPOINT patch").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectPatch(mock, 1, "Ann").WillReturnRows(userRows(1))
+	expectAudit(mock, admin.ID, AuditBulkUpdate, "1", 0)
+	mock.ExpectExec("RELEASE SAVEPOINT patch").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectExec("SAVEPOINT patch").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectPatch(mock, 2, "Bob").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT patch").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectExec("SAVEPOINT patch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE users u SET is_active")).
+		WithArgs(4, testTenant, false).
+		WillReturnRows(activeRow(4, false, true))
+	expectAudit(mock, admin.ID, AuditDeactivate, "4", 1)
+	mock.ExpectExec("RELEASE SAVEPOINT patch").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectExec("SAVEPOINT patch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT patch").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectCommit()
+
+	result := bulkUpdate(t, h, "/users/bulk-update", `[
+		{"id":1,"name":"Ann"},
+		{"id":2,"name":"Bob"},
+		{"id":3},
+		{"id":4,"active":false},
+		{"id":5,"email":"taken@example.com"}
+	]`)
+	if !result.Committed {
+		t.Error("committed = false, want true")
+	}
+	checkStatuses(t, result, api.PatchUpdated, api.PatchNotFound, api.PatchValidationFailed, api.PatchUpdated, api.PatchConflict)
+	if u := result.Results[3].User; u == nil || u.Active {
+		t.Errorf("results[3].user = %+v, want the deactivated user", u)
+	}
+
+	// Only the deactivation has an event, as DeactivateUser would publish
+	got := published.Events()
+	if len(got) != 1 || got[0].Type != EventUserDeactivated {
+		t.Errorf("events = %+v, want one %s", got, EventUserDeactivated)
+	}
+}
+
+func TestBulkUpdateUsersAtomicRollsBack(t *testing.T) {
+	published := events.NewMemory()
+	h, mock := newMockHandler(t, WithPublisher(published))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE users u SET is_active")).
+		WithArgs(1, testTenant, false).
+		WillReturnRows(activeRow(1, false, true))
+	expectAudit(mock, admin.ID, AuditDeactivate, "1", 0)
+	expectPatch(mock, 2, "Bob").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	result := bulkUpdate(t, h, "/users/bulk-update?atomic=true", `[
+		{"id":1,"active":false},
+		{"id":2,"name":"Bob"},
+		{"id":3,"name":"Cy"}
+	]`)
+	if result.Committed {
+		t.Error("committed = true, want false")
+	}
+	checkStatuses(t, result, api.PatchRolledBack, api.PatchNotFound, api.PatchSkipped)
+	if got := published.Events(); len(got) != 0 {
+		t.Errorf("events = %+v, want none for a rolled back batch", got)
+	}
+}
+
+func TestBulkUpdateUsersAtomicRejectsInvalidBatchUpfront(t *testing.T) {
+	// No query is expected: the invalid patch fails the batch before the database
+	h, _ := newMockHandler(t)
+
+	result := bulkUpdate(t, h, "/users/bulk-update?atomic=true", `[{"id":1,"name":"Ann"},{"id":2}]`)
+	if result.Committed {
+		t.Error("committed = true, want false")
+	}
+	checkStatuses(t, result, api.PatchSkipped, api.PatchValidationFailed)
+	if fields := result.Results[1].Fields; len(fields) != 1 || fields[0].Field != "name" {
+		t.Errorf("fields = %+v, want an error for name", fields)
+	}
+}
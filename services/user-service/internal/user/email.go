@@ -0,0 +1,54 @@
+package user
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EmailNormalization is how emails are rewritten before they are validated and stored
+type EmailNormalization string
+
+const (
+	// EmailNormalizeOff stores emails exactly as given
+	EmailNormalizeOff EmailNormalization = "off"
+	// EmailNormalizeDomain trims surrounding space and lowercases the domain, which
+	// is case-insensitive; the local part is kept as given
+	EmailNormalizeDomain EmailNormalization = "domain"
+	// EmailNormalizeFull also lowercases the local part. Mail servers may treat it
+	// as case-sensitive, but in practice almost none do.
+	EmailNormalizeFull EmailNormalization = "full"
+)
+
+// EmailNormalizationFromEnv reads EMAIL_NORMALIZE (off, domain or full), default domain.
+// Whichever is chosen, uniqueness ignores case: the email blind index is always
+// taken of the fully lowercased address.
+func EmailNormalizationFromEnv() (EmailNormalization, error) {
+	raw := os.Getenv("EMAIL_NORMALIZE")
+	if raw == "" {
+		return EmailNormalizeDomain, nil
+	}
+	switch n := EmailNormalization(strings.ToLower(raw)); n {
+	case EmailNormalizeOff, EmailNormalizeDomain, EmailNormalizeFull:
+		return n, nil
+	}
+	return "", fmt.Errorf("invalid EMAIL_NORMALIZE %q (want off, domain or full)", raw)
+}
+
+// Normalize rewrites an email address according to n, e.g. " John@Example.COM "
+// becomes "John@example.com" with EmailNormalizeDomain
+func (n EmailNormalization) Normalize(email string) string {
+	if n == EmailNormalizeOff {
+		return email
+	}
+	email = strings.TrimSpace(email)
+	if n == EmailNormalizeFull {
+		return strings.ToLower(email)
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	return email[:at] + strings.ToLower(email[at:])
+}
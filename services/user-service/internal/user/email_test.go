@@ -0,0 +1,117 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEmailNormalize(t *testing.T) {
+	tests := []struct {
+		mode    EmailNormalization
+		in, out string
+	}{
+		{EmailNormalizeDomain, "John@Example.COM", "John@example.com"},
+		{EmailNormalizeDomain, "  john@example.com\t\n", "john@example.com"},
+		{EmailNormalizeDomain, " JOHN@EXAMPLE.COM ", "JOHN@example.com"},
+		// Only the part after the last @ is the domain
+		{EmailNormalizeDomain, `"A@B"@Example.COM`, `"A@B"@example.com`},
+		{EmailNormalizeDomain, " NoAtSign ", "NoAtSign"},
+		{EmailNormalizeDomain, "   ", ""},
+		{EmailNormalizeFull, " John@Example.COM ", "john@example.com"},
+		{EmailNormalizeFull, "ÉLODIE@Example.com", "élodie@example.com"},
+		{EmailNormalizeOff, " John@Example.COM ", " John@Example.COM "},
+	}
+	for _, tt := range tests {
+		if got := tt.mode.Normalize(tt.in); got != tt.out {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", tt.mode, tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestEmailNormalizationFromEnv(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    EmailNormalization
+		wantErr bool
+	}{
+		{env: "", want: EmailNormalizeDomain},
+		{env: "off", want: EmailNormalizeOff},
+		{env: "domain", want: EmailNormalizeDomain},
+		{env: "FULL", want: EmailNormalizeFull},
+		{env: "lower", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("EMAIL_NORMALIZE", tt.env)
+		got, err := EmailNormalizationFromEnv()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("EMAIL_NORMALIZE=%q: got %q, %v; want %q, error %v", tt.env, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCaseVariantsShareBlindIndex(t *testing.T) {
+	// Whatever EMAIL_NORMALIZE keeps of the local part, uniqueness ignores case
+	repo, _ := newMockRepository(t)
+	want := repo.cipher.BlindIndex("john@example.com")
+	for _, email := range []string{"John@example.com", "JOHN@EXAMPLE.COM", " john@Example.com "} {
+		if got := repo.cipher.BlindIndex(email); got != want {
+			t.Errorf("BlindIndex(%q) differs from john@example.com's", email)
+		}
+	}
+}
+
+func TestCreateUserNormalizesEmail(t *testing.T) {
+	tests := []struct {
+		mode   EmailNormalization
+		stored string
+	}{
+		{EmailNormalizeDomain, "John@example.com"},
+		{EmailNormalizeFull, "john@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			h, mock := newMockHandler(t, WithEmailNormalization(tt.mode))
+			hash := h.repo.cipher.BlindIndex("john@example.com")
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock")).
+				WithArgs(testTenant, hash).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+				WithArgs(testTenant, 0, hash, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (tenant_id, name, email, email_hash)")).
+				WithArgs(testTenant, "John", sqlmock.AnyArg(), hash).
+				WillReturnRows(sqlmock.NewRows(userColumns).
+					AddRow(1, "John", tt.stored, nil, nil, testTenant, hash, nil, nil, nil, nil, true))
+			mock.ExpectCommit()
+
+			w := serve(h.CreateUser, admin, http.MethodPost, "/users", `{"name":"John","email":"  John@Example.COM "}`)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want 201: %s", w.Code, w.Body)
+			}
+			var got UserResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Email != tt.stored {
+				t.Errorf("email = %q, want %q", got.Email, tt.stored)
+			}
+		})
+	}
+}
+
+func TestCreateUserRefusesWhitespaceEmail(t *testing.T) {
+	// No query is expected: the trimmed email is empty
+	h, _ := newMockHandler(t)
+
+	w := serve(h.CreateUser, admin, http.MethodPost, "/users", `{"name":"John","email":" \t "}`)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "must not be empty") {
+		t.Errorf("got %d %s, want 422 must not be empty", w.Code, w.Body)
+	}
+}
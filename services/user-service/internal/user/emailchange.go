@@ -0,0 +1,272 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"shared/auth"
+	"shared/events"
+	"shared/httpx"
+	"shared/tenant"
+	"strconv"
+	"time"
+	"user-service/internal/db/generated"
+)
+
+// PendingEmailTTL is how long a requested email change can be confirmed
+const PendingEmailTTL = 24 * time.Hour
+
+// Email change events, for a mailer to act on
+const (
+	// EventEmailChangeRequested carries the confirmation token to the new address
+	EventEmailChangeRequested = "user.email_change_requested"
+	// EventEmailChangeNotice warns the current address that a change was requested
+	EventEmailChangeNotice = "user.email_change_notice"
+)
+
+// ErrInvalidEmailToken is returned when a confirmation token matches no pending
+// change in the caller's tenant, or the change has expired
+var ErrInvalidEmailToken = errors.New("confirmation token is invalid or has expired")
+
+// ErrNoPendingEmail is returned when cancelling an email change that doesn't exist
+var ErrNoPendingEmail = errors.New("user has no pending email change")
+
+// EmailChange is an email change waiting to be confirmed from the new address
+type EmailChange struct {
+	UserID    int32
+	OldEmail  string
+	NewEmail  string
+	Token     string // sent to NewEmail; only its hash is stored
+	ExpiresAt time.Time
+}
+
+// EmailChangeRequested is the data of a user.email_change_requested event
+type EmailChangeRequested struct {
+	UserID    int32  `json:"user_id"`
+	Email     string `json:"email"` // the new address, where the token is to be sent
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"` // RFC3339, UTC
+}
+
+// EmailChangeNotice is the data of a user.email_change_notice event
+type EmailChangeNotice struct {
+	UserID    int32  `json:"user_id"`
+	Email     string `json:"email"` // the current address, which is told a change was requested
+	ExpiresAt string `json:"expires_at"`
+}
+
+// claimEmail locks an email's blind index for the rest of the transaction and
+// fails with ErrDuplicateEmail if a user other than userID has the email, either
+// as their own or as a change they may still confirm. The unique index only
+// covers current emails; without this a new user could take an address that
+// someone else is about to confirm.
+func (r *Repository) claimEmail(ctx context.Context, q *generated.Queries, userID int32, hash sql.NullString) error {
+	tenantID := tenant.FromContext(ctx)
+	if err := q.LockEmail(ctx, generated.LockEmailParams{TenantID: tenantID, EmailHash: hash.String}); err != nil {
+		return fmt.Errorf("could not lock email: %w", err)
+	}
+	claimed, err := q.EmailClaimed(ctx, generated.EmailClaimedParams{
+		TenantID:  tenantID,
+		ID:        userID,
+		EmailHash: hash,
+		Now:       sql.NullTime{Time: r.clock.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("could not check email: %w", err)
+	}
+	if claimed {
+		return ErrDuplicateEmail
+	}
+	return nil
+}
+
+// setPendingEmail stores email as u's pending email with a new confirmation
+// token, replacing any earlier pending change, and updates u to match
+func (r *Repository) setPendingEmail(ctx context.Context, q *generated.Queries, u *generated.User, email, sealed string, hash sql.NullString) (*EmailChange, error) {
+	token := r.ids.NewToken()
+	expiresAt := r.clock.Now().UTC().Add(PendingEmailTTL)
+	params := generated.SetPendingEmailParams{
+		PendingEmail:          sql.NullString{String: sealed, Valid: true},
+		PendingEmailHash:      hash,
+		EmailTokenHash:        hashEmailToken(token),
+		PendingEmailExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+		ID:                    u.ID,
+		TenantID:              u.TenantID,
+	}
+	if _, err := q.SetPendingEmail(ctx, params); err != nil {
+		return nil, fmt.Errorf("could not save pending email: %w", err)
+	}
+
+	u.PendingEmail = sql.NullString{String: email, Valid: true}
+	u.PendingEmailHash = params.PendingEmailHash
+	u.EmailTokenHash = params.EmailTokenHash
+	u.PendingEmailExpiresAt = params.PendingEmailExpiresAt
+	return &EmailChange{UserID: u.ID, OldEmail: u.Email, NewEmail: email, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// hashEmailToken is what is stored of a confirmation token, so a database leak
+// doesn't hand out working links
+func hashEmailToken(token string) sql.NullString {
+	sum := sha256.Sum256([]byte(token))
+	return sql.NullString{String: hex.EncodeToString(sum[:]), Valid: true}
+}
+
+// ConfirmEmail applies the pending email change that token confirms
+func (r *Repository) ConfirmEmail(ctx context.Context, token string) (generated.User, error) {
+	user, err := r.q.ConfirmEmail(ctx, generated.ConfirmEmailParams{
+		TenantID:       tenant.FromContext(ctx),
+		EmailTokenHash: hashEmailToken(token),
+		Now:            sql.NullTime{Time: r.clock.Now().UTC(), Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.User{}, ErrInvalidEmailToken
+	}
+	// Only possible for an address taken before pending changes were claimed
+	if isDuplicateEmail(err) {
+		return generated.User{}, ErrDuplicateEmail
+	}
+	if err != nil {
+		return generated.User{}, fmt.Errorf("could not confirm email: %w", err)
+	}
+	if err := r.openUser(&user); err != nil {
+		return generated.User{}, err
+	}
+	return user, nil
+}
+
+// CancelEmailChange drops a user's pending email change, so its token stops working
+func (r *Repository) CancelEmailChange(ctx context.Context, id int32) error {
+	cancelled, err := r.q.CancelPendingEmail(ctx, generated.CancelPendingEmailParams{ID: id, TenantID: tenant.FromContext(ctx)})
+	if err != nil {
+		return fmt.Errorf("could not cancel email change: %w", err)
+	}
+	if cancelled == 0 {
+		return ErrNoPendingEmail
+	}
+	return nil
+}
+
+// ConfirmEmail applies a pending email change. The token from the message sent
+// to the new address is the only credential needed, so the link works from any
+// browser: GET takes it as ?token=, POST as {"token":"..."}.
+func (h *Handler) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var input struct {
+			Token *string `json:"token"`
+		}
+		if err := httpx.DecodeJSON(w, r, &input); err != nil {
+			httpx.Error(w, httpx.StatusCode(err), err.Error())
+			return
+		}
+		if input.Token != nil {
+			token = *input.Token
+		}
+	}
+	var v httpx.Validation
+	v.Required("token", &token)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	user, err := h.repo.ConfirmEmail(r.Context(), token)
+	if errors.Is(err, ErrInvalidEmailToken) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, ErrDuplicateEmail) {
+		httpx.ErrorCode(w, http.StatusConflict, CodeEmailTaken, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("User %d confirmed their new email", user.ID)
+
+	httpx.WriteJSON(w, r, http.StatusOK, userResponse(r, user))
+}
+
+// CancelEmailChange drops the pending email change of the user in the path. Only
+// the user themselves and admins may cancel it.
+func (h *Handler) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+	if !selfOrAdmin(auth.FromContext(r.Context()), int32(id)) {
+		httpx.Error(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	err = h.repo.CancelEmailChange(r.Context(), int32(id))
+	if errors.Is(err, ErrNoPendingEmail) {
+		httpx.DeleteNotFound(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// selfOrAdmin reports whether p is the user with the given ID or an admin
+func selfOrAdmin(p auth.Principal, id int32) bool {
+	return p.HasRole(RoleAdmin) || (p.Authenticated() && p.ID == strconv.Itoa(int(id)))
+}
+
+// userResponse is NewUserResponse plus the pending email change, which only the
+// user themselves and admins may see
+func userResponse(r *http.Request, u generated.User) UserResponse {
+	resp := NewUserResponse(u)
+	if u.PendingEmail.Valid && selfOrAdmin(auth.FromContext(r.Context()), u.ID) {
+		pending := u.PendingEmail.String
+		resp.PendingEmail = &pending
+		resp.PendingEmailExpiresAt = nullableTime(u.PendingEmailExpiresAt)
+	}
+	return resp
+}
+
+// userResponses maps a list of user rows with userResponse
+func userResponses(r *http.Request, users []generated.User) []UserResponse {
+	out := make([]UserResponse, len(users))
+	for i, u := range users {
+		out[i] = userResponse(r, u)
+	}
+	return out
+}
+
+// publishEmailChange asks for the confirmation to be sent to the new address and
+// the current one to be told
+func (h *Handler) publishEmailChange(ctx context.Context, c *EmailChange) {
+	expiresAt := c.ExpiresAt.Format(time.RFC3339)
+	h.publish(ctx, EventEmailChangeRequested, EmailChangeRequested{UserID: c.UserID, Email: c.NewEmail, Token: c.Token, ExpiresAt: expiresAt})
+	h.publish(ctx, EventEmailChangeNotice, EmailChangeNotice{UserID: c.UserID, Email: c.OldEmail, ExpiresAt: expiresAt})
+}
+
+// publish sends a user event. The database change is already committed by the
+// time this runs, so a failed publish is logged rather than failing the request.
+func (h *Handler) publish(ctx context.Context, eventType string, data any) {
+	event := events.Event{
+		ID:         h.ids.NewID(),
+		Type:       eventType,
+		OccurredAt: h.clock.Now().UTC(),
+		Data:       data,
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := h.publisher.Publish(ctx, event); err != nil {
+		log.Printf("Could not publish %s event: %v", eventType, err)
+	}
+}
@@ -0,0 +1,155 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"shared/auth"
+	"shared/clock"
+	"shared/events"
+	"shared/ids"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+var emailChangeNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newEmailChangeHandler(t *testing.T) (*Handler, *events.Memory, sqlmock.Sqlmock) {
+	t.Helper()
+	published := events.NewMemory()
+	h, mock := newMockHandler(t, WithClock(clock.NewFake(emailChangeNow)), WithIDGenerator(ids.NewSequence()), WithPublisher(published))
+	return h, published, mock
+}
+
+// expectClaim expects user id to lock email's hash and find it claimed or not
+func expectClaim(mock sqlmock.Sqlmock, id int32, hash string, claimed bool) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock")).
+		WithArgs(testTenant, hash).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(testTenant, id, hash, emailChangeNow).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(claimed))
+}
+
+// expectPendingEmail expects user id, holding old@example.com, to be given a
+// pending change to the email with hash and the confirmation token
+func expectPendingEmail(h *Handler, mock sqlmock.Sqlmock, id int32, hash, token string) {
+	expectUpsert(mock, id, "Ann", "old@example.com", h.repo.cipher.BlindIndex("old@example.com"), false)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users\nSET pending_email")).
+		WithArgs(sqlmock.AnyArg(), hash, hashEmailToken(token).String, emailChangeNow.Add(PendingEmailTTL), id, testTenant).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestPutUserHoldsNewEmailAsPending(t *testing.T) {
+	h, published, mock := newEmailChangeHandler(t)
+	self := auth.Principal{ID: "42", Roles: []string{"customer"}}
+	hash := h.repo.cipher.BlindIndex("new@example.com")
+
+	mock.ExpectBegin()
+	expectClaim(mock, 42, hash, false)
+	expectPendingEmail(h, mock, 42, hash, "token-1")
+	mock.ExpectCommit()
+
+	w := serve(h.PutUser, self, http.MethodPut, "/users/42", `{"name":"Ann","email":"new@example.com"}`, "id", "42")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var got UserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "old@example.com" {
+		t.Errorf("email = %q, want it unchanged until confirmed", got.Email)
+	}
+	if got.PendingEmail == nil || *got.PendingEmail != "new@example.com" {
+		t.Errorf("pending_email = %v, want new@example.com", got.PendingEmail)
+	}
+
+	sent := published.Events()
+	if len(sent) != 2 || sent[0].Type != EventEmailChangeRequested || sent[1].Type != EventEmailChangeNotice {
+		t.Fatalf("events = %+v, want a change request and a notice", sent)
+	}
+	if req := sent[0].Data.(EmailChangeRequested); req.Email != "new@example.com" || req.Token != "token-1" {
+		t.Errorf("request = %+v, want the token sent to new@example.com", req)
+	}
+	if notice := sent[1].Data.(EmailChangeNotice); notice.Email != "old@example.com" {
+		t.Errorf("notice = %+v, want it sent to old@example.com", notice)
+	}
+}
+
+func TestPutUserRefusesEmailHeldByAnotherUser(t *testing.T) {
+	// EmailClaimed covers both another user's current email and their pending one
+	h, published, mock := newEmailChangeHandler(t)
+	self := auth.Principal{ID: "42", Roles: []string{"customer"}}
+
+	mock.ExpectBegin()
+	expectClaim(mock, 42, h.repo.cipher.BlindIndex("taken@example.com"), true)
+	mock.ExpectRollback()
+
+	w := serve(h.PutUser, self, http.MethodPut, "/users/42", `{"name":"Ann","email":"Taken@Example.com"}`, "id", "42")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodeEmailTaken) {
+		t.Errorf("status = %d, want 409 %s: %s", w.Code, CodeEmailTaken, w.Body)
+	}
+	if sent := published.Events(); len(sent) != 0 {
+		t.Errorf("events = %+v, want none", sent)
+	}
+}
+
+func TestSecondPendingClaimOnSameEmailConflicts(t *testing.T) {
+	h, published, mock := newEmailChangeHandler(t)
+	hash := h.repo.cipher.BlindIndex("new@example.com")
+
+	// Both claims take the same advisory lock, so the second waits for the first to
+	// commit and then sees its pending change
+	mock.ExpectBegin()
+	expectClaim(mock, 42, hash, false)
+	expectPendingEmail(h, mock, 42, hash, "token-1")
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	expectClaim(mock, 43, hash, true)
+	mock.ExpectRollback()
+
+	first := serve(h.PutUser, auth.Principal{ID: "42"}, http.MethodPut, "/users/42", `{"name":"Ann","email":"new@example.com"}`, "id", "42")
+	if first.Code != http.StatusOK {
+		t.Fatalf("first claim status = %d, want 200: %s", first.Code, first.Body)
+	}
+	second := serve(h.PutUser, auth.Principal{ID: "43"}, http.MethodPut, "/users/43", `{"name":"Bob","email":"new@example.com"}`, "id", "43")
+	if second.Code != http.StatusConflict {
+		t.Errorf("second claim status = %d, want 409: %s", second.Code, second.Body)
+	}
+	if sent := published.Events(); len(sent) != 2 {
+		t.Errorf("got %d events, want only the first claim's two", len(sent))
+	}
+}
+
+func TestPutUserCannotStartAnotherUsersEmailChange(t *testing.T) {
+	// No query is expected: nothing is stored as pending for someone else
+	h, published, _ := newEmailChangeHandler(t)
+
+	w := serve(h.PutUser, auth.Principal{ID: "7", Roles: []string{"customer"}}, http.MethodPut, "/users/42", `{"name":"Ann","email":"attacker@example.com"}`, "id", "42")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+	if sent := published.Events(); len(sent) != 0 {
+		t.Errorf("events = %+v, want none", sent)
+	}
+}
+
+func TestConfirmEmailRefusesAddressTakenMeanwhile(t *testing.T) {
+	h, _, mock := newEmailChangeHandler(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET email = pending_email")).
+		WithArgs(testTenant, hashEmailToken("token-1").String, emailChangeNow).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: emailHashIndex})
+
+	_, err := h.repo.ConfirmEmail(tenantContext(auth.Principal{}), "token-1")
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("err = %v, want ErrDuplicateEmail", err)
+	}
+}
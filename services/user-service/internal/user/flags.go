@@ -0,0 +1,18 @@
+package user
+
+import "shared/featureflag"
+
+// Feature flags understood by the user service
+const (
+	FlagBulkOperations = "bulk-operations"
+)
+
+// Flags declares the feature flags understood by the user service.
+// Each can be overridden with FEATURE_<NAME>=true|false or at runtime via /admin/flags.
+var Flags = []featureflag.Flag{
+	{
+		Name:        FlagBulkOperations,
+		Description: "Serve POST /users/bulk-delete; turn off to hide it",
+		Default:     true,
+	},
+}
@@ -1,137 +1,279 @@
 package user
 
 import (
-	"encoding/json"
+	"errors"
 	"net/http"
+	"shared/auth"
+	"shared/featureflag"
+	"shared/httpx"
 	"strconv"
+	"user-service/internal/db/generated"
 )
 
 type Handler struct {
-	 repo *Repository
+	repo  *Repository
+	flags *featureflag.Set
+	options
 }
 
-func NewHandler(repo *Repository) *Handler {
-	return &Handler{repo: repo}
+func NewHandler(repo *Repository, flags *featureflag.Set, opts ...Option) *Handler {
+	return &Handler{repo: repo, flags: flags, options: newOptions(opts)}
 }
 
 func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.repo.ListUsers(r.Context())
-	
+	page, err := httpx.ParsePage(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(users)
+	// MAX_RESULT_ROWS is a hard cap on top of the page size
+	truncated := page.Limit > h.maxResultRows
+	if truncated {
+		page.Limit = h.maxResultRows
+	}
+
+	// ?active=false lists only deactivated users, ?active=true only active ones
+	var active *bool
+	if raw := r.URL.Query().Get("active"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			httpx.Error(w, http.StatusBadRequest, "active must be true or false")
+			return
+		}
+		active = &v
+	}
+
+	// Fetch one extra row to find out whether there is a next page
+	users, err := h.repo.ListUsers(r.Context(), active, int32(page.Limit+1), int32(page.Offset))
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	hasNext := len(users) > page.Limit
+	if hasNext {
+		users = users[:page.Limit]
+	}
+
+	response := httpx.NewListResponse(r, page, userResponses(r, users), hasNext)
+	response.Truncated = truncated
+	httpx.WriteJSON(w, r, http.StatusOK, response)
 }
 
-func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request)  {
+// ExportUsers streams every user in the caller's tenant, up to MAX_RESULT_ROWS
+func (h *Handler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	out := httpx.NewListWriter(w)
+	written, truncated := 0, false
+
+	// Read one row past the cap to find out whether there were more
+	err := h.repo.EachUser(r.Context(), int32(h.maxResultRows+1), func(u generated.User) error {
+		if written == h.maxResultRows {
+			truncated = true
+			return nil
+		}
+		written++
+		return out.Write(userResponse(r, u))
+	})
+	out.Close(truncated, err)
+}
+
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name string `json:"name"`
-		Email string `json:"email"`
+		Name  *string `json:"name"`
+		Email *string `json:"email"`
+	}
+
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+
+	if input.Email != nil {
+		*input.Email = h.emailNorm.Normalize(*input.Email)
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "name and email are required", http.StatusBadRequest)
+	var v httpx.Validation
+	v.Required("name", input.Name)
+	v.Name("name", input.Name, h.nameMaxLen)
+	v.Required("email", input.Email)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
 		return
 	}
 
-	user, err := h.repo.CreateUser(r.Context(), input.Name, input.Email)
+	user, err := h.repo.CreateUser(r.Context(), *input.Name, *input.Email)
+	if errors.Is(err, ErrDuplicateEmail) {
+		httpx.ErrorCode(w, http.StatusConflict, CodeEmailTaken, err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	httpx.WriteJSON(w, r, http.StatusCreated, NewUserResponse(user))
 }
 
-// UpdateUser updates a user in the database
-func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+// PutUser replaces the user with the ID in the path, or creates it with that ID if
+// there is none, answering 200 or 201 respectively. A new email for an existing
+// user is held as pending until it is confirmed from the new address, so a stolen
+// session can't lock the owner out by changing it. Users may replace themselves;
+// replacing anyone else or creating a user takes an admin.
+func (h *Handler) PutUser(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name string `json:"name"`
-		Email string `json:"email"`
+		Name  *string `json:"name"`
+		Email *string `json:"email"`
 	}
 
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id is required")
 		return
 	}
 
 	// Parse id to int32
 	idInt, err := strconv.ParseInt(id, 10, 32)
 	if err != nil {
-		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
+		return
+	}
+	// IDs come from a sequence starting at 1, so a new user can't be given anything less
+	if idInt < 1 {
+		httpx.Error(w, http.StatusBadRequest, "id must be positive")
+		return
+	}
+
+	// Users may replace themselves; anyone else, and creating a user, needs an admin
+	caller := auth.FromContext(r.Context())
+	if !caller.Authenticated() {
+		httpx.Error(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if !selfOrAdmin(caller, int32(idInt)) {
+		httpx.Error(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	isAdmin := caller.HasRole(RoleAdmin)
+
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "name, email are required", http.StatusBadRequest)
+	if input.Email != nil {
+		*input.Email = h.emailNorm.Normalize(*input.Email)
+	}
+
+	var v httpx.Validation
+	v.Required("name", input.Name)
+	v.Name("name", input.Name, h.nameMaxLen)
+	v.Required("email", input.Email)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
 		return
 	}
 
-	user, err := h.repo.UpdateUser(r.Context(), int32(idInt), input.Name, input.Email)
+	user, created, change, err := h.repo.UpsertUser(r.Context(), int32(idInt), *input.Name, *input.Email, isAdmin)
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusForbidden, "only admins can create users")
+		return
+	}
+	if errors.Is(err, ErrIDTaken) {
+		httpx.ErrorCode(w, http.StatusConflict, "id_taken", err.Error())
+		return
+	}
+	if errors.Is(err, ErrDuplicateEmail) {
+		httpx.ErrorCode(w, http.StatusConflict, CodeEmailTaken, err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(user)
+	if change != nil {
+		h.publishEmailChange(r.Context(), change)
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	httpx.WriteJSON(w, r, status, userResponse(r, user))
 }
 
 // DeleteUser deletes a user from the database
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	
+
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id is required")
 		return
 	}
 
 	// Parse id to int32
 	idInt, err := strconv.ParseInt(id, 10, 32)
 	if err != nil {
-		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
 		return
 	}
 
 	err = h.repo.DeleteUser(r.Context(), int32(idInt))
+	if errors.Is(err, ErrNotFound) {
+		httpx.DeleteNotFound(w, r, err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// BulkDeleteUsers deletes the users listed in {"ids":[...]} and reports how many
+// existed; IDs that don't exist are ignored
+func (h *Handler) BulkDeleteUsers(w http.ResponseWriter, r *http.Request) {
+	ids, err := httpx.DecodeIDs(w, r, h.maxBatchSize)
+	if err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+
+	deleted, err := h.repo.DeleteUsers(r.Context(), ids)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	httpx.WriteJSON(w, r, http.StatusOK, map[string]int{"deleted": len(deleted)})
+}
+
 // GetUser retrieves a user from the database
 func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id is required")
 		return
 	}
 
 	// Parse id to int32
 	idInt, err := strconv.ParseInt(id, 10, 32)
 	if err != nil {
-		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		httpx.Error(w, http.StatusBadRequest, "id must be an integer")
 		return
 	}
 	user, err := h.repo.GetUser(r.Context(), int32(idInt))
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(user)
+	httpx.WriteJSON(w, r, http.StatusOK, userResponse(r, user))
 }
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"shared/auth"
+	"strings"
+	"testing"
+)
+
+func TestPutUserAccess(t *testing.T) {
+	self := auth.Principal{ID: "42", Roles: []string{"customer"}}
+	other := auth.Principal{ID: "7", Roles: []string{"customer"}}
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		exists    bool
+		want      int
+	}{
+		{name: "anonymous", want: http.StatusUnauthorized},
+		{name: "other user", principal: other, exists: true, want: http.StatusForbidden},
+		{name: "self replacing", principal: self, exists: true, want: http.StatusOK},
+		{name: "self creating", principal: self, want: http.StatusForbidden},
+		{name: "admin replacing", principal: admin, exists: true, want: http.StatusOK},
+		{name: "admin creating", principal: admin, want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newMockHandler(t)
+
+			// Anonymous and other callers are refused before the database is touched
+			if tt.principal.Authenticated() && tt.principal.ID != other.ID {
+				expectUpsertBegin(mock, 42)
+				expectUpsert(mock, 42, "Ann", "ann@example.com", h.repo.cipher.BlindIndex("ann@example.com"), !tt.exists)
+				switch {
+				case tt.want == http.StatusForbidden:
+					mock.ExpectRollback()
+				case !tt.exists:
+					expectSyncSequence(mock)
+					mock.ExpectCommit()
+				default:
+					mock.ExpectCommit()
+				}
+			}
+
+			w := serve(h.PutUser, tt.principal, http.MethodPut, "/users/42", `{"name":"Ann","email":"ann@example.com"}`, "id", "42")
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestPutUserIsInAccess(t *testing.T) {
+	// Listed so the route is checked, but the owner check is PutUser's own
+	if _, ok := Access["PUT /users/{id}"]; !ok {
+		t.Fatal(`Access has no "PUT /users/{id}"`)
+	}
+	if got := authorize("/users/{id}", http.MethodPut, "/users/42", &auth.Principal{ID: "42"}); got != http.StatusNoContent {
+		t.Errorf("status = %d, want the request passed on to PutUser", got)
+	}
+}
+
+func TestCreateUserReportsMalformedBody(t *testing.T) {
+	// No query is expected: every body is refused before the database
+	h, _ := newMockHandler(t)
+
+	tests := []struct {
+		name, body string
+		want       string
+	}{
+		{name: "empty", body: "", want: "request body is required"},
+		{name: "wrong type", body: `{"name":5}`, want: `request body contains an invalid value for the \"name\" field (at position 9)`},
+		{name: "unknown field", body: `{"name":"Ann","role":"admin"}`, want: `request body contains unknown field \"role\"`},
+		{name: "two documents", body: `{"name":"Ann"}{}`, want: "request body must only contain a single JSON object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h.CreateUser, admin, http.MethodPost, "/users", tt.body)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("got %d %s, want 400 %s", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUserInAnotherTenantIsNotFound(t *testing.T) {
+	h, mock := newMockHandler(t)
+
+	// User 42 exists, but in another tenant, so the scoped query finds nothing
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE id = $1 AND tenant_id = $2")).
+		WithArgs(42, testTenant).
+		WillReturnError(sql.ErrNoRows)
+
+	w := serve(h.GetUser, admin, http.MethodGet, "/users/42", "", "id", "42")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404, not 403, so existence isn't leaked", w.Code)
+	}
+}
+
+func TestWritesRequireJSONContentType(t *testing.T) {
+	// No query is expected: the body is refused before it is decoded
+	h, _ := newMockHandler(t)
+
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		for _, tt := range []struct {
+			handler        http.HandlerFunc
+			method, target string
+		}{
+			{h.CreateUser, http.MethodPost, "/users"},
+			{h.PutUser, http.MethodPut, "/users/42"},
+		} {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{"name":"Ann","email":"ann@example.com"}`)).
+				WithContext(tenantContext(admin))
+			r.SetPathValue("id", "42")
+			if contentType != "" {
+				r.Header.Set("Content-Type", contentType)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("%s %s with Content-Type %q: status = %d, want 415", tt.method, tt.target, contentType, w.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"shared/auth"
+	"shared/httpx"
+	"shared/jwt"
+	"shared/tenant"
+	"strconv"
+	"time"
+)
+
+// MaxImpersonationTTL caps impersonation tokens whatever IMPERSONATION_TTL says
+const MaxImpersonationTTL = 15 * time.Minute
+
+// AuditImpersonate is the audit log action recorded for each impersonation
+const AuditImpersonate = "user.impersonate"
+
+// ImpersonationConfig configures POST /admin/impersonate/{userID}
+type ImpersonationConfig struct {
+	Key []byte        // JWT_SIGNING_KEY; impersonation is disabled without it
+	TTL time.Duration // IMPERSONATION_TTL; capped at MaxImpersonationTTL
+}
+
+// ImpersonationConfigFromEnv reads the impersonation configuration from the environment
+func ImpersonationConfigFromEnv() (ImpersonationConfig, error) {
+	cfg := ImpersonationConfig{Key: jwt.KeyFromEnv(), TTL: MaxImpersonationTTL}
+
+	if raw := os.Getenv("IMPERSONATION_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl <= 0 {
+			return cfg, fmt.Errorf("invalid IMPERSONATION_TTL %q", raw)
+		}
+		cfg.TTL = ttl
+	}
+	if cfg.TTL > MaxImpersonationTTL {
+		log.Printf("IMPERSONATION_TTL %s is above the %s maximum; using the maximum", cfg.TTL, MaxImpersonationTTL)
+		cfg.TTL = MaxImpersonationTTL
+	}
+	return cfg, nil
+}
+
+// ImpersonationToken is the response of POST /admin/impersonate/{userID}. There is
+// deliberately no refresh token: the session ends when the token expires.
+type ImpersonationToken struct {
+	Token          string `json:"token"`
+	TokenType      string `json:"token_type"`
+	ExpiresAt      string `json:"expires_at"` // RFC3339, UTC
+	UserID         int32  `json:"user_id"`
+	ImpersonatedBy string `json:"impersonated_by"`
+}
+
+// Impersonate issues a short-lived token that acts as the user in the path, carrying
+// the caller as its impersonator. Only admins may call it (see Access), and every
+// impersonation is written to the audit log before the token is handed out.
+func (h *Handler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	if h.impersonation.Key == nil {
+		httpx.Error(w, http.StatusNotFound, "impersonation is not enabled")
+		return
+	}
+
+	staff := auth.FromContext(r.Context())
+	if staff.Impersonated() {
+		httpx.Error(w, http.StatusForbidden, "cannot impersonate from an impersonated session")
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("userID"), 10, 32)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "userID must be an integer")
+		return
+	}
+
+	target, err := h.repo.GetUser(r.Context(), int32(id))
+	if errors.Is(err, ErrNotFound) {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !target.IsActive {
+		httpx.ErrorCode(w, http.StatusForbidden, CodeAccountDisabled, ErrAccountDisabled.Error())
+		return
+	}
+
+	now := h.clock.Now().UTC()
+	expiresAt := now.Add(h.impersonation.TTL)
+	token, err := jwt.Sign(jwt.Claims{
+		Subject:        strconv.Itoa(int(target.ID)),
+		Tenant:         tenant.FromContext(r.Context()),
+		ImpersonatedBy: staff.ID,
+		IssuedAt:       now.Unix(),
+		ExpiresAt:      expiresAt.Unix(),
+	}, h.impersonation.Key)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// No audit entry, no token
+	err = h.repo.RecordAudit(r.Context(), staff.ID, AuditImpersonate, strconv.Itoa(int(target.ID)),
+		map[string]string{"expires_at": expiresAt.Format(time.RFC3339)})
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("User %s is impersonating user %d until %s", staff.ID, target.ID, expiresAt.Format(time.RFC3339))
+
+	w.Header().Set("Cache-Control", "no-store")
+	httpx.WriteJSON(w, r, http.StatusCreated, ImpersonationToken{
+		Token:          token,
+		TokenType:      "Bearer",
+		ExpiresAt:      expiresAt.Format(time.RFC3339),
+		UserID:         target.ID,
+		ImpersonatedBy: staff.ID,
+	})
+}
@@ -0,0 +1,109 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"shared/auth"
+	"shared/clock"
+	"shared/jwt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var impersonationKey = []byte("impersonation-test-key")
+
+func newImpersonationHandler(t *testing.T) (*Handler, *clock.Fake, sqlmock.Sqlmock) {
+	t.Helper()
+	c := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h, mock := newMockHandler(t, WithClock(c), WithImpersonation(ImpersonationConfig{Key: impersonationKey, TTL: MaxImpersonationTTL}))
+	return h, c, mock
+}
+
+func TestNonAdminCannotImpersonate(t *testing.T) {
+	customer := auth.Principal{ID: "7", Roles: []string{"customer"}}
+
+	for _, pattern := range []string{"/admin/impersonate/{userID}", "/users/{userID}/impersonate"} {
+		for _, tt := range []struct {
+			name      string
+			principal *auth.Principal
+			want      int
+		}{
+			{name: "anonymous", want: http.StatusUnauthorized},
+			{name: "customer", principal: &customer, want: http.StatusForbidden},
+		} {
+			t.Run(pattern+" "+tt.name, func(t *testing.T) {
+				target := strings.Replace(pattern, "{userID}", "42", 1)
+				if got := authorize(pattern, http.MethodPost, target, tt.principal); got != tt.want {
+					t.Errorf("status = %d, want %d", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestImpersonatedSessionCannotImpersonate(t *testing.T) {
+	// No query is expected: the request is refused before the user is looked up
+	h, _, _ := newImpersonationHandler(t)
+
+	staff := auth.Principal{ID: "staff-1", Roles: []string{RoleAdmin}, ImpersonatedBy: "staff-2"}
+	w := serve(h.Impersonate, staff, http.MethodPost, "/admin/impersonate/42", "", "userID", "42")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestImpersonateWritesAuditEntry(t *testing.T) {
+	h, c, mock := newImpersonationHandler(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users")).
+		WithArgs(42, testTenant).
+		WillReturnRows(userRows(42))
+	mock.ExpectBegin()
+	expectAudit(mock, admin.ID, AuditImpersonate, "42", 4)
+	mock.ExpectCommit()
+
+	w := serve(h.Impersonate, admin, http.MethodPost, "/admin/impersonate/42", "", "userID", "42")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", w.Code, w.Body)
+	}
+
+	var got ImpersonationToken
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := jwt.Verify(got.Token, impersonationKey, c.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "42" || claims.ImpersonatedBy != admin.ID || claims.Tenant != testTenant {
+		t.Errorf("claims = %+v, want subject 42 impersonated by %s in %s", claims, admin.ID, testTenant)
+	}
+	if ttl := time.Unix(claims.ExpiresAt, 0).Sub(c.Now()); ttl != MaxImpersonationTTL {
+		t.Errorf("token lives %s, want %s", ttl, MaxImpersonationTTL)
+	}
+}
+
+func TestImpersonateWithoutAuditEntryIssuesNoToken(t *testing.T) {
+	h, _, mock := newImpersonationHandler(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users")).
+		WithArgs(42, testTenant).
+		WillReturnRows(userRows(42))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO audit_chain_heads")).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	w := serve(h.Impersonate, admin, http.MethodPost, "/admin/impersonate/42", "", "userID", "42")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	if got := w.Body.String(); strings.Contains(got, `"token"`) {
+		t.Errorf("response carries a token: %s", got)
+	}
+}
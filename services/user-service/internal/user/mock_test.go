@@ -0,0 +1,96 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"shared/auth"
+	"shared/featureflag"
+	"shared/tenant"
+	"strings"
+	"testing"
+	"user-service/internal/pii"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+// testTenant is the tenant every test request is made in
+const testTenant = "acme"
+
+// newMockRepository returns a Repository backed by sqlmock. Read retries are off,
+// so a failed expectation isn't retried, and unmet expectations fail the test.
+func newMockRepository(t *testing.T, opts ...Option) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	cipher, err := pii.NewCipher(make([]byte, pii.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(sqlx.NewDb(db, "postgres"), cipher, append([]Option{WithReadRetry(false)}, opts...)...), mock
+}
+
+// newMockHandler returns a Handler over newMockRepository
+func newMockHandler(t *testing.T, opts ...Option) (*Handler, sqlmock.Sqlmock) {
+	t.Helper()
+	repo, mock := newMockRepository(t, opts...)
+	return NewHandler(repo, featureflag.New(Flags...), opts...), mock
+}
+
+// tenantContext returns a context in testTenant, with principal as the caller
+func tenantContext(principal auth.Principal) context.Context {
+	return auth.WithPrincipal(tenant.WithTenant(context.Background(), testTenant), principal)
+}
+
+// serve sends a request with body to handler in testTenant as principal;
+// pathValues are the wildcards the mux would have matched, as name, value pairs
+func serve(handler http.HandlerFunc, principal auth.Principal, method, target, body string, pathValues ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(tenantContext(principal))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// userColumns are the columns the user queries return, in order
+var userColumns = []string{"id", "name", "email", "created_at", "deleted_at", "tenant_id", "email_hash",
+	"pending_email", "pending_email_hash", "email_token_hash", "pending_email_expires_at", "is_active"}
+
+// userRows returns rows of active users in testTenant with the given IDs
+func userRows(ids ...int32) *sqlmock.Rows {
+	rows := sqlmock.NewRows(userColumns)
+	for _, id := range ids {
+		rows.AddRow(id, fmt.Sprintf("User %d", id), fmt.Sprintf("user%d@example.com", id), nil, nil, testTenant, nil, nil, nil, nil, nil, true)
+	}
+	return rows
+}
+
+// expectAudit expects one audit entry appended to testTenant's chain inside an
+// open transaction, with head the sequence number of the entry before it
+func expectAudit(mock sqlmock.Sqlmock, actor, action, target string, head int64) {
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO audit_chain_heads")).
+		WithArgs(testTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "seq", "hash"}).AddRow(testTenant, head, "prev"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_log")).
+		WithArgs(testTenant, actor, action, target, sqlmock.AnyArg(), sqlmock.AnyArg(), head+1, []byte("prev"), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE audit_chain_heads")).
+		WithArgs(testTenant, head+1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
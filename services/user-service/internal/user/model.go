@@ -1,3 +1,41 @@
 package user
 
+import (
+	"database/sql"
+	"shared/api"
+	"time"
+	"user-service/internal/db/generated"
+)
 
+// UserResponse is the JSON representation of a user. Handlers always send this
+// rather than the generated struct, so the wire format doesn't change when the
+// schema does. It is defined in shared/api, which the Go client shares.
+type UserResponse = api.User
+
+// NewUserResponse maps a user row to its JSON representation
+func NewUserResponse(u generated.User) UserResponse {
+	return UserResponse{
+		ID:        u.ID,
+		Name:      u.Name,
+		Email:     u.Email,
+		CreatedAt: nullableTime(u.CreatedAt),
+		Active:    u.IsActive,
+	}
+}
+
+// NewUserResponses maps a list of user rows
+func NewUserResponses(users []generated.User) []UserResponse {
+	out := make([]UserResponse, len(users))
+	for i, u := range users {
+		out[i] = NewUserResponse(u)
+	}
+	return out
+}
+
+func nullableTime(t sql.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+	formatted := t.Time.UTC().Format(time.RFC3339)
+	return &formatted
+}
@@ -0,0 +1,101 @@
+package user
+
+import (
+	"shared/clock"
+	"shared/events"
+	"shared/httpx"
+	"shared/ids"
+	"shared/querylog"
+)
+
+// Option customises a Handler or Repository, mainly so tests can control time and IDs
+type Option func(*options)
+
+type options struct {
+	clock         clock.Clock
+	ids           ids.Generator
+	maxBatchSize  int
+	maxResultRows int
+	impersonation ImpersonationConfig
+	slowQueries   querylog.Config
+	readRetry     bool
+	emailNorm     EmailNormalization
+	publisher     events.Publisher
+	auditKey      []byte
+	nameMaxLen    int
+}
+
+// WithClock replaces the real clock
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithIDGenerator replaces the random ID/token generator
+func WithIDGenerator(g ids.Generator) Option {
+	return func(o *options) { o.ids = g }
+}
+
+// WithMaxBatchSize caps how many IDs a bulk request may name
+func WithMaxBatchSize(n int) Option {
+	return func(o *options) { o.maxBatchSize = n }
+}
+
+// WithImpersonation enables POST /admin/impersonate/{userID}
+func WithImpersonation(cfg ImpersonationConfig) Option {
+	return func(o *options) { o.impersonation = cfg }
+}
+
+// WithMaxResultRows caps the rows a single response may return
+func WithMaxResultRows(n int) Option {
+	return func(o *options) { o.maxResultRows = n }
+}
+
+// WithSlowQueryLog logs repository queries slower than cfg.Threshold, with the
+// plans of slow reads when cfg.Explain is set; slow queries aren't logged by default
+func WithSlowQueryLog(cfg querylog.Config) Option {
+	return func(o *options) { o.slowQueries = cfg }
+}
+
+// WithEmailNormalization sets how emails are normalized before they are stored
+func WithEmailNormalization(n EmailNormalization) Option {
+	return func(o *options) { o.emailNorm = n }
+}
+
+// WithPublisher sets where user.* events are sent, among them the confirmation
+// tokens of email changes; events are discarded by default
+func WithPublisher(p events.Publisher) Option {
+	return func(o *options) { o.publisher = p }
+}
+
+// WithReadRetry sets whether repository reads that lose their database
+// connection are retried once; they are by default
+func WithReadRetry(enabled bool) Option {
+	return func(o *options) { o.readRetry = enabled }
+}
+
+// WithAuditSigningKey enables GET /admin/audit/export, signing exports with key
+func WithAuditSigningKey(key []byte) Option {
+	return func(o *options) { o.auditKey = key }
+}
+
+// WithNameMaxLen sets the longest name accepted, in characters; default httpx.DefaultNameMaxLen
+func WithNameMaxLen(n int) Option {
+	return func(o *options) { o.nameMaxLen = n }
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		clock:         clock.Real(),
+		readRetry:     true,
+		ids:           ids.Random(),
+		maxBatchSize:  httpx.DefaultMaxBatchSize,
+		maxResultRows: httpx.DefaultMaxResultRows,
+		emailNorm:     EmailNormalizeDomain,
+		publisher:     events.Nop{},
+		nameMaxLen:    httpx.DefaultNameMaxLen,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,151 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"user-service/internal/db/generated"
+	"user-service/internal/pii"
+
+	"github.com/lib/pq"
+)
+
+// fieldEmail names the users.email column in its ciphertext, so it can't be
+// decrypted as any other field. pending_email uses it too, since confirming a
+// change moves the ciphertext into email as it is.
+const fieldEmail = "users.email"
+
+// emailHashIndex is the unique index on (tenant_id, email_hash)
+const emailHashIndex = "users_tenant_email_hash_key"
+
+// ErrDuplicateEmail is returned when another user in the tenant has the same email
+var ErrDuplicateEmail = errors.New("a user with this email already exists")
+
+// CodeEmailTaken is the error code of the 409 for ErrDuplicateEmail
+const CodeEmailTaken = "email_taken"
+
+// decryptFailures counts rows that could not be decrypted since startup
+var decryptFailures atomic.Int64
+
+// DecryptFailures reports how many rows could not be decrypted since startup,
+// for /health. Any at all means data is unreadable and needs attention.
+func DecryptFailures() int64 {
+	return decryptFailures.Load()
+}
+
+// sealEmail returns the ciphertext and blind index to store for an email
+func (r *Repository) sealEmail(email string) (string, sql.NullString, error) {
+	sealed, err := r.cipher.Encrypt(fieldEmail, email)
+	if err != nil {
+		return "", sql.NullString{}, fmt.Errorf("could not encrypt email: %w", err)
+	}
+	return sealed, sql.NullString{String: r.cipher.BlindIndex(email), Valid: true}, nil
+}
+
+// openUser replaces the stored ciphertext in u with plaintext. A failure is
+// never papered over with an empty field: it is logged as an alert and returned,
+// so the request fails with a 500.
+func (r *Repository) openUser(u *generated.User) error {
+	email, err := r.cipher.Decrypt(fieldEmail, u.Email)
+	if err != nil {
+		decryptFailures.Add(1)
+		log.Printf("ALERT: could not decrypt email of user %d in tenant %s: %v", u.ID, u.TenantID, err)
+		return fmt.Errorf("user %d: %w", u.ID, err)
+	}
+	u.Email = email
+
+	if u.PendingEmail.Valid {
+		pending, err := r.cipher.Decrypt(fieldEmail, u.PendingEmail.String)
+		if err != nil {
+			decryptFailures.Add(1)
+			log.Printf("ALERT: could not decrypt pending email of user %d in tenant %s: %v", u.ID, u.TenantID, err)
+			return fmt.Errorf("user %d: %w", u.ID, err)
+		}
+		u.PendingEmail.String = pending
+	}
+	return nil
+}
+
+func (r *Repository) openUsers(users []generated.User) error {
+	for i := range users {
+		if err := r.openUser(&users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDuplicateEmail reports whether err is a unique violation (23505) on the email hash
+func isDuplicateEmail(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == emailHashIndex
+}
+
+// EncryptionProgress is reported after each batch of EncryptEmails
+type EncryptionProgress struct {
+	Encrypted int     // rows encrypted so far in this run
+	Conflicts []int32 // users whose email duplicates another's once normalized, left as they are
+	Remaining int64   // rows still unencrypted when the run started, less those done since
+	LastID    int32   // the highest user ID looked at
+}
+
+// EncryptEmails encrypts the emails still stored in plaintext, batchSize rows at
+// a time across all tenants, calling progress after every batch. It only touches
+// rows without a blind index, so it can be stopped and run again at any point and
+// carries on where it left off.
+//
+// Emails that were distinct before but collide once normalized (e.g. differing in
+// case) can't both be indexed; they are reported in Conflicts and left in
+// plaintext to be resolved by hand.
+func (r *Repository) EncryptEmails(ctx context.Context, batchSize int32, progress func(EncryptionProgress)) (EncryptionProgress, error) {
+	remaining, err := r.q.CountUnencryptedUsers(ctx)
+	if err != nil {
+		return EncryptionProgress{}, fmt.Errorf("could not count unencrypted users: %w", err)
+	}
+	p := EncryptionProgress{Remaining: remaining}
+
+	for {
+		rows, err := r.q.ListUnencryptedUsers(ctx, generated.ListUnencryptedUsersParams{ID: p.LastID, Limit: batchSize})
+		if err != nil {
+			return p, fmt.Errorf("could not list unencrypted users: %w", err)
+		}
+		if len(rows) == 0 {
+			return p, nil
+		}
+
+		for _, row := range rows {
+			p.LastID = row.ID
+			if pii.IsEncrypted(row.Email) {
+				// The service always writes both together, but never encrypt twice
+				if row.Email, err = r.cipher.Decrypt(fieldEmail, row.Email); err != nil {
+					return p, fmt.Errorf("user %d: %w", row.ID, err)
+				}
+			}
+			sealed, hash, err := r.sealEmail(row.Email)
+			if err != nil {
+				return p, err
+			}
+
+			updated, err := r.q.SetEncryptedEmail(ctx, generated.SetEncryptedEmailParams{ID: row.ID, Email: sealed, EmailHash: hash})
+			if isDuplicateEmail(err) {
+				p.Conflicts = append(p.Conflicts, row.ID)
+				continue
+			}
+			if err != nil {
+				return p, fmt.Errorf("could not encrypt email of user %d: %w", row.ID, err)
+			}
+			if updated > 0 {
+				p.Encrypted++
+				p.Remaining--
+			}
+		}
+		progress(p)
+
+		if ctx.Err() != nil {
+			return p, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PurgerConfig configures the soft-delete purge job
+type PurgerConfig struct {
+	Retention time.Duration // SOFT_DELETE_RETENTION; how long soft-deleted rows are kept
+	Interval  time.Duration // SOFT_DELETE_PURGE_INTERVAL; how often the purge runs
+	BatchSize int32         // SOFT_DELETE_PURGE_BATCH; rows deleted per statement
+}
+
+// PurgerConfigFromEnv reads the purge job configuration from the environment
+func PurgerConfigFromEnv() (PurgerConfig, error) {
+	cfg := PurgerConfig{
+		Retention: 90 * 24 * time.Hour,
+		Interval:  time.Hour,
+		BatchSize: 500,
+	}
+
+	if raw := os.Getenv("SOFT_DELETE_RETENTION"); raw != "" {
+		retention, err := parseDays(raw)
+		if err != nil || retention <= 0 {
+			return cfg, fmt.Errorf("invalid SOFT_DELETE_RETENTION %q", raw)
+		}
+		cfg.Retention = retention
+	}
+
+	if raw := os.Getenv("SOFT_DELETE_PURGE_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return cfg, fmt.Errorf("invalid SOFT_DELETE_PURGE_INTERVAL %q", raw)
+		}
+		cfg.Interval = interval
+	}
+
+	if raw := os.Getenv("SOFT_DELETE_PURGE_BATCH"); raw != "" {
+		batch, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil || batch <= 0 {
+			return cfg, fmt.Errorf("invalid SOFT_DELETE_PURGE_BATCH %q", raw)
+		}
+		cfg.BatchSize = int32(batch)
+	}
+
+	return cfg, nil
+}
+
+// parseDays parses a Go duration, additionally accepting whole days such as "90d"
+func parseDays(raw string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// Purger hard-deletes users whose soft-delete is older than the retention period
+type Purger struct {
+	repo *Repository
+	cfg  PurgerConfig
+}
+
+// NewPurger creates a Purger for the given repository
+func NewPurger(repo *Repository, cfg PurgerConfig) *Purger {
+	return &Purger{repo: repo, cfg: cfg}
+}
+
+// Run purges on every interval until ctx is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	log.Printf("Soft-delete purge started (retention: %s, interval: %s, batch: %d)", p.cfg.Retention, p.cfg.Interval, p.cfg.BatchSize)
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.Purge(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Soft-delete purge failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Soft-delete purge stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes expired rows in batches, so no single statement holds locks for long,
+// and returns the total number of rows removed
+func (p *Purger) Purge(ctx context.Context) (int64, error) {
+	cutoff := p.repo.clock.Now().UTC().Add(-p.cfg.Retention)
+
+	var total int64
+	for {
+		purged, err := p.repo.PurgeDeletedUsers(ctx, cutoff, p.cfg.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += purged
+
+		if purged < int64(p.cfg.BatchSize) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Printf("Soft-delete purge removed %d users deleted before %s", total, cutoff.Format(time.RFC3339))
+	}
+	return total, nil
+}
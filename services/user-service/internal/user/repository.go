@@ -2,78 +2,439 @@ package user
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"shared/dbretry"
+	"shared/querylog"
+	"shared/tenant"
+	"strconv"
+	"time"
 	"user-service/internal/db/generated"
+	"user-service/internal/pii"
 
 	"github.com/jmoiron/sqlx"
 )
 
-// Repository provides access to user data via sqlc-generated queries
+// ErrNotFound is returned when a user does not exist in the caller's tenant
+var ErrNotFound = errors.New("user not found")
+
+// ErrIDTaken is returned when PUT names an ID that belongs to another tenant or a deleted user
+var ErrIDTaken = errors.New("this user ID is not available")
+
+// Repository provides access to user data via sqlc-generated queries. Emails are
+// encrypted with cipher on the way in and decrypted on the way out, so callers
+// only ever see plaintext.
 type Repository struct {
-	q *generated.Queries
+	db     *sql.DB
+	q      *generated.Queries
+	cipher *pii.Cipher
+	options
 }
 
 // NewRepository creates a new Repository with a connected database
-func NewRepository(db *sqlx.DB) *Repository {
-	return &Repository{q: generated.New(db.DB)}
+func NewRepository(db *sqlx.DB, cipher *pii.Cipher, opts ...Option) *Repository {
+	o := newOptions(opts)
+	return &Repository{db: db.DB, q: generated.New(dbretry.Wrap(querylog.Wrap(db.DB, o.slowQueries), o.readRetry)), cipher: cipher, options: o}
 }
 
-// ListUsers retrieves all users from the database
-func (r *Repository) ListUsers(ctx context.Context) ([]generated.User, error) {
-	users, err := r.q.ListUsers(ctx)
+// ListUsers retrieves a page of users in the caller's tenant; with active set, only
+// the users that are active or deactivated
+func (r *Repository) ListUsers(ctx context.Context, active *bool, limit, offset int32) ([]generated.User, error) {
+	var filter sql.NullBool
+	if active != nil {
+		filter = sql.NullBool{Bool: *active, Valid: true}
+	}
+
+	users, err := r.q.ListUsers(ctx, generated.ListUsersParams{
+		TenantID: tenant.FromContext(ctx),
+		Active:   filter,
+		Limit:    limit,
+		Offset:   offset,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("could not list users: %w", err)
 	}
+	if err := r.openUsers(users); err != nil {
+		return nil, err
+	}
 	if users == nil {
 		users = []generated.User{}
 	}
 	return users, nil
 }
 
+// exportUsers lists every user in a tenant; rows are read one at a time by EachUser
+const exportUsers = `SELECT id, name, email, created_at, deleted_at, tenant_id, email_hash,
+  pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active FROM users
+WHERE tenant_id = $1 AND deleted_at IS NULL
+ORDER BY id
+LIMIT $2`
+
+// EachUser calls fn for up to limit users in the caller's tenant in ID order, reading
+// rows as they arrive instead of loading them all. It stops at the first error from fn.
+func (r *Repository) EachUser(ctx context.Context, limit int32, fn func(generated.User) error) error {
+	rows, err := r.db.QueryContext(ctx, exportUsers, tenant.FromContext(ctx), limit)
+	if err != nil {
+		return fmt.Errorf("could not export users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u generated.User
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt, &u.DeletedAt, &u.TenantID, &u.EmailHash,
+			&u.PendingEmail, &u.PendingEmailHash, &u.EmailTokenHash, &u.PendingEmailExpiresAt, &u.IsActive); err != nil {
+			return fmt.Errorf("could not export users: %w", err)
+		}
+		if err := r.openUser(&u); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not export users: %w", err)
+	}
+	return nil
+}
 
 // CreateUsers creates a user to the database
 func (r *Repository) CreateUser(ctx context.Context, name, email string) (generated.User, error) {
-	createUserParams := generated.CreateUserParams {
-		Name: name,
-		Email: email,
+	sealed, hash, err := r.sealEmail(email)
+	if err != nil {
+		return generated.User{}, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return generated.User{}, fmt.Errorf("could not create user: %w", err)
+	}
+	defer tx.Rollback()
+	q := r.q.WithTx(tx)
+
+	// No user has ID 0, so the email must be free of everyone. Concurrent creates
+	// of one email queue on claimEmail's lock, so the second finds the first's row.
+	// Writers that don't take the lock, like cmd/encrypt-pii, are caught by the
+	// unique index instead, which CreateUser also reports as ErrDuplicateEmail.
+	if err := r.claimEmail(ctx, q, 0, hash); err != nil {
+		return generated.User{}, err
+	}
+	createUserParams := generated.CreateUserParams{
+		TenantID:  tenant.FromContext(ctx),
+		Name:      name,
+		Email:     sealed,
+		EmailHash: hash,
+	}
+	user, err := q.CreateUser(ctx, createUserParams)
+	if isDuplicateEmail(err) {
+		return generated.User{}, ErrDuplicateEmail
 	}
-	user, err := r.q.CreateUser(ctx, createUserParams)
 	if err != nil {
 		return generated.User{}, fmt.Errorf("could not create user: %w", err)
 	}
+	if err := tx.Commit(); err != nil {
+		return generated.User{}, fmt.Errorf("could not create user: %w", err)
+	}
 
+	user.Email = email
 	return user, nil
 }
 
 // GetUser retrieves a user from the database
 func (r *Repository) GetUser(ctx context.Context, id int32) (generated.User, error) {
-	user, err := r.q.GetUser(ctx, id)
+	user, err := r.q.GetUser(ctx, generated.GetUserParams{ID: id, TenantID: tenant.FromContext(ctx)})
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.User{}, ErrNotFound
+	}
 	if err != nil {
 		return generated.User{}, fmt.Errorf("could not get user: %w", err)
 	}
+	if err := r.openUser(&user); err != nil {
+		return generated.User{}, err
+	}
 	return user, nil
 }
 
-// UpdateUser updates a user in the database
-func (r *Repository) UpdateUser(ctx context.Context, id int32, name, email string) (generated.User, error) {
-	updateUserParams := generated.UpdateUserParams {
-		ID: id,
-		Name: name,
-		Email: email,
+// UpsertUser creates the user with the given ID in the caller's tenant, or replaces
+// its name if it exists, reporting whether it was created. An ID held by another
+// tenant or by a deleted user is never overwritten: that is ErrIDTaken.
+//
+// A new email for an existing user is only stored as pending, and the returned
+// EmailChange carries the token that confirms it; it is nil if the email is unchanged.
+// Without create, a missing user is ErrNotFound and nothing is saved.
+func (r *Repository) UpsertUser(ctx context.Context, id int32, name, email string, create bool) (generated.User, bool, *EmailChange, error) {
+	sealed, hash, err := r.sealEmail(email)
+	if err != nil {
+		return generated.User{}, false, nil, err
 	}
-	user, err := r.q.UpdateUser(ctx, updateUserParams)
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return generated.User{}, fmt.Errorf("could not update user: %w", err)
+		return generated.User{}, false, nil, fmt.Errorf("could not save user: %w", err)
 	}
-	return user, nil
+	defer tx.Rollback()
+	q := r.q.WithTx(tx)
+
+	// Checked whether the user is created or changes their email: either way the
+	// email ends up held by this user
+	if err := r.claimEmail(ctx, q, id, hash); err != nil {
+		return generated.User{}, false, nil, err
+	}
+
+	row, err := q.UpsertUser(ctx, generated.UpsertUserParams{
+		ID:        id,
+		TenantID:  tenant.FromContext(ctx),
+		Name:      name,
+		Email:     sealed,
+		EmailHash: hash,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.User{}, false, nil, ErrIDTaken
+	}
+	if isDuplicateEmail(err) {
+		return generated.User{}, false, nil, ErrDuplicateEmail
+	}
+	if err != nil {
+		return generated.User{}, false, nil, fmt.Errorf("could not save user: %w", err)
+	}
+	if row.Inserted && !create {
+		return generated.User{}, false, nil, ErrNotFound
+	}
+	user := generated.User{
+		ID:                    row.ID,
+		Name:                  row.Name,
+		Email:                 row.Email,
+		CreatedAt:             row.CreatedAt,
+		DeletedAt:             row.DeletedAt,
+		TenantID:              row.TenantID,
+		EmailHash:             row.EmailHash,
+		PendingEmail:          row.PendingEmail,
+		PendingEmailHash:      row.PendingEmailHash,
+		EmailTokenHash:        row.EmailTokenHash,
+		PendingEmailExpiresAt: row.PendingEmailExpiresAt,
+		IsActive:              row.IsActive,
+	}
+	if err := r.openUser(&user); err != nil {
+		return generated.User{}, false, nil, err
+	}
+
+	var change *EmailChange
+	switch {
+	case row.Inserted:
+		// The ID didn't come from the sequence, which would otherwise hand it out again
+		if err := q.SyncUserIDSequence(ctx); err != nil {
+			return generated.User{}, false, nil, fmt.Errorf("could not advance user ID sequence: %w", err)
+		}
+	case row.EmailHash != hash:
+		if change, err = r.setPendingEmail(ctx, q, &user, email, sealed, hash); err != nil {
+			return generated.User{}, false, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return generated.User{}, false, nil, fmt.Errorf("could not save user: %w", err)
+	}
+	return user, row.Inserted, change, nil
+}
+
+// UserPatch changes the fields of one user that are set. Active activates or
+// deactivates the user as SetActive does.
+type UserPatch struct {
+	ID     int32
+	Name   *string
+	Email  *string
+	Active *bool
+}
+
+// PatchOutcome is what became of one UserPatch; Err is nil, ErrNotFound or
+// ErrDuplicateEmail. ActiveChanged reports whether the patch activated or
+// deactivated the user.
+type PatchOutcome struct {
+	User          generated.User
+	ActiveChanged bool
+	Err           error
+}
+
+// PatchUsers applies patches in order in one transaction, recording an audit entry
+// by actorID for every user changed. A patch that fails is undone on its own and the
+// rest still apply, unless atomic is set: then the first failure rolls everything
+// back and the outcomes stop at it. It reports whether the transaction was committed.
+func (r *Repository) PatchUsers(ctx context.Context, actorID string, patches []UserPatch, atomic bool) ([]PatchOutcome, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not update users: %w", err)
+	}
+	defer tx.Rollback()
+	q := r.q.WithTx(tx)
+
+	outcomes := make([]PatchOutcome, 0, len(patches))
+	for _, p := range patches {
+		// A failed statement aborts a Postgres transaction, so each patch gets a
+		// savepoint to roll back to
+		if !atomic {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT patch"); err != nil {
+				return nil, false, fmt.Errorf("could not update users: %w", err)
+			}
+		}
+
+		outcome := r.patchUser(ctx, q, actorID, p)
+		outcomes = append(outcomes, outcome)
+		err := outcome.Err
+		if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrDuplicateEmail) {
+			return nil, false, err
+		}
+
+		switch {
+		case err != nil && atomic:
+			return outcomes, false, nil
+		case err != nil:
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT patch")
+		case !atomic:
+			_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT patch")
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("could not update users: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("could not update users: %w", err)
+	}
+	return outcomes, true, nil
+}
+
+func (r *Repository) patchUser(ctx context.Context, q *generated.Queries, actorID string, p UserPatch) PatchOutcome {
+	var outcome PatchOutcome
+	if p.Name != nil || p.Email != nil {
+		outcome.User, outcome.Err = r.patchFields(ctx, q, actorID, p)
+		if outcome.Err != nil {
+			return outcome
+		}
+	}
+	if p.Active != nil {
+		// Read back after the other fields, so the user returned has them all
+		outcome.User, outcome.ActiveChanged, outcome.Err = r.changeActive(ctx, q, actorID, p.ID, *p.Active)
+	}
+	return outcome
 }
 
-// DeleteUser deletes a user from the database
+// patchFields applies the name and email of p, recording them under AuditBulkUpdate
+func (r *Repository) patchFields(ctx context.Context, q *generated.Queries, actorID string, p UserPatch) (generated.User, error) {
+	params := generated.PatchUserParams{ID: p.ID, TenantID: tenant.FromContext(ctx)}
+	changed := []string{}
+	if p.Name != nil {
+		params.Name = sql.NullString{String: *p.Name, Valid: true}
+		changed = append(changed, "name")
+	}
+	if p.Email != nil {
+		sealed, hash, err := r.sealEmail(*p.Email)
+		if err != nil {
+			return generated.User{}, err
+		}
+		if err := r.claimEmail(ctx, q, p.ID, hash); err != nil {
+			return generated.User{}, err
+		}
+		params.Email = sql.NullString{String: sealed, Valid: true}
+		params.EmailHash = hash
+		changed = append(changed, "email")
+	}
+
+	user, err := q.PatchUser(ctx, params)
+	if errors.Is(err, sql.ErrNoRows) {
+		return generated.User{}, ErrNotFound
+	}
+	if isDuplicateEmail(err) {
+		return generated.User{}, ErrDuplicateEmail
+	}
+	if err != nil {
+		return generated.User{}, fmt.Errorf("could not update user %d: %w", p.ID, err)
+	}
+	if err := r.openUser(&user); err != nil {
+		return generated.User{}, err
+	}
+
+	// Only the names of the fields: the audit log must not become a copy of the PII
+	err = r.recordAudit(ctx, q, actorID, AuditBulkUpdate, strconv.Itoa(int(user.ID)), map[string][]string{"fields": changed})
+	return user, err
+}
+
+// DeleteUser soft-deletes a user; the row is hard-deleted later by the Purger
 func (r *Repository) DeleteUser(ctx context.Context, id int32) error {
-	err := r.q.DeleteUser(ctx, id)
+	deleted, err := r.q.DeleteUser(ctx, generated.DeleteUserParams{
+		ID:        id,
+		TenantID:  tenant.FromContext(ctx),
+		DeletedAt: sql.NullTime{Time: r.clock.Now().UTC(), Valid: true},
+	})
 	if err != nil {
 		return fmt.Errorf("could not delete user: %w", err)
 	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
+// DeleteUsers soft-deletes the users with the given IDs in the caller's tenant in one
+// statement, returning the IDs that existed and were deleted
+func (r *Repository) DeleteUsers(ctx context.Context, ids []int32) ([]int32, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not delete users: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := r.q.WithTx(tx).DeleteUsers(ctx, generated.DeleteUsersParams{
+		Ids:       ids,
+		TenantID:  tenant.FromContext(ctx),
+		DeletedAt: sql.NullTime{Time: r.clock.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not delete users: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not delete users: %w", err)
+	}
+	if deleted == nil {
+		deleted = []int32{}
+	}
+	return deleted, nil
+}
+
+// RecordAudit writes an audit log entry for a staff action on target in the caller's
+// tenant. To record a change, use recordAudit in the change's transaction instead.
+func (r *Repository) RecordAudit(ctx context.Context, actorID, action, targetID string, detail any) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not record audit entry: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := r.recordAudit(ctx, r.q.WithTx(tx), actorID, action, targetID, detail); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not record audit entry: %w", err)
+	}
+	return nil
+}
+
+// PurgeDeletedUsers hard-deletes up to limit users soft-deleted before cutoff, across all tenants
+func (r *Repository) PurgeDeletedUsers(ctx context.Context, cutoff time.Time, limit int32) (int64, error) {
+	purged, err := r.q.PurgeDeletedUsers(ctx, generated.PurgeDeletedUsersParams{
+		DeletedAt: sql.NullTime{Time: cutoff, Valid: true},
+		Limit:     limit,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not purge deleted users: %w", err)
+	}
+	return purged, nil
+}
+
+// TenantExists reports whether a tenant is registered
+func (r *Repository) TenantExists(ctx context.Context, id string) (bool, error) {
+	exists, err := r.q.TenantExists(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("could not check tenant: %w", err)
+	}
+	return exists, nil
+}
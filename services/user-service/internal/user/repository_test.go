@@ -0,0 +1,236 @@
+package user
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"shared/database/dbtest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"user-service/internal/pii"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+// upsertColumns are the columns UpsertUser returns: userColumns and inserted
+var upsertColumns = append(slices.Clone(userColumns), "inserted")
+
+// expectUpsertBegin expects UpsertUser to open its transaction and find email unclaimed
+func expectUpsertBegin(mock sqlmock.Sqlmock, id int32) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock")).
+		WithArgs(testTenant, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(testTenant, id, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+}
+
+// expectUpsert expects the upsert of user id with name and email, answering with
+// the row as stored: created or not, and holding the email with emailHash
+func expectUpsert(mock sqlmock.Sqlmock, id int32, name, email, emailHash string, inserted bool) *sqlmock.ExpectedQuery {
+	return mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (id, tenant_id, name, email, email_hash)")).
+		WithArgs(id, testTenant, name, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(upsertColumns).
+			AddRow(id, name, email, nil, nil, testTenant, emailHash, nil, nil, nil, nil, true, inserted))
+}
+
+// expectSyncSequence expects the user ID sequence to be moved past explicit IDs
+func expectSyncSequence(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT setval(pg_get_serial_sequence('users', 'id')")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestUpsertUserCreatesWithExplicitID(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	hash := repo.cipher.BlindIndex("ann@example.com")
+
+	expectUpsertBegin(mock, 42)
+	expectUpsert(mock, 42, "Ann", "ann@example.com", hash, true)
+	// 42 didn't come from the sequence, which must not hand it out again
+	expectSyncSequence(mock)
+	mock.ExpectCommit()
+
+	user, created, change, err := repo.UpsertUser(tenantContext(admin), 42, "Ann", "ann@example.com", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("created = false, want true")
+	}
+	if change != nil {
+		t.Errorf("change = %+v, want none for a new user", change)
+	}
+	if user.ID != 42 || user.Email != "ann@example.com" {
+		t.Errorf("user = %d %q, want 42 ann@example.com", user.ID, user.Email)
+	}
+}
+
+func TestUpsertUserReplacesExistingUser(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	hash := repo.cipher.BlindIndex("ann@example.com")
+
+	// An existing user leaves the sequence alone, and keeps an unchanged email
+	expectUpsertBegin(mock, 42)
+	expectUpsert(mock, 42, "Ann Lee", "ann@example.com", hash, false)
+	mock.ExpectCommit()
+
+	user, created, change, err := repo.UpsertUser(tenantContext(admin), 42, "Ann Lee", "ann@example.com", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Error("created = true, want false")
+	}
+	if change != nil {
+		t.Errorf("change = %+v, want none for an unchanged email", change)
+	}
+	if user.Name != "Ann Lee" {
+		t.Errorf("name = %q, want Ann Lee", user.Name)
+	}
+}
+
+func TestUpsertUserRefusesIDOfAnotherTenant(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// The conflicting row is in another tenant, so the upsert updates nothing
+	expectUpsertBegin(mock, 42)
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (id, tenant_id, name, email, email_hash)")).
+		WithArgs(42, testTenant, "Ann", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, _, _, err := repo.UpsertUser(tenantContext(admin), 42, "Ann", "ann@example.com", true)
+	if !errors.Is(err, ErrIDTaken) {
+		t.Errorf("err = %v, want ErrIDTaken", err)
+	}
+}
+
+func TestUpsertUserWithoutCreateLeavesMissingUser(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// The insert is rolled back, and the sequence isn't touched
+	expectUpsertBegin(mock, 42)
+	expectUpsert(mock, 42, "Ann", "ann@example.com", repo.cipher.BlindIndex("ann@example.com"), true)
+	mock.ExpectRollback()
+
+	_, _, _, err := repo.UpsertUser(tenantContext(admin), 42, "Ann", "ann@example.com", false)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestUpsertUserFailsWhenSequenceCannotAdvance(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Committing without moving the sequence would let CreateUser collide with 42 later
+	expectUpsertBegin(mock, 42)
+	expectUpsert(mock, 42, "Ann", "ann@example.com", repo.cipher.BlindIndex("ann@example.com"), true)
+	mock.ExpectExec(regexp.QuoteMeta("SELECT setval")).WillReturnError(errors.New("permission denied for sequence"))
+	mock.ExpectRollback()
+
+	if _, _, _, err := repo.UpsertUser(tenantContext(admin), 42, "Ann", "ann@example.com", true); err == nil {
+		t.Error("err = nil, want the sequence error")
+	}
+}
+
+func TestListUsersIsScopedToTenant(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// The caller's tenant is bound as $1, so other tenants' rows can't be listed
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users\nWHERE tenant_id = $1")).
+		WithArgs(testTenant, nil, 21, 0).
+		WillReturnRows(userRows(1, 2))
+
+	users, err := repo.ListUsers(tenantContext(admin), nil, 21, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Errorf("got %d users, want 2", len(users))
+	}
+}
+
+// usersTable stands in for the users table without its unique index on
+// email_hash, as in an environment whose migrations haven't added it yet
+type usersTable struct {
+	mu      sync.Mutex
+	claimed map[string]bool // email hashes of committed users
+	lastID  int32
+}
+
+// handle answers the statements CreateUser makes besides its advisory lock
+func (u *usersTable) handle(tx *dbtest.Tx, query string, args []driver.Value) (*dbtest.Result, error) {
+	switch {
+	case strings.Contains(query, "-- name: EmailClaimed"):
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return &dbtest.Result{Columns: []string{"exists"}, Rows: [][]driver.Value{{u.claimed[args[2].(string)]}}}, nil
+	case strings.Contains(query, "-- name: CreateUser"):
+		// Give racing transactions time to check the email before this one commits
+		time.Sleep(time.Millisecond)
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		u.lastID++
+		hash := args[3].(string)
+		tx.OnCommit(func() {
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			u.claimed[hash] = true
+		})
+		return &dbtest.Result{Columns: userColumns, Rows: [][]driver.Value{
+			{int64(u.lastID), args[1], args[2], nil, nil, args[0], hash, nil, nil, nil, nil, true},
+		}}, nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func TestConcurrentCreatesOfOneEmailLetOneWin(t *testing.T) {
+	users := &usersTable{claimed: map[string]bool{}}
+	db := (&dbtest.DB{Handle: users.handle}).Open()
+	defer db.Close()
+	cipher, err := pii.NewCipher(make([]byte, pii.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(sqlx.NewDb(db, "postgres"), cipher, WithReadRetry(false))
+
+	// 50 signups of one address, differing only in case, all released at once
+	const n = 50
+	start := make(chan struct{})
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		email := "ann@example.com"
+		if i%2 == 1 {
+			email = "Ann@Example.com"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, errs[i] = repo.CreateUser(tenantContext(admin), fmt.Sprintf("Ann %d", i), email)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrDuplicateEmail):
+			t.Errorf("create %d: err = %v, want ErrDuplicateEmail", i, err)
+		}
+	}
+	if created != 1 || users.lastID != 1 {
+		t.Errorf("%d creates succeeded and %d rows were inserted, want one of each", created, users.lastID)
+	}
+}
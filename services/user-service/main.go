@@ -2,16 +2,30 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"shared/admin"
+	"shared/auth"
+	"shared/chaos"
+	"shared/database"
+	"shared/dbretry"
+	"shared/events"
+	"shared/featureflag"
+	"shared/health"
+	"shared/httpx"
+	"shared/oplog"
+	"shared/querylog"
+	"shared/shutdown"
+	"shared/statsz"
+	"shared/tenant"
+	"sync"
 	"syscall"
-	"time"
-	"user-service/internal/db"
+	"user-service/internal/pii"
 	"user-service/internal/user"
+	"user-service/migrations"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
@@ -21,46 +35,243 @@ import (
 func main() {
 	_ = godotenv.Load()
 
-	conn, err := db.Connect()
+	dbConfig, err := database.ConfigFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer conn.Close()
+	conn, err := database.Connect(dbConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Readiness is reported on /readyz; required dependencies must be up before we start
+	deps := health.New(health.Dependency{Name: "postgres", Required: true, Check: conn.PingContext})
+
+	startupWait, err := health.StartupWaitFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := deps.WaitForRequired(context.Background(), startupWait); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Connected to Postgres")
+	database.Warmup(context.Background(), conn, dbConfig)
+
+	// Migrations are embedded in the binary; MIGRATIONS_DIR reads them from disk in development
+	migrationsFS, err := database.MigrationsFromEnv(migrations.FS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Versions are kept in users_schema_migrations
+	if err := database.Migrate(conn, database.Migrations{FS: migrationsFS, Service: "users"}); err != nil {
+		log.Fatal(err)
+	}
 
-	if err := db.Migrate(conn); err != nil {
+	// Emails are encrypted at rest with a data key unwrapped at startup
+	cipher, err := pii.FromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Could not load PII keys (generate them with go run ./cmd/encrypt-pii -generate-keys): %v", err)
+	}
+
+	slowQueries, err := querylog.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	readRetry, err := dbretry.EnabledFromEnv()
+	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Request counts behind /admin/statsz, for environments without Prometheus
+	stats := statsz.New()
+
 	// Create a multiplexer (router)
 	mux := http.NewServeMux()
-	repo := user.NewRepository(conn)
-	handler := user.NewHandler(repo)
+	repo := user.NewRepository(conn, cipher, user.WithSlowQueryLog(slowQueries), user.WithReadRetry(readRetry))
+	flags := featureflag.New(user.Flags...)
+	maxBatchSize, err := httpx.MaxBatchSizeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxResultRows, err := httpx.MaxResultRowsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	impersonation, err := user.ImpersonationConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	emailNorm, err := user.EmailNormalizationFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	nameMaxLen, err := httpx.NameMaxLenFromEnv("USER_NAME_MAX_LEN")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Email changes are confirmed with a token that only reaches the new address
+	// through user.email_change_requested events
+	publisher, err := events.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pinger, ok := publisher.(events.Pinger); ok {
+		deps.Register(health.Dependency{Name: "events", Required: false, Check: pinger.Ping})
+	}
+	if _, ok := publisher.(events.Nop); ok {
+		log.Printf("WARNING: EVENT_SINK is not set; email changes can't be confirmed")
+	}
+
+	handler := user.NewHandler(repo, flags,
+		user.WithMaxBatchSize(maxBatchSize),
+		user.WithMaxResultRows(maxResultRows),
+		user.WithImpersonation(impersonation),
+		user.WithEmailNormalization(emailNorm),
+		user.WithNameMaxLen(nameMaxLen),
+		user.WithPublisher(publisher),
+		user.WithAuditSigningKey(user.AuditSigningKeyFromEnv()),
+	)
+
+	// Background jobs stop when jobsCtx is cancelled during shutdown
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	var jobs sync.WaitGroup
+
+	purgerCfg, err := user.PurgerConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobs.Go(func() { user.NewPurger(repo, purgerCfg).Run(jobsCtx) })
 
 	// Add a route handler
-	mux.HandleFunc("/health", healthHandler(conn))
-	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			handler.ListUsers(w, r)
-		case http.MethodPost:
-			handler.CreateUser(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	mux.HandleFunc("/health", healthHandler(conn, flags))
+	mux.HandleFunc("/readyz", deps.ReadyHandler())
+	mux.Handle("/admin/flags", admin.RequireToken(admin.TokenFromEnv(), flags.Handler()))
+	mux.Handle("GET /admin/statsz", admin.RequireToken(admin.TokenFromEnv(), stats.Handler(conn.DB)))
 
-	mux.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			handler.GetUser(w, r)
-		case http.MethodPut:
-			handler.UpdateUser(w, r)
-		case http.MethodDelete:
-			handler.DeleteUser(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
+	// Fault injection for resilience testing; never on in production
+	chaosEnabled, err := chaos.EnabledFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var injector *chaos.Injector
+	if chaosEnabled {
+		injector = chaos.New(mux)
+		mux.Handle("/admin/chaos", admin.RequireToken(admin.TokenFromEnv(), injector.Handler()))
+		log.Printf("WARNING: CHAOS_ENABLED is set; faults can be injected through /admin/chaos")
+	}
+
+	// Resource routes are scoped to the tenant set by the gateway
+	withTenant := tenant.Middleware(repo.TenantExists)
+
+	mux.Handle("/users", withTenant(httpx.Methods{
+		http.MethodGet:  handler.ListUsers,
+		http.MethodPost: handler.CreateUser,
+	}))
+
+	mux.Handle("/users/export", withTenant(httpx.Methods{
+		http.MethodGet: handler.ExportUsers,
+	}))
+
+	// Bulk deletes can be switched off at runtime via /admin/flags
+	mux.Handle("/users/bulk-delete", featureflag.Gate(flags, user.FlagBulkOperations, withTenant(httpx.Methods{
+		http.MethodPost: handler.BulkDeleteUsers,
+	})))
+
+	mux.Handle("/users/bulk-update", withTenant(httpx.Methods{
+		http.MethodPost: handler.BulkUpdateUsers,
+	}))
+
+	mux.Handle("/users/confirm-email", withTenant(httpx.Methods{
+		http.MethodGet:  handler.ConfirmEmail,
+		http.MethodPost: handler.ConfirmEmail,
+	}))
+
+	mux.Handle("/users/{id}/pending-email", withTenant(httpx.Methods{
+		http.MethodDelete: handler.CancelEmailChange,
+	}))
+
+	// Deactivation keeps the user, unlike DELETE, and is undone by activate
+	mux.Handle("/users/{id}/deactivate", withTenant(httpx.Methods{
+		http.MethodPost: handler.DeactivateUser,
+	}))
+	mux.Handle("/users/{id}/activate", withTenant(httpx.Methods{
+		http.MethodPost: handler.ActivateUser,
+	}))
+
+	mux.Handle("/users/{id}", withTenant(httpx.Methods{
+		http.MethodGet:    handler.GetUser,
+		http.MethodPut:    handler.PutUser,
+		http.MethodDelete: handler.DeleteUser,
+	}))
+
+	// Impersonation is also served under /users so it is reachable through the gateway
+	impersonateRoute := withTenant(httpx.Methods{
+		http.MethodPost: handler.Impersonate,
 	})
+	mux.Handle("/admin/impersonate/{userID}", impersonateRoute)
+	mux.Handle("/users/{userID}/impersonate", impersonateRoute)
+
+	// The audit log is hash chained; exports are signed with AUDIT_SIGNING_KEY
+	mux.Handle("/admin/audit/export", withTenant(httpx.Methods{
+		http.MethodGet: handler.ExportAudit,
+	}))
+	mux.Handle("/admin/audit/verify", withTenant(httpx.Methods{
+		http.MethodGet: handler.VerifyAudit,
+	}))
+
+	// Mutating requests are recorded before they run, for support investigations
+	oplogCfg, err := oplog.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	operations := oplog.FromEnv(oplogCfg, conn.DB)
+	if db, ok := operations.(*oplog.DBStore); ok {
+		jobs.Go(func() { db.RunPruner(jobsCtx) })
+	}
+	mux.Handle("GET /admin/operations", admin.RequireToken(admin.TokenFromEnv(), oplog.Handler(operations)))
+
+	routeTimeouts, err := httpx.RouteTimeoutsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shutdownBudget, err := shutdown.BudgetFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequired, err := auth.RequiredFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	contentTypes, err := httpx.ContentTypeModeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errorLanguages, err := httpx.LanguagesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The gateway's address belongs in TRUSTED_PROXIES, or every request's client is the gateway
+	trustedProxies, err := httpx.TrustedProxiesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Authorization and injected faults run inside the route deadline
+	var root http.Handler = mux
+	root = injector.Middleware(root)
+	root = oplog.New(operations, user.Operations, oplogCfg).Middleware(mux)(root)
+	root = auth.Authorize(mux, user.Access, authRequired)(root)
+	root = httpx.Timeouts(mux, routeTimeouts)(root)
+	root = httpx.ContentTypes(contentTypes)(root)
+	root = httpx.ErrorLanguages(errorLanguages)(root)
+	root = stats.Middleware(root)
+	root = httpx.ClientIPs(trustedProxies)(root)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -73,7 +284,7 @@ func main() {
 	// Http server struct
 	server := &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: root,
 	}
 
 	// Channel to listen for OS signals
@@ -92,34 +303,40 @@ func main() {
 	<-stop
 	log.Println("Shutting down server...")
 
-	// Give outstanding requests time to finish
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Stop accepting requests and drain in-flight ones first, then stop background
+	// jobs, so nothing is still writing when events are flushed and the database closes
+	var seq shutdown.Sequence
+	seq.Add("http server", server.Shutdown)
+	seq.Add("background jobs", func(ctx context.Context) error {
+		stopJobs()
+		return shutdown.Wait(jobs.Wait)(ctx)
+	})
+	seq.AddCloser("event publisher", publisher.Close)
+	seq.AddCloser("database", conn.Close)
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Error During shutdown: %v", err)
+	if err := seq.Run(shutdownBudget); err != nil {
+		log.Fatalf("Error during shutdown: %v", err)
 	}
 
 	log.Println("Server gracefully stopped.")
 }
 
-func healthHandler(db *sqlx.DB) http.HandlerFunc {
+func healthHandler(db *sqlx.DB, flags *featureflag.Set) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Set response type to JSON
-		w.Header().Set("Content-Type", "Application/json")
-
 		// Check database connectivity
 		err := db.Ping()
-		status := "ok"
+		status, code := "ok", http.StatusOK
 		if err != nil {
-			status = "db_unreachable"
+			status, code = "db_unreachable", http.StatusServiceUnavailable
 			log.Printf("Health check failed: %v", err)
-			w.WriteHeader(http.StatusServiceUnavailable)
 		}
 
 		// Write JSON response
-		json.NewEncoder(w).Encode(map[string]string{"status": status})
+		httpx.WriteJSON(w, r, code, map[string]any{
+			"status":               status,
+			"flags":                flags.Snapshot(),
+			"pii_decrypt_failures": user.DecryptFailures(),
+		})
 	}
 }
-
@@ -0,0 +1,12 @@
+-- Sensitive actions taken by staff, e.g. impersonating a user
+CREATE TABLE IF NOT EXISTS audit_log (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id VARCHAR(64) NOT NULL REFERENCES tenants (id),
+  actor_id VARCHAR(64) NOT NULL,
+  action VARCHAR(64) NOT NULL,
+  target_id VARCHAR(64) NOT NULL,
+  detail JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_tenant_idx ON audit_log (tenant_id, created_at);
@@ -0,0 +1,4 @@
+-- Encrypted emails stay encrypted; the column is left as TEXT to hold them
+DROP INDEX IF EXISTS users_tenant_email_hash_key;
+ALTER TABLE users DROP COLUMN IF EXISTS email_hash;
+ALTER TABLE users ADD CONSTRAINT users_tenant_email_key UNIQUE (tenant_id, email);
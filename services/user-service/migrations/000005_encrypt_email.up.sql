@@ -0,0 +1,10 @@
+-- Emails are encrypted by the application: email holds the ciphertext, which is
+-- longer than the address, and email_hash a keyed hash of the normalized address
+-- that enforces uniqueness. Existing rows are encrypted by cmd/encrypt-pii.
+ALTER TABLE users ALTER COLUMN email TYPE TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_hash VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_email_hash_key ON users (tenant_id, email_hash);
+
+-- Ciphertexts never repeat, so a constraint on email can't catch duplicates
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_tenant_email_key;
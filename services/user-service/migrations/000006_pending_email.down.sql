@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS users_tenant_pending_email_hash_idx;
+DROP INDEX IF EXISTS users_email_token_hash_key;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS email_token_hash;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email_hash;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
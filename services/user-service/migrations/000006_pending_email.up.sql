@@ -0,0 +1,11 @@
+-- An email change waits here until the new address is confirmed. pending_email
+-- is encrypted and pending_email_hash is its blind index, like email and
+-- email_hash; only a hash of the confirmation token is stored.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email_hash VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_token_hash VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email_expires_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_token_hash_key ON users (email_token_hash);
+-- Pending emails are checked against other users' emails when claimed; this makes the check cheap
+CREATE INDEX IF NOT EXISTS users_tenant_pending_email_hash_idx ON users (tenant_id, pending_email_hash);
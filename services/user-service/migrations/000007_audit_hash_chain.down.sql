@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS audit_chain_heads;
+DROP INDEX IF EXISTS audit_log_tenant_seq_key;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS hash;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS prev_hash;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS seq;
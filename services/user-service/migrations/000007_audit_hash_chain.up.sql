@@ -0,0 +1,16 @@
+-- Audit entries are hash chained per tenant: hash is SHA-256 over prev_hash and
+-- the entry's canonical content, so an edit or a missing entry breaks the chain.
+-- Entries written before this migration have no seq and are not covered.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS seq BIGINT;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS prev_hash BYTEA;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS hash BYTEA;
+
+CREATE UNIQUE INDEX IF NOT EXISTS audit_log_tenant_seq_key ON audit_log (tenant_id, seq);
+
+-- The last entry of each tenant's chain. Writers lock this row to append, so
+-- entries get consecutive seq values even under concurrent writes.
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+  tenant_id VARCHAR(64) PRIMARY KEY REFERENCES tenants (id),
+  seq BIGINT NOT NULL,
+  hash BYTEA NOT NULL
+);
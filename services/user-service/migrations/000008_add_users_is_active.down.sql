@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_tenant_inactive_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS is_active;
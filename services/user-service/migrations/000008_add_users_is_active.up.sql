@@ -0,0 +1,6 @@
+-- A deactivated user is kept, and can still be read, but is refused sign-in and
+-- impersonation until activated again. Deletion is separate and removes the user.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT true;
+
+-- ?active=false lists a tenant's deactivated users, which are few
+CREATE INDEX IF NOT EXISTS users_tenant_inactive_idx ON users (tenant_id, id) WHERE NOT is_active;
@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"io/fs"
+	"os"
+	"regexp"
+	"shared/database"
+	"strconv"
+	"testing"
+)
+
+// service is the name database.Migrate keeps these migrations' version under
+const service = "users"
+
+// migrationName is how golang-migrate names a migration file
+var migrationName = regexp.MustCompile(`^(\d+)_\w+\.(up|down)\.sql$`)
+
+// versions checks that every embedded migration has an up and a down file and
+// that versions run from 1 without gaps, and returns the last
+func versions(t *testing.T) int {
+	t.Helper()
+	names, err := fs.Glob(FS, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[int]map[string]bool{}
+	for _, name := range names {
+		m := migrationName.FindStringSubmatch(name)
+		if m == nil {
+			t.Errorf("%s: not named NNNNNN_name.up.sql or .down.sql", name)
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		if files[version] == nil {
+			files[version] = map[string]bool{}
+		}
+		files[version][m[2]] = true
+	}
+	for version := 1; version <= len(files); version++ {
+		if !files[version]["up"] || !files[version]["down"] {
+			t.Errorf("version %d: want an up and a down migration, have %v", version, files[version])
+		}
+	}
+	return len(files)
+}
+
+func TestMigrationsAreComplete(t *testing.T) {
+	if last := versions(t); last == 0 {
+		t.Error("no migrations embedded")
+	}
+}
+
+// TestMigrateAgainstPostgres applies the migrations through the shared database
+// package. It needs a Postgres to write to, so only runs with TEST_DATABASE_URL
+// set, as make test-integration does.
+func TestMigrateAgainstPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	conn, err := database.Connect(database.Config{URL: url, Logf: t.Logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	m := database.Migrations{FS: FS, Service: service, Logf: t.Logf}
+	// The second run finds nothing to do, as a restarted replica does
+	for range 2 {
+		if err := database.Migrate(conn, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var version int
+	var dirty bool
+	if err := conn.QueryRow("SELECT version, dirty FROM "+m.Table()).Scan(&version, &dirty); err != nil {
+		t.Fatal(err)
+	}
+	if want := versions(t); version != want || dirty {
+		t.Errorf("%s at version %d (dirty %v), want %d", m.Table(), version, dirty, want)
+	}
+}
@@ -0,0 +1,24 @@
+-- name: LockAuditHead :one
+-- Returns the head of a tenant's audit chain, creating an empty one, and locks it
+-- until the transaction ends so concurrent writers append one at a time
+INSERT INTO audit_chain_heads (tenant_id, seq, hash)
+VALUES ($1, 0, '')
+ON CONFLICT (tenant_id) DO UPDATE SET seq = audit_chain_heads.seq
+RETURNING tenant_id, seq, hash;
+
+-- name: RecordAudit :exec
+INSERT INTO audit_log (tenant_id, actor_id, action, target_id, detail, created_at, seq, prev_hash, hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: AdvanceAuditHead :exec
+UPDATE audit_chain_heads SET seq = $2, hash = $3
+WHERE tenant_id = $1;
+
+-- name: GetAuditHead :one
+SELECT tenant_id, seq, hash FROM audit_chain_heads
+WHERE tenant_id = $1;
+
+-- name: CountUnchainedAudit :one
+-- Counts the entries written before the audit log was hash chained
+SELECT COUNT(*) FROM audit_log
+WHERE tenant_id = $1 AND seq IS NULL;
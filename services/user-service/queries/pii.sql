@@ -0,0 +1,13 @@
+-- name: CountUnencryptedUsers :one
+SELECT COUNT(*) FROM users WHERE email_hash IS NULL;
+
+-- name: ListUnencryptedUsers :many
+-- Covers every tenant and soft-deleted rows, which still hold the plaintext
+SELECT id, email FROM users
+WHERE id > $1 AND email_hash IS NULL
+ORDER BY id
+LIMIT $2;
+
+-- name: SetEncryptedEmail :execrows
+UPDATE users SET email = $2, email_hash = $3
+WHERE id = $1 AND email_hash IS NULL;
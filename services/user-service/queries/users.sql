@@ -1,19 +1,99 @@
 -- name: ListUsers :many
-SELECT id, name, email, created_at FROM users ORDER BY id;
+-- Active NULL lists every user; true or false only those active or deactivated
+SELECT id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active FROM users
+WHERE tenant_id = sqlc.arg(tenant_id) AND deleted_at IS NULL
+  AND (sqlc.narg(active)::boolean IS NULL OR is_active = sqlc.narg(active))
+ORDER BY id
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
 
 -- name: GetUser :one
-SELECT id, name, email, created_at FROM users WHERE id = $1;
+SELECT id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active FROM users
+WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL;
 
 -- name: CreateUser :one
-INSERT INTO users (name, email)
-VALUES ($1, $2)
-RETURNING id, name, email, created_at;
+INSERT INTO users (tenant_id, name, email, email_hash)
+VALUES ($1, $2, $3, $4)
+RETURNING id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active;
 
--- name: UpdateUser :one
+-- name: UpsertUser :one
+-- Returns no row when the ID belongs to another tenant or a deleted user, which
+-- are never overwritten. inserted is true when the row was created. An existing
+-- user keeps their email: changing it goes through SetPendingEmail.
+INSERT INTO users (id, tenant_id, name, email, email_hash)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE
+SET name = EXCLUDED.name
+WHERE users.tenant_id = EXCLUDED.tenant_id AND users.deleted_at IS NULL
+RETURNING id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active, (xmax = 0) AS inserted;
+
+-- name: SyncUserIDSequence :exec
+-- Moves the ID sequence past IDs chosen by UpsertUser, so CreateUser never hands them out again
+SELECT setval(pg_get_serial_sequence('users', 'id'), GREATEST((SELECT MAX(id) FROM users), nextval(pg_get_serial_sequence('users', 'id'))));
+
+-- name: PatchUser :one
+-- Changes only the fields given; the others are passed as NULL
+UPDATE users
+SET name = COALESCE(sqlc.narg(name), name),
+    email = COALESCE(sqlc.narg(email), email),
+    email_hash = COALESCE(sqlc.narg(email_hash), email_hash)
+WHERE id = sqlc.arg(id) AND tenant_id = sqlc.arg(tenant_id) AND deleted_at IS NULL
+RETURNING id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active;
+
+-- name: SetPendingEmail :execrows
+-- Replaces any earlier pending change, whose token stops working
 UPDATE users
-SET name = $2, email = $3
-WHERE id = $1
-RETURNING id, name, email, created_at;
+SET pending_email = sqlc.arg(pending_email), pending_email_hash = sqlc.arg(pending_email_hash), email_token_hash = sqlc.arg(email_token_hash), pending_email_expires_at = sqlc.arg(pending_email_expires_at)
+WHERE id = sqlc.arg(id) AND tenant_id = sqlc.arg(tenant_id) AND deleted_at IS NULL;
+
+-- name: ConfirmEmail :one
+-- Applies the pending change whose token hashes to email_token_hash, unless it has expired
+UPDATE users
+SET email = pending_email, email_hash = pending_email_hash,
+    pending_email = NULL, pending_email_hash = NULL, email_token_hash = NULL, pending_email_expires_at = NULL
+WHERE tenant_id = sqlc.arg(tenant_id) AND email_token_hash = sqlc.arg(email_token_hash) AND pending_email_expires_at > sqlc.arg(now) AND deleted_at IS NULL
+RETURNING id, name, email, created_at, deleted_at, tenant_id, email_hash, pending_email, pending_email_hash, email_token_hash, pending_email_expires_at, is_active;
+
+-- name: CancelPendingEmail :execrows
+UPDATE users
+SET pending_email = NULL, pending_email_hash = NULL, email_token_hash = NULL, pending_email_expires_at = NULL
+WHERE id = $1 AND tenant_id = $2 AND pending_email IS NOT NULL AND deleted_at IS NULL;
+
+-- name: LockEmail :exec
+-- Serializes transactions claiming the same email in a tenant until they end
+SELECT pg_advisory_xact_lock(hashtextextended(sqlc.arg(tenant_id)::text || ':' || sqlc.arg(email_hash)::text, 0));
+
+-- name: EmailClaimed :one
+-- Reports whether another user has the email, or has asked to change to it and
+-- may still confirm. Call it after LockEmail so the answer holds until commit.
+SELECT EXISTS (
+  SELECT 1 FROM users
+  WHERE tenant_id = sqlc.arg(tenant_id) AND id <> sqlc.arg(id)
+    AND (email_hash = sqlc.arg(email_hash) OR (pending_email_hash = sqlc.arg(email_hash) AND pending_email_expires_at > sqlc.arg(now) AND deleted_at IS NULL))
+);
+
+-- name: SetUserActive :one
+-- Returns no row for a missing or deleted user. was_active is the state before,
+-- so a repeated call can be told apart from a change.
+UPDATE users u SET is_active = $3
+FROM (
+  SELECT id, is_active FROM users
+  WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+  FOR UPDATE
+) old
+WHERE u.id = old.id
+RETURNING u.id, u.name, u.email, u.created_at, u.deleted_at, u.tenant_id, u.email_hash, u.pending_email, u.pending_email_hash, u.email_token_hash, u.pending_email_expires_at, u.is_active, old.is_active AS was_active;
+
+-- name: DeleteUser :execrows
+UPDATE users SET deleted_at = $3
+WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE id IN (
+  SELECT id FROM users WHERE deleted_at < $1 ORDER BY id LIMIT $2
+);
 
--- name: DeleteUser :exec
-DELETE FROM users WHERE id = $1;
+-- name: DeleteUsers :many
+UPDATE users SET deleted_at = sqlc.arg(deleted_at)
+WHERE id = ANY(sqlc.arg(ids)::int[]) AND tenant_id = sqlc.arg(tenant_id) AND deleted_at IS NULL
+RETURNING id;
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"strings"
+)
+
+// TokenFromEnv returns the admin token configured via ADMIN_TOKEN
+func TokenFromEnv() string {
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// RequireToken protects an admin handler with a shared token, sent either as
+// "Authorization: Bearer <token>" or "X-Admin-Token: <token>".
+// When no token is configured the admin endpoints are disabled entirely.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			httpx.Error(w, http.StatusForbidden, "admin endpoints are disabled")
+			return
+		}
+
+		provided := r.Header.Get("X-Admin-Token")
+		if provided == "" {
+			provided = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			httpx.Error(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
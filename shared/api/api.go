@@ -0,0 +1,166 @@
+// Package api defines the JSON bodies of the public API. The services encode their
+// responses with these types and shared/client decodes them, so the two can't drift.
+// List and error envelopes are httpx.ListResponse and httpx.ErrorResponse.
+package api
+
+import (
+	"shared/httpx"
+	"time"
+)
+
+// User is the JSON representation of a user
+type User struct {
+	ID        int32   `json:"id"`
+	Name      string  `json:"name"`
+	Email     string  `json:"email"`
+	CreatedAt *string `json:"created_at"` // RFC3339, UTC
+	// A deactivated user can't sign in or be impersonated until activated again
+	Active bool `json:"active"`
+
+	// An email change waiting to be confirmed from the new address; only shown
+	// to the user themselves and to admins
+	PendingEmail          *string `json:"pending_email,omitempty"`
+	PendingEmailExpiresAt *string `json:"pending_email_expires_at,omitempty"` // RFC3339, UTC
+}
+
+// UserInput is the body of POST /users and PUT /users/{id}. A new email for an
+// existing user only takes effect once confirmed.
+type UserInput struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// UserPatch is one item of the body of POST /users/bulk-update; fields left nil
+// are not changed
+type UserPatch struct {
+	ID     int32   `json:"id"`
+	Name   *string `json:"name,omitempty"`
+	Email  *string `json:"email,omitempty"`
+	Active *bool   `json:"active,omitempty"` // false deactivates the user
+}
+
+// Outcomes of a UserPatch in a BulkUpdateResult
+const (
+	PatchUpdated          = "updated"
+	PatchNotFound         = "not_found"
+	PatchValidationFailed = "validation_failed"
+	PatchConflict         = "conflict"    // e.g. the email belongs to another user
+	PatchRolledBack       = "rolled_back" // applied, then undone by a later failure in an atomic update
+	PatchSkipped          = "skipped"     // not attempted because an atomic update had already failed
+)
+
+// UserPatchResult is the outcome of one UserPatch
+type UserPatchResult struct {
+	ID     int32              `json:"id"`
+	Status string             `json:"status"`
+	User   *User              `json:"user,omitempty"`   // the user as updated, with status updated
+	Error  string             `json:"error,omitempty"`  // why the patch failed
+	Fields []httpx.FieldError `json:"fields,omitempty"` // with status validation_failed
+}
+
+// BulkUpdateResult is the 207 response of POST /users/bulk-update, with one result
+// per patch in request order
+type BulkUpdateResult struct {
+	Committed bool              `json:"committed"` // false when an atomic update was rolled back
+	Results   []UserPatchResult `json:"results"`
+}
+
+// Product is the JSON representation of a product
+type Product struct {
+	ID                int32   `json:"id"`
+	Name              string  `json:"name"`
+	Description       *string `json:"description"`
+	DescriptionFormat string  `json:"description_format"`         // html, markdown or plain; only html is sanitized as stored
+	DescriptionHTML   *string `json:"description_html,omitempty"` // rendered and sanitized, with ?render=html
+	Price             float64 `json:"price"`
+	PriceCents        int64   `json:"price_cents"`
+	Stock             int32   `json:"stock"`
+	Category          *string `json:"category"`
+	CreatedAt         *string `json:"created_at"`  // RFC3339, UTC
+	ArchivedAt        *string `json:"archived_at"` // set once archived; archived products are left out of listings
+
+	// When the product can be bought, RFC3339 in UTC; null for an open end. Outside
+	// the window it is left out of listings and can't be reserved.
+	AvailableFrom  *string `json:"available_from"`
+	AvailableUntil *string `json:"available_until"`
+	Available      bool    `json:"available"` // inside the window now
+
+	// The locale name and description are translated into, when the request
+	// asked for one and the product has a translation; absent for the base record
+	Locale *string `json:"locale,omitempty"`
+
+	// Related collections, present only when named in ?expand: the product's
+	// category and its ancestors, top-level first, and all its translations
+	Categories   *[]Category           `json:"categories,omitempty"`
+	Translations *[]ProductTranslation `json:"translations,omitempty"`
+}
+
+// ProductTranslation is a product's name and description in one locale
+type ProductTranslation struct {
+	ProductID         int32   `json:"product_id"`
+	Locale            string  `json:"locale"`
+	Name              string  `json:"name"`
+	Description       *string `json:"description"`
+	DescriptionFormat string  `json:"description_format"`
+	UpdatedAt         string  `json:"updated_at"` // RFC3339, UTC
+}
+
+// ProductTranslationInput is the body of PUT /products/{id}/translations/{locale},
+// and a translation of an imported product
+type ProductTranslationInput struct {
+	Name              string `json:"name"`
+	Description       string `json:"description,omitempty"`
+	DescriptionFormat string `json:"description_format,omitempty"` // html if empty
+}
+
+// ProductInput is the body of POST /products and PUT /products/{id}
+type ProductInput struct {
+	Name              string  `json:"name"`
+	Description       string  `json:"description,omitempty"`
+	DescriptionFormat string  `json:"description_format,omitempty"` // html if empty
+	Price             float64 `json:"price"`
+	Stock             int32   `json:"stock"`
+	Category          string  `json:"category,omitempty"`
+
+	// The availability window; either end may be left open
+	AvailableFrom  *time.Time `json:"available_from,omitempty"`
+	AvailableUntil *time.Time `json:"available_until,omitempty"`
+}
+
+// Category is the JSON representation of a product category
+type Category struct {
+	Slug   string  `json:"slug"`
+	Name   string  `json:"name"`
+	Parent *string `json:"parent"` // null for a top-level category
+}
+
+// CategoryInput is the body of PUT /products/categories/{slug}
+type CategoryInput struct {
+	Name   string  `json:"name"`
+	Parent *string `json:"parent"` // omitted or null for a top-level category
+}
+
+// CategoryNode is a category in GET /products/categories/tree, with its
+// subcategories nested below it
+type CategoryNode struct {
+	Slug     string         `json:"slug"`
+	Name     string         `json:"name"`
+	Children []CategoryNode `json:"children"`
+}
+
+// Reservation is the JSON representation of a stock reservation
+type Reservation struct {
+	ID         string  `json:"id"`
+	ProductID  int32   `json:"product_id"`
+	Quantity   int32   `json:"quantity"`
+	Status     string  `json:"status"`     // active, released or expired
+	ExpiresAt  string  `json:"expires_at"` // RFC3339, UTC
+	ReleasedAt *string `json:"released_at"`
+	Stock      int32   `json:"stock"` // the product's stock left after this change
+}
+
+// BulkResult reports which of the requested products a bulk operation changed
+type BulkResult struct {
+	Affected []int32 `json:"affected"`
+	NotFound []int32 `json:"not_found"`
+}
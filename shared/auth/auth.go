@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Headers carrying the caller's identity from the gateway to the services. The
+// gateway strips any client-supplied values, so services can trust them.
+const (
+	HeaderUserID         = "X-User-ID"
+	HeaderRoles          = "X-User-Roles"      // comma-separated
+	HeaderImpersonatedBy = "X-Impersonated-By" // set when support staff act as the user
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	ID             string
+	Roles          []string
+	ImpersonatedBy string // staff member acting as ID, if the session is impersonated
+}
+
+// Impersonated reports whether someone else is acting as the principal, e.g. so
+// a UI can show a banner
+func (p Principal) Impersonated() bool {
+	return p.ImpersonatedBy != ""
+}
+
+// Authenticated reports whether the request carried an identity
+func (p Principal) Authenticated() bool {
+	return p.ID != ""
+}
+
+// HasRole reports whether the principal has any of roles
+func (p Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest reads the principal forwarded by the gateway
+func FromRequest(r *http.Request) Principal {
+	p := Principal{ID: strings.TrimSpace(r.Header.Get(HeaderUserID))}
+	if p.ID == "" {
+		return p
+	}
+	p.ImpersonatedBy = strings.TrimSpace(r.Header.Get(HeaderImpersonatedBy))
+	for role := range strings.SplitSeq(r.Header.Get(HeaderRoles), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			p.Roles = append(p.Roles, role)
+		}
+	}
+	return p
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal set by Authorize; it is anonymous if there is none
+func FromContext(ctx context.Context) Principal {
+	p, _ := ctx.Value(contextKey{}).(Principal)
+	return p
+}
+
+// AnyPrincipal in a Matrix allows any caller: authenticated, or anonymous when
+// authentication is not required
+var AnyPrincipal = []string{}
+
+// Matrix maps "METHOD pattern" (as registered on the mux) to the roles allowed to
+// call it. Routes that aren't listed are not checked, so health, metrics and admin
+// routes keep their own protection. HEAD is checked against the GET entry.
+type Matrix map[string][]string
+
+// RequiredFromEnv reads AUTH_REQUIRED. It defaults to false, which lets anonymous
+// callers use AnyPrincipal routes; role-restricted routes always need a principal.
+func RequiredFromEnv() (bool, error) {
+	raw := os.Getenv("AUTH_REQUIRED")
+	if raw == "" {
+		return false, nil
+	}
+	required, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid AUTH_REQUIRED %q", raw)
+	}
+	return required, nil
+}
+
+// Authorize returns middleware that checks every request against matrix for the mux
+// route it matches. Missing credentials get 401; a principal without a permitted role gets 403.
+func Authorize(mux *http.ServeMux, matrix Matrix, required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := FromRequest(r)
+
+			// HEAD is served by the GET handler, so it needs the same roles
+			method := r.Method
+			if method == http.MethodHead {
+				method = http.MethodGet
+			}
+
+			_, pattern := mux.Handler(r)
+			if roles, ok := matrix[method+" "+pattern]; ok {
+				switch {
+				case !principal.Authenticated() && (required || len(roles) > 0):
+					httpx.Error(w, http.StatusUnauthorized, "authentication required")
+					return
+				case len(roles) > 0 && !principal.HasRole(roles...):
+					log.Printf("Denied %s %s to %s (roles %v, need one of %v)", r.Method, r.URL.Path, principal.ID, principal.Roles, roles)
+					httpx.Error(w, http.StatusForbidden, "Forbidden")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	matrix := Matrix{
+		"GET /items":         AnyPrincipal,
+		"POST /items":        {"admin", "inventory"},
+		"DELETE /items/{id}": {"admin"},
+	}
+	mux := http.NewServeMux()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	mux.Handle("/items", ok)
+	mux.Handle("/items/{id}", ok)
+	mux.Handle("/health", ok)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		user     string // X-User-ID; empty for anonymous
+		roles    string // X-User-Roles
+		required bool   // AUTH_REQUIRED
+		want     int
+	}{
+		{name: "open route, anonymous", method: http.MethodGet, target: "/items", want: http.StatusNoContent},
+		{name: "open route, anonymous, auth required", method: http.MethodGet, target: "/items", required: true, want: http.StatusUnauthorized},
+		{name: "open route, any principal, auth required", method: http.MethodGet, target: "/items", user: "7", required: true, want: http.StatusNoContent},
+		{name: "HEAD uses the GET entry", method: http.MethodHead, target: "/items", required: true, want: http.StatusUnauthorized},
+		{name: "role route, anonymous", method: http.MethodPost, target: "/items", want: http.StatusUnauthorized},
+		{name: "role route, no role", method: http.MethodPost, target: "/items", user: "7", roles: "customer", want: http.StatusForbidden},
+		{name: "role route, one of the roles", method: http.MethodPost, target: "/items", user: "7", roles: "customer, inventory", want: http.StatusNoContent},
+		{name: "role route, other role", method: http.MethodDelete, target: "/items/5", user: "7", roles: "inventory", want: http.StatusForbidden},
+		{name: "role route, admin", method: http.MethodDelete, target: "/items/5", user: "7", roles: "admin", want: http.StatusNoContent},
+		{name: "roles without a user are ignored", method: http.MethodDelete, target: "/items/5", roles: "admin", want: http.StatusUnauthorized},
+		{name: "unlisted route", method: http.MethodGet, target: "/health", required: true, want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.user != "" {
+				r.Header.Set(HeaderUserID, tt.user)
+			}
+			if tt.roles != "" {
+				r.Header.Set(HeaderRoles, tt.roles)
+			}
+			w := httptest.NewRecorder()
+			Authorize(mux, matrix, tt.required)(mux).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
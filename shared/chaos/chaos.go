@@ -0,0 +1,223 @@
+// Package chaos injects faults into a service's responses, so the gateway's
+// timeouts, fallbacks and outlier ejection can be exercised without breaking a
+// backend. It is off unless CHAOS_ENABLED is set, and refuses to start in
+// production. Every injected fault is logged with a [CHAOS] prefix and marked
+// with the X-Chaos-Injected response header, so nobody mistakes it for a real one.
+package chaos
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header names the fault injected into a response: latency or error
+const Header = "X-Chaos-Injected"
+
+// AllRoutes is the Rule.Route that applies to every route except /admin/ ones, so
+// chaos can always be turned off again
+const AllRoutes = "*"
+
+// EnabledFromEnv reads CHAOS_ENABLED; default false. It is an error to set it
+// while ENVIRONMENT is production.
+func EnabledFromEnv() (bool, error) {
+	raw := os.Getenv("CHAOS_ENABLED")
+	if raw == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid CHAOS_ENABLED %q", raw)
+	}
+	if enabled && strings.EqualFold(os.Getenv("ENVIRONMENT"), "production") {
+		return false, fmt.Errorf("CHAOS_ENABLED must not be set when ENVIRONMENT is production")
+	}
+	return enabled, nil
+}
+
+// Rule is the faults injected into one route. Each probability is from 0 to 1 and
+// rolled separately for every request: latency is added first, then the
+// connection may be dropped or, failing that, answered with ErrorStatus.
+type Rule struct {
+	Route              string  `json:"route"` // "[METHOD ]pattern" as registered on the mux, or "*"
+	LatencyProbability float64 `json:"latency_probability"`
+	LatencyMS          int     `json:"latency_ms"`
+	ErrorProbability   float64 `json:"error_probability"`
+	ErrorStatus        int     `json:"error_status,omitempty"` // 500 or 503; default 503
+	DropProbability    float64 `json:"drop_probability"`
+}
+
+// Injector holds the rules set through its Handler. A nil Injector injects nothing.
+type Injector struct {
+	mux  *http.ServeMux
+	roll func() float64
+
+	mu    sync.RWMutex
+	rules map[string]Rule // by Route
+}
+
+// New creates an Injector without rules. Routes are matched against mux.
+func New(mux *http.ServeMux) *Injector {
+	return &Injector{mux: mux, roll: rand.Float64, rules: map[string]Rule{}}
+}
+
+// Rules returns the current rules, sorted by route
+func (i *Injector) Rules() []Rule {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	rules := make([]Rule, 0, len(i.rules))
+	for _, rule := range i.rules {
+		rules = append(rules, rule)
+	}
+	slices.SortFunc(rules, func(a, b Rule) int { return strings.Compare(a.Route, b.Route) })
+	return rules
+}
+
+// Replace swaps in a new set of rules, after checking every one of them
+func (i *Injector) Replace(rules []Rule) error {
+	byRoute := make(map[string]Rule, len(rules))
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+		if rule.ErrorStatus == 0 {
+			rule.ErrorStatus = http.StatusServiceUnavailable
+		}
+		byRoute[rule.Route] = rule
+	}
+
+	i.mu.Lock()
+	i.rules = byRoute
+	i.mu.Unlock()
+	log.Printf("[CHAOS] Rules replaced: %d route(s) now have faults injected", len(byRoute))
+	return nil
+}
+
+func (r Rule) validate() error {
+	if r.Route != AllRoutes && !strings.HasPrefix(r.Route, "/") && !strings.Contains(r.Route, " /") {
+		return fmt.Errorf("route %q must be \"[METHOD ]pattern\" or %q", r.Route, AllRoutes)
+	}
+	for name, p := range map[string]float64{
+		"latency_probability": r.LatencyProbability,
+		"error_probability":   r.ErrorProbability,
+		"drop_probability":    r.DropProbability,
+	} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("%s of %q must be between 0 and 1", name, r.Route)
+		}
+	}
+	if r.LatencyMS < 0 {
+		return fmt.Errorf("latency_ms of %q must not be negative", r.Route)
+	}
+	if r.ErrorStatus != 0 && r.ErrorStatus != http.StatusInternalServerError && r.ErrorStatus != http.StatusServiceUnavailable {
+		return fmt.Errorf("error_status of %q must be 500 or 503", r.Route)
+	}
+	return nil
+}
+
+// ruleFor finds the rule for a request matched to a mux pattern: the method
+// specific one, then the one for every method, then AllRoutes
+func (i *Injector) ruleFor(r *http.Request, pattern string) (Rule, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if len(i.rules) == 0 {
+		return Rule{}, false
+	}
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		pattern = path
+	}
+	for _, key := range []string{r.Method + " " + pattern, pattern} {
+		if rule, ok := i.rules[key]; ok {
+			return rule, true
+		}
+	}
+	if rule, ok := i.rules[AllRoutes]; ok && !strings.HasPrefix(pattern, "/admin/") {
+		return rule, true
+	}
+	return Rule{}, false
+}
+
+// Middleware injects the faults of the rule matching each request. Install it
+// inside httpx.Timeouts, so injected latency counts against the route deadline.
+func (i *Injector) Middleware(next http.Handler) http.Handler {
+	if i == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := i.mux.Handler(r)
+		rule, ok := i.ruleFor(r, pattern)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if rule.LatencyMS > 0 && i.roll() < rule.LatencyProbability {
+			delay := time.Duration(rule.LatencyMS) * time.Millisecond
+			i.logFault(r, rule, fmt.Sprintf("%s of latency", delay))
+			w.Header().Set(Header, "latency")
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if i.roll() < rule.DropProbability {
+			i.logFault(r, rule, "a dropped connection")
+			// Aborts the response without a reply; the server closes the connection
+			panic(http.ErrAbortHandler)
+		}
+		if i.roll() < rule.ErrorProbability {
+			i.logFault(r, rule, fmt.Sprintf("a %d", rule.ErrorStatus))
+			w.Header().Set(Header, "error")
+			httpx.ErrorCode(w, rule.ErrorStatus, "chaos_injected", "Fault injected for chaos testing")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (i *Injector) logFault(r *http.Request, rule Rule, fault string) {
+	log.Printf("[CHAOS] [%s] Injecting %s into %s %s (rule %q); this is not a real failure",
+		httpx.RequestID(r), fault, r.Method, r.URL.Path, rule.Route)
+}
+
+// Handler serves the chaos admin API: GET lists the rules, PUT replaces them with
+// the JSON array sent, and DELETE removes them all. It should be mounted behind
+// admin authentication.
+func (i *Injector) Handler() http.Handler {
+	return httpx.Methods{
+		http.MethodGet:    i.listRules,
+		http.MethodPut:    i.replaceRules,
+		http.MethodDelete: i.clearRules,
+	}
+}
+
+func (i *Injector) listRules(w http.ResponseWriter, r *http.Request) {
+	httpx.WriteJSON(w, r, http.StatusOK, i.Rules())
+}
+
+func (i *Injector) replaceRules(w http.ResponseWriter, r *http.Request) {
+	var rules []Rule
+	if err := httpx.DecodeJSON(w, r, &rules); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+	if err := i.Replace(rules); err != nil {
+		httpx.Error(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	i.listRules(w, r)
+}
+
+func (i *Injector) clearRules(w http.ResponseWriter, r *http.Request) {
+	i.Replace(nil)
+	w.WriteHeader(http.StatusNoContent)
+}
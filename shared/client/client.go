@@ -0,0 +1,228 @@
+// Package client is a Go client for the public API, as served through the gateway.
+// Request and response bodies are the shared/api types the services themselves
+// encode, so the client can't drift from them.
+//
+//	c, err := client.New("https://api.example.com", client.WithToken(token))
+//	user, err := c.Users.Get(ctx, 42)
+//	if errors.Is(err, client.ErrNotFound) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout caps each call when WithTimeout isn't given
+const DefaultTimeout = 10 * time.Second
+
+// RetryPolicy says how often a failed call is tried again. Only idempotent calls
+// (GET, PUT, DELETE) are retried, and only after a network error or a 502, 503 or
+// 504; a Retry-After from the server is honoured in place of the backoff.
+type RetryPolicy struct {
+	MaxAttempts int           // including the first; 1 or less disables retries
+	Backoff     time.Duration // wait before the first retry, doubled for each one after
+}
+
+// DefaultRetryPolicy retries twice, after 100ms and then 200ms
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: 100 * time.Millisecond}
+
+// Client calls the API. Users and Products group the calls by resource.
+type Client struct {
+	Users    *UsersService
+	Products *ProductsService
+
+	baseURL *url.URL
+	http    *http.Client
+	token   string
+	timeout time.Duration
+	retry   RetryPolicy
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken sends token as a bearer token on every call
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTimeout caps each attempt of a call in place of DefaultTimeout; the
+// context's deadline still applies
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithHTTPClient makes calls through a copy of hc, e.g. to use its transport. The
+// copy's Timeout is replaced by WithTimeout or DefaultTimeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// New creates a Client for the gateway at baseURL, e.g. "https://api.example.com";
+// the /api prefix is added by the client
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+
+	c := &Client{
+		baseURL: u,
+		http:    http.DefaultClient,
+		timeout: DefaultTimeout,
+		retry:   DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	hc := *c.http
+	hc.Timeout = c.timeout
+	c.http = &hc
+	c.Users = &UsersService{c: c}
+	c.Products = &ProductsService{c: c}
+	return c, nil
+}
+
+// do sends a request to the escaped path (below /api) with body encoded as JSON,
+// and decodes a successful response into out if it isn't nil. It returns the
+// response status.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) (int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, fmt.Errorf("could not encode request: %w", err)
+		}
+	}
+
+	// path is escaped already, e.g. a slug through url.PathEscape, so it is joined
+	// to the escaped base path rather than to Path, which would escape it again
+	u := *c.baseURL
+	u.RawPath = u.EscapedPath() + "/api" + path
+	unescaped, err := url.PathUnescape(u.RawPath)
+	if err != nil {
+		return 0, fmt.Errorf("invalid path %q: %w", path, err)
+	}
+	u.Path = unescaped
+	u.RawQuery = query.Encode()
+
+	attempts := 1
+	if isIdempotent(method) && c.retry.MaxAttempts > 1 {
+		attempts = c.retry.MaxAttempts
+	}
+	backoff := c.retry.Backoff
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, method, u.String(), payload)
+		retryable := err != nil && ctx.Err() == nil
+		if err == nil {
+			retryable = resp.StatusCode == http.StatusBadGateway ||
+				resp.StatusCode == http.StatusServiceUnavailable ||
+				resp.StatusCode == http.StatusGatewayTimeout
+		}
+		if !retryable || attempt == attempts {
+			if err != nil {
+				return 0, err
+			}
+			return resp.StatusCode, decodeResponse(resp, out)
+		}
+
+		wait := backoff
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				wait = after
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		backoff *= 2
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, target string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.http.Do(req)
+}
+
+// decodeResponse decodes a 2xx body into out, or any other response into an error
+func decodeResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return parseError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryAfter reads a Retry-After header given in seconds
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// pageQuery encodes limit and offset, leaving out zero values so the server defaults apply
+func pageQuery(limit, offset int) url.Values {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		q.Set("offset", strconv.Itoa(offset))
+	}
+	return q
+}
+
+// idPath returns prefix/id
+func idPath(prefix string, id int32) string {
+	return prefix + "/" + strconv.FormatInt(int64(id), 10)
+}
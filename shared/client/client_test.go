@@ -0,0 +1,346 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"shared/api"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorded is what the test server saw of a request
+type recorded struct {
+	method, uri, body string
+	header            http.Header
+}
+
+// newServer starts a server that records each request and answers it with
+// status and body, and returns a Client for it with retries off
+func newServer(t *testing.T, status int, body string, opts ...Option) (*Client, *recorded) {
+	t.Helper()
+	var got recorded
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = recorded{method: r.Method, uri: r.URL.RequestURI(), body: string(b), header: r.Header.Clone()}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, append([]Option{WithRetryPolicy(RetryPolicy{MaxAttempts: 1})}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &got
+}
+
+func TestRequests(t *testing.T) {
+	active := false
+	tests := []struct {
+		name        string
+		call        func(context.Context, *Client) error
+		method, uri string
+		body        string // the JSON the server must receive, if any
+	}{
+		{name: "list users", method: "GET", uri: "/api/users?active=false&limit=10&offset=20",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.Users.List(ctx, UserListOptions{ListOptions: ListOptions{Limit: 10, Offset: 20}, Active: &active})
+				return err
+			}},
+		{name: "list users with defaults", method: "GET", uri: "/api/users",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.Users.List(ctx, UserListOptions{})
+				return err
+			}},
+		{name: "get user", method: "GET", uri: "/api/users/42",
+			call: func(ctx context.Context, c *Client) error { _, err := c.Users.Get(ctx, 42); return err }},
+		{name: "create user", method: "POST", uri: "/api/users", body: `{"name":"Ann","email":"ann@example.com"}`,
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.Users.Create(ctx, api.UserInput{Name: "Ann", Email: "ann@example.com"})
+				return err
+			}},
+		{name: "put user", method: "PUT", uri: "/api/users/42", body: `{"name":"Ann","email":"ann@example.com"}`,
+			call: func(ctx context.Context, c *Client) error {
+				_, _, err := c.Users.Put(ctx, 42, api.UserInput{Name: "Ann", Email: "ann@example.com"})
+				return err
+			}},
+		{name: "delete user", method: "DELETE", uri: "/api/users/42",
+			call: func(ctx context.Context, c *Client) error { return c.Users.Delete(ctx, 42) }},
+		{name: "bulk delete users", method: "POST", uri: "/api/users/bulk-delete", body: `{"ids":[1,2]}`,
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.Users.BulkDelete(ctx, []int32{1, 2})
+				return err
+			}},
+		{name: "get product rendered", method: "GET", uri: "/api/products/7?render=html",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.Products.Get(ctx, 7, GetOptions{RenderHTML: true})
+				return err
+			}},
+		{name: "reserve stock", method: "POST", uri: "/api/products/7/reserve", body: `{"quantity":3}`,
+			call: func(ctx context.Context, c *Client) error { _, err := c.Products.Reserve(ctx, 7, 3); return err }},
+		{name: "release stock", method: "POST", uri: "/api/products/7/release", body: `{"reservation_id":"r-1"}`,
+			call: func(ctx context.Context, c *Client) error { _, err := c.Products.Release(ctx, 7, "r-1"); return err }},
+		{name: "category slug escaped", method: "PUT", uri: "/api/products/categories/a%2Fb", body: `{"name":"A/B","parent":null}`,
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.Products.PutCategory(ctx, "a/b", api.CategoryInput{Name: "A/B"})
+				return err
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, got := newServer(t, http.StatusOK, `{}`)
+			if err := tt.call(context.Background(), c); err != nil {
+				t.Fatal(err)
+			}
+			if got.method != tt.method || got.uri != tt.uri {
+				t.Errorf("got %s %s, want %s %s", got.method, got.uri, tt.method, tt.uri)
+			}
+			if got.body != tt.body {
+				t.Errorf("body = %s, want %s", got.body, tt.body)
+			}
+			if tt.body != "" && got.header.Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got.header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestBaseURLPathAndToken(t *testing.T) {
+	var uri, authz string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uri, authz = r.URL.RequestURI(), r.Header.Get("Authorization")
+		io.WriteString(w, `{"id":42,"name":"Ann"}`)
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL+"/gateway/", WithToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := c.Users.Get(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 42 || user.Name != "Ann" {
+		t.Errorf("user = %+v, want 42 Ann", user)
+	}
+	if uri != "/gateway/api/users/42" || authz != "Bearer secret" {
+		t.Errorf("got %s with Authorization %q, want /gateway/api/users/42 with Bearer secret", uri, authz)
+	}
+}
+
+func TestNewRejectsBadBaseURL(t *testing.T) {
+	for _, raw := range []string{"", "api.example.com", "://x", "/api"} {
+		if _, err := New(raw); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestPutReportsCreated(t *testing.T) {
+	for status, want := range map[int]bool{http.StatusCreated: true, http.StatusOK: false} {
+		c, _ := newServer(t, status, `{"id":42}`)
+		_, created, err := c.Users.Put(context.Background(), 42, api.UserInput{Name: "Ann", Email: "ann@example.com"})
+		if err != nil || created != want {
+			t.Errorf("%d: created = %v, %v; want %v", status, created, err, want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		sentinel error
+		code     string
+		message  string
+	}{
+		{name: "not found", status: 404, body: `{"error":"user not found"}`, sentinel: ErrNotFound, message: "user not found"},
+		{name: "conflict with code", status: 409, body: `{"error":"email already in use","code":"email_taken"}`,
+			sentinel: ErrConflict, code: "email_taken", message: "email already in use"},
+		{name: "unauthorized", status: 401, body: `{"error":"authentication required"}`, sentinel: ErrUnauthorized, message: "authentication required"},
+		{name: "forbidden", status: 403, body: `{"error":"Forbidden"}`, sentinel: ErrForbidden, message: "Forbidden"},
+		{name: "body not an envelope", status: 500, body: `<html>oops</html>`, message: "Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newServer(t, tt.status, tt.body)
+			_, err := c.Users.Get(context.Background(), 42)
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %#v, want an *Error", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.code || apiErr.Message != tt.message {
+				t.Errorf("err = %+v, want %d %q %q", apiErr, tt.status, tt.code, tt.message)
+			}
+			for _, sentinel := range []error{ErrNotFound, ErrConflict, ErrUnauthorized, ErrForbidden} {
+				if got, want := errors.Is(err, sentinel), sentinel == tt.sentinel; got != want {
+					t.Errorf("errors.Is(err, %v) = %v, want %v", sentinel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	c, _ := newServer(t, http.StatusUnprocessableEntity,
+		`{"error":"validation failed","fields":[{"field":"name","message":"is required"},{"field":"email","message":"must not be empty"}]}`)
+	_, err := c.Users.Create(context.Background(), api.UserInput{})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("err = %#v, want a *ValidationError", err)
+	}
+	if len(verr.Fields) != 2 || verr.Fields[0].Field != "name" || verr.Fields[1].Message != "must not be empty" {
+		t.Errorf("fields = %+v, want name and email", verr.Fields)
+	}
+	if want := "validation failed; name is required; email must not be empty"; err.Error() != want {
+		t.Errorf("message = %q, want %q", err.Error(), want)
+	}
+}
+
+// flakyServer answers the first failures requests with status and the rest with
+// 200, and counts the requests it gets
+func flakyServer(t *testing.T, failures int32, status int, header http.Header) (string, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(status)
+			return
+		}
+		io.WriteString(w, `{"id":1}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &calls
+}
+
+func TestRetriesIdempotentCalls(t *testing.T) {
+	url, calls := flakyServer(t, 2, http.StatusServiceUnavailable, nil)
+	c, _ := New(url, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}))
+
+	if _, err := c.Users.Get(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	url, calls := flakyServer(t, 10, http.StatusBadGateway, nil)
+	c, _ := New(url, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}))
+
+	_, err := c.Users.Get(context.Background(), 1)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("err = %v, want the last 502", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+}
+
+func TestDoesNotRetryPostOrClientErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		call   func(*Client) error
+	}{
+		{name: "POST on 503", status: http.StatusServiceUnavailable, call: func(c *Client) error {
+			_, err := c.Users.Create(context.Background(), api.UserInput{Name: "Ann"})
+			return err
+		}},
+		{name: "GET on 500", status: http.StatusInternalServerError, call: func(c *Client) error {
+			_, err := c.Users.Get(context.Background(), 1)
+			return err
+		}},
+		{name: "GET on 429", status: http.StatusTooManyRequests, call: func(c *Client) error {
+			_, err := c.Users.Get(context.Background(), 1)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, calls := flakyServer(t, 1, tt.status, nil)
+			c, _ := New(url, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}))
+			if err := tt.call(c); err == nil {
+				t.Error("err = nil, want the first response's error")
+			}
+			if got := calls.Load(); got != 1 {
+				t.Errorf("got %d attempts, want 1", got)
+			}
+		})
+	}
+}
+
+func TestRetryAfterReplacesBackoff(t *testing.T) {
+	// An hour's backoff would time the test out; Retry-After: 0 retries at once
+	url, calls := flakyServer(t, 1, http.StatusServiceUnavailable, http.Header{"Retry-After": {"0"}})
+	c, _ := New(url, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Hour}))
+
+	if _, err := c.Users.Get(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+}
+
+func TestRetryWaitStopsWhenContextEnds(t *testing.T) {
+	url, _ := flakyServer(t, 10, http.StatusServiceUnavailable, nil)
+	c, _ := New(url, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Users.Get(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+}
+
+func TestTimeoutCapsEachAttempt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	c, _ := New(srv.URL, WithTimeout(50*time.Millisecond), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+
+	start := time.Now()
+	_, err := c.Users.Get(context.Background(), 1)
+	if err == nil || !strings.Contains(err.Error(), "Client.Timeout") {
+		t.Errorf("err = %v, want the client timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("call took %s, want it cut off near 50ms", elapsed)
+	}
+}
+
+func TestDecodesListEnvelope(t *testing.T) {
+	c, _ := newServer(t, http.StatusOK, `{"version":1,"data":[{"id":1,"name":"Ann"},{"id":2,"name":"Bob"}],"limit":2,"offset":0,"links":{"next":"/api/users?limit=2&offset=2"}}`)
+	page, err := c.Users.List(context.Background(), UserListOptions{ListOptions: ListOptions{Limit: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(page.Data)
+	if string(got) != `[{"id":1,"name":"Ann","email":"","created_at":null,"active":false},{"id":2,"name":"Bob","email":"","created_at":null,"active":false}]` {
+		t.Errorf("data = %s", got)
+	}
+	if page.Links.Next == "" {
+		t.Error("links.next is empty, want the next page")
+	}
+}
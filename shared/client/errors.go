@@ -0,0 +1,75 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"shared/httpx"
+)
+
+// Sentinel errors an *Error matches with errors.Is, by status
+var (
+	ErrNotFound     = errors.New("not found")    // 404
+	ErrConflict     = errors.New("conflict")     // 409, e.g. a duplicate email or insufficient stock
+	ErrUnauthorized = errors.New("unauthorized") // 401, a missing or invalid token
+	ErrForbidden    = errors.New("forbidden")    // 403, the token's roles don't allow the call
+)
+
+// Error is a non-2xx response, parsed from the standard error envelope
+type Error struct {
+	StatusCode int
+	Code       string // machine-readable class where the server gives one, e.g. insufficient_stock
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+// Is matches the sentinel error for e's status
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// ValidationError is a 422 response, listing the problem with each field
+type ValidationError struct {
+	Message string
+	Fields  []httpx.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Message
+	for _, f := range e.Fields {
+		msg += fmt.Sprintf("; %s %s", f.Field, f.Message)
+	}
+	return msg
+}
+
+// parseError builds an *Error or *ValidationError from an error response
+func parseError(resp *http.Response) error {
+	var body httpx.ErrorResponse
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(raw, &body); err != nil || body.Error == "" {
+		body.Error = http.StatusText(resp.StatusCode)
+	}
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return &ValidationError{Message: body.Error, Fields: body.Fields}
+	}
+	return &Error{StatusCode: resp.StatusCode, Code: body.Code, Message: body.Error}
+}
@@ -0,0 +1,91 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"shared/api"
+	"shared/client"
+	"time"
+)
+
+// newGateway stands in for the gateway, answering the calls the examples make
+func newGateway() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/users/42":
+			io.WriteString(w, `{"id":42,"name":"Ann","email":"ann@example.com","active":true}`)
+		case "POST /api/users":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"error":"validation failed","fields":[{"field":"name","message":"is required"}]}`)
+		case "POST /api/products/7/reserve":
+			w.WriteHeader(http.StatusConflict)
+			io.WriteString(w, `{"error":"only 2 in stock","code":"insufficient_stock"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":"not found"}`)
+		}
+	}))
+}
+
+func Example() {
+	gateway := newGateway()
+	defer gateway.Close()
+
+	c, err := client.New(gateway.URL,
+		client.WithToken("token"),
+		client.WithTimeout(5*time.Second),
+		client.WithRetryPolicy(client.RetryPolicy{MaxAttempts: 3, Backoff: 200 * time.Millisecond}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	user, err := c.Users.Get(context.Background(), 42)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(user.Name, user.Email)
+
+	if _, err := c.Users.Get(context.Background(), 7); errors.Is(err, client.ErrNotFound) {
+		fmt.Println("user 7 not found")
+	}
+	// Output:
+	// Ann ann@example.com
+	// user 7 not found
+}
+
+func ExampleValidationError() {
+	gateway := newGateway()
+	defer gateway.Close()
+	c, _ := client.New(gateway.URL)
+
+	_, err := c.Users.Create(context.Background(), api.UserInput{Email: "ann@example.com"})
+	var verr *client.ValidationError
+	if errors.As(err, &verr) {
+		for _, f := range verr.Fields {
+			fmt.Println(f.Field, f.Message)
+		}
+	}
+	// Output:
+	// name is required
+}
+
+func ExampleError() {
+	gateway := newGateway()
+	defer gateway.Close()
+	c, _ := client.New(gateway.URL)
+
+	_, err := c.Products.Reserve(context.Background(), 7, 3)
+	var apiErr *client.Error
+	if errors.Is(err, client.ErrConflict) && errors.As(err, &apiErr) {
+		fmt.Println(apiErr.Code)
+	}
+	// Output:
+	// insufficient_stock
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"shared/api"
+	"shared/httpx"
+	"strings"
+)
+
+// ProductsService calls /api/products
+type ProductsService struct {
+	c *Client
+}
+
+// ProductListOptions selects a page of products, optionally in one category
+type ProductListOptions struct {
+	ListOptions
+	Category string
+	Expand   []string // related collections to embed; a listing may expand one
+
+	// IncludeUnavailable also lists products outside their availability window; admin only
+	IncludeUnavailable bool
+}
+
+// GetOptions changes what Get returns
+type GetOptions struct {
+	RenderHTML bool     // also return the description rendered as sanitized HTML
+	Expand     []string // related collections to embed, e.g. categories and translations
+}
+
+// List returns a page of products, leaving out archived ones
+func (s *ProductsService) List(ctx context.Context, opts ProductListOptions) (httpx.ListResponse[api.Product], error) {
+	query := pageQuery(opts.Limit, opts.Offset)
+	if opts.Category != "" {
+		query.Set("category", opts.Category)
+	}
+	if len(opts.Expand) > 0 {
+		query.Set("expand", strings.Join(opts.Expand, ","))
+	}
+	if opts.IncludeUnavailable {
+		query.Set("include_unavailable", "true")
+	}
+	var page httpx.ListResponse[api.Product]
+	_, err := s.c.do(ctx, http.MethodGet, "/products", query, nil, &page)
+	return page, err
+}
+
+// Get returns one product
+func (s *ProductsService) Get(ctx context.Context, id int32, opts GetOptions) (api.Product, error) {
+	query := url.Values{}
+	if opts.RenderHTML {
+		query.Set("render", "html")
+	}
+	if len(opts.Expand) > 0 {
+		query.Set("expand", strings.Join(opts.Expand, ","))
+	}
+	var product api.Product
+	_, err := s.c.do(ctx, http.MethodGet, idPath("/products", id), query, nil, &product)
+	return product, err
+}
+
+// Create creates a product; a taken name, where names must be unique, is ErrConflict
+func (s *ProductsService) Create(ctx context.Context, input api.ProductInput) (api.Product, error) {
+	var product api.Product
+	_, err := s.c.do(ctx, http.MethodPost, "/products", nil, input, &product)
+	return product, err
+}
+
+// Update replaces a product
+func (s *ProductsService) Update(ctx context.Context, id int32, input api.ProductInput) (api.Product, error) {
+	var product api.Product
+	_, err := s.c.do(ctx, http.MethodPut, idPath("/products", id), nil, input, &product)
+	return product, err
+}
+
+// Delete deletes a product
+func (s *ProductsService) Delete(ctx context.Context, id int32) error {
+	_, err := s.c.do(ctx, http.MethodDelete, idPath("/products", id), nil, nil, nil)
+	return err
+}
+
+// Categories lists the categories products can be filed under
+func (s *ProductsService) Categories(ctx context.Context) ([]api.Category, error) {
+	var categories []api.Category
+	_, err := s.c.do(ctx, http.MethodGet, "/products/categories", nil, nil, &categories)
+	return categories, err
+}
+
+// CategoryTree returns the top-level categories with their subcategories nested below them
+func (s *ProductsService) CategoryTree(ctx context.Context) ([]api.CategoryNode, error) {
+	var tree []api.CategoryNode
+	_, err := s.c.do(ctx, http.MethodGet, "/products/categories/tree", nil, nil, &tree)
+	return tree, err
+}
+
+// PutCategory creates or replaces a category; admin only
+func (s *ProductsService) PutCategory(ctx context.Context, slug string, input api.CategoryInput) (api.Category, error) {
+	var category api.Category
+	_, err := s.c.do(ctx, http.MethodPut, "/products/categories/"+url.PathEscape(slug), nil, input, &category)
+	return category, err
+}
+
+// DeleteCategory deletes a category; one with subcategories or products is ErrConflict
+func (s *ProductsService) DeleteCategory(ctx context.Context, slug string) error {
+	_, err := s.c.do(ctx, http.MethodDelete, "/products/categories/"+url.PathEscape(slug), nil, nil, nil)
+	return err
+}
+
+// Reserve takes quantity units of a product out of stock for checkout until the
+// reservation is released or expires. Not enough stock is ErrConflict with Code
+// insufficient_stock.
+func (s *ProductsService) Reserve(ctx context.Context, id int32, quantity int32) (api.Reservation, error) {
+	var reservation api.Reservation
+	body := map[string]int32{"quantity": quantity}
+	_, err := s.c.do(ctx, http.MethodPost, idPath("/products", id)+"/reserve", nil, body, &reservation)
+	return reservation, err
+}
+
+// Release returns the stock of a reservation. A reservation that was already
+// released or has expired is ErrConflict with Code reservation_inactive.
+func (s *ProductsService) Release(ctx context.Context, id int32, reservationID string) (api.Reservation, error) {
+	var reservation api.Reservation
+	body := map[string]string{"reservation_id": reservationID}
+	_, err := s.c.do(ctx, http.MethodPost, idPath("/products", id)+"/release", nil, body, &reservation)
+	return reservation, err
+}
+
+// BulkDelete deletes the products with the given IDs
+func (s *ProductsService) BulkDelete(ctx context.Context, ids []int32) (api.BulkResult, error) {
+	return s.bulk(ctx, "/products/bulk-delete", map[string]any{"ids": ids})
+}
+
+// BulkArchive archives the products with the given IDs, hiding them from listings
+func (s *ProductsService) BulkArchive(ctx context.Context, ids []int32) (api.BulkResult, error) {
+	return s.bulk(ctx, "/products/bulk-archive", map[string]any{"ids": ids})
+}
+
+// BulkCategorize moves the products with the given IDs into a category; an empty
+// category removes them from their category
+func (s *ProductsService) BulkCategorize(ctx context.Context, ids []int32, category string) (api.BulkResult, error) {
+	return s.bulk(ctx, "/products/bulk-categorize", map[string]any{"ids": ids, "category": category})
+}
+
+func (s *ProductsService) bulk(ctx context.Context, path string, body any) (api.BulkResult, error) {
+	var result api.BulkResult
+	_, err := s.c.do(ctx, http.MethodPost, path, nil, body, &result)
+	return result, err
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"shared/api"
+	"shared/httpx"
+	"strconv"
+)
+
+// UsersService calls /api/users
+type UsersService struct {
+	c *Client
+}
+
+// ListOptions selects a page of a list; zero values use the server's defaults
+type ListOptions struct {
+	Limit  int
+	Offset int
+}
+
+// UserListOptions selects a page of users, optionally only active or deactivated ones
+type UserListOptions struct {
+	ListOptions
+	Active *bool
+}
+
+// List returns a page of users. Links.Next in the envelope is empty on the last page.
+func (s *UsersService) List(ctx context.Context, opts UserListOptions) (httpx.ListResponse[api.User], error) {
+	query := pageQuery(opts.Limit, opts.Offset)
+	if opts.Active != nil {
+		query.Set("active", strconv.FormatBool(*opts.Active))
+	}
+	var page httpx.ListResponse[api.User]
+	_, err := s.c.do(ctx, http.MethodGet, "/users", query, nil, &page)
+	return page, err
+}
+
+// Get returns one user
+func (s *UsersService) Get(ctx context.Context, id int32) (api.User, error) {
+	var user api.User
+	_, err := s.c.do(ctx, http.MethodGet, idPath("/users", id), nil, nil, &user)
+	return user, err
+}
+
+// Create creates a user; a duplicate email is ErrConflict
+func (s *UsersService) Create(ctx context.Context, input api.UserInput) (api.User, error) {
+	var user api.User
+	_, err := s.c.do(ctx, http.MethodPost, "/users", nil, input, &user)
+	return user, err
+}
+
+// Put replaces the user with the given ID, or creates it with that ID if there is
+// none, reporting whether it was created. A new email for an existing user is
+// returned as PendingEmail until it is confirmed.
+func (s *UsersService) Put(ctx context.Context, id int32, input api.UserInput) (api.User, bool, error) {
+	var user api.User
+	status, err := s.c.do(ctx, http.MethodPut, idPath("/users", id), nil, input, &user)
+	return user, status == http.StatusCreated, err
+}
+
+// ConfirmEmail applies the pending email change that token, sent to the new
+// address, confirms. An unknown or expired token is ErrNotFound.
+func (s *UsersService) ConfirmEmail(ctx context.Context, token string) (api.User, error) {
+	var user api.User
+	_, err := s.c.do(ctx, http.MethodPost, "/users/confirm-email", nil, map[string]string{"token": token}, &user)
+	return user, err
+}
+
+// CancelEmailChange drops a user's pending email change; with none it is ErrNotFound
+func (s *UsersService) CancelEmailChange(ctx context.Context, id int32) error {
+	_, err := s.c.do(ctx, http.MethodDelete, idPath("/users", id)+"/pending-email", nil, nil, nil)
+	return err
+}
+
+// Deactivate keeps a user but stops them signing in until Activate; requires the
+// admin role
+func (s *UsersService) Deactivate(ctx context.Context, id int32) (api.User, error) {
+	var user api.User
+	_, err := s.c.do(ctx, http.MethodPost, idPath("/users", id)+"/deactivate", nil, nil, &user)
+	return user, err
+}
+
+// Activate undoes Deactivate; requires the admin role
+func (s *UsersService) Activate(ctx context.Context, id int32) (api.User, error) {
+	var user api.User
+	_, err := s.c.do(ctx, http.MethodPost, idPath("/users", id)+"/activate", nil, nil, &user)
+	return user, err
+}
+
+// Delete deletes a user
+func (s *UsersService) Delete(ctx context.Context, id int32) error {
+	_, err := s.c.do(ctx, http.MethodDelete, idPath("/users", id), nil, nil, nil)
+	return err
+}
+
+// BulkDelete deletes the users with the given IDs, ignoring those that don't
+// exist, and returns how many were deleted
+func (s *UsersService) BulkDelete(ctx context.Context, ids []int32) (int, error) {
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+	_, err := s.c.do(ctx, http.MethodPost, "/users/bulk-delete", nil, map[string][]int32{"ids": ids}, &result)
+	return result.Deleted, err
+}
+
+// BulkUpdate applies patches to many users in one transaction and returns the
+// outcome of each. A failed patch doesn't stop the others unless atomic is set, in
+// which case any failure rolls the whole batch back and Committed is false. Needs
+// the admin role.
+func (s *UsersService) BulkUpdate(ctx context.Context, patches []api.UserPatch, atomic bool) (api.BulkUpdateResult, error) {
+	var query url.Values
+	if atomic {
+		query = url.Values{"atomic": {"true"}}
+	}
+	var result api.BulkUpdateResult
+	_, err := s.c.do(ctx, http.MethodPost, "/users/bulk-update", query, patches, &result)
+	return result, err
+}
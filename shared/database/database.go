@@ -0,0 +1,261 @@
+// Package database connects the services to Postgres and applies their schema
+// migrations. Each service owns its migrations and passes them in as an fs.FS;
+// versions are kept in a table named after the service, so two services can
+// share a database without their versions colliding.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// DefaultConnMaxIdleTime is how long a pooled connection may sit idle before it
+// is closed, unless DB_CONN_MAX_IDLE_TIME says otherwise
+const DefaultConnMaxIdleTime = 5 * time.Minute
+
+// LegacyMigrationsTable is where versions were kept before each service had its
+// own table. Migrate carries its version over the first time it runs.
+const LegacyMigrationsTable = "schema_migrations"
+
+// Config is how to connect to the database
+type Config struct {
+	URL             string        // DATABASE_URL
+	ConnMaxIdleTime time.Duration // DB_CONN_MAX_IDLE_TIME; 0 keeps idle connections open
+	WarmupConns     int           // DB_WARMUP_CONNS; connections Warmup opens, 0 for none
+
+	// Logf receives progress messages; log.Printf if nil
+	Logf func(format string, args ...any)
+}
+
+// ConfigFromEnv reads DATABASE_URL, DB_CONN_MAX_IDLE_TIME (default five minutes)
+// and DB_WARMUP_CONNS (default 0)
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{URL: os.Getenv("DATABASE_URL"), ConnMaxIdleTime: DefaultConnMaxIdleTime}
+	if cfg.URL == "" {
+		return cfg, fmt.Errorf("DATABASE_URL not set")
+	}
+	if raw := os.Getenv("DB_CONN_MAX_IDLE_TIME"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid DB_CONN_MAX_IDLE_TIME %q", raw)
+		}
+		cfg.ConnMaxIdleTime = d
+	}
+	if raw := os.Getenv("DB_WARMUP_CONNS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid DB_WARMUP_CONNS %q", raw)
+		}
+		cfg.WarmupConns = n
+	}
+	return cfg, nil
+}
+
+func (c Config) logf(format string, args ...any) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// driverName is the database/sql driver Connect opens cfg.URL with; tests swap in their own
+var driverName = "postgres"
+
+// Connect opens a Postgres connection pool. Connections are made lazily, so
+// callers should wait for the database to answer a ping before using it.
+func Connect(cfg Config) (*sqlx.DB, error) {
+	conn, err := sqlx.Open(driverName, cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Idle connections are reaped before a pooler like PgBouncer kills them
+	// under us, which would fail the next query to use one
+	conn.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	if cfg.ConnMaxIdleTime > 0 {
+		cfg.logf("Idle database connections are closed after %s", cfg.ConnMaxIdleTime)
+	} else {
+		cfg.logf("Idle database connections are kept open (DB_CONN_MAX_IDLE_TIME=0)")
+	}
+
+	// database/sql keeps two idle connections by default; more would be closed
+	// as soon as Warmup returned them
+	if cfg.WarmupConns > defaultMaxIdleConns {
+		conn.SetMaxIdleConns(cfg.WarmupConns)
+	}
+
+	return conn, nil
+}
+
+// defaultMaxIdleConns is database/sql's limit on idle connections when none is set
+const defaultMaxIdleConns = 2
+
+// Warmup opens cfg.WarmupConns connections at once and pings each, then returns
+// them to the pool idle, so the first requests after startup don't each pay for
+// a new connection. Call it once the database is up. It reports how many were
+// warmed; a connection that fails is logged and skipped, as the pool will try
+// again when it is needed. Idle connections are still closed after ConnMaxIdleTime.
+func Warmup(ctx context.Context, conn *sqlx.DB, cfg Config) int {
+	if cfg.WarmupConns == 0 {
+		return 0
+	}
+
+	// Every connection is held until all are open, or the pool would hand the
+	// same one out again
+	conns := make([]*sql.Conn, 0, cfg.WarmupConns)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	var failed error
+	for range cfg.WarmupConns {
+		c, err := conn.Conn(ctx)
+		if err == nil {
+			err = c.PingContext(ctx)
+			if err != nil {
+				c.Close()
+			}
+		}
+		if err != nil {
+			failed = err
+			continue
+		}
+		conns = append(conns, c)
+	}
+
+	if failed != nil {
+		cfg.logf("Warmed %d of %d database connections (DB_WARMUP_CONNS); last error: %v", len(conns), cfg.WarmupConns, failed)
+	} else {
+		cfg.logf("Warmed %d database connections (DB_WARMUP_CONNS)", len(conns))
+	}
+	return len(conns)
+}
+
+// Migrations are one service's schema migrations
+type Migrations struct {
+	// FS holds the NNNNNN_name.up.sql and .down.sql files at its root
+	FS fs.FS
+	// Service names the table versions are kept in: <Service>_schema_migrations
+	Service string
+
+	// Logf receives progress messages; log.Printf if nil
+	Logf func(format string, args ...any)
+}
+
+// MigrationsFromEnv returns embedded, the migrations compiled into the binary,
+// unless MIGRATIONS_DIR names a directory to read them from instead. The
+// directory is for development, to try a migration without rebuilding; images
+// don't need to ship one.
+func MigrationsFromEnv(embedded fs.FS) (fs.FS, error) {
+	dir := os.Getenv("MIGRATIONS_DIR")
+	if dir == "" {
+		return embedded, nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("invalid MIGRATIONS_DIR %q: not a directory", dir)
+	}
+	log.Printf("WARNING: MIGRATIONS_DIR is set; migrations are read from %s instead of the binary", dir)
+	return os.DirFS(dir), nil
+}
+
+// Table is where the versions of these migrations are kept
+func (m Migrations) Table() string {
+	return m.Service + "_" + LegacyMigrationsTable
+}
+
+// Migrate runs all pending migrations
+func Migrate(conn *sqlx.DB, m Migrations) error {
+	if m.Service == "" {
+		return errors.New("migrations need a service name")
+	}
+	cfg := Config{Logf: m.Logf}
+	cfg.logf("Running migrations...")
+
+	if err := adoptLegacyVersion(conn.DB, m.Table(), cfg); err != nil {
+		return err
+	}
+
+	source, err := iofs.New(m.FS, ".")
+	if err != nil {
+		return fmt.Errorf("could not read migrations: %w", err)
+	}
+	driver, err := postgres.WithInstance(conn.DB, &postgres.Config{MigrationsTable: m.Table()})
+	if err != nil {
+		return fmt.Errorf("could not start postgres driver: %w", err)
+	}
+
+	migrator, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("could not create migrate instance: %w", err)
+	}
+
+	if err := migrator.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	cfg.logf("Migrations complete.")
+	return nil
+}
+
+// adoptLegacyVersion creates table from the version in LegacyMigrationsTable,
+// when the service's own table doesn't exist yet, so a database migrated before
+// the tables were split isn't migrated again from scratch. The legacy table is
+// left in place for older releases.
+func adoptLegacyVersion(conn *sql.DB, table string, cfg Config) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("could not check migration version: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Replicas starting together adopt the version once
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", table); err != nil {
+		return fmt.Errorf("could not lock migration version: %w", err)
+	}
+	var exists, legacy bool
+	err = tx.QueryRow("SELECT to_regclass($1) IS NOT NULL, to_regclass($2) IS NOT NULL", table, LegacyMigrationsTable).Scan(&exists, &legacy)
+	if err != nil {
+		return fmt.Errorf("could not check migration version: %w", err)
+	}
+	if exists || !legacy {
+		return nil
+	}
+
+	var version int64
+	var dirty bool
+	err = tx.QueryRow("SELECT version, dirty FROM "+LegacyMigrationsTable+" LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", LegacyMigrationsTable, err)
+	}
+
+	if _, err := tx.Exec("CREATE TABLE " + table + " (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)"); err != nil {
+		return fmt.Errorf("could not create %s: %w", table, err)
+	}
+	if _, err := tx.Exec("INSERT INTO "+table+" (version, dirty) VALUES ($1, $2)", version, dirty); err != nil {
+		return fmt.Errorf("could not adopt migration version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not adopt migration version: %w", err)
+	}
+	cfg.logf("Adopted migration version %d from %s into %s", version, LegacyMigrationsTable, table)
+	return nil
+}
@@ -0,0 +1,325 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+// stubDriver hands out connections that do nothing, counting those open, so the
+// pool settings Connect applies can be watched without a database
+type stubDriver struct {
+	open atomic.Int32
+}
+
+func (d *stubDriver) Open(string) (driver.Conn, error) {
+	d.open.Add(1)
+	return &stubConn{d: d}, nil
+}
+
+type stubConn struct {
+	d *stubDriver
+}
+
+func (c *stubConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *stubConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+func (c *stubConn) Close() error {
+	c.d.open.Add(-1)
+	return nil
+}
+
+// connectStub makes Connect open a fresh stubDriver for the rest of the test
+func connectStub(t *testing.T) *stubDriver {
+	t.Helper()
+	d := &stubDriver{}
+	name := "stub-" + t.Name()
+	sql.Register(name, d)
+	previous := driverName
+	driverName = name
+	t.Cleanup(func() { driverName = previous })
+	return d
+}
+
+// logLines returns a Logf collecting what it is given, and the lines so far
+func logLines() (func(string, ...any), *[]string) {
+	var lines []string
+	return func(format string, args ...any) { lines = append(lines, fmt.Sprintf(format, args...)) }, &lines
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name              string
+		url, idle, warmup string
+		want              Config
+		wantErr           string
+	}{
+		{name: "defaults", url: "postgres://db/app",
+			want: Config{URL: "postgres://db/app", ConnMaxIdleTime: DefaultConnMaxIdleTime}},
+		{name: "idle time", url: "postgres://db/app", idle: "30s",
+			want: Config{URL: "postgres://db/app", ConnMaxIdleTime: 30 * time.Second}},
+		{name: "idle time off", url: "postgres://db/app", idle: "0",
+			want: Config{URL: "postgres://db/app"}},
+		{name: "warmup", url: "postgres://db/app", warmup: "8",
+			want: Config{URL: "postgres://db/app", ConnMaxIdleTime: DefaultConnMaxIdleTime, WarmupConns: 8}},
+		{name: "no url", wantErr: "DATABASE_URL not set"},
+		{name: "idle time without unit", url: "postgres://db/app", idle: "30", wantErr: `invalid DB_CONN_MAX_IDLE_TIME "30"`},
+		{name: "negative idle time", url: "postgres://db/app", idle: "-1m", wantErr: `invalid DB_CONN_MAX_IDLE_TIME "-1m"`},
+		{name: "negative warmup", url: "postgres://db/app", warmup: "-1", wantErr: `invalid DB_WARMUP_CONNS "-1"`},
+		{name: "warmup not a number", url: "postgres://db/app", warmup: "many", wantErr: `invalid DB_WARMUP_CONNS "many"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DATABASE_URL", tt.url)
+			t.Setenv("DB_CONN_MAX_IDLE_TIME", tt.idle)
+			t.Setenv("DB_WARMUP_CONNS", tt.warmup)
+
+			cfg, err := ConfigFromEnv()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.URL != tt.want.URL || cfg.ConnMaxIdleTime != tt.want.ConnMaxIdleTime || cfg.WarmupConns != tt.want.WarmupConns {
+				t.Errorf("got %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectReapsIdleConnections(t *testing.T) {
+	d := connectStub(t)
+	logf, lines := logLines()
+
+	conn, err := Connect(Config{URL: "stub", ConnMaxIdleTime: 10 * time.Millisecond, Logf: logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.Stats().Idle; got != 1 {
+		t.Fatalf("idle = %d, want the pinged connection", got)
+	}
+
+	// database/sql checks for idle connections at most once a second
+	deadline := time.Now().Add(3 * time.Second)
+	for conn.Stats().MaxIdleTimeClosed == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if stats := conn.Stats(); stats.MaxIdleTimeClosed != 1 || stats.Idle != 0 || d.open.Load() != 0 {
+		t.Errorf("closed %d for idle time, %d idle, %d open; want the connection closed", stats.MaxIdleTimeClosed, stats.Idle, d.open.Load())
+	}
+
+	if want := "Idle database connections are closed after 10ms"; strings.Join(*lines, "\n") != want {
+		t.Errorf("logged %q, want %q", *lines, want)
+	}
+}
+
+func TestConnectKeepsIdleConnectionsWhenOff(t *testing.T) {
+	d := connectStub(t)
+	logf, lines := logLines()
+
+	conn, err := Connect(Config{URL: "stub", Logf: logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if stats := conn.Stats(); stats.MaxIdleTimeClosed != 0 || stats.Idle != 1 || d.open.Load() != 1 {
+		t.Errorf("closed %d for idle time, %d idle, %d open; want the connection kept", stats.MaxIdleTimeClosed, stats.Idle, d.open.Load())
+	}
+
+	if want := "Idle database connections are kept open (DB_CONN_MAX_IDLE_TIME=0)"; strings.Join(*lines, "\n") != want {
+		t.Errorf("logged %q, want %q", *lines, want)
+	}
+}
+
+func TestWarmupKeepsConnectionsIdle(t *testing.T) {
+	d := connectStub(t)
+	logf, lines := logLines()
+	cfg := Config{URL: "stub", ConnMaxIdleTime: time.Hour, WarmupConns: 5, Logf: logf}
+
+	conn, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// More than database/sql's default of two idle connections stay in the pool
+	if got := Warmup(context.Background(), conn, cfg); got != 5 {
+		t.Errorf("warmed %d, want 5", got)
+	}
+	if stats := conn.Stats(); stats.Idle != 5 || d.open.Load() != 5 {
+		t.Errorf("%d idle, %d open, want 5 of each", stats.Idle, d.open.Load())
+	}
+	if last := (*lines)[len(*lines)-1]; last != "Warmed 5 database connections (DB_WARMUP_CONNS)" {
+		t.Errorf("logged %q", last)
+	}
+}
+
+func TestWarmupDisabled(t *testing.T) {
+	d := connectStub(t)
+	conn, err := Connect(Config{URL: "stub", Logf: func(string, ...any) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := Warmup(context.Background(), conn, Config{}); got != 0 || d.open.Load() != 0 {
+		t.Errorf("warmed %d with %d open, want none", got, d.open.Load())
+	}
+}
+
+// newMockDB returns a database over sqlmock; unmet expectations fail the test
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// expectVersionCheck expects adoptLegacyVersion to lock and look for both tables
+func expectVersionCheck(mock sqlmock.Sqlmock, exists, legacy bool) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock(hashtext($1))")).
+		WithArgs("users_schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT to_regclass($1) IS NOT NULL, to_regclass($2) IS NOT NULL")).
+		WithArgs("users_schema_migrations", LegacyMigrationsTable).
+		WillReturnRows(sqlmock.NewRows([]string{"exists", "legacy"}).AddRow(exists, legacy))
+}
+
+func TestAdoptLegacyVersion(t *testing.T) {
+	db, mock := newMockDB(t)
+	logf, lines := logLines()
+
+	expectVersionCheck(mock, false, true)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, dirty FROM schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(4, false))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE users_schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users_schema_migrations (version, dirty) VALUES ($1, $2)")).
+		WithArgs(4, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := adoptLegacyVersion(db, "users_schema_migrations", Config{Logf: logf}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Adopted migration version 4 from schema_migrations into users_schema_migrations"; strings.Join(*lines, "\n") != want {
+		t.Errorf("logged %q, want %q", *lines, want)
+	}
+}
+
+func TestAdoptLegacyVersionLeavesOtherDatabasesAlone(t *testing.T) {
+	tests := []struct {
+		name           string
+		exists, legacy bool
+		legacyRows     *sqlmock.Rows // the legacy table's rows, when it is read
+	}{
+		{name: "already adopted", exists: true, legacy: true},
+		{name: "new database", exists: false, legacy: false},
+		{name: "empty legacy table", exists: false, legacy: true, legacyRows: sqlmock.NewRows([]string{"version", "dirty"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectVersionCheck(mock, tt.exists, tt.legacy)
+			if tt.legacyRows != nil {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT version, dirty FROM schema_migrations")).WillReturnRows(tt.legacyRows)
+			}
+			// Nothing is created, and the transaction only held the lock
+			mock.ExpectRollback()
+
+			if err := adoptLegacyVersion(db, "users_schema_migrations", Config{Logf: func(string, ...any) {}}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAdoptLegacyVersionFailsWhenLockFails(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock")).WillReturnError(errors.New("permission denied"))
+	mock.ExpectRollback()
+
+	if err := adoptLegacyVersion(db, "users_schema_migrations", Config{}); err == nil {
+		t.Error("err = nil, want the lock error")
+	}
+}
+
+func TestMigrationsTableIsPerService(t *testing.T) {
+	users := Migrations{Service: "users"}.Table()
+	products := Migrations{Service: "products"}.Table()
+	if users != "users_schema_migrations" || products != "products_schema_migrations" {
+		t.Errorf("tables = %q and %q, want users_schema_migrations and products_schema_migrations", users, products)
+	}
+}
+
+func TestMigrateRequiresService(t *testing.T) {
+	// No query is expected: the missing name is caught first
+	db, _ := newMockDB(t)
+	if err := Migrate(sqlx.NewDb(db, "postgres"), Migrations{FS: fstest.MapFS{}}); err == nil {
+		t.Error("err = nil, want an error for the missing service name")
+	}
+}
+
+func TestMigrationsFromEnv(t *testing.T) {
+	embedded := fstest.MapFS{"000001_init.up.sql": {Data: []byte("SELECT 1")}}
+
+	t.Setenv("MIGRATIONS_DIR", "")
+	if got, err := MigrationsFromEnv(embedded); err != nil || !reflect.DeepEqual(got, fs.FS(embedded)) {
+		t.Errorf("got %v, %v, want the embedded migrations", got, err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "000001_dev.up.sql"), []byte("SELECT 2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MIGRATIONS_DIR", dir)
+	got, err := MigrationsFromEnv(embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := fs.ReadFile(got, "000001_dev.up.sql"); err != nil || string(body) != "SELECT 2" {
+		t.Errorf("read %q, %v, want the directory's migration", body, err)
+	}
+
+	t.Setenv("MIGRATIONS_DIR", filepath.Join(dir, "000001_dev.up.sql"))
+	if _, err := MigrationsFromEnv(embedded); err == nil {
+		t.Error("err = nil, want an error for a file")
+	}
+}
@@ -0,0 +1,220 @@
+// Package dbtest is an in-memory stand-in for Postgres, for tests of code that
+// serializes on transaction-scoped advisory locks. sqlmock answers statements one
+// at a time in a fixed order, so it can't show that concurrent transactions wait
+// for each other; DB blocks on pg_advisory_xact_lock as Postgres does.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// DB is a database whose statements are answered by Handle, except for
+// pg_advisory_xact_lock, which DB answers itself: it blocks until no other open
+// transaction holds a lock on the same arguments, and the lock is let go when the
+// transaction commits or rolls back. It is safe for concurrent use; Handle must be too.
+type DB struct {
+	// Handle answers every other statement, made in tx. A statement outside a
+	// transaction gets one of its own, committed once Handle returns.
+	Handle func(tx *Tx, query string, args []driver.Value) (*Result, error)
+
+	mu   sync.Mutex
+	held map[string]*lock
+}
+
+// lock is an advisory lock held by a transaction
+type lock struct {
+	owner    *Tx
+	released chan struct{}
+}
+
+// Result is what a statement returns; nil is no rows, as for a write without RETURNING
+type Result struct {
+	Columns []string
+	Rows    [][]driver.Value
+}
+
+// Tx is a transaction on DB
+type Tx struct {
+	locks    []string
+	onCommit []func()
+}
+
+// OnCommit runs fn if tx commits. Handle uses it to make a transaction's writes
+// visible to others only once it ends, as Postgres would.
+func (tx *Tx) OnCommit(fn func()) {
+	tx.onCommit = append(tx.onCommit, fn)
+}
+
+// Open returns a *sql.DB on d. Each connection holds at most one transaction.
+func (d *DB) Open() *sql.DB {
+	return sql.OpenDB(connector{d})
+}
+
+// acquire takes the advisory lock on key for tx, waiting for any other
+// transaction holding it. Like Postgres, a transaction can take its own lock again.
+func (d *DB) acquire(ctx context.Context, tx *Tx, key string) error {
+	for {
+		d.mu.Lock()
+		if d.held == nil {
+			d.held = map[string]*lock{}
+		}
+		l, ok := d.held[key]
+		if !ok {
+			d.held[key] = &lock{owner: tx, released: make(chan struct{})}
+			tx.locks = append(tx.locks, key)
+		}
+		d.mu.Unlock()
+		if !ok || l.owner == tx {
+			return nil
+		}
+
+		select {
+		case <-l.released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// end finishes tx, running its commit hooks if it committed, then lets go of its locks
+func (d *DB) end(tx *Tx, commit bool) {
+	if commit {
+		for _, fn := range tx.onCommit {
+			fn()
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range tx.locks {
+		if l := d.held[key]; l != nil && l.owner == tx {
+			close(l.released)
+			delete(d.held, key)
+		}
+	}
+	tx.locks, tx.onCommit = nil, nil
+}
+
+// run answers one statement in the connection's transaction, or in one of its own
+func (d *DB) run(ctx context.Context, c *conn, query string, named []driver.NamedValue) (*Result, error) {
+	args := make([]driver.Value, len(named))
+	for i, v := range named {
+		args[i] = v.Value
+	}
+
+	tx, own := c.tx, c.tx == nil
+	if own {
+		tx = &Tx{}
+	}
+	var result *Result
+	var err error
+	if strings.Contains(query, "pg_advisory_xact_lock") {
+		keys := make([]string, len(args))
+		for i, arg := range args {
+			keys[i] = fmt.Sprint(arg)
+		}
+		result, err = &Result{}, d.acquire(ctx, tx, strings.Join(keys, ":"))
+	} else {
+		result, err = d.Handle(tx, query, args)
+	}
+	if own {
+		d.end(tx, err == nil)
+	}
+	if result == nil {
+		result = &Result{}
+	}
+	return result, err
+}
+
+type connector struct{ db *DB }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return &conn{db: c.db}, nil }
+func (connector) Driver() driver.Driver                          { return drv{} }
+
+type drv struct{}
+
+func (drv) Open(string) (driver.Conn, error) {
+	return nil, errors.New("dbtest: open a DB with DB.Open")
+}
+
+type conn struct {
+	db *DB
+	tx *Tx
+}
+
+func (c *conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("dbtest: prepared statements are not supported")
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		c.db.end(c.tx, false)
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("dbtest: transaction already open")
+	}
+	c.tx = &Tx{}
+	return connTx{c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.db.run(ctx, c, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(result.Rows)), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.db.run(ctx, c, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: result}, nil
+}
+
+type connTx struct{ c *conn }
+
+func (t connTx) Commit() error   { return t.finish(true) }
+func (t connTx) Rollback() error { return t.finish(false) }
+
+func (t connTx) finish(commit bool) error {
+	tx := t.c.tx
+	if tx == nil {
+		return sql.ErrTxDone
+	}
+	t.c.tx = nil
+	t.c.db.end(tx, commit)
+	return nil
+}
+
+type rows struct {
+	result *Result
+	next   int
+}
+
+func (r *rows) Columns() []string { return r.result.Columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.next])
+	r.next++
+	return nil
+}
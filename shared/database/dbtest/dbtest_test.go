@@ -0,0 +1,148 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lockSQL is how callers take an advisory lock, as user-service's LockEmail does
+const lockSQL = "SELECT pg_advisory_xact_lock(hashtextextended($1::text || ':' || $2::text, 0))"
+
+// begin opens a transaction holding the lock on tenant and key
+func begin(t *testing.T, db *sql.DB, tenant, key string) *sql.Tx {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(lockSQL, tenant, key); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// lockAsync takes the lock on tenant and key in a new transaction, reporting on
+// the channel once it has it
+func lockAsync(db *sql.DB, tenant, key string) (<-chan *sql.Tx, <-chan error) {
+	locked, failed := make(chan *sql.Tx, 1), make(chan error, 1)
+	go func() {
+		tx, err := db.Begin()
+		if err == nil {
+			_, err = tx.Exec(lockSQL, tenant, key)
+		}
+		if err != nil {
+			failed <- err
+			return
+		}
+		locked <- tx
+	}()
+	return locked, failed
+}
+
+func TestAdvisoryLockWaitsForHolder(t *testing.T) {
+	for _, end := range []string{"commit", "rollback"} {
+		t.Run(end, func(t *testing.T) {
+			db := (&DB{}).Open()
+			defer db.Close()
+
+			first := begin(t, db, "acme", "ann")
+			locked, failed := lockAsync(db, "acme", "ann")
+			select {
+			case <-locked:
+				t.Fatal("second transaction took a lock the first holds")
+			case err := <-failed:
+				t.Fatal(err)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			if end == "commit" {
+				first.Commit()
+			} else {
+				first.Rollback()
+			}
+			select {
+			case second := <-locked:
+				second.Rollback()
+			case err := <-failed:
+				t.Fatal(err)
+			case <-time.After(time.Second):
+				t.Fatalf("lock not let go on %s", end)
+			}
+		})
+	}
+}
+
+func TestAdvisoryLocksOnOtherKeysDontWait(t *testing.T) {
+	db := (&DB{}).Open()
+	defer db.Close()
+
+	held := begin(t, db, "acme", "ann")
+	defer held.Rollback()
+	// The same key again in the holder, and other keys elsewhere, go straight through
+	if _, err := held.Exec(lockSQL, "acme", "ann"); err != nil {
+		t.Fatal(err)
+	}
+	begin(t, db, "acme", "bob").Rollback()
+	begin(t, db, "other", "ann").Rollback()
+}
+
+func TestAdvisoryLockWaitEndsWithContext(t *testing.T) {
+	db := (&DB{}).Open()
+	defer db.Close()
+
+	held := begin(t, db, "acme", "ann")
+	defer held.Rollback()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, lockSQL, "acme", "ann"); err == nil {
+		t.Error("err = nil, want the context's error")
+	}
+}
+
+func TestHandleAnswersOtherStatements(t *testing.T) {
+	var committed atomic.Int32
+	db := (&DB{Handle: func(tx *Tx, query string, args []driver.Value) (*Result, error) {
+		if query == "INSERT" {
+			tx.OnCommit(func() { committed.Add(1) })
+			return nil, nil
+		}
+		return &Result{Columns: []string{"n"}, Rows: [][]driver.Value{{int64(committed.Load())}, {args[0]}}}, nil
+	}}).Open()
+	defer db.Close()
+
+	// Writes are only seen once their transaction commits
+	tx, _ := db.Begin()
+	tx.Exec("INSERT")
+	tx.Rollback()
+	tx, _ = db.Begin()
+	tx.Exec("INSERT")
+	tx.Commit()
+	// A statement outside a transaction commits on its own
+	db.Exec("INSERT")
+
+	rows, err := db.Query("SELECT", int64(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []int64
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 7 {
+		t.Errorf("rows = %v, want [2 7]", got)
+	}
+}
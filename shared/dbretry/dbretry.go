@@ -0,0 +1,110 @@
+// Package dbretry retries reads that failed because their database connection
+// was lost, e.g. while Postgres restarts. A read that is safe to run again
+// (see querylog.IsRead) is retried once; database/sql drops the broken
+// connection, so the retry goes out on another one from the pool. Writes are
+// never retried, since the first attempt may have been applied.
+//
+// DB wraps the DBTX of sqlc-generated queries. Queries run in a transaction
+// (Queries.WithTx) go to the *sql.Tx directly and aren't retried: the
+// transaction dies with its connection.
+package dbretry
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"shared/querylog"
+	"strconv"
+	"syscall"
+
+	"github.com/lib/pq"
+)
+
+// DBTX is the interface sqlc-generated queries run through
+type DBTX interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+// EnabledFromEnv reads DB_RETRY_READS; reads are retried unless it is false
+func EnabledFromEnv() (bool, error) {
+	raw := os.Getenv("DB_RETRY_READS")
+	if raw == "" {
+		return true, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return true, fmt.Errorf("invalid DB_RETRY_READS %q", raw)
+	}
+	return enabled, nil
+}
+
+// IsConnectionLost reports whether err means the connection a query ran on
+// broke, rather than the query itself failing
+func IsConnectionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		return pqErr.Code.Class() == "08" // connection_exception
+	}
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// DB retries reads that lost their connection
+type DB struct {
+	DBTX
+	logf func(format string, args ...any)
+}
+
+// Wrap returns db with read retries, or db itself if they are disabled
+func Wrap(db DBTX, enabled bool) DBTX {
+	if !enabled {
+		return db
+	}
+	return &DB{DBTX: db, logf: log.Printf}
+}
+
+// QueryContext runs a query, running it again if it was a read and lost its
+// connection. Errors while reading the rows aren't retried, since some may
+// already have been consumed.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	rows, err := db.DBTX.QueryContext(ctx, query, args...)
+	if db.retry(ctx, query, err) {
+		rows, err = db.DBTX.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+// QueryRowContext runs a query, running it again if it was a read and lost its connection
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	row := db.DBTX.QueryRowContext(ctx, query, args...)
+	if db.retry(ctx, query, row.Err()) {
+		row = db.DBTX.QueryRowContext(ctx, query, args...)
+	}
+	return row
+}
+
+func (db *DB) retry(ctx context.Context, query string, err error) bool {
+	if ctx.Err() != nil || !IsConnectionLost(err) || !querylog.IsRead(query) {
+		return false
+	}
+	db.logf("Retrying %s after losing its database connection: %v", querylog.QueryName(query), err)
+	return true
+}
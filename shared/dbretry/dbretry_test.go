@@ -0,0 +1,183 @@
+package dbretry
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"syscall"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// adminShutdown is what a query gets when Postgres restarts under it. database/sql
+// only retries driver.ErrBadConn itself, so this reaches the wrapper.
+var adminShutdown = &pq.Error{Code: "57P01", Message: "terminating connection due to administrator command"}
+
+const getProduct = "-- name: GetProduct :one\nSELECT id, name FROM products WHERE id = $1"
+
+// newDB returns a retrying DB over sqlmock and the number of retries it logged
+func newDB(t *testing.T) (*DB, sqlmock.Sqlmock, *int) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	retries := 0
+	return &DB{DBTX: db, logf: func(string, ...any) { retries++ }}, mock, &retries
+}
+
+func TestReadRecoversOnRetry(t *testing.T) {
+	db, mock, retries := newDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(getProduct)).WithArgs(1).WillReturnError(adminShutdown)
+	mock.ExpectQuery(regexp.QuoteMeta(getProduct)).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Lamp"))
+
+	var id int
+	var name string
+	if err := db.QueryRowContext(context.Background(), getProduct, 1).Scan(&id, &name); err != nil {
+		t.Fatalf("err = %v, want the retry to succeed", err)
+	}
+	if name != "Lamp" || *retries != 1 {
+		t.Errorf("got %q after %d retries, want Lamp after 1", name, *retries)
+	}
+}
+
+func TestQueryRecoversOnRetry(t *testing.T) {
+	db, mock, retries := newDB(t)
+	query := "-- name: ListProducts :many\nSELECT id FROM products"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(fmt.Errorf("read: %w", syscall.ECONNRESET))
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+
+	rows, err := db.QueryContext(context.Background(), query)
+	if err != nil {
+		t.Fatalf("err = %v, want the retry to succeed", err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if n != 2 || *retries != 1 {
+		t.Errorf("got %d rows after %d retries, want 2 after 1", n, *retries)
+	}
+}
+
+func TestReadIsRetriedOnlyOnce(t *testing.T) {
+	db, mock, retries := newDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(getProduct)).WithArgs(1).WillReturnError(adminShutdown)
+	mock.ExpectQuery(regexp.QuoteMeta(getProduct)).WithArgs(1).WillReturnError(adminShutdown)
+
+	err := db.QueryRowContext(context.Background(), getProduct, 1).Scan(new(int), new(string))
+	if !errors.Is(err, adminShutdown) || *retries != 1 {
+		t.Errorf("got %v after %d retries, want the second failure after 1", err, *retries)
+	}
+}
+
+func TestNotRetried(t *testing.T) {
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		query string
+		err   error
+	}{
+		// The insert may have been applied before the connection went
+		{name: "write", ctx: context.Background(), query: "-- name: CreateProduct :one\nINSERT INTO products (name) VALUES ($1) RETURNING id", err: adminShutdown},
+		{name: "write in a CTE", ctx: context.Background(), query: "WITH moved AS (UPDATE products SET stock = 0 RETURNING id) SELECT id FROM moved", err: adminShutdown},
+		{name: "query error", ctx: context.Background(), query: getProduct, err: &pq.Error{Code: "42P01", Message: "relation does not exist"}},
+		{name: "no rows", ctx: context.Background(), query: getProduct, err: sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, retries := newDB(t)
+			// Only one attempt is expected, so a retry fails the expectations
+			mock.ExpectQuery(regexp.QuoteMeta(tt.query)).WillReturnError(tt.err)
+
+			rows, err := db.QueryContext(tt.ctx, tt.query)
+			if err == nil {
+				rows.Close()
+				t.Fatal("err = nil, want the first attempt's error")
+			}
+			if *retries != 0 {
+				t.Errorf("retried %d times, want none", *retries)
+			}
+		})
+	}
+}
+
+// cancelOnQuery cancels the caller's context while its query runs, as a client
+// going away during a Postgres restart would
+type cancelOnQuery struct {
+	DBTX
+	cancel context.CancelFunc
+}
+
+func (c cancelOnQuery) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer c.cancel()
+	return c.DBTX.QueryContext(context.WithoutCancel(ctx), query, args...)
+}
+
+func TestNotRetriedOnceContextIsDone(t *testing.T) {
+	db, mock, retries := newDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.DBTX = cancelOnQuery{DBTX: db.DBTX, cancel: cancel}
+	mock.ExpectQuery(regexp.QuoteMeta(getProduct)).WillReturnError(adminShutdown)
+
+	if _, err := db.QueryContext(ctx, getProduct); !errors.Is(err, adminShutdown) || *retries != 0 {
+		t.Errorf("got %v after %d retries, want the first failure and no retry", err, *retries)
+	}
+}
+
+func TestIsConnectionLost(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("query: %w", driver.ErrBadConn), true},
+		{io.EOF, true},
+		{io.ErrUnexpectedEOF, true},
+		{syscall.ECONNRESET, true},
+		{syscall.EPIPE, true},
+		{adminShutdown, true},
+		{&pq.Error{Code: "57P02"}, true},  // crash_shutdown
+		{&pq.Error{Code: "57P03"}, true},  // cannot_connect_now
+		{&pq.Error{Code: "08006"}, true},  // connection_failure
+		{&pq.Error{Code: "57014"}, false}, // query_canceled
+		{&pq.Error{Code: "23505"}, false}, // unique_violation
+		{sql.ErrNoRows, false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		if got := IsConnectionLost(tt.err); got != tt.want {
+			t.Errorf("IsConnectionLost(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWrapDisabled(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got := Wrap(db, false); got != DBTX(db) {
+		t.Error("Wrap(db, false) wrapped db, want it returned as is")
+	}
+	if _, ok := Wrap(db, true).(*DB); !ok {
+		t.Error("Wrap(db, true) didn't wrap db")
+	}
+}
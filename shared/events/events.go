@@ -0,0 +1,195 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Event is a domain event such as product.created
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// Publisher delivers events to an event sink
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+	Close() error
+}
+
+// Pinger is implemented by publishers that can report whether their sink is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// FromEnv builds the Publisher selected by EVENT_SINK:
+//
+//	EVENT_SINK=webhook  POST each event to EVENT_WEBHOOK_URL
+//	EVENT_SINK=nats     publish each event to NATS_URL, using the event type as subject
+//	EVENT_SINK=memory   keep events in memory (local development)
+//	unset or "none"     discard events
+func FromEnv() (Publisher, error) {
+	switch sink := os.Getenv("EVENT_SINK"); sink {
+	case "", "none":
+		return Nop{}, nil
+	case "webhook":
+		url := os.Getenv("EVENT_WEBHOOK_URL")
+		if url == "" {
+			return nil, fmt.Errorf("EVENT_SINK=webhook requires EVENT_WEBHOOK_URL")
+		}
+		return NewWebhook(url), nil
+	case "nats":
+		url := os.Getenv("NATS_URL")
+		if url == "" {
+			url = nats.DefaultURL
+		}
+		return NewNATS(url)
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_SINK %q", sink)
+	}
+}
+
+// Fanout publishes every event to each of its publishers
+type Fanout []Publisher
+
+func (f Fanout) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, p := range f {
+		if err := p.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f Fanout) Close() error {
+	var errs []error
+	for _, p := range f {
+		if err := p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Nop discards every event
+type Nop struct{}
+
+func (Nop) Publish(ctx context.Context, event Event) error { return nil }
+func (Nop) Close() error                                   { return nil }
+
+// Webhook POSTs each event as JSON to a URL
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a Webhook publisher for url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (p *Webhook) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *Webhook) Close() error { return nil }
+
+// NATS publishes each event on a subject named after its type
+type NATS struct {
+	conn *nats.Conn
+}
+
+// NewNATS connects to the NATS server at url. If the server is down the connection
+// keeps retrying in the background, so a missing broker doesn't stop the service starting.
+func NewNATS(url string) (*NATS, error) {
+	conn, err := nats.Connect(url, nats.Name("event-publisher"), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to NATS: %w", err)
+	}
+	return &NATS{conn: conn}, nil
+}
+
+func (p *NATS) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %w", err)
+	}
+	if err := p.conn.Publish(event.Type, body); err != nil {
+		return fmt.Errorf("could not publish to NATS: %w", err)
+	}
+	return nil
+}
+
+// Ping reports whether the connection to the NATS server is up
+func (p *NATS) Ping(ctx context.Context) error {
+	if !p.conn.IsConnected() {
+		return fmt.Errorf("NATS connection is %s", p.conn.Status())
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+// Close flushes pending messages and closes the connection
+func (p *NATS) Close() error {
+	return p.conn.Drain()
+}
+
+// Memory keeps published events in memory. It is safe for concurrent use.
+type Memory struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewMemory creates an empty Memory publisher
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (p *Memory) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *Memory) Close() error { return nil }
+
+// Events returns a copy of everything published so far
+func (p *Memory) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Event(nil), p.events...)
+}
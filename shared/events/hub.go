@@ -0,0 +1,141 @@
+package events
+
+import (
+	"context"
+	"shared/tenant"
+	"sync"
+)
+
+// Delivery is an event as broadcast by a Hub, numbered so subscribers can resume
+type Delivery struct {
+	Seq   uint64
+	Event Event
+}
+
+type hubEntry struct {
+	Delivery
+	tenant string
+}
+
+// Hub is an in-process Publisher that broadcasts events to live subscribers,
+// such as server-sent event streams. Events are scoped to the tenant in the
+// publishing context, and the most recent ones are kept so a subscriber that
+// reconnects can catch up on what it missed.
+type Hub struct {
+	buffer int // per-subscriber channel size
+
+	mu      sync.Mutex
+	seq     uint64
+	history []hubEntry // ring buffer of recent events
+	next    int        // ring position of the next write
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+// NewHub creates a Hub remembering the last history events and buffering up to
+// buffer events per subscriber
+func NewHub(history, buffer int) *Hub {
+	return &Hub{
+		buffer:  buffer,
+		history: make([]hubEntry, 0, history),
+		subs:    map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription receives the events of one tenant from a Hub
+type Subscription struct {
+	// C delivers events in order. It is closed when the Hub closes, or if the
+	// subscriber falls more than its buffer behind; it should then reconnect.
+	C <-chan Delivery
+
+	c      chan Delivery
+	tenant string
+	hub    *Hub
+}
+
+// Publish broadcasts event to the subscribers of the tenant in ctx. Subscribers
+// whose buffer is full are disconnected rather than blocking the publisher.
+func (h *Hub) Publish(ctx context.Context, event Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		// Nobody can be listening any more
+		return nil
+	}
+
+	h.seq++
+	entry := hubEntry{Delivery: Delivery{Seq: h.seq, Event: event}, tenant: tenant.FromContext(ctx)}
+	if len(h.history) < cap(h.history) {
+		h.history = append(h.history, entry)
+	} else if cap(h.history) > 0 {
+		h.history[h.next] = entry
+		h.next = (h.next + 1) % cap(h.history)
+	}
+
+	for sub := range h.subs {
+		if sub.tenant != entry.tenant {
+			continue
+		}
+		select {
+		case sub.c <- entry.Delivery:
+		default:
+			h.drop(sub)
+		}
+	}
+	return nil
+}
+
+// Subscribe starts receiving the events of tenantID. If after is non-zero, the
+// remembered events with a higher sequence number are returned to be sent first.
+func (h *Hub) Subscribe(tenantID string, after uint64) (*Subscription, []Delivery) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := make(chan Delivery, h.buffer)
+	sub := &Subscription{C: c, c: c, tenant: tenantID, hub: h}
+	if h.closed {
+		close(c)
+		return sub, nil
+	}
+	h.subs[sub] = struct{}{}
+
+	var backlog []Delivery
+	if after > 0 {
+		// Walk the ring from oldest to newest
+		for i := range h.history {
+			entry := h.history[(h.next+i)%len(h.history)]
+			if entry.tenant == tenantID && entry.Seq > after {
+				backlog = append(backlog, entry.Delivery)
+			}
+		}
+	}
+	return sub, backlog
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.drop(s)
+}
+
+// drop removes a subscriber and closes its channel; h.mu must be held
+func (h *Hub) drop(sub *Subscription) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Close disconnects every subscriber
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		h.drop(sub)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package featureflag
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Flag declares a feature flag and its default state
+type Flag struct {
+	Name        string
+	Description string
+	Default     bool
+}
+
+// State is the current value of a flag and where that value came from
+type State struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+	Source      string `json:"source"` // "default", "env" or "runtime"
+}
+
+// Set holds the flags declared by a service. It is safe for concurrent use.
+type Set struct {
+	mu    sync.RWMutex
+	flags map[string]*State
+}
+
+// New creates a Set from the declared flags, applying FEATURE_<NAME> env overrides
+func New(flags ...Flag) *Set {
+	s := &Set{flags: make(map[string]*State, len(flags))}
+	for _, f := range flags {
+		state := &State{
+			Name:        f.Name,
+			Description: f.Description,
+			Enabled:     f.Default,
+			Source:      "default",
+		}
+
+		key := EnvKey(f.Name)
+		if raw, ok := os.LookupEnv(key); ok {
+			enabled, err := strconv.ParseBool(raw)
+			if err != nil {
+				log.Printf("[Flags] Ignoring %s=%q: %v", key, raw, err)
+			} else {
+				state.Enabled = enabled
+				state.Source = "env"
+			}
+		}
+
+		s.flags[f.Name] = state
+	}
+	return s
+}
+
+// EnvKey returns the environment variable that overrides a flag, e.g. strict-prices -> FEATURE_STRICT_PRICES
+func EnvKey(name string) string {
+	key := strings.ToUpper(name)
+	key = strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(key)
+	return "FEATURE_" + key
+}
+
+// Enabled reports whether a flag is on. Undeclared flags are always off.
+func (s *Set) Enabled(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.flags[name]
+	return ok && state.Enabled
+}
+
+// Set changes a flag at runtime and logs the change
+func (s *Set) Set(name string, enabled bool) error {
+	s.mu.Lock()
+	state, ok := s.flags[name]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	previous := state.Enabled
+	state.Enabled = enabled
+	state.Source = "runtime"
+	s.mu.Unlock()
+
+	if previous != enabled {
+		log.Printf("[Flags] %s changed: %t -> %t", name, previous, enabled)
+	}
+	return nil
+}
+
+// States returns a copy of every flag's state, sorted by name
+func (s *Set) States() []State {
+	if s == nil {
+		return []State{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make([]State, 0, len(s.flags))
+	for _, state := range s.flags {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Snapshot returns flag name -> enabled, suitable for health payloads
+func (s *Set) Snapshot() map[string]bool {
+	snapshot := map[string]bool{}
+	for _, state := range s.States() {
+		snapshot[state.Name] = state.Enabled
+	}
+	return snapshot
+}
+
+// Handler serves the flag admin API: GET lists flags, POST {"name": ..., "enabled": ...} flips one.
+// It should be mounted behind admin authentication.
+func (s *Set) Handler() http.Handler {
+	return httpx.Methods{
+		http.MethodGet:  s.listFlags,
+		http.MethodPost: s.setFlag,
+	}
+}
+
+func (s *Set) listFlags(w http.ResponseWriter, r *http.Request) {
+	httpx.WriteJSON(w, r, http.StatusOK, s.States())
+}
+
+func (s *Set) setFlag(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string `json:"name"`
+		Enabled *bool  `json:"enabled"`
+	}
+	if err := httpx.DecodeJSON(w, r, &input); err != nil {
+		httpx.Error(w, httpx.StatusCode(err), err.Error())
+		return
+	}
+	if input.Name == "" || input.Enabled == nil {
+		httpx.Error(w, http.StatusBadRequest, "name and enabled are required")
+		return
+	}
+	if err := s.Set(input.Name, *input.Enabled); err != nil {
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.listFlags(w, r)
+}
@@ -0,0 +1,16 @@
+package featureflag
+
+import "net/http"
+
+// Gate serves next only while the named flag is on. While it is off the route
+// answers 404, exactly as if it had never been registered, so a dark-launched
+// endpoint gives nothing away.
+func Gate(s *Set, name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.Enabled(name) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
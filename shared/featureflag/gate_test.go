@@ -0,0 +1,50 @@
+package featureflag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGateHidesRouteWhileFlagIsOff(t *testing.T) {
+	t.Setenv(EnvKey("search"), "false")
+	flags := New(Flag{Name: "search", Default: true})
+
+	mux := http.NewServeMux()
+	mux.Handle("/search", Gate(flags, "search", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// Off, the route can't be told apart from one that was never registered
+	hidden, missing := get("/search"), get("/missing")
+	if hidden.Code != http.StatusNotFound {
+		t.Fatalf("status with the flag off = %d, want 404", hidden.Code)
+	}
+	if hidden.Body.String() != missing.Body.String() {
+		t.Errorf("body = %q, want %q as for an unregistered route", hidden.Body, missing.Body)
+	}
+
+	if err := flags.Set("search", true); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/search"); w.Code != http.StatusNoContent {
+		t.Errorf("status with the flag on = %d, want 204", w.Code)
+	}
+}
+
+func TestGateHidesUndeclaredFlag(t *testing.T) {
+	handler := Gate(New(), "unknown", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called for an undeclared flag")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,174 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"shared/httpx"
+	"sync"
+	"time"
+)
+
+// Overall readiness reported by /readyz
+const (
+	StatusOK       = "ok"       // every dependency is up
+	StatusDegraded = "degraded" // an optional dependency is down; requests are still served
+	StatusDown     = "down"     // a required dependency is down; the service is not ready
+)
+
+// checkTimeout bounds a single dependency check
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is reachable
+type Check func(ctx context.Context) error
+
+// Dependency is something the service talks to. A required dependency being down
+// makes the service unready; an optional one only marks it degraded.
+type Dependency struct {
+	Name     string
+	Required bool
+	Check    Check
+}
+
+// DependencyStatus is the result of checking one dependency
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	Required  bool    `json:"required"`
+	Status    string  `json:"status"` // "up" or "down"
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the aggregated readiness of a service
+type Report struct {
+	Status       string             `json:"status"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+// Checker holds the dependencies of a service
+type Checker struct {
+	mu   sync.RWMutex
+	deps []Dependency
+}
+
+// New creates a Checker for the given dependencies
+func New(deps ...Dependency) *Checker {
+	return &Checker{deps: deps}
+}
+
+// Register adds a dependency
+func (c *Checker) Register(dep Dependency) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deps = append(c.deps, dep)
+}
+
+// Check runs every dependency check in parallel and aggregates the result
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.RLock()
+	deps := append([]Dependency(nil), c.deps...)
+	c.mu.RUnlock()
+
+	statuses := make([]DependencyStatus, len(deps))
+	var wg sync.WaitGroup
+	for i, dep := range deps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = check(ctx, dep)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Dependencies: statuses}
+	for _, s := range statuses {
+		if s.Status == "up" {
+			continue
+		}
+		if s.Required {
+			report.Status = StatusDown
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func check(ctx context.Context, dep Dependency) DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := dep.Check(ctx)
+	status := DependencyStatus{
+		Name:      dep.Name,
+		Required:  dep.Required,
+		Status:    "up",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = "down"
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// ReadyHandler serves the readiness report; it answers 503 only when a required dependency is down
+func (c *Checker) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Check(r.Context())
+
+		status := http.StatusOK
+		if report.Status == StatusDown {
+			status = http.StatusServiceUnavailable
+		}
+		httpx.WriteJSON(w, r, status, report)
+	}
+}
+
+// StartupWaitFromEnv reads STARTUP_WAIT, how long to wait for required dependencies
+// before giving up at startup (default 30s)
+func StartupWaitFromEnv() (time.Duration, error) {
+	wait := 30 * time.Second
+	if raw := os.Getenv("STARTUP_WAIT"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return wait, fmt.Errorf("invalid STARTUP_WAIT %q", raw)
+		}
+		wait = d
+	}
+	return wait, nil
+}
+
+// WaitForRequired retries the required dependencies with exponential backoff until
+// they are all up or wait has passed. Optional dependencies are not waited for.
+func (c *Checker) WaitForRequired(ctx context.Context, wait time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		var down []error
+		for _, s := range c.Check(ctx).Dependencies {
+			if s.Required && s.Status != "up" {
+				down = append(down, fmt.Errorf("%s: %s", s.Name, s.Error))
+			}
+		}
+		if len(down) == 0 {
+			return nil
+		}
+
+		err := errors.Join(down...)
+		log.Printf("Waiting for required dependencies (attempt %d): %v", attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("required dependencies unavailable after %s: %w", wait, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
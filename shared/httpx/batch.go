@@ -0,0 +1,74 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// DefaultMaxBatchSize is the most IDs a bulk request may name when MAX_BATCH_SIZE is unset
+const DefaultMaxBatchSize = 100
+
+// MaxBatchSizeFromEnv reads MAX_BATCH_SIZE, the most IDs a bulk request may name
+func MaxBatchSizeFromEnv() (int, error) {
+	raw := os.Getenv("MAX_BATCH_SIZE")
+	if raw == "" {
+		return DefaultMaxBatchSize, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return DefaultMaxBatchSize, fmt.Errorf("invalid MAX_BATCH_SIZE %q", raw)
+	}
+	return n, nil
+}
+
+// DecodeIDs decodes a bulk request body of the form {"ids":[1,2,3]}, checked by CheckIDs
+func DecodeIDs(w http.ResponseWriter, r *http.Request, max int) ([]int32, error) {
+	var input struct {
+		IDs []int32 `json:"ids"`
+	}
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return nil, err
+	}
+
+	return CheckIDs(input.IDs, max)
+}
+
+// CheckIDs validates the IDs of a bulk request decoded as part of a larger body.
+// It requires at least one and at most max IDs; duplicates are removed.
+func CheckIDs(ids []int32, max int) ([]int32, error) {
+	if len(ids) == 0 {
+		return nil, &DecodeError{Status: http.StatusUnprocessableEntity, Msg: "ids must not be empty"}
+	}
+	if len(ids) > max {
+		return nil, &DecodeError{Status: http.StatusRequestEntityTooLarge, Msg: fmt.Sprintf("at most %d ids can be given at once", max)}
+	}
+
+	seen := make(map[int32]bool, len(ids))
+	unique := make([]int32, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique, nil
+}
+
+// DefaultMaxResultRows is the most rows a response may hold when MAX_RESULT_ROWS is unset
+const DefaultMaxResultRows = 10000
+
+// MaxResultRowsFromEnv reads MAX_RESULT_ROWS, the hard cap on rows returned by a
+// single response. Lists that hit it are marked truncated.
+func MaxResultRowsFromEnv() (int, error) {
+	raw := os.Getenv("MAX_RESULT_ROWS")
+	if raw == "" {
+		return DefaultMaxResultRows, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return DefaultMaxResultRows, fmt.Errorf("invalid MAX_RESULT_ROWS %q", raw)
+	}
+	return n, nil
+}
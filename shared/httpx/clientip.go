@@ -0,0 +1,159 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// TrustedProxies are the networks whose X-Forwarded-For is believed: the gateway
+// and any load balancer in front of it. A request from anywhere else is its own
+// client, whatever headers it sends.
+type TrustedProxies []netip.Prefix
+
+// TrustedProxiesFromEnv reads TRUSTED_PROXIES, a comma-separated list of CIDRs or
+// single addresses, e.g. "10.0.0.0/8,fd00::/8,192.0.2.7"; default none
+func TrustedProxiesFromEnv() (TrustedProxies, error) {
+	return ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+}
+
+// ParseTrustedProxies parses a TRUSTED_PROXIES value
+func ParseTrustedProxies(raw string) (TrustedProxies, error) {
+	var trusted TrustedProxies
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", entry, err)
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	return trusted, nil
+}
+
+// Trusts reports whether addr is in a trusted network
+func (t TrustedProxies) Trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerTrusted reports whether r came straight from a trusted proxy, so its
+// forwarded headers can be believed
+func (t TrustedProxies) PeerTrusted(r *http.Request) bool {
+	peer, ok := peerAddr(r)
+	return ok && t.Trusts(peer)
+}
+
+// clientIP derives the client address of r. From an untrusted peer that is the
+// peer itself. From a trusted one, X-Forwarded-For is walked right to left, each
+// hop having been appended by the proxy after it, and the first address that
+// isn't a trusted proxy is the client; entries left of it are whatever the
+// client sent and are never looked at. A malformed hop ends the walk at the
+// proxy that passed it on.
+func (t TrustedProxies) clientIP(r *http.Request) string {
+	peer, ok := peerAddr(r)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !t.Trusts(peer) {
+		return peer.String()
+	}
+
+	client := peer
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = hop
+		if !t.Trusts(hop) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// peerAddr is the address of the immediate peer, from RemoteAddr
+func peerAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// forwardedFor splits every X-Forwarded-For header of r into its hops, in order
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	return hops
+}
+
+// parseHop parses one X-Forwarded-For entry. Entries are normally bare addresses,
+// but some proxies add a port ("192.0.2.1:4711", "[2001:db8::1]:4711") or
+// bracket an IPv6 address without one ("[2001:db8::1]").
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if strings.HasPrefix(hop, "[") && strings.HasSuffix(hop, "]") {
+		hop = hop[1 : len(hop)-1]
+	}
+	if addr, err := netip.ParseAddr(hop); err == nil && addr.Zone() == "" {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil && addrPort.Addr().Zone() == "" {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+type clientIPKey struct{}
+
+// ClientIPs derives the client address of every request once, trusting the
+// forwarded headers of trusted, for ClientIP to return. Install it outermost.
+func ClientIPs(trusted TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := trusted.clientIP(r)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+		})
+	}
+}
+
+// ClientIP returns the address of the client that made r, as derived by ClientIPs.
+// It is the only place client addresses should come from: RemoteAddr is a proxy
+// for most requests, and X-Forwarded-For is whatever the client wants it to be
+// unless a trusted proxy vouches for it. Without ClientIPs installed it falls
+// back to the peer address.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return TrustedProxies(nil).clientIP(r)
+}
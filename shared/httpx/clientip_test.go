@@ -0,0 +1,120 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string // one X-Forwarded-For header per entry
+		want   string
+	}{
+		// Spoofing: forwarded headers from anyone but a trusted proxy are ignored
+		{name: "untrusted peer, forged header", remote: "203.0.113.9:5000", xff: []string{"10.0.0.5"}, want: "203.0.113.9"},
+		{name: "untrusted peer, forged chain", remote: "203.0.113.9:5000", xff: []string{"198.51.100.1, 10.0.0.2"}, want: "203.0.113.9"},
+		{name: "untrusted IPv6 peer, forged header", remote: "[2001:db8::9]:5000", xff: []string{"198.51.100.1"}, want: "2001:db8::9"},
+		{name: "untrusted peer, no header", remote: "203.0.113.9:5000", want: "203.0.113.9"},
+
+		// A trusted peer vouches for the hop it appended
+		{name: "trusted peer, no header", remote: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "one hop", remote: "10.0.0.1:5000", xff: []string{"203.0.113.5"}, want: "203.0.113.5"},
+		{name: "two trusted hops", remote: "10.0.0.1:5000", xff: []string{"203.0.113.5, 10.0.0.2"}, want: "203.0.113.5"},
+		{name: "three trusted hops", remote: "10.0.0.1:5000", xff: []string{"203.0.113.5, 10.0.0.3, 10.0.0.2"}, want: "203.0.113.5"},
+		{name: "client-sent entries left of the client", remote: "10.0.0.1:5000", xff: []string{"1.2.3.4, 10.9.9.9, 203.0.113.5, 10.0.0.2"}, want: "203.0.113.5"},
+		{name: "hops split over headers", remote: "10.0.0.1:5000", xff: []string{"1.2.3.4", "203.0.113.5, 10.0.0.2"}, want: "203.0.113.5"},
+		{name: "every hop trusted", remote: "10.0.0.1:5000", xff: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "spaces around hops", remote: "10.0.0.1:5000", xff: []string{"  203.0.113.5 ,10.0.0.2  "}, want: "203.0.113.5"},
+
+		// IPv6 and bracketed forms
+		{name: "IPv6 peer and hop", remote: "[fd00::1]:5000", xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "IPv6 peer without port", remote: "fd00::1", xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "bracketed hop with port", remote: "10.0.0.1:5000", xff: []string{"[2001:db8::1]:4711"}, want: "2001:db8::1"},
+		{name: "bracketed hop without port", remote: "10.0.0.1:5000", xff: []string{"[2001:db8::1]"}, want: "2001:db8::1"},
+		{name: "IPv4 hop with port", remote: "10.0.0.1:5000", xff: []string{"203.0.113.5:4711"}, want: "203.0.113.5"},
+		{name: "mapped peer", remote: "[::ffff:10.0.0.1]:5000", xff: []string{"203.0.113.5"}, want: "203.0.113.5"},
+		{name: "mapped hop", remote: "10.0.0.1:5000", xff: []string{"::ffff:203.0.113.5"}, want: "203.0.113.5"},
+		{name: "mixed families", remote: "[fd00::1]:5000", xff: []string{"203.0.113.5, 10.0.0.2"}, want: "203.0.113.5"},
+		{name: "compressed and expanded forms", remote: "10.0.0.1:5000", xff: []string{"2001:0db8:0000:0000:0000:0000:0000:0001"}, want: "2001:db8::1"},
+
+		// A malformed hop ends the walk at the proxy that passed it on
+		{name: "garbage from the client", remote: "10.0.0.1:5000", xff: []string{"unknown"}, want: "10.0.0.1"},
+		{name: "garbage behind a trusted hop", remote: "10.0.0.1:5000", xff: []string{"203.0.113.5, garbage, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "garbage left of the client", remote: "10.0.0.1:5000", xff: []string{"garbage, 203.0.113.5"}, want: "203.0.113.5"},
+		{name: "empty last entry", remote: "10.0.0.1:5000", xff: []string{"203.0.113.5,"}, want: "10.0.0.1"},
+		{name: "empty header", remote: "10.0.0.1:5000", xff: []string{""}, want: "10.0.0.1"},
+		{name: "zone in hop", remote: "10.0.0.1:5000", xff: []string{"fe80::1%eth0"}, want: "10.0.0.1"},
+		{name: "CIDR in hop", remote: "10.0.0.1:5000", xff: []string{"203.0.113.0/24"}, want: "10.0.0.1"},
+		{name: "hostname in hop", remote: "10.0.0.1:5000", xff: []string{"client.example.com"}, want: "10.0.0.1"},
+		{name: "unclosed bracket", remote: "10.0.0.1:5000", xff: []string{"[2001:db8::1"}, want: "10.0.0.1"},
+		{name: "malformed peer", remote: "not-an-address", xff: []string{"203.0.113.5"}, want: "not-an-address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, xff := range tt.xff {
+				r.Header.Add("X-Forwarded-For", xff)
+			}
+
+			var got string
+			ClientIPs(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutMiddlewareTrustsNobody(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.5")
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("ClientIP = %q, want the peer", got)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "", want: "[]"},
+		{raw: "10.0.0.0/8", want: "[10.0.0.0/8]"},
+		{raw: " 10.1.2.3/8 , , 192.0.2.7 ", want: "[10.0.0.0/8 192.0.2.7/32]"},
+		{raw: "2001:db8::1", want: "[2001:db8::1/128]"},
+		{raw: "::ffff:10.0.0.0/104", want: "[10.0.0.0/8]"},
+		{raw: "::ffff:192.0.2.7", want: "[192.0.2.7/32]"},
+		{raw: "10.0.0.0/33", wantErr: true},
+		{raw: "proxy.internal", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTrustedProxies(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseTrustedProxies(%q) = %v, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTrustedProxies(%q): %v", tt.raw, err)
+			continue
+		}
+		if s := fmt.Sprint(got); s != tt.want {
+			t.Errorf("ParseTrustedProxies(%q) = %s, want %s", tt.raw, s, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ContentTypeMode says whether DecodeJSON insists on a JSON Content-Type
+type ContentTypeMode string
+
+const (
+	// ContentTypeStrict answers 415 unless the request says application/json
+	// (parameters such as charset are allowed)
+	ContentTypeStrict ContentTypeMode = "strict"
+	// ContentTypeLenient decodes the body whatever Content-Type it came with, for
+	// clients that can't be fixed; the body must still be valid JSON
+	ContentTypeLenient ContentTypeMode = "lenient"
+)
+
+// ContentTypeModeFromEnv reads JSON_CONTENT_TYPE (strict or lenient); default strict
+func ContentTypeModeFromEnv() (ContentTypeMode, error) {
+	switch raw := ContentTypeMode(os.Getenv("JSON_CONTENT_TYPE")); raw {
+	case "":
+		return ContentTypeStrict, nil
+	case ContentTypeStrict, ContentTypeLenient:
+		return raw, nil
+	default:
+		return ContentTypeStrict, fmt.Errorf("invalid JSON_CONTENT_TYPE %q: expected strict or lenient", raw)
+	}
+}
+
+type contentTypeKey struct{}
+
+// ContentTypes applies mode to every DecodeJSON call made while serving a request
+func ContentTypes(mode ContentTypeMode) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if mode != ContentTypeLenient {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contentTypeKey{}, mode)))
+		})
+	}
+}
+
+func lenientContentType(ctx context.Context) bool {
+	mode, _ := ctx.Value(contentTypeKey{}).(ContentTypeMode)
+	return mode == ContentTypeLenient
+}
@@ -0,0 +1,92 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContentTypes(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		strict      int // status in strict mode; lenient mode always decodes
+	}{
+		{name: "json", contentType: "application/json", strict: http.StatusOK},
+		{name: "charset suffix", contentType: "application/json; charset=utf-8", strict: http.StatusOK},
+		{name: "upper case", contentType: "Application/JSON; Charset=UTF-8", strict: http.StatusOK},
+		{name: "missing", strict: http.StatusUnsupportedMediaType},
+		{name: "text/plain", contentType: "text/plain", strict: http.StatusUnsupportedMediaType},
+		{name: "form", contentType: "application/x-www-form-urlencoded", strict: http.StatusUnsupportedMediaType},
+		{name: "json lookalike", contentType: "application/jsonp", strict: http.StatusUnsupportedMediaType},
+		{name: "malformed", contentType: "application/json; charset", strict: http.StatusUnsupportedMediaType},
+	}
+	for _, mode := range []ContentTypeMode{ContentTypeStrict, ContentTypeLenient} {
+		handler := ContentTypes(mode)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var dst struct {
+				Name string `json:"name"`
+			}
+			if err := DecodeJSON(w, r, &dst); err != nil {
+				Error(w, StatusCode(err), err.Error())
+			}
+		}))
+		for _, tt := range tests {
+			t.Run(string(mode)+"/"+tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"Ann"}`))
+				if tt.contentType != "" {
+					r.Header.Set("Content-Type", tt.contentType)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				want := tt.strict
+				if mode == ContentTypeLenient {
+					want = http.StatusOK
+				}
+				if w.Code != want {
+					t.Errorf("status = %d, want %d: %s", w.Code, want, w.Body)
+				}
+				if want == http.StatusUnsupportedMediaType && !strings.Contains(w.Body.String(), "Content-Type must be application/json") {
+					t.Errorf("body = %s, want it to name the expected Content-Type", w.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestLenientStillRequiresJSON(t *testing.T) {
+	handler := ContentTypes(ContentTypeLenient)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var dst map[string]string
+		if err := DecodeJSON(w, r, &dst); err != nil {
+			Error(w, StatusCode(err), err.Error())
+		}
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("name=Ann"))
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a body that isn't JSON", w.Code)
+	}
+}
+
+func TestContentTypeModeFromEnv(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    ContentTypeMode
+		wantErr bool
+	}{
+		{env: "", want: ContentTypeStrict},
+		{env: "strict", want: ContentTypeStrict},
+		{env: "lenient", want: ContentTypeLenient},
+		{env: "loose", want: ContentTypeStrict, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JSON_CONTENT_TYPE", tt.env)
+		got, err := ContentTypeModeFromEnv()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("JSON_CONTENT_TYPE=%q: got %q, %v; want %q, error %v", tt.env, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
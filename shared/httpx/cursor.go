@@ -0,0 +1,87 @@
+package httpx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultCursorMaxAge is how long a snapshot cursor stays valid unless CURSOR_MAX_AGE says otherwise
+const DefaultCursorMaxAge = time.Hour
+
+// ErrCursorExpired is returned by ParseCursor for a snapshot older than the
+// allowed window; clients should answer it by starting the listing again
+var ErrCursorExpired = errors.New("cursor has expired; start the listing again")
+
+// Cursor is a keyset position in a list ordered by ID. A snapshot cursor also
+// carries the time its listing started, so rows created since can be left out.
+type Cursor struct {
+	After    int64      `json:"after"`
+	Snapshot *time.Time `json:"snapshot,omitempty"`
+}
+
+// CursorMaxAgeFromEnv reads CURSOR_MAX_AGE, how long after it started a snapshot
+// listing can be continued; default one hour
+func CursorMaxAgeFromEnv() (time.Duration, error) {
+	raw := os.Getenv("CURSOR_MAX_AGE")
+	if raw == "" {
+		return DefaultCursorMaxAge, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid CURSOR_MAX_AGE %q", raw)
+	}
+	return d, nil
+}
+
+// Encode returns the opaque form of c sent to clients as next_cursor
+func (c Cursor) Encode() string {
+	body, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+// ParseCursor decodes a cursor sent back by a client. A snapshot taken longer
+// than maxAge before now returns ErrCursorExpired.
+func ParseCursor(raw string, now time.Time, maxAge time.Duration) (Cursor, error) {
+	var c Cursor
+	body, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil || json.Unmarshal(body, &c) != nil || c.After < 0 {
+		return Cursor{}, errors.New("cursor is invalid")
+	}
+	if c.Snapshot != nil && now.Sub(*c.Snapshot) > maxAge {
+		return Cursor{}, ErrCursorExpired
+	}
+	return c, nil
+}
+
+// CursorLinks builds the links of a cursor-paginated page: self as requested,
+// first without a cursor, and next continuing from the given cursor unless it
+// is empty. There is no prev: keyset pages are only walked forwards.
+func CursorLinks(r *http.Request, limit int, next string) Links {
+	link := func(cursor string) string {
+		u := BaseURL(r)
+		u.Path += r.URL.Path
+		q := r.URL.Query()
+		q.Set("limit", strconv.Itoa(limit))
+		q.Del("cursor")
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	links := Links{
+		Self:  link(r.URL.Query().Get("cursor")),
+		First: link(""),
+	}
+	if next != "" {
+		links.Next = link(next)
+	}
+	return links
+}
@@ -0,0 +1,102 @@
+package httpx
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	snapshot := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for _, c := range []Cursor{{After: 0}, {After: 42}, {After: 42, Snapshot: &snapshot}} {
+		got, err := ParseCursor(c.Encode(), snapshot, time.Hour)
+		if err != nil {
+			t.Fatalf("ParseCursor(%+v): %v", c, err)
+		}
+		if got.After != c.After || (got.Snapshot == nil) != (c.Snapshot == nil) || (got.Snapshot != nil && !got.Snapshot.Equal(*c.Snapshot)) {
+			t.Errorf("got %+v, want %+v", got, c)
+		}
+	}
+}
+
+func TestParseCursorExpiry(t *testing.T) {
+	snapshot := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	raw := Cursor{After: 42, Snapshot: &snapshot}.Encode()
+
+	tests := []struct {
+		name    string
+		age     time.Duration
+		expired bool
+	}{
+		{name: "fresh", age: 0},
+		{name: "at the limit", age: time.Hour},
+		{name: "just past the limit", age: time.Hour + time.Nanosecond, expired: true},
+		{name: "a day old", age: 24 * time.Hour, expired: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCursor(raw, snapshot.Add(tt.age), time.Hour)
+			if got := errors.Is(err, ErrCursorExpired); got != tt.expired {
+				t.Errorf("err = %v, want expired %v", err, tt.expired)
+			}
+		})
+	}
+
+	// Without a snapshot there is nothing to go stale
+	if _, err := ParseCursor(Cursor{After: 42}.Encode(), snapshot.Add(24*time.Hour), time.Hour); err != nil {
+		t.Errorf("plain cursor: err = %v, want nil", err)
+	}
+}
+
+func TestParseCursorRejectsGarbage(t *testing.T) {
+	for _, raw := range []string{"%%", "bm90IGpzb24", Cursor{After: -1}.Encode(), "eyJhZnRlciI6IngifQ"} {
+		if _, err := ParseCursor(raw, time.Now(), time.Hour); err == nil || errors.Is(err, ErrCursorExpired) {
+			t.Errorf("ParseCursor(%q): err = %v, want cursor is invalid", raw, err)
+		}
+	}
+}
+
+func TestCursorMaxAgeFromEnv(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: "", want: DefaultCursorMaxAge},
+		{raw: "15m", want: 15 * time.Minute},
+		{raw: "0s", wantErr: true},
+		{raw: "-1h", wantErr: true},
+		{raw: "hour", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("CURSOR_MAX_AGE", tt.raw)
+		got, err := CursorMaxAgeFromEnv()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("CURSOR_MAX_AGE=%q: got %s, %v, want %s (error %v)", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCursorLinks(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/products?category=lamps&cursor=abc&limit=50", nil)
+
+	links := CursorLinks(r, 20, "def")
+	if links.Self != "http://example.com/products?category=lamps&cursor=abc&limit=20" {
+		t.Errorf("self = %q", links.Self)
+	}
+	if links.First != "http://example.com/products?category=lamps&limit=20" {
+		t.Errorf("first = %q, want no cursor", links.First)
+	}
+	if links.Next != "http://example.com/products?category=lamps&cursor=def&limit=20" {
+		t.Errorf("next = %q", links.Next)
+	}
+	if links.Prev != "" {
+		t.Errorf("prev = %q, want none for keyset pages", links.Prev)
+	}
+
+	if last := CursorLinks(r, 20, ""); last.Next != "" {
+		t.Errorf("next = %q on the last page, want none", last.Next)
+	}
+}
@@ -0,0 +1,31 @@
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DeadlineHeader carries the absolute time by which the caller needs an answer,
+// so work whose result would be discarded isn't started
+const DeadlineHeader = "X-Request-Deadline"
+
+// ParseDeadline reads a deadline written as RFC 3339 (with optional fractional
+// seconds) or as Unix milliseconds. ok is false if the value is missing or malformed.
+func ParseDeadline(value string) (deadline time.Time, ok bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil && ms > 0 {
+		return time.UnixMilli(ms), true
+	}
+	return time.Time{}, false
+}
+
+// SetDeadline writes deadline to h as RFC 3339 with nanoseconds
+func SetDeadline(h http.Header, deadline time.Time) {
+	h.Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+}
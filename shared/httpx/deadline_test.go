@@ -0,0 +1,44 @@
+package httpx
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseDeadline(t *testing.T) {
+	want := time.Date(2026, 3, 1, 12, 0, 5, 250_000_000, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Time
+		wantOK bool
+	}{
+		{value: "2026-03-01T12:00:05.25Z", want: want, wantOK: true},
+		{value: "2026-03-01T13:00:05.25+01:00", want: want, wantOK: true},
+		{value: "2026-03-01T12:00:05Z", want: want.Truncate(time.Second), wantOK: true},
+		{value: "1772366405250", want: want, wantOK: true},
+		{value: ""},
+		{value: "soon"},
+		{value: "0"},
+		{value: "-1772366405250"},
+		{value: "2026-03-01 12:00:05"},
+	}
+	for _, tt := range tests {
+		got, ok := ParseDeadline(tt.value)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("ParseDeadline(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSetDeadlineRoundTrips(t *testing.T) {
+	deadline := time.Date(2026, 3, 1, 12, 0, 5, 123_456_789, time.FixedZone("CET", 3600))
+	h := http.Header{}
+	SetDeadline(h, deadline)
+
+	got, ok := ParseDeadline(h.Get(DeadlineHeader))
+	if !ok || !got.Equal(deadline) {
+		t.Errorf("round trip of %v gave %v, %v", deadline, got, ok)
+	}
+}
@@ -0,0 +1,104 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MaxBodyBytes is the largest request body DecodeJSON will read
+const MaxBodyBytes = 1 << 20 // 1MB
+
+// DecodeError describes why a request body could not be decoded and the status to answer with
+type DecodeError struct {
+	Status int
+	Msg    string
+}
+
+func (e *DecodeError) Error() string {
+	return e.Msg
+}
+
+// StatusCode returns the HTTP status for an error returned by DecodeJSON
+func StatusCode(err error) int {
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		return decodeErr.Status
+	}
+	return http.StatusBadRequest
+}
+
+// DecodeJSON decodes a single JSON value from the request body into dst.
+// It requires a JSON Content-Type (unless ContentTypes made the request lenient),
+// limits the body to MaxBodyBytes, rejects unknown fields and trailing data, and
+// returns a *DecodeError with a message that says what was wrong.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	return DecodeJSONLimit(w, r, dst, MaxBodyBytes)
+}
+
+// DecodeJSONLimit is DecodeJSON with a caller-chosen body size limit, for bulk endpoints
+func DecodeJSONLimit(w http.ResponseWriter, r *http.Request, dst any, limit int64) error {
+	// Checked first so an empty POST gets a clear message rather than a Content-Type complaint
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return &DecodeError{Status: http.StatusBadRequest, Msg: "request body is required"}
+	}
+
+	if !lenientContentType(r.Context()) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return &DecodeError{Status: http.StatusUnsupportedMediaType, Msg: "Content-Type must be application/json"}
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return decodeStrict(json.NewDecoder(r.Body), dst)
+}
+
+// DecodeRaw decodes a body that DecodeJSON read into a json.RawMessage, with the
+// same checks and errors. It is for handlers that choose what to decode into from
+// the body itself.
+func DecodeRaw(body json.RawMessage, dst any) error {
+	return decodeStrict(json.NewDecoder(bytes.NewReader(body)), dst)
+}
+
+func decodeStrict(dec *json.Decoder, dst any) error {
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		var maxBytesErr *http.MaxBytesError
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return &DecodeError{Status: http.StatusBadRequest, Msg: "request body is required"}
+		case errors.As(err, &syntaxErr):
+			return &DecodeError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("request body contains badly-formed JSON (at position %d)", syntaxErr.Offset)}
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return &DecodeError{Status: http.StatusBadRequest, Msg: "request body contains badly-formed JSON"}
+		case errors.As(err, &typeErr):
+			if typeErr.Field != "" {
+				return &DecodeError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("request body contains an invalid value for the %q field (at position %d)", typeErr.Field, typeErr.Offset)}
+			}
+			return &DecodeError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("request body contains an invalid value (at position %d)", typeErr.Offset)}
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return &DecodeError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("request body contains unknown field %s", field)}
+		case errors.As(err, &maxBytesErr):
+			return &DecodeError{Status: http.StatusRequestEntityTooLarge, Msg: fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit)}
+		default:
+			return &DecodeError{Status: http.StatusBadRequest, Msg: "request body could not be decoded"}
+		}
+	}
+
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return &DecodeError{Status: http.StatusBadRequest, Msg: "request body must only contain a single JSON object"}
+	}
+
+	return nil
+}
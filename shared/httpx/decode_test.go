@@ -0,0 +1,76 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int // 0 when the body decodes
+		wantMsg     string
+	}{
+		{name: "valid", contentType: "application/json", body: `{"name":"Ann"}`},
+		{name: "charset parameter", contentType: "application/json; charset=utf-8", body: `{"name":"Ann"}`},
+		{name: "empty", contentType: "application/json", body: "",
+			wantStatus: http.StatusBadRequest, wantMsg: "request body is required"},
+		{name: "whitespace only", contentType: "application/json", body: "  \n",
+			wantStatus: http.StatusBadRequest, wantMsg: "request body is required"},
+		{name: "wrong content type", contentType: "text/plain", body: `{"name":"Ann"}`,
+			wantStatus: http.StatusUnsupportedMediaType, wantMsg: "Content-Type must be application/json"},
+		{name: "no content type", body: `{"name":"Ann"}`,
+			wantStatus: http.StatusUnsupportedMediaType, wantMsg: "Content-Type must be application/json"},
+		{name: "syntax error", contentType: "application/json", body: `{"name" "Ann"}`,
+			wantStatus: http.StatusBadRequest, wantMsg: "request body contains badly-formed JSON (at position 9)"},
+		{name: "truncated", contentType: "application/json", body: `{"name":"Ann"`,
+			wantStatus: http.StatusBadRequest, wantMsg: "request body contains badly-formed JSON"},
+		{name: "wrong type", contentType: "application/json", body: `{"name":5}`,
+			wantStatus: http.StatusBadRequest, wantMsg: `request body contains an invalid value for the "name" field (at position 9)`},
+		{name: "wrong top-level type", contentType: "application/json", body: `["Ann"]`,
+			wantStatus: http.StatusBadRequest, wantMsg: "request body contains an invalid value (at position 1)"},
+		{name: "unknown field", contentType: "application/json", body: `{"name":"Ann","nickname":"A"}`,
+			wantStatus: http.StatusBadRequest, wantMsg: `request body contains unknown field "nickname"`},
+		{name: "two documents", contentType: "application/json", body: `{"name":"Ann"}{"name":"Bob"}`,
+			wantStatus: http.StatusBadRequest, wantMsg: "request body must only contain a single JSON object"},
+		{name: "trailing garbage", contentType: "application/json", body: `{"name":"Ann"} x`,
+			wantStatus: http.StatusBadRequest, wantMsg: "request body must only contain a single JSON object"},
+		{name: "too large", contentType: "application/json", body: `{"name":"` + strings.Repeat("a", MaxBodyBytes) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge, wantMsg: "request body must not be larger than 1048576 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			var dst struct {
+				Name string `json:"name"`
+			}
+			err := DecodeJSON(httptest.NewRecorder(), r, &dst)
+
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				if dst.Name != "Ann" {
+					t.Errorf("name = %q, want Ann", dst.Name)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("err = nil, want %d %q", tt.wantStatus, tt.wantMsg)
+			}
+			if got := StatusCode(err); got != tt.wantStatus {
+				t.Errorf("status = %d, want %d", got, tt.wantStatus)
+			}
+			if err.Error() != tt.wantMsg {
+				t.Errorf("message = %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Headers of the idempotent DELETE contract; see DeleteNotFound
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	AlreadyDeletedHeader = "X-Already-Deleted"
+)
+
+// DeleteNotFound answers a DELETE whose resource doesn't exist. By default that
+// is a 404 with msg. A client that retries deletes after a timeout can instead
+// ask for the retry to succeed, with ?idempotent=true or any Idempotency-Key
+// header; it then gets 204 with X-Already-Deleted: true. A resource that was
+// never there can't be told apart from one that was deleted, so both succeed.
+// The key itself isn't stored: a delete has nothing to replay.
+func DeleteNotFound(w http.ResponseWriter, r *http.Request, msg string) {
+	idempotent := r.Header.Get(IdempotencyKeyHeader) != ""
+	if raw := r.URL.Query().Get("idempotent"); raw != "" {
+		flag, err := strconv.ParseBool(raw)
+		if err != nil {
+			Error(w, http.StatusBadRequest, "idempotent must be true or false")
+			return
+		}
+		idempotent = idempotent || flag
+	}
+
+	if !idempotent {
+		Error(w, http.StatusNotFound, msg)
+		return
+	}
+	w.Header().Set(AlreadyDeletedHeader, "true")
+	w.WriteHeader(http.StatusNoContent)
+}
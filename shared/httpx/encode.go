@@ -0,0 +1,43 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// RequestIDHeader carries the ID the gateway gives each request, so log lines
+// about one request can be matched up across services
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID returns the ID of r, or "-" if it came without one
+func RequestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	return "-"
+}
+
+// WriteJSON answers r with status and v encoded as JSON. The body is encoded
+// before anything is sent, so a value that can't be encoded still gets a 500
+// instead of a 200 with half a body. A failure writing it out, usually the
+// client going away, can no longer be reported to the client: it is logged
+// with the request ID and returned, so the caller can tell the response didn't
+// arrive.
+func WriteJSON(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		log.Printf("[%s] Could not encode response to %s %s: %v", RequestID(r), r.Method, r.URL.Path, err)
+		Error(w, http.StatusInternalServerError, "could not encode response")
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	n, err := w.Write(body.Bytes())
+	if err != nil {
+		log.Printf("[%s] Response to %s %s cut off after %d of %d bytes: %v", RequestID(r), r.Method, r.URL.Path, n, body.Len(), err)
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package httpx
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// failingWriter accepts limit bytes of body and then fails, as writing to a
+// client that went away does
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if len(p) <= f.limit {
+		f.limit -= len(p)
+		return f.ResponseRecorder.Write(p)
+	}
+	n, _ := f.ResponseRecorder.Write(p[:f.limit])
+	f.limit = 0
+	return n, syscall.EPIPE
+}
+
+// captureLog sends the standard logger to a buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(prev)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func jsonRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/users?limit=2", nil)
+	r.Header.Set(RequestIDHeader, "req-42")
+	return r
+}
+
+func TestWriteJSONLogsCutOffResponse(t *testing.T) {
+	logged := captureLog(t)
+	w := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 10}
+
+	err := WriteJSON(w, jsonRequest(), http.StatusOK, map[string]string{"name": "a long enough value"})
+	if !errors.Is(err, syscall.EPIPE) {
+		t.Errorf("err = %v, want the write error returned", err)
+	}
+	want := "[req-42] Response to GET /users cut off after 10 of 31 bytes: broken pipe\n"
+	if logged.String() != want {
+		t.Errorf("logged %q, want %q", logged, want)
+	}
+}
+
+func TestWriteJSONRefusesUnencodableValue(t *testing.T) {
+	logged := captureLog(t)
+	w := httptest.NewRecorder()
+
+	if err := WriteJSON(w, jsonRequest(), http.StatusOK, map[string]float64{"price": math.Inf(1)}); err == nil {
+		t.Error("err = nil, want the encoding error")
+	}
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "could not encode response") {
+		t.Errorf("got %d %s, want a 500 rather than half a body", w.Code, w.Body)
+	}
+	if !strings.HasPrefix(logged.String(), "[req-42] Could not encode response to GET /users: json: unsupported value: +Inf") {
+		t.Errorf("logged %q, want the encoding error with the request ID", logged)
+	}
+}
+
+func TestWriteJSONSuccessLogsNothing(t *testing.T) {
+	logged := captureLog(t)
+	w := httptest.NewRecorder()
+
+	if err := WriteJSON(w, jsonRequest(), http.StatusCreated, map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != "{\"id\":1}\n" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d %q (%s)", w.Code, w.Body, w.Header().Get("Content-Type"))
+	}
+	if logged.Len() != 0 {
+		t.Errorf("logged %q, want nothing", logged)
+	}
+}
+
+func TestErrorLogsCutOffResponse(t *testing.T) {
+	logged := captureLog(t)
+	w := &failingWriter{ResponseRecorder: httptest.NewRecorder()}
+
+	Error(w, http.StatusNotFound, "user not found")
+	if want := "Error response 404 cut off: broken pipe\n"; logged.String() != want {
+		t.Errorf("logged %q, want %q", logged, want)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if got := RequestID(jsonRequest()); got != "req-42" {
+		t.Errorf("RequestID = %q, want req-42", got)
+	}
+	if got := RequestID(httptest.NewRequest(http.MethodGet, "/", nil)); got != "-" {
+		t.Errorf("RequestID without header = %q, want -", got)
+	}
+}
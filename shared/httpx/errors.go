@@ -0,0 +1,44 @@
+package httpx
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ErrorResponse is the JSON body of every error response
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Code   string       `json:"code,omitempty"` // machine-readable class, where one applies
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// Error writes msg as a JSON error response with the given status
+func Error(w http.ResponseWriter, status int, msg string) {
+	writeError(w, status, ErrorResponse{Error: msg})
+}
+
+// ErrorCode is Error with a machine-readable code, for errors clients are expected
+// to tell apart, e.g. upstream_timeout from upstream_unreachable
+func ErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	writeError(w, status, ErrorResponse{Error: msg, Code: code})
+}
+
+// ValidationFailed answers 422 with the problems found in each field
+func ValidationFailed(w http.ResponseWriter, fields []FieldError) {
+	writeError(w, http.StatusUnprocessableEntity, ErrorResponse{Error: "validation failed", Fields: fields})
+}
+
+func writeError(w http.ResponseWriter, status int, body ErrorResponse) {
+	if language := errorLanguage(w); language != "" {
+		body = localize(body, language)
+		w.Header().Set("Content-Language", language)
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error response %d cut off: %v", status, err)
+	}
+}
@@ -0,0 +1,187 @@
+package httpx
+
+import (
+	"cmp"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language error messages are written in, and the one
+// answered when a client accepts none of the others
+const DefaultLanguage = "en"
+
+// messageFiles holds a catalog per language, e.g. messages/es.json, mapping each
+// English message to its translation. A message formatted with arguments, such as
+// "must be at most %d characters", is translated by its format.
+//
+//go:embed messages/*.json
+var messageFiles embed.FS
+
+// catalogs maps each language with a message file to its messages
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := messageFiles.ReadDir("messages")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := map[string]map[string]string{}
+	for _, f := range files {
+		raw, err := messageFiles.ReadFile("messages/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			panic(fmt.Sprintf("messages/%s: %v", f.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = messages
+	}
+	return catalogs
+}
+
+// LanguagesFromEnv reads ERROR_LANGUAGES, the comma-separated languages error
+// messages may be translated into besides English, e.g. "es"; default every
+// language there is a catalog for. "none" keeps every error in English.
+func LanguagesFromEnv() ([]string, error) {
+	available := slices.Sorted(maps.Keys(catalogs))
+	raw := os.Getenv("ERROR_LANGUAGES")
+	switch raw {
+	case "":
+		return available, nil
+	case "none":
+		return nil, nil
+	}
+
+	var languages []string
+	for _, entry := range strings.Split(raw, ",") {
+		language := strings.ToLower(strings.TrimSpace(entry))
+		if language == DefaultLanguage || slices.Contains(languages, language) {
+			continue
+		}
+		if catalogs[language] == nil {
+			return nil, fmt.Errorf("invalid ERROR_LANGUAGES entry %q (available: %s)", entry, strings.Join(available, ", "))
+		}
+		languages = append(languages, language)
+	}
+	return languages, nil
+}
+
+// ErrorLanguages answers the errors written by Error, ErrorCode and
+// ValidationFailed in the language the request's Accept-Language prefers among
+// languages, English otherwise. Only messages in the catalog are translated;
+// codes and field names never are, so clients can keep matching on them.
+func ErrorLanguages(languages []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(languages) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			language := matchLanguage(languages, r.Header.Get("Accept-Language"))
+			if language == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(&languageWriter{ResponseWriter: w, language: language}, r)
+		})
+	}
+}
+
+// languageWriter carries the language errors are answered in to writeError,
+// which only gets the ResponseWriter
+type languageWriter struct {
+	http.ResponseWriter
+	language string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *languageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// errorLanguage finds the language ErrorLanguages chose for w, looking through
+// any writers wrapped around it since; "" means English
+func errorLanguage(w http.ResponseWriter) string {
+	for {
+		switch t := w.(type) {
+		case *languageWriter:
+			return t.language
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return ""
+		}
+	}
+}
+
+// matchLanguage returns the first language of an Accept-Language header, most
+// preferred first, that is one of languages; "" when English or nothing offered
+// comes first. Only the language part of a tag counts, so es-MX is answered in es.
+func matchLanguage(languages []string, header string) string {
+	type weighted struct {
+		language string
+		q        float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				continue
+			}
+		}
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if language != "" && q > 0 {
+			tags = append(tags, weighted{language, q})
+		}
+	}
+	slices.SortStableFunc(tags, func(a, b weighted) int { return cmp.Compare(b.q, a.q) })
+
+	for _, t := range tags {
+		if t.language == DefaultLanguage || t.language == "*" {
+			return ""
+		}
+		if slices.Contains(languages, t.language) {
+			return t.language
+		}
+	}
+	return ""
+}
+
+// translate returns msg in language, or as it is when the catalog doesn't have it
+func translate(language, msg string) string {
+	if translated, ok := catalogs[language][msg]; ok {
+		return translated
+	}
+	return msg
+}
+
+// localize translates the messages of an error response into language
+func localize(body ErrorResponse, language string) ErrorResponse {
+	body.Error = translate(language, body.Error)
+	if body.Fields != nil {
+		fields := make([]FieldError, len(body.Fields))
+		for i, f := range body.Fields {
+			fields[i] = f
+			if f.format != "" {
+				if format, ok := catalogs[language][f.format]; ok {
+					fields[i].Message = fmt.Sprintf(format, f.args...)
+				}
+			} else {
+				fields[i].Message = translate(language, f.Message)
+			}
+		}
+		body.Fields = fields
+	}
+	return body
+}
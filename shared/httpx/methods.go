@@ -0,0 +1,116 @@
+package httpx
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Methods dispatches a request to the handler registered for its HTTP method.
+// HEAD is answered by the GET handler with the body discarded, OPTIONS is
+// answered automatically with the Allow list, and any other unregistered method
+// gets 405 Method Not Allowed with an Allow header.
+//
+//	mux.Handle("/users", httpx.Methods{
+//		http.MethodGet:  handler.ListUsers,
+//		http.MethodPost: handler.CreateUser,
+//	})
+type Methods map[string]http.HandlerFunc
+
+func (m Methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := m[r.Method]; ok {
+		h(w, r)
+		return
+	}
+
+	if get, ok := m[http.MethodGet]; ok && r.Method == http.MethodHead {
+		hw := &headWriter{ResponseWriter: w}
+		get(hw, r)
+		hw.commit(true)
+		return
+	}
+
+	w.Header().Set("Allow", m.Allow())
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	Error(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// Allow returns the value of the Allow header for this route
+func (m Methods) Allow() string {
+	methods := make([]string, 0, len(m)+2)
+	for method := range m {
+		methods = append(methods, method)
+	}
+	for _, implied := range m.implied() {
+		if _, ok := m[implied]; !ok {
+			methods = append(methods, implied)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// implied lists the methods answered without a handler of their own
+func (m Methods) implied() []string {
+	if _, ok := m[http.MethodGet]; ok {
+		return []string{http.MethodHead, http.MethodOptions}
+	}
+	return []string{http.MethodOptions}
+}
+
+// headWriter runs a GET handler for a HEAD request. Headers and status pass
+// through unchanged; the body is counted instead of sent, so a Content-Length
+// can be given for responses the handler didn't stream.
+type headWriter struct {
+	http.ResponseWriter
+	status    int
+	length    int
+	committed bool // headers have gone to the client
+}
+
+func (w *headWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.length += len(b)
+	return len(b), nil
+}
+
+// Flush sends the headers as they are, for streaming handlers whose length isn't known
+func (w *headWriter) Flush() {
+	w.commit(false)
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// commit writes the status and headers once. done means the handler has
+// returned, so the counted length is the whole body.
+func (w *headWriter) commit(done bool) {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	if done && w.status != http.StatusNoContent && w.status != http.StatusNotModified &&
+		h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" {
+		h.Set("Content-Length", strconv.Itoa(w.length))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
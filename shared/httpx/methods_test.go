@@ -0,0 +1,105 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// routeMethods are the method sets the services register with Methods, by route
+var routeMethods = map[string][]string{
+	"/users":                               {http.MethodGet, http.MethodPost},
+	"/users/{id}":                          {http.MethodGet, http.MethodPut, http.MethodDelete},
+	"/users/export":                        {http.MethodGet},
+	"/users/bulk-delete":                   {http.MethodPost},
+	"/users/bulk-update":                   {http.MethodPost},
+	"/users/confirm-email":                 {http.MethodGet, http.MethodPost},
+	"/users/{id}/pending-email":            {http.MethodDelete},
+	"/users/{id}/deactivate":               {http.MethodPost},
+	"/users/{userID}/impersonate":          {http.MethodPost},
+	"/products":                            {http.MethodGet, http.MethodPost},
+	"/products/{id}":                       {http.MethodGet, http.MethodPut, http.MethodDelete},
+	"/products/{id}/translations/{locale}": {http.MethodGet, http.MethodPut},
+	"/products/categories/{slug}":          {http.MethodPut, http.MethodDelete},
+	"/products/import":                     {http.MethodPost},
+}
+
+// methodsFor returns Methods whose handlers answer 2xx with the method in the body
+func methodsFor(methods []string) Methods {
+	m := Methods{}
+	for _, method := range methods {
+		status := http.StatusOK
+		if method == http.MethodPost {
+			status = http.StatusCreated
+		}
+		m[method] = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(r.Method))
+		}
+	}
+	return m
+}
+
+// wantAllow is the Allow header for methods: them, plus HEAD for GET routes and OPTIONS
+func wantAllow(methods []string) string {
+	allow := append(slices.Clone(methods), http.MethodOptions)
+	if slices.Contains(methods, http.MethodGet) {
+		allow = append(allow, http.MethodHead)
+	}
+	slices.Sort(allow)
+	return strings.Join(allow, ", ")
+}
+
+func TestMethodsPerRoute(t *testing.T) {
+	for route, methods := range routeMethods {
+		t.Run(route, func(t *testing.T) {
+			m := methodsFor(methods)
+			allow := wantAllow(methods)
+
+			serve := func(method string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				m.ServeHTTP(w, httptest.NewRequest(method, route, nil))
+				return w
+			}
+
+			// Registered methods reach their handler untouched
+			for _, method := range methods {
+				w := serve(method)
+				if w.Code >= 300 || w.Body.String() != method || w.Header().Get("Allow") != "" {
+					t.Errorf("%s: got %d %q (Allow %q), want the handler's response", method, w.Code, w.Body, w.Header().Get("Allow"))
+				}
+			}
+
+			if w := serve(http.MethodOptions); w.Code != http.StatusNoContent || w.Header().Get("Allow") != allow {
+				t.Errorf("OPTIONS: got %d with Allow %q, want 204 with %q", w.Code, w.Header().Get("Allow"), allow)
+			}
+
+			for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+				if slices.Contains(methods, method) {
+					continue
+				}
+				if w := serve(method); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != allow {
+					t.Errorf("%s: got %d with Allow %q, want 405 with %q", method, w.Code, w.Header().Get("Allow"), allow)
+				}
+			}
+		})
+	}
+}
+
+func TestMethodsAnswersHeadWithGet(t *testing.T) {
+	m := Methods{http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1}`))
+	}}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/users/1", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("got %d with %d body bytes, want 200 and no body", w.Code, w.Body.Len())
+	}
+	if got := w.Header().Get("Content-Length"); got != "8" {
+		t.Errorf("Content-Length = %q, want the GET body's 8", got)
+	}
+}
@@ -0,0 +1,42 @@
+package httpx
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultNameMaxLen is the longest name accepted unless configured lower; it is
+// also the size of the name columns, so it can't be raised
+const DefaultNameMaxLen = 255
+
+// NameMaxLenFromEnv reads the longest name accepted, in characters, from env
+// (e.g. USER_NAME_MAX_LEN); from 1 to DefaultNameMaxLen, which is the default
+func NameMaxLenFromEnv(env string) (int, error) {
+	raw := os.Getenv(env)
+	if raw == "" {
+		return DefaultNameMaxLen, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > DefaultNameMaxLen {
+		return DefaultNameMaxLen, fmt.Errorf("invalid %s %q (want 1 to %d)", env, raw, DefaultNameMaxLen)
+	}
+	return n, nil
+}
+
+// Name checks a name field, if it was sent: at most maxLen characters, and no
+// control characters such as newlines, tabs or NUL
+func (v *Validation) Name(field string, value *string, maxLen int) {
+	if value == nil {
+		return
+	}
+	if utf8.RuneCountInString(*value) > maxLen {
+		v.Addf(field, "must be at most %d characters", maxLen)
+	}
+	if strings.ContainsFunc(*value, unicode.IsControl) {
+		v.Add(field, "must not contain control characters")
+	}
+}
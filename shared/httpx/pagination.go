@@ -0,0 +1,139 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Pagination defaults for list endpoints
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Page is the limit/offset window requested by a list call
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// ParsePage reads ?limit= and ?offset= from the request, applying defaults and bounds
+func ParsePage(r *http.Request) (Page, error) {
+	page := Page{Limit: DefaultLimit}
+
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return page, fmt.Errorf("limit must be an integer between 1 and %d", MaxLimit)
+		}
+		page.Limit = limit
+	}
+
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return page, fmt.Errorf("offset must be a non-negative integer")
+		}
+		page.Offset = offset
+	}
+
+	return page, nil
+}
+
+// Links are ready-made navigation URLs for a page of results
+type Links struct {
+	Self  string `json:"self"`
+	First string `json:"first"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+}
+
+// EnvelopeVersion is the version of the list envelope format. It is bumped when
+// the envelope changes incompatibly, so clients can detect what they are reading.
+const EnvelopeVersion = 1
+
+// ListResponse is the envelope returned by list endpoints
+type ListResponse[T any] struct {
+	Version int   `json:"version"`
+	Data    []T   `json:"data"`
+	Limit   int   `json:"limit"`
+	Offset  int   `json:"offset"`
+	Links   Links `json:"links"`
+
+	// Truncated reports that fewer rows were returned than requested because of
+	// the MAX_RESULT_ROWS cap; the next link continues from where this page ended
+	Truncated bool `json:"truncated"`
+
+	// NextCursor continues a cursor-paginated listing; it is empty on the last
+	// page and on offset-paginated ones
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// NewListResponse builds the list envelope. hasNext reports whether another page
+// exists; callers usually find out by fetching one row more than the limit.
+func NewListResponse[T any](r *http.Request, page Page, data []T, hasNext bool) ListResponse[T] {
+	return ListResponse[T]{
+		Version: EnvelopeVersion,
+		Data:    data,
+		Limit:   page.Limit,
+		Offset:  page.Offset,
+		Links:   PageLinks(r, page, hasNext),
+	}
+}
+
+// PageLinks builds self/first/prev/next URLs for the current page. The URLs are
+// fully qualified using the public base URL (see BaseURL); prev is omitted on the
+// first page and next on the last.
+func PageLinks(r *http.Request, page Page, hasNext bool) Links {
+	link := func(offset int) string {
+		u := BaseURL(r)
+		u.Path += r.URL.Path
+		q := r.URL.Query()
+		q.Set("limit", strconv.Itoa(page.Limit))
+		q.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	links := Links{
+		Self:  link(page.Offset),
+		First: link(0),
+	}
+	if page.Offset > 0 {
+		links.Prev = link(max(page.Offset-page.Limit, 0))
+	}
+	if hasNext {
+		links.Next = link(page.Offset + page.Limit)
+	}
+	return links
+}
+
+// BaseURL returns the public URL the client used to reach this service, honouring
+// X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix set by the gateway
+func BaseURL(r *http.Request) *url.URL {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := firstForwarded(r.Header.Get("X-Forwarded-Proto")); proto != "" {
+		scheme = proto
+	}
+
+	host := r.Host
+	if fwdHost := firstForwarded(r.Header.Get("X-Forwarded-Host")); fwdHost != "" {
+		host = fwdHost
+	}
+
+	prefix := strings.TrimSuffix(r.Header.Get("X-Forwarded-Prefix"), "/")
+
+	return &url.URL{Scheme: scheme, Host: host, Path: prefix}
+}
+
+// firstForwarded returns the first (client-facing) value of a comma-separated forwarded header
+func firstForwarded(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
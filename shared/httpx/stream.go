@@ -0,0 +1,74 @@
+package httpx
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// streamFlushEvery is how many elements ListWriter writes between flushes
+const streamFlushEvery = 100
+
+// ListWriter writes a list response one element at a time, so large results such
+// as exports never have to be held in memory. The body is an object with the
+// envelope version, the elements in "data", and whether the list was truncated.
+type ListWriter struct {
+	w     http.ResponseWriter
+	rc    *http.ResponseController
+	enc   *json.Encoder
+	count int
+}
+
+// NewListWriter sends the headers and opens the list. From here on the status
+// can't change, so errors must be reported through Close.
+func NewListWriter(w http.ResponseWriter) *ListWriter {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"version":%d,"data":[`, EnvelopeVersion)
+	return &ListWriter{w: w, rc: http.NewResponseController(w), enc: json.NewEncoder(w)}
+}
+
+// Write appends v to the list, flushing every few elements
+func (l *ListWriter) Write(v any) error {
+	if l.count > 0 {
+		if _, err := l.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	if err := l.enc.Encode(v); err != nil {
+		return err
+	}
+	l.count++
+	if l.count%streamFlushEvery == 0 {
+		// Not every writer can flush; the data still arrives, just later
+		l.rc.Flush()
+	}
+	return nil
+}
+
+// Close ends the list. truncated reports that more elements existed than were
+// allowed (see MaxResultRowsFromEnv). If the stream failed part way, err is
+// logged and the list is marked truncated, as the status was already sent.
+func (l *ListWriter) Close(truncated bool, err error) {
+	l.CloseWith(truncated, err, nil)
+}
+
+// CloseWith ends the list like Close, then adds the fields of trailer, which must
+// encode as a JSON object, e.g. a signature over what was streamed. A nil
+// trailer adds nothing.
+func (l *ListWriter) CloseWith(truncated bool, err error, trailer any) {
+	if err != nil {
+		log.Printf("Streamed response truncated after %d elements: %v", l.count, err)
+		truncated = true
+	}
+	fmt.Fprintf(l.w, "],\"truncated\":%t", truncated)
+	if trailer != nil {
+		body, err := json.Marshal(trailer)
+		if err == nil && len(body) > 2 && body[0] == '{' {
+			fmt.Fprintf(l.w, ",%s", body[1:len(body)-1])
+		}
+	}
+	fmt.Fprint(l.w, "}\n")
+	l.rc.Flush()
+}
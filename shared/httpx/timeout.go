@@ -0,0 +1,122 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultRouteTimeout applies to routes without an entry in ROUTE_TIMEOUTS
+const DefaultRouteTimeout = 10 * time.Second
+
+// RouteTimeouts maps a route to the deadline its requests get. Keys are mux patterns,
+// optionally prefixed with a method: "GET /products" only applies to GETs, while
+// "/products" applies to every method. A zero timeout means no deadline.
+type RouteTimeouts struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// RouteTimeoutsFromEnv reads ROUTE_TIMEOUTS (e.g. "GET /products:5s,POST /products:2s")
+// and the fallback ROUTE_TIMEOUT_DEFAULT
+func RouteTimeoutsFromEnv() (RouteTimeouts, error) {
+	timeouts, err := ParseRouteTimeouts(os.Getenv("ROUTE_TIMEOUTS"))
+	if err != nil {
+		return timeouts, err
+	}
+
+	if raw := os.Getenv("ROUTE_TIMEOUT_DEFAULT"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return timeouts, fmt.Errorf("invalid ROUTE_TIMEOUT_DEFAULT %q", raw)
+		}
+		timeouts.Default = d
+	}
+
+	return timeouts, nil
+}
+
+// ParseRouteTimeouts parses a comma-separated list of "[METHOD ]pattern:duration" entries
+func ParseRouteTimeouts(raw string) (RouteTimeouts, error) {
+	timeouts := RouteTimeouts{Default: DefaultRouteTimeout, Routes: map[string]time.Duration{}}
+
+	for entry := range strings.SplitSeq(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// Split on the last colon, so a pattern may itself contain one
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return timeouts, fmt.Errorf("invalid route timeout %q: expected [METHOD ]pattern:duration", entry)
+		}
+		route, rawDuration := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+
+		d, err := time.ParseDuration(rawDuration)
+		if err != nil || d <= 0 {
+			return timeouts, fmt.Errorf("invalid route timeout %q: bad duration %q", entry, rawDuration)
+		}
+
+		method, pattern, hasMethod := strings.Cut(route, " ")
+		if !hasMethod {
+			method, pattern = "", route
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			return timeouts, fmt.Errorf("invalid route timeout %q: pattern must start with /", entry)
+		}
+		timeouts.Routes[routeKey(strings.ToUpper(method), strings.TrimSpace(pattern))] = d
+	}
+
+	return timeouts, nil
+}
+
+// For returns the timeout for a request matched to a mux pattern
+func (t RouteTimeouts) For(method, pattern string) time.Duration {
+	if d, ok := t.Routes[routeKey(method, pattern)]; ok {
+		return d
+	}
+	if d, ok := t.Routes[routeKey("", pattern)]; ok {
+		return d
+	}
+	return t.Default
+}
+
+func routeKey(method, pattern string) string {
+	if method == "" {
+		return pattern
+	}
+	return method + " " + pattern
+}
+
+// Timeouts returns middleware that gives each request's context the deadline configured
+// for the mux route it matches, or the caller's X-Request-Deadline if that is sooner.
+// Handlers see the deadline through r.Context() and should pass it on to database and
+// upstream calls.
+func Timeouts(mux *http.ServeMux, timeouts RouteTimeouts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := mux.Handler(r)
+
+			timeout := timeouts.For(r.Method, pattern)
+			if timeout <= 0 {
+				// Long-lived routes such as event streams have no deadline
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			deadline := time.Now().Add(timeout)
+			if callerDeadline, ok := ParseDeadline(r.Header.Get(DeadlineHeader)); ok && callerDeadline.Before(deadline) {
+				deadline = callerDeadline
+			}
+
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
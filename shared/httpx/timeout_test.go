@@ -0,0 +1,60 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTimeoutsTakesSoonerDeadline(t *testing.T) {
+	mux := http.NewServeMux()
+	var deadline time.Time
+	var hasDeadline bool
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, hasDeadline = r.Context().Deadline()
+	})
+	mux.Handle("/products", record)
+	mux.Handle("/products/events", record)
+	timeouts := RouteTimeouts{Default: 10 * time.Second, Routes: map[string]time.Duration{"/products/events": 0}}
+	handler := Timeouts(mux, timeouts)(mux)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   time.Duration // from now; 0 for no deadline
+	}{
+		{name: "no header", path: "/products", want: 10 * time.Second},
+		{name: "sooner caller deadline", path: "/products", header: time.Now().Add(2 * time.Second).Format(time.RFC3339Nano), want: 2 * time.Second},
+		{name: "sooner caller deadline in millis", path: "/products", header: strconv.FormatInt(time.Now().Add(2*time.Second).UnixMilli(), 10), want: 2 * time.Second},
+		{name: "later caller deadline", path: "/products", header: time.Now().Add(time.Minute).Format(time.RFC3339Nano), want: 10 * time.Second},
+		{name: "malformed header", path: "/products", header: "soon", want: 10 * time.Second},
+		{name: "route without a timeout", path: "/products/events"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set(DeadlineHeader, tt.header)
+			}
+			start := time.Now()
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if tt.want == 0 {
+				if hasDeadline {
+					t.Errorf("deadline = %v, want none", deadline)
+				}
+				return
+			}
+			if !hasDeadline {
+				t.Fatal("no deadline, want one")
+			}
+			// Allow for the time between building the header and serving the request
+			if got := deadline.Sub(start); got > tt.want+time.Second || got < tt.want-time.Second {
+				t.Errorf("deadline is %v away, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package httpx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes what is wrong with one field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	// format and args are what Message was formatted from, so it can be translated
+	format string
+	args   []any
+}
+
+// Validation collects field errors while checking a decoded request body.
+// Decode optional-vs-required fields into pointers so an omitted field (nil)
+// can be told apart from one sent empty.
+type Validation struct {
+	errs []FieldError
+}
+
+// Required checks a string field was sent and is not empty or whitespace
+func (v *Validation) Required(field string, value *string) {
+	switch {
+	case value == nil:
+		v.Add(field, "is required")
+	case strings.TrimSpace(*value) == "":
+		v.Add(field, "must not be empty")
+	}
+}
+
+// Add records a problem with a field
+func (v *Validation) Add(field, message string) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: message})
+}
+
+// Addf records a problem with a field, formatting the message. The format is
+// what the message catalog translates, so keep it constant.
+func (v *Validation) Addf(field, format string, args ...any) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...), format: format, args: args})
+}
+
+// Valid reports whether no problems were recorded
+func (v *Validation) Valid() bool {
+	return len(v.errs) == 0
+}
+
+// Errors returns the recorded problems in the order they were found
+func (v *Validation) Errors() []FieldError {
+	return v.errs
+}
@@ -0,0 +1,76 @@
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+)
+
+// Generator produces identifiers and opaque tokens. Production code uses Random;
+// tests use a Sequence so generated values are predictable.
+type Generator interface {
+	// NewID returns a new UUID-formatted identifier
+	NewID() string
+	// NewToken returns a URL-safe random token
+	NewToken() string
+}
+
+type randomGenerator struct{}
+
+// Random returns a Generator backed by crypto/rand
+func Random() Generator {
+	return randomGenerator{}
+}
+
+// NewID returns a random (version 4) UUID
+func (randomGenerator) NewID() string {
+	var b [16]byte
+	mustRead(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// NewToken returns 32 random bytes encoded as unpadded URL-safe base64
+func (randomGenerator) NewToken() string {
+	var b [32]byte
+	mustRead(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func mustRead(b []byte) {
+	// crypto/rand.Read never returns an error on supported platforms
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("ids: crypto/rand failed: %v", err))
+	}
+}
+
+// Sequence is a deterministic Generator for tests. It is safe for concurrent use.
+type Sequence struct {
+	mu sync.Mutex
+	n  uint64
+}
+
+// NewSequence creates a Sequence starting at 1
+func NewSequence() *Sequence {
+	return &Sequence{}
+}
+
+// NewID returns UUID-formatted IDs counting up from 00000000-0000-0000-0000-000000000001
+func (s *Sequence) NewID() string {
+	n := s.next()
+	return fmt.Sprintf("00000000-0000-0000-0000-%012x", n)
+}
+
+// NewToken returns tokens of the form token-1, token-2, ...
+func (s *Sequence) NewToken() string {
+	return fmt.Sprintf("token-%d", s.next())
+}
+
+func (s *Sequence) next() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	return s.n
+}
@@ -0,0 +1,476 @@
+package jobqueue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"shared/clock"
+	"shared/ids"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"    // waiting to run, including retries waiting for their backoff
+	StatusRunning   = "running"   // claimed by a worker
+	StatusSucceeded = "succeeded" // finished successfully
+	StatusDead      = "dead"      // failed MaxAttempts times; will not be retried
+)
+
+// ErrNotFound is returned when a job does not exist in the caller's tenant
+var ErrNotFound = errors.New("job not found")
+
+var (
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jobs_queue_depth",
+		Help: "Jobs waiting to run, by kind.",
+	}, []string{"kind"})
+	jobWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jobs_wait_seconds",
+		Help:    "Time from a job becoming runnable to a worker claiming it.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+	}, []string{"kind"})
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jobs_duration_seconds",
+		Help:    "Time spent running a job attempt, by kind and outcome.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+	}, []string{"kind", "outcome"})
+)
+
+// Progress is the latest progress reported by a running job
+type Progress struct {
+	Processed int `json:"processed"`
+	Total     int `json:"total"`
+	Failed    int `json:"failed,omitempty"` // items processed without success, for jobs that carry on past them
+}
+
+// Job is a unit of background work stored in the jobs table
+type Job struct {
+	ID          string          `json:"id"`
+	TenantID    string          `json:"-"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Payload     json.RawMessage `json:"-"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Progress    *Progress       `json:"progress,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	LastError   *string         `json:"last_error,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// ReportProgress lets a running job record how far it has got
+type ReportProgress func(Progress)
+
+// Handler runs a job and returns a JSON-serialisable result. A returned error
+// retries the job with backoff until MaxAttempts is reached.
+type Handler func(ctx context.Context, job Job, progress ReportProgress) (any, error)
+
+// Config tunes the worker pool
+type Config struct {
+	Concurrency  int           // jobs run in parallel
+	PollInterval time.Duration // how often idle workers look for work
+	JobTimeout   time.Duration // maximum time for one attempt; longer-running jobs are considered lost
+	MaxAttempts  int           // attempts before a job is dead-lettered
+	Retention    time.Duration // how long finished jobs are kept for polling; 0 keeps them forever
+}
+
+// DefaultConfig returns sensible worker settings
+func DefaultConfig() Config {
+	return Config{
+		Concurrency:  2,
+		PollInterval: time.Second,
+		JobTimeout:   15 * time.Minute,
+		MaxAttempts:  5,
+		Retention:    7 * 24 * time.Hour,
+	}
+}
+
+// ConfigFromEnv reads worker settings from JOB_WORKERS, JOB_MAX_ATTEMPTS and
+// JOB_RETENTION, starting from DefaultConfig
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+
+	if raw := os.Getenv("JOB_WORKERS"); raw != "" {
+		workers, err := strconv.Atoi(raw)
+		if err != nil || workers <= 0 {
+			return cfg, fmt.Errorf("invalid JOB_WORKERS %q", raw)
+		}
+		cfg.Concurrency = workers
+	}
+
+	if raw := os.Getenv("JOB_MAX_ATTEMPTS"); raw != "" {
+		attempts, err := strconv.Atoi(raw)
+		if err != nil || attempts <= 0 {
+			return cfg, fmt.Errorf("invalid JOB_MAX_ATTEMPTS %q", raw)
+		}
+		cfg.MaxAttempts = attempts
+	}
+
+	if raw := os.Getenv("JOB_RETENTION"); raw != "" {
+		retention, err := time.ParseDuration(raw)
+		if err != nil || retention < 0 {
+			return cfg, fmt.Errorf("invalid JOB_RETENTION %q", raw)
+		}
+		cfg.Retention = retention
+	}
+
+	return cfg, nil
+}
+
+// Queue is a Postgres-backed job queue. Jobs survive restarts because all state
+// lives in the jobs table; workers claim jobs with SELECT ... FOR UPDATE SKIP LOCKED
+// so several replicas can share one queue.
+type Queue struct {
+	db       *sql.DB
+	cfg      Config
+	clock    clock.Clock
+	ids      ids.Generator
+	handlers map[string]Handler
+}
+
+// New creates a Queue backed by db, which must contain the jobs table
+func New(db *sql.DB, cfg Config, c clock.Clock, g ids.Generator) *Queue {
+	return &Queue{db: db, cfg: cfg, clock: c, ids: g, handlers: map[string]Handler{}}
+}
+
+// Register sets the handler for a job kind. Register all kinds before calling Run.
+func (q *Queue) Register(kind string, h Handler) {
+	q.handlers[kind] = h
+}
+
+// Enqueue stores a new job to be run as soon as a worker is free
+func (q *Queue) Enqueue(ctx context.Context, tenantID, kind string, payload any) (Job, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("could not encode job payload: %w", err)
+	}
+
+	now := q.clock.Now().UTC()
+	job := Job{
+		ID:          q.ids.NewID(),
+		TenantID:    tenantID,
+		Kind:        kind,
+		Status:      StatusQueued,
+		Payload:     body,
+		MaxAttempts: q.cfg.MaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+	}
+
+	_, err = q.db.ExecContext(ctx, `
+		INSERT INTO jobs (id, tenant_id, kind, status, payload, max_attempts, run_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		job.ID, job.TenantID, job.Kind, job.Status, []byte(job.Payload), job.MaxAttempts, job.RunAt, job.CreatedAt)
+	if err != nil {
+		return Job{}, fmt.Errorf("could not enqueue job: %w", err)
+	}
+	return job, nil
+}
+
+const jobColumns = `id, tenant_id, kind, status, payload, attempts, max_attempts, progress, result, last_error, run_at, created_at, started_at, finished_at`
+
+// Get returns a job in the given tenant
+func (q *Queue) Get(ctx context.Context, tenantID, id string) (Job, error) {
+	row := q.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("could not get job: %w", err)
+	}
+	return job, nil
+}
+
+// Run starts the workers and blocks until ctx is cancelled and in-flight jobs have stopped.
+// Jobs interrupted by shutdown are put back in the queue without using up an attempt.
+func (q *Queue) Run(ctx context.Context) {
+	log.Printf("Job workers started (concurrency: %d, kinds: %d)", q.cfg.Concurrency, len(q.handlers))
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.maintain(ctx)
+	}()
+
+	wg.Wait()
+	log.Println("Job workers stopped.")
+}
+
+// work claims and runs jobs until ctx is cancelled
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Could not claim job: %v", err)
+		}
+		if err != nil || job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(q.cfg.PollInterval):
+			}
+			continue
+		}
+		q.execute(ctx, *job)
+	}
+}
+
+// claim marks the oldest runnable job as running and returns it, or nil if there is none
+func (q *Queue) claim(ctx context.Context) (*Job, error) {
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := q.clock.Now().UTC()
+	row := tx.QueryRowContext(ctx, `
+		SELECT `+jobColumns+` FROM jobs
+		WHERE status = $1 AND run_at <= $2 AND kind = ANY($3)
+		ORDER BY run_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`,
+		StatusQueued, now, pq.Array(kinds))
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE jobs SET status = $2, attempts = attempts + 1, started_at = $3 WHERE id = $1`,
+		job.ID, StatusRunning, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	jobWait.WithLabelValues(job.Kind).Observe(now.Sub(job.RunAt).Seconds())
+	job.Status = StatusRunning
+	job.Attempts++
+	job.StartedAt = &now
+	return &job, nil
+}
+
+// execute runs a claimed job and records the outcome
+func (q *Queue) execute(ctx context.Context, job Job) {
+	jobCtx, cancel := context.WithTimeout(ctx, q.cfg.JobTimeout)
+	defer cancel()
+
+	progress := func(p Progress) {
+		body, _ := json.Marshal(p)
+		if _, err := q.db.ExecContext(context.WithoutCancel(jobCtx), `UPDATE jobs SET progress = $2 WHERE id = $1`, job.ID, body); err != nil {
+			log.Printf("Could not record progress for job %s: %v", job.ID, err)
+		}
+	}
+
+	started := q.clock.Now()
+	result, err := q.runHandler(jobCtx, job, progress)
+	elapsed := q.clock.Now().Sub(started).Seconds()
+
+	// Use a fresh context so the outcome is recorded even during shutdown
+	saveCtx, saveCancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer saveCancel()
+	now := q.clock.Now().UTC()
+
+	switch {
+	case err == nil:
+		jobDuration.WithLabelValues(job.Kind, "succeeded").Observe(elapsed)
+		body, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			body = nil
+		}
+		_, err = q.db.ExecContext(saveCtx, `UPDATE jobs SET status = $2, result = $3, last_error = NULL, finished_at = $4 WHERE id = $1`,
+			job.ID, StatusSucceeded, body, now)
+		log.Printf("Job %s (%s) succeeded in %.2fs", job.ID, job.Kind, elapsed)
+
+	case ctx.Err() != nil:
+		// Interrupted by shutdown: put it back without counting the attempt
+		jobDuration.WithLabelValues(job.Kind, "interrupted").Observe(elapsed)
+		_, err = q.db.ExecContext(saveCtx, `UPDATE jobs SET status = $2, attempts = attempts - 1, run_at = $3 WHERE id = $1`,
+			job.ID, StatusQueued, now)
+		log.Printf("Job %s (%s) interrupted by shutdown; requeued", job.ID, job.Kind)
+
+	case job.Attempts >= job.MaxAttempts:
+		jobDuration.WithLabelValues(job.Kind, "dead").Observe(elapsed)
+		_, err = q.db.ExecContext(saveCtx, `UPDATE jobs SET status = $2, last_error = $3, finished_at = $4 WHERE id = $1`,
+			job.ID, StatusDead, err.Error(), now)
+		log.Printf("Job %s (%s) failed permanently after %d attempts", job.ID, job.Kind, job.Attempts)
+
+	default:
+		jobDuration.WithLabelValues(job.Kind, "failed").Observe(elapsed)
+		retryAt := now.Add(backoff(job.Attempts))
+		_, err = q.db.ExecContext(saveCtx, `UPDATE jobs SET status = $2, last_error = $3, run_at = $4 WHERE id = $1`,
+			job.ID, StatusQueued, err.Error(), retryAt)
+		log.Printf("Job %s (%s) failed (attempt %d/%d); retrying at %s", job.ID, job.Kind, job.Attempts, job.MaxAttempts, retryAt.Format(time.RFC3339))
+	}
+
+	if err != nil {
+		log.Printf("Could not record outcome of job %s: %v", job.ID, err)
+	}
+}
+
+// runHandler calls the job's handler, turning a panic into an error
+func (q *Queue) runHandler(ctx context.Context, job Job, progress ReportProgress) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return q.handlers[job.Kind](ctx, job, progress)
+}
+
+// backoff returns the delay before retry n: 2s, 4s, 8s, ... capped at 10 minutes
+func backoff(attempt int) time.Duration {
+	delay := time.Duration(1<<min(attempt, 10)) * time.Second
+	return min(delay, 10*time.Minute)
+}
+
+// maintain periodically requeues jobs lost by crashed workers, deletes finished
+// jobs past their retention and refreshes the depth gauge
+func (q *Queue) maintain(ctx context.Context) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		q.requeueLost(ctx)
+		q.purgeFinished(ctx)
+		q.refreshDepth(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requeueLost puts back jobs that have been running for longer than any attempt may
+// take, which means the worker that claimed them died
+func (q *Queue) requeueLost(ctx context.Context) {
+	cutoff := q.clock.Now().UTC().Add(-q.cfg.JobTimeout - time.Minute)
+	result, err := q.db.ExecContext(ctx, `UPDATE jobs SET status = $1 WHERE status = $2 AND started_at < $3`,
+		StatusQueued, StatusRunning, cutoff)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Could not requeue lost jobs: %v", err)
+		}
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Requeued %d jobs lost by stopped workers", n)
+	}
+}
+
+// purgeFinished deletes succeeded and dead jobs that finished longer than
+// Retention ago; their IDs answer ErrNotFound afterwards
+func (q *Queue) purgeFinished(ctx context.Context) {
+	if q.cfg.Retention == 0 {
+		return
+	}
+	cutoff := q.clock.Now().UTC().Add(-q.cfg.Retention)
+	result, err := q.db.ExecContext(ctx, `DELETE FROM jobs WHERE status IN ($1, $2) AND finished_at < $3`,
+		StatusSucceeded, StatusDead, cutoff)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Could not delete finished jobs: %v", err)
+		}
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Deleted %d jobs finished more than %s ago", n, q.cfg.Retention)
+	}
+}
+
+// refreshDepth updates the queue depth gauge
+func (q *Queue) refreshDepth(ctx context.Context) {
+	rows, err := q.db.QueryContext(ctx, `SELECT kind, count(*) FROM jobs WHERE status = $1 GROUP BY kind`, StatusQueued)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Could not measure queue depth: %v", err)
+		}
+		return
+	}
+	defer rows.Close()
+
+	for kind := range q.handlers {
+		queueDepth.WithLabelValues(kind).Set(0)
+	}
+	for rows.Next() {
+		var kind string
+		var depth float64
+		if err := rows.Scan(&kind, &depth); err != nil {
+			return
+		}
+		queueDepth.WithLabelValues(kind).Set(depth)
+	}
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row scanner) (Job, error) {
+	var job Job
+	var payload, progress, result []byte
+	var lastError sql.NullString
+	var startedAt, finishedAt sql.NullTime
+
+	err := row.Scan(&job.ID, &job.TenantID, &job.Kind, &job.Status, &payload, &job.Attempts, &job.MaxAttempts,
+		&progress, &result, &lastError, &job.RunAt, &job.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return Job{}, err
+	}
+
+	job.Payload = payload
+	if progress != nil {
+		job.Progress = &Progress{}
+		if err := json.Unmarshal(progress, job.Progress); err != nil {
+			return Job{}, fmt.Errorf("could not decode job progress: %w", err)
+		}
+	}
+	if result != nil {
+		job.Result = result
+	}
+	if lastError.Valid {
+		job.LastError = &lastError.String
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
@@ -0,0 +1,238 @@
+package jobqueue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"shared/clock"
+	"shared/ids"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// testNow is when the fake clock of newMockQueue stands
+var testNow = time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+// jobColumnNames are jobColumns as sqlmock rows need them
+var jobColumnNames = []string{"id", "tenant_id", "kind", "status", "payload", "attempts", "max_attempts",
+	"progress", "result", "last_error", "run_at", "created_at", "started_at", "finished_at"}
+
+// newMockQueue returns a Queue over sqlmock with the default config, a clock frozen
+// at testNow and sequential IDs; unmet expectations fail the test
+func newMockQueue(t *testing.T) (*Queue, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return New(db, DefaultConfig(), clock.NewFake(testNow), ids.NewSequence()), mock
+}
+
+// runningJob returns a job of kind "test" on its first of three attempts
+func runningJob() Job {
+	return Job{ID: "job-1", TenantID: "acme", Kind: "test", Status: StatusRunning, Attempts: 1, MaxAttempts: 3}
+}
+
+func TestEnqueueCreatesQueuedJob(t *testing.T) {
+	q, mock := newMockQueue(t)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO jobs")).
+		WithArgs("00000000-0000-0000-0000-000000000001", "acme", "test", StatusQueued, []byte(`{"rows":2}`), 5, testNow, testNow).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	job, err := q.Enqueue(context.Background(), "acme", "test", map[string]int{"rows": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != "00000000-0000-0000-0000-000000000001" || job.Status != StatusQueued || job.MaxAttempts != 5 {
+		t.Errorf("job = %+v, want a queued job with the next ID and 5 attempts", job)
+	}
+	if !job.RunAt.Equal(testNow) || job.Progress != nil {
+		t.Errorf("job = %+v, want it runnable now without progress", job)
+	}
+}
+
+func TestEnqueueRejectsUnencodablePayload(t *testing.T) {
+	// No insert is expected
+	q, _ := newMockQueue(t)
+	if _, err := q.Enqueue(context.Background(), "acme", "test", func() {}); err == nil {
+		t.Error("err = nil, want the encoding error")
+	}
+}
+
+func TestExecuteRecordsProgressAndCompletion(t *testing.T) {
+	q, mock := newMockQueue(t)
+	q.Register("test", func(ctx context.Context, job Job, progress ReportProgress) (any, error) {
+		progress(Progress{Processed: 1, Total: 2})
+		progress(Progress{Processed: 2, Total: 2, Failed: 1})
+		return map[string]int{"created": 1}, nil
+	})
+
+	// Each report is saved as it is made, then the result once the handler returns
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE jobs SET progress = $2 WHERE id = $1")).
+		WithArgs("job-1", []byte(`{"processed":1,"total":2}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE jobs SET progress = $2 WHERE id = $1")).
+		WithArgs("job-1", []byte(`{"processed":2,"total":2,"failed":1}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE jobs SET status = $2, result = $3, last_error = NULL, finished_at = $4")).
+		WithArgs("job-1", StatusSucceeded, []byte(`{"created":1}`), testNow).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	q.execute(context.Background(), runningJob())
+}
+
+func TestExecuteRetriesFailedJob(t *testing.T) {
+	q, mock := newMockQueue(t)
+	q.Register("test", func(context.Context, Job, ReportProgress) (any, error) {
+		return nil, errors.New("upstream down")
+	})
+
+	// The first attempt failed, so the job waits backoff(1) before the next
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE jobs SET status = $2, last_error = $3, run_at = $4")).
+		WithArgs("job-1", StatusQueued, "upstream down", testNow.Add(2*time.Second)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	q.execute(context.Background(), runningJob())
+}
+
+func TestExecuteDeadLettersLastAttempt(t *testing.T) {
+	q, mock := newMockQueue(t)
+	q.Register("test", func(context.Context, Job, ReportProgress) (any, error) {
+		panic("nil map")
+	})
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE jobs SET status = $2, last_error = $3, finished_at = $4")).
+		WithArgs("job-1", StatusDead, "job panicked: nil map", testNow).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	job := runningJob()
+	job.Attempts = job.MaxAttempts
+	q.execute(context.Background(), job)
+}
+
+func TestExecuteRequeuesOnShutdown(t *testing.T) {
+	q, mock := newMockQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	q.Register("test", func(ctx context.Context, job Job, progress ReportProgress) (any, error) {
+		cancel()
+		return nil, ctx.Err()
+	})
+
+	// The interrupted attempt is given back
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE jobs SET status = $2, attempts = attempts - 1, run_at = $3")).
+		WithArgs("job-1", StatusQueued, testNow).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	q.execute(ctx, runningJob())
+}
+
+func TestClaimMarksOldestJobRunning(t *testing.T) {
+	q, mock := newMockQueue(t)
+	q.Register("test", nil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FOR UPDATE SKIP LOCKED")).
+		WithArgs(StatusQueued, testNow, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(jobColumnNames).
+			AddRow("job-1", "acme", "test", StatusQueued, []byte(`{}`), 0, 3, nil, nil, nil, testNow.Add(-time.Minute), testNow.Add(-time.Minute), nil, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE jobs SET status = $2, attempts = attempts + 1, started_at = $3")).
+		WithArgs("job-1", StatusRunning, testNow).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	job, err := q.claim(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.Status != StatusRunning || job.Attempts != 1 || job.StartedAt == nil || !job.StartedAt.Equal(testNow) {
+		t.Errorf("job = %+v, want it running on its first attempt", job)
+	}
+}
+
+func TestClaimWithEmptyQueue(t *testing.T) {
+	q, mock := newMockQueue(t)
+	q.Register("test", nil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FOR UPDATE SKIP LOCKED")).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	if job, err := q.claim(context.Background()); job != nil || err != nil {
+		t.Errorf("got %+v, %v, want no job", job, err)
+	}
+}
+
+func TestGetReportsProgressAndResult(t *testing.T) {
+	q, mock := newMockQueue(t)
+	finished := testNow.Add(time.Minute)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM jobs WHERE id = $1 AND tenant_id = $2")).
+		WithArgs("job-1", "acme").
+		WillReturnRows(sqlmock.NewRows(jobColumnNames).
+			AddRow("job-1", "acme", "test", StatusSucceeded, []byte(`{}`), 1, 3,
+				[]byte(`{"processed":250,"total":250,"failed":3}`), []byte(`{"created":247}`), nil,
+				testNow, testNow, testNow, finished))
+
+	job, err := q.Get(context.Background(), "acme", "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Progress == nil || *job.Progress != (Progress{Processed: 250, Total: 250, Failed: 3}) {
+		t.Errorf("progress = %+v, want 250 of 250 with 3 failed", job.Progress)
+	}
+	if string(job.Result) != `{"created":247}` || job.FinishedAt == nil || !job.FinishedAt.Equal(finished) {
+		t.Errorf("job = %+v, want the stored result and finish time", job)
+	}
+
+	// The progress is what a poller sees
+	body, _ := json.Marshal(job)
+	var polled struct {
+		Status   string   `json:"status"`
+		Progress Progress `json:"progress"`
+	}
+	if err := json.Unmarshal(body, &polled); err != nil {
+		t.Fatal(err)
+	}
+	if polled.Status != StatusSucceeded || polled.Progress.Processed != 250 {
+		t.Errorf("polled %s, want succeeded with its progress", body)
+	}
+}
+
+func TestGetInAnotherTenantIsNotFound(t *testing.T) {
+	q, mock := newMockQueue(t)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM jobs WHERE id = $1 AND tenant_id = $2")).
+		WithArgs("job-1", "globex").
+		WillReturnError(sql.ErrNoRows)
+
+	if _, err := q.Get(context.Background(), "globex", "job-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{5, 32 * time.Second},
+		{9, 512 * time.Second},
+		{10, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
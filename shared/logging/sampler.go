@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultWindow is how long repeats of a line are collapsed when LOG_SAMPLING_WINDOW is unset
+const DefaultWindow = 10 * time.Second
+
+// Key identifies lines that count as repeats of each other
+type Key struct {
+	Message string // a stable message name, e.g. "proxy error"
+	Service string
+	Class   string // see ErrorClass
+}
+
+// Sampler collapses identical log lines. The first line for a key is logged
+// immediately; further lines within the window are counted and reported as a
+// single "repeated N times" line when the window closes.
+type Sampler struct {
+	window  time.Duration
+	enabled bool
+	logf    func(format string, args ...any)
+
+	mu   sync.Mutex
+	seen map[Key]*repeat
+}
+
+type repeat struct {
+	line  string // most recent line, reported in the summary
+	count int    // lines suppressed since the first
+}
+
+// NewSampler creates a Sampler that collapses repeats within window
+func NewSampler(window time.Duration) *Sampler {
+	return &Sampler{window: window, enabled: true, logf: log.Printf, seen: map[Key]*repeat{}}
+}
+
+// SamplerFromEnv creates a Sampler configured by LOG_SAMPLING (set to "off" to log
+// every line) and LOG_SAMPLING_WINDOW
+func SamplerFromEnv() (*Sampler, error) {
+	window := DefaultWindow
+	if raw := os.Getenv("LOG_SAMPLING_WINDOW"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid LOG_SAMPLING_WINDOW %q", raw)
+		}
+		window = d
+	}
+
+	s := NewSampler(window)
+	switch raw := strings.ToLower(os.Getenv("LOG_SAMPLING")); raw {
+	case "", "on", "true":
+	case "off", "false":
+		s.enabled = false
+	default:
+		return nil, fmt.Errorf("invalid LOG_SAMPLING %q", raw)
+	}
+	return s, nil
+}
+
+// Printf logs a line, unless a line with the same key was logged within the window
+func (s *Sampler) Printf(key Key, format string, args ...any) {
+	line := fmt.Sprintf(format, args...)
+	if s == nil || !s.enabled {
+		log.Print(line)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.seen[key]; ok {
+		r.line = line
+		r.count++
+		return
+	}
+
+	s.seen[key] = &repeat{line: line}
+	s.logf("%s", line)
+	time.AfterFunc(s.window, func() { s.summarise(key) })
+}
+
+// Flush reports any pending repeat counts now, e.g. during shutdown
+func (s *Sampler) Flush() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	keys := make([]Key, 0, len(s.seen))
+	for key := range s.seen {
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+
+	for _, key := range keys {
+		s.summarise(key)
+	}
+}
+
+// summarise closes the window for key, logging how many lines were suppressed
+func (s *Sampler) summarise(key Key) {
+	s.mu.Lock()
+	r, ok := s.seen[key]
+	delete(s.seen, key)
+	s.mu.Unlock()
+
+	if ok && r.count > 0 {
+		s.logf("%s (repeated %d times in %s)", r.line, r.count, s.window)
+	}
+}
+
+// ErrorClass buckets an error for use in a Key, so e.g. every refused connection
+// to a service collapses into one line regardless of the exact message
+func ErrorClass(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection_reset"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	default:
+		return "other"
+	}
+}
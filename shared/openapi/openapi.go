@@ -0,0 +1,330 @@
+// Package openapi builds an OpenAPI 3 document describing a service's routes.
+// Operations are registered with the same "METHOD /path" patterns as the mux,
+// and request and response schemas are reflected from the Go types handlers
+// encode, so the document follows the DTOs as they change. Named struct types
+// become shared component schemas; field names come from their json tags.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"shared/httpx"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version documents are written in
+const Version = "3.0.3"
+
+// EnabledFromEnv reads OPENAPI_ENABLED; the document is served unless it is false
+func EnabledFromEnv() (bool, error) {
+	raw := os.Getenv("OPENAPI_ENABLED")
+	if raw == "" {
+		return true, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return true, fmt.Errorf("invalid OPENAPI_ENABLED %q", raw)
+	}
+	return enabled, nil
+}
+
+// Document is an OpenAPI document. Build one with New and Add.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Components holds the schemas operations refer to by name
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// PathItem holds the operations on one path
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+	Head   *Operation `json:"head,omitempty"`
+}
+
+// Operation describes one method on one path
+type Operation struct {
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the body an operation reads
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is one possible answer of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType gives the schema of a body in one content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema OpenAPI 3.0 uses
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// New creates an empty document. The error shape every service shares is
+// registered up front as the ErrorResponse schema.
+func New(title, version string) *Document {
+	d := &Document{
+		OpenAPI:    Version,
+		Info:       Info{Title: title, Version: version},
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}
+	d.SchemaOf(httpx.ErrorResponse{})
+	return d
+}
+
+var pathParam = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// Add registers op under a mux pattern such as "GET /products/{id}". Wildcards in
+// the path that op doesn't describe itself are added as string path parameters.
+func (d *Document) Add(pattern string, op Operation) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		panic(fmt.Sprintf("openapi: pattern %q has no method", pattern))
+	}
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		if !hasParam(op.Parameters, m[1], "path") {
+			op.Parameters = append(op.Parameters, Path(m[1], &Schema{Type: "string"}))
+		}
+	}
+	path = pathParam.ReplaceAllString(path, "{$1}")
+
+	item := d.Paths[path]
+	if item == nil {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	slot := map[string]**Operation{
+		http.MethodGet:    &item.Get,
+		http.MethodPut:    &item.Put,
+		http.MethodPost:   &item.Post,
+		http.MethodDelete: &item.Delete,
+		http.MethodPatch:  &item.Patch,
+		http.MethodHead:   &item.Head,
+	}[method]
+	if slot == nil {
+		panic(fmt.Sprintf("openapi: unsupported method in %q", pattern))
+	}
+	*slot = &op
+}
+
+func hasParam(params []Parameter, name, in string) bool {
+	for _, p := range params {
+		if p.Name == name && p.In == in {
+			return true
+		}
+	}
+	return false
+}
+
+// Path describes a path parameter
+func Path(name string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Schema: schema}
+}
+
+// Query describes an optional query parameter
+func Query(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// Header describes an optional request header
+func Header(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "header", Description: description, Schema: schema}
+}
+
+// Integer is the schema of an int32 path or query parameter
+func Integer() *Schema {
+	return &Schema{Type: "integer", Format: "int32"}
+}
+
+// String is the schema of a string parameter, limited to values if any are given
+func String(values ...string) *Schema {
+	return &Schema{Type: "string", Enum: values}
+}
+
+// Body is a required JSON request body shaped like v
+func (d *Document) Body(v any) *RequestBody {
+	return &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: d.SchemaOf(v)}}}
+}
+
+// JSON is a JSON response shaped like v
+func (d *Document) JSON(description string, v any) Response {
+	return Response{Description: description, Content: map[string]MediaType{"application/json": {Schema: d.SchemaOf(v)}}}
+}
+
+// Error is an error response with the ErrorResponse body
+func (d *Document) Error(description string) Response {
+	return d.JSON(description, httpx.ErrorResponse{})
+}
+
+// Empty is a response without a body
+func Empty(description string) Response {
+	return Response{Description: description}
+}
+
+// Stream is a response of media type contentType whose body isn't JSON, such as
+// text/event-stream
+func Stream(description, contentType string) Response {
+	return Response{Description: description, Content: map[string]MediaType{contentType: {Schema: &Schema{Type: "string"}}}}
+}
+
+// SchemaOf returns the schema of v's type. Named struct types are added to the
+// components and referred to by name.
+func (d *Document) SchemaOf(v any) *Schema {
+	return d.schema(reflect.TypeOf(v))
+}
+
+var (
+	timeType = reflect.TypeFor[time.Time]()
+	rawType  = reflect.TypeFor[json.RawMessage]()
+)
+
+func (d *Document) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawType:
+		return &Schema{} // any JSON value
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := d.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case reflect.Struct:
+		name := componentName(t)
+		if name == "" {
+			return d.object(t)
+		}
+		if _, ok := d.Components.Schemas[name]; !ok {
+			d.Components.Schemas[name] = &Schema{} // placeholder, so recursive types terminate
+			d.Components.Schemas[name] = d.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		return &Schema{}
+	}
+}
+
+// object describes a struct's JSON fields; fields without omitempty are required
+func (d *Document) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = d.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// componentName names a struct's schema after its type; instances of generic types
+// such as httpx.ListResponse[api.Product] become ListResponseOfProduct
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	base, args, generic := strings.Cut(name, "[")
+	if !generic {
+		return name
+	}
+	args = strings.TrimSuffix(args, "]")
+	return base + "Of" + args[strings.LastIndex(args, ".")+1:]
+}
+
+// Handler serves the document as JSON. It is encoded once, so register every
+// operation before calling Handler.
+func (d *Document) Handler() http.Handler {
+	body, err := json.Marshal(d)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: %v", err))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+}
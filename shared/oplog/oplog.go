@@ -0,0 +1,302 @@
+// Package oplog keeps an operation log of mutating requests, for support
+// investigations such as "what did the PUT that broke this product contain".
+// Each opted-in request is recorded before its handler runs, with a redacted copy
+// of its body, and its status is added once it has been answered. Records go to
+// a Store: the log output, or the operations table for querying through
+// /admin/operations.
+package oplog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"shared/auth"
+	"shared/clock"
+	"shared/httpx"
+	"shared/ids"
+	"shared/tenant"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stores an operation log can be kept in
+const (
+	SinkOff = "off" // nothing is recorded
+	SinkLog = "log" // one structured log line when a request starts and one when it ends
+	SinkDB  = "db"  // the operations table, queryable through /admin/operations
+)
+
+// Redacted replaces the value of a redacted field
+const Redacted = "[REDACTED]"
+
+// maxCapturedBody is the largest body that is parsed for redaction; a larger one
+// is recorded without its body
+const maxCapturedBody = 64 << 10
+
+// Config is read from the environment:
+//
+//	OPLOG_SINK       off (default), log or db
+//	OPLOG_MAX_BODY   bytes of redacted body kept per operation, default 4096
+//	OPLOG_RETENTION  how long the db sink keeps operations, default 30d; 0 keeps them
+//	OPLOG_PRUNE_INTERVAL  how often expired operations are deleted, default 1h
+type Config struct {
+	Sink          string
+	MaxBody       int
+	Retention     time.Duration
+	PruneInterval time.Duration
+}
+
+// ConfigFromEnv reads the operation log configuration from the environment
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Sink: SinkOff, MaxBody: 4096, Retention: 30 * 24 * time.Hour, PruneInterval: time.Hour}
+
+	switch raw := os.Getenv("OPLOG_SINK"); raw {
+	case "":
+	case SinkOff, SinkLog, SinkDB:
+		cfg.Sink = raw
+	default:
+		return cfg, fmt.Errorf("invalid OPLOG_SINK %q: expected off, log or db", raw)
+	}
+
+	if raw := os.Getenv("OPLOG_MAX_BODY"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid OPLOG_MAX_BODY %q", raw)
+		}
+		cfg.MaxBody = n
+	}
+
+	if raw := os.Getenv("OPLOG_RETENTION"); raw != "" {
+		retention, err := parseDays(raw)
+		if err != nil || retention < 0 {
+			return cfg, fmt.Errorf("invalid OPLOG_RETENTION %q", raw)
+		}
+		cfg.Retention = retention
+	}
+
+	if raw := os.Getenv("OPLOG_PRUNE_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return cfg, fmt.Errorf("invalid OPLOG_PRUNE_INTERVAL %q", raw)
+		}
+		cfg.PruneInterval = interval
+	}
+
+	return cfg, nil
+}
+
+// parseDays parses a Go duration, additionally accepting whole days such as "30d"
+func parseDays(raw string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// Operation is one recorded request
+type Operation struct {
+	ID             string     `json:"id"`
+	Method         string     `json:"method"`
+	Path           string     `json:"path"`
+	Route          string     `json:"route"` // the mux pattern, e.g. PUT /products/{id}
+	ActorID        string     `json:"actor_id,omitempty"`
+	ImpersonatedBy string     `json:"impersonated_by,omitempty"`
+	TenantID       string     `json:"tenant_id,omitempty"`
+	RequestID      string     `json:"request_id,omitempty"`
+	Body           string     `json:"body,omitempty"`         // redacted JSON, cut at OPLOG_MAX_BODY bytes
+	BodyOmitted    string     `json:"body_omitted,omitempty"` // why the body isn't there, if it was sent
+	Status         int        `json:"status,omitempty"`       // 0 until the request has been answered
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// Rule opts a route into the log. Fields named "password", or containing it
+// (current_password, password_hash), are always redacted, at any depth.
+type Rule struct {
+	Redact []string // further field names whose values are never recorded
+}
+
+// Routes maps "METHOD pattern" (as registered on the mux) to how its requests are
+// recorded. Routes that aren't listed are not recorded.
+type Routes map[string]Rule
+
+// Store keeps operations
+type Store interface {
+	// Begin records an operation before its request is handled
+	Begin(ctx context.Context, op Operation) error
+	// Finish records the outcome of an operation passed to Begin
+	Finish(ctx context.Context, op Operation) error
+}
+
+// Recorder records the requests to opted-in routes
+type Recorder struct {
+	store   Store
+	routes  Routes
+	maxBody int
+	clock   clock.Clock
+	ids     ids.Generator
+}
+
+// New creates a Recorder keeping operations in store; a nil store records nothing
+func New(store Store, routes Routes, cfg Config) *Recorder {
+	return &Recorder{store: store, routes: routes, maxBody: cfg.MaxBody, clock: clock.Real(), ids: ids.Random()}
+}
+
+// Middleware records every request to a route in the Recorder's Routes. It must
+// run inside auth.Authorize, which sets the actor. The handler runs even if the
+// operation can't be recorded, as a broken log shouldn't stop writes; the failure
+// is logged instead.
+func (rec *Recorder) Middleware(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if rec.store == nil || len(rec.routes) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := mux.Handler(r)
+			route := r.Method + " " + pattern
+			rule, ok := rec.routes[route]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal := auth.FromContext(r.Context())
+			op := Operation{
+				ID:             rec.ids.NewID(),
+				Method:         r.Method,
+				Path:           r.URL.Path,
+				Route:          route,
+				ActorID:        principal.ID,
+				ImpersonatedBy: principal.ImpersonatedBy,
+				TenantID:       r.Header.Get(tenant.Header),
+				RequestID:      r.Header.Get(httpx.RequestIDHeader),
+				StartedAt:      rec.clock.Now().UTC(),
+			}
+			op.Body, op.BodyOmitted = rec.captureBody(r, rule)
+
+			// The outcome is recorded even if the client has gone
+			ctx := context.WithoutCancel(r.Context())
+			if err := rec.store.Begin(ctx, op); err != nil {
+				log.Printf("Could not record operation %s %s: %v", r.Method, r.URL.Path, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w}
+			defer func() {
+				op.Status = sw.status
+				if op.Status == 0 {
+					op.Status = http.StatusOK
+				}
+				finished := rec.clock.Now().UTC()
+				op.FinishedAt = &finished
+				if err := rec.store.Finish(ctx, op); err != nil {
+					log.Printf("Could not record outcome of operation %s: %v", op.ID, err)
+				}
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// captureBody reads r's body for the log and puts it back for the handler. It
+// returns the redacted body, or why there is none.
+func (rec *Recorder) captureBody(r *http.Request, rule Rule) (body, omitted string) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", ""
+	}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxCapturedBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(raw), r.Body), r.Body}
+	switch {
+	case err != nil:
+		return "", "unreadable"
+	case len(raw) == 0:
+		return "", ""
+	case len(raw) > maxCapturedBody:
+		return "", "too large"
+	}
+
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		// Anything but JSON can't be redacted, so it isn't kept
+		return "", "not JSON"
+	}
+	redacted, err := json.Marshal(redact(doc, rule.Redact))
+	if err != nil {
+		return "", "not JSON"
+	}
+	if len(redacted) > rec.maxBody {
+		return string(redacted[:rec.maxBody]), ""
+	}
+	return string(redacted), ""
+}
+
+// redact replaces the values of password fields and of fields named in names,
+// in objects at any depth
+func redact(v any, names []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if redactedField(key, names) {
+				v[key] = Redacted
+			} else {
+				v[key] = redact(value, names)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redact(value, names)
+		}
+	}
+	return v
+}
+
+func redactedField(key string, names []string) bool {
+	if strings.Contains(strings.ToLower(key), "password") {
+		return true
+	}
+	for _, name := range names {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusWriter remembers the status a handler answered with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,217 @@
+package oplog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"shared/clock"
+	"shared/httpx"
+	"strconv"
+	"time"
+)
+
+// DefaultQueryLimit and MaxQueryLimit bound how many operations Query returns
+const (
+	DefaultQueryLimit = 50
+	MaxQueryLimit     = 500
+)
+
+// FromEnv returns the Store cfg.Sink names; nil for SinkOff. db is only used by SinkDB.
+func FromEnv(cfg Config, db *sql.DB) Store {
+	switch cfg.Sink {
+	case SinkLog:
+		return NewLogStore(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	case SinkDB:
+		return NewDBStore(db, cfg)
+	default:
+		return nil
+	}
+}
+
+// LogStore writes operations to a structured logger, one line when a request
+// starts and one with the same id when it ends. It can't be queried.
+type LogStore struct {
+	logger *slog.Logger
+}
+
+// NewLogStore creates a LogStore writing to logger
+func NewLogStore(logger *slog.Logger) *LogStore {
+	return &LogStore{logger: logger}
+}
+
+// Begin logs the operation
+func (s *LogStore) Begin(ctx context.Context, op Operation) error {
+	s.logger.InfoContext(ctx, "operation started",
+		slog.String("id", op.ID),
+		slog.String("method", op.Method),
+		slog.String("path", op.Path),
+		slog.String("route", op.Route),
+		slog.String("actor_id", op.ActorID),
+		slog.String("impersonated_by", op.ImpersonatedBy),
+		slog.String("tenant_id", op.TenantID),
+		slog.String("request_id", op.RequestID),
+		slog.String("body", op.Body),
+		slog.String("body_omitted", op.BodyOmitted),
+	)
+	return nil
+}
+
+// Finish logs the operation's outcome
+func (s *LogStore) Finish(ctx context.Context, op Operation) error {
+	s.logger.InfoContext(ctx, "operation finished",
+		slog.String("id", op.ID),
+		slog.Int("status", op.Status),
+		slog.Duration("duration", op.FinishedAt.Sub(op.StartedAt)),
+	)
+	return nil
+}
+
+// DBStore keeps operations in the operations table, which the service's own
+// migrations must create
+type DBStore struct {
+	db    *sql.DB
+	cfg   Config
+	clock clock.Clock
+}
+
+// NewDBStore creates a DBStore; cfg sets its retention
+func NewDBStore(db *sql.DB, cfg Config) *DBStore {
+	return &DBStore{db: db, cfg: cfg, clock: clock.Real()}
+}
+
+// Begin inserts the operation without a status
+func (s *DBStore) Begin(ctx context.Context, op Operation) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO operations (id, method, path, route, actor_id, impersonated_by, tenant_id, request_id, body, body_omitted, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		op.ID, op.Method, op.Path, op.Route, op.ActorID, op.ImpersonatedBy, op.TenantID, op.RequestID, op.Body, op.BodyOmitted, op.StartedAt)
+	if err != nil {
+		return fmt.Errorf("could not insert operation: %w", err)
+	}
+	return nil
+}
+
+// Finish sets the operation's status
+func (s *DBStore) Finish(ctx context.Context, op Operation) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE operations SET status = $2, finished_at = $3 WHERE id = $1`,
+		op.ID, op.Status, op.FinishedAt)
+	if err != nil {
+		return fmt.Errorf("could not update operation: %w", err)
+	}
+	return nil
+}
+
+// Filter selects operations to return from Query
+type Filter struct {
+	ActorID string // only operations by this user, if set
+	Limit   int
+}
+
+// Query returns the most recent operations matching f, newest first
+func (s *DBStore) Query(ctx context.Context, f Filter) ([]Operation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, method, path, route, actor_id, impersonated_by, tenant_id, request_id, body, body_omitted, status, started_at, finished_at
+		FROM operations
+		WHERE $1 = '' OR actor_id = $1
+		ORDER BY started_at DESC, id
+		LIMIT $2`, f.ActorID, f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query operations: %w", err)
+	}
+	defer rows.Close()
+
+	ops := []Operation{}
+	for rows.Next() {
+		var op Operation
+		var status sql.NullInt32
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&op.ID, &op.Method, &op.Path, &op.Route, &op.ActorID, &op.ImpersonatedBy, &op.TenantID,
+			&op.RequestID, &op.Body, &op.BodyOmitted, &status, &op.StartedAt, &finishedAt); err != nil {
+			return nil, fmt.Errorf("could not read operation: %w", err)
+		}
+		op.Status = int(status.Int32)
+		if finishedAt.Valid {
+			op.FinishedAt = &finishedAt.Time
+		}
+		ops = append(ops, op)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read operations: %w", err)
+	}
+	return ops, nil
+}
+
+// Prune deletes operations started longer than the retention ago and returns how
+// many were removed
+func (s *DBStore) Prune(ctx context.Context) (int64, error) {
+	if s.cfg.Retention == 0 {
+		return 0, nil
+	}
+	cutoff := s.clock.Now().UTC().Add(-s.cfg.Retention)
+	result, err := s.db.ExecContext(ctx, `DELETE FROM operations WHERE started_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("could not prune operations: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		log.Printf("Deleted %d operations started more than %s ago", n, s.cfg.Retention)
+	}
+	return n, nil
+}
+
+// RunPruner prunes on every OPLOG_PRUNE_INTERVAL until ctx is cancelled
+func (s *DBStore) RunPruner(ctx context.Context) {
+	if s.cfg.Retention == 0 {
+		return
+	}
+	ticker := time.NewTicker(s.cfg.PruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Prune(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Operation log prune failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Handler serves GET /admin/operations: the most recent operations, newest
+// first, optionally only those by ?user_id, up to ?limit. Only the db sink can be
+// queried; with any other store it answers 404.
+func Handler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db, ok := store.(*DBStore)
+		if !ok {
+			httpx.Error(w, http.StatusNotFound, "operation log is not queryable; set OPLOG_SINK=db")
+			return
+		}
+
+		q := r.URL.Query()
+		f := Filter{ActorID: q.Get("user_id"), Limit: DefaultQueryLimit}
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				httpx.Error(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			f.Limit = min(n, MaxQueryLimit)
+		}
+
+		ops, err := db.Query(r.Context(), f)
+		if err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		httpx.WriteJSON(w, r, http.StatusOK, map[string]any{"operations": ops})
+	})
+}
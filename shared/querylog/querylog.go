@@ -0,0 +1,201 @@
+// Package querylog logs database queries that exceed a time threshold and can
+// log the query plan of slow reads, to help find missing indexes.
+//
+// DB wraps a *sql.DB and satisfies the DBTX interface of sqlc-generated
+// queries. Queries run in a transaction (Queries.WithTx) go to the *sql.Tx
+// directly and aren't timed.
+package querylog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultThreshold is the slow-query threshold when SLOW_QUERY_THRESHOLD is unset
+const DefaultThreshold = 500 * time.Millisecond
+
+// explainTimeout bounds how long re-running a query for its plan may take
+const explainTimeout = 30 * time.Second
+
+// Config is read from the environment:
+//
+//	SLOW_QUERY_THRESHOLD  queries taking longer are logged, default 500ms; 0 disables
+//	EXPLAIN_SLOW_QUERIES  set to true to also log the plan of slow reads, from
+//	                      EXPLAIN (ANALYZE, BUFFERS); this runs the query a
+//	                      second time, so it is meant for debugging
+type Config struct {
+	Threshold time.Duration
+	Explain   bool
+}
+
+// ConfigFromEnv reads the slow-query configuration from the environment
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Threshold: DefaultThreshold}
+
+	if raw := os.Getenv("SLOW_QUERY_THRESHOLD"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid SLOW_QUERY_THRESHOLD %q", raw)
+		}
+		cfg.Threshold = d
+	}
+
+	if raw := os.Getenv("EXPLAIN_SLOW_QUERIES"); raw != "" {
+		explain, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid EXPLAIN_SLOW_QUERIES %q", raw)
+		}
+		cfg.Explain = explain
+	}
+
+	return cfg, nil
+}
+
+// DB times the queries it runs and logs the slow ones
+type DB struct {
+	*sql.DB
+	cfg  Config
+	logf func(format string, args ...any)
+
+	// explaining holds a token while a plan is being fetched, so a burst of slow
+	// queries re-runs at most one of them at a time
+	explaining chan struct{}
+}
+
+// Wrap returns db with slow-query logging configured by cfg
+func Wrap(db *sql.DB, cfg Config) *DB {
+	return &DB{DB: db, cfg: cfg, logf: log.Printf, explaining: make(chan struct{}, 1)}
+}
+
+// ExecContext runs a statement and logs it if it was slow
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	db.observe(ctx, query, args, time.Since(start))
+	return result, err
+}
+
+// QueryContext runs a query and logs it if it was slow to return its first rows
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	db.observe(ctx, query, args, time.Since(start))
+	return rows, err
+}
+
+// QueryRowContext runs a query and logs it if it was slow
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	start := time.Now()
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	db.observe(ctx, query, args, time.Since(start))
+	return row
+}
+
+func (db *DB) observe(ctx context.Context, query string, args []any, elapsed time.Duration) {
+	if db.cfg.Threshold <= 0 || elapsed < db.cfg.Threshold {
+		return
+	}
+	name := QueryName(query)
+	db.logf("Slow query %s took %s (threshold %s)", name, elapsed.Round(time.Millisecond), db.cfg.Threshold)
+
+	if !db.cfg.Explain || !IsRead(query) {
+		return
+	}
+	select {
+	case db.explaining <- struct{}{}:
+	default:
+		db.logf("Skipping plan for slow query %s: another plan is being fetched", name)
+		return
+	}
+
+	// The plan is fetched in the background so the request isn't held up by
+	// running its query twice
+	go func() {
+		defer func() { <-db.explaining }()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), explainTimeout)
+		defer cancel()
+
+		plan, err := db.explain(ctx, query, args)
+		if err != nil {
+			db.logf("Could not get plan for slow query %s: %v", name, err)
+			return
+		}
+		db.logf("Plan for slow query %s:\n%s", name, plan)
+	}()
+}
+
+// explain runs EXPLAIN (ANALYZE, BUFFERS) on query. ANALYZE executes the query,
+// so it runs in a read-only transaction that is always rolled back: anything
+// IsRead let through that would still write fails instead of writing.
+func (db *DB) explain(ctx context.Context, query string, args []any) (string, error) {
+	tx, err := db.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "EXPLAIN (ANALYZE, BUFFERS)\n"+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var plan strings.Builder
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		plan.WriteString(line)
+		plan.WriteByte('\n')
+	}
+	return strings.TrimRight(plan.String(), "\n"), rows.Err()
+}
+
+var (
+	commentLine = regexp.MustCompile(`(?m)^\s*--.*$`)
+	nameComment = regexp.MustCompile(`--\s*name:\s*(\w+)`)
+	// lockingRead matches SELECT ... FOR UPDATE and the other row-locking clauses
+	lockingRead = regexp.MustCompile(`(?i)\bFOR\s+(UPDATE|SHARE|NO\s+KEY\s+UPDATE|KEY\s+SHARE)\b`)
+	writeVerb   = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE)\b`)
+)
+
+// IsRead reports whether query is a plain read that is safe to run again:
+// a SELECT, or a WITH whose parts don't write, that takes no row locks
+func IsRead(query string) bool {
+	body := commentLine.ReplaceAllString(query, "")
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT":
+	case "WITH":
+		if writeVerb.MatchString(body) {
+			return false
+		}
+	default:
+		return false
+	}
+	return !lockingRead.MatchString(body)
+}
+
+// QueryName returns the sqlc query name from the "-- name:" comment, or the
+// start of the statement when there is none
+func QueryName(query string) string {
+	if m := nameComment.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+	body := strings.Join(strings.Fields(commentLine.ReplaceAllString(query, "")), " ")
+	if len(body) > 60 {
+		body = body[:60] + "..."
+	}
+	return strconv.Quote(body)
+}
@@ -0,0 +1,280 @@
+// Package redis is a small Redis client speaking RESP2 over a pool of plain TCP
+// connections. It covers what the gateway needs (commands and Lua scripts), not
+// pub/sub, pipelining or cluster mode.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a command when ctx has no sooner deadline
+const DefaultTimeout = time.Second
+
+// maxIdle is how many idle connections the pool keeps open
+const maxIdle = 16
+
+// ErrNil is returned by the typed helpers when Redis replies with a nil bulk string
+var ErrNil = errors.New("redis: nil reply")
+
+// Error is an error reply from the server, e.g. "NOSCRIPT No matching script"
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// NoScript reports whether EVALSHA failed because the server doesn't have the script cached
+func (e Error) NoScript() bool { return strings.HasPrefix(string(e), "NOSCRIPT") }
+
+// ScriptSHA returns the SHA1 that EVALSHA uses to refer to script
+func ScriptSHA(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// Client sends commands to one Redis server. It is safe for concurrent use.
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// New creates a Client for a redis://[user:password@]host:port[/db] URL.
+// Connections are opened on first use.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis URL %q: want redis://host:port[/db]", rawURL)
+	}
+
+	c := &Client{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return c, nil
+}
+
+// Do sends a command and returns its reply: a string for simple and bulk strings,
+// int64 for integers, []any for arrays, and nil for nil replies. An error reply is
+// returned as an Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(DefaultTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.SetDeadline(deadline)
+
+	reply, err := cn.do(args...)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection state is unknown after a network or protocol error
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Int runs a command that replies with an integer
+func (c *Client) Int(ctx context.Context, args ...string) (int64, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %T to %s", reply, args[0])
+	}
+	return n, nil
+}
+
+// Bytes runs a command that replies with a bulk string, returning ErrNil if it is nil
+func (c *Client) Bytes(ctx context.Context, args ...string) ([]byte, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return nil, ErrNil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %T to %s", reply, args[0])
+	}
+}
+
+// Ping checks the server is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Close closes the idle connections
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// get takes an idle connection or dials a new one, authenticating and selecting the database
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+	nc, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to redis: %w", err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.SetDeadline(deadline)
+	}
+
+	var setup [][]string
+	switch {
+	case c.username != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := cn.do(args...); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("could not %s on redis connection: %w", strings.ToLower(args[0]), err)
+		}
+	}
+	return cn, nil
+}
+
+// put returns a healthy connection to the pool
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// do writes one command as an array of bulk strings and reads its reply
+func (cn *conn) do(args ...string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(cn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(cn.r)
+}
+
+// readReply parses one RESP2 reply
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			// An error inside an array (e.g. from EXEC) is returned as the element
+			item, err := readReply(r)
+			var replyErr Error
+			if errors.As(err, &replyErr) {
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
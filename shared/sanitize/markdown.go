@@ -0,0 +1,143 @@
+package sanitize
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Markdown renders a small, common subset of Markdown to sanitized HTML: headings,
+// paragraphs, bullet and numbered lists, block quotes, fenced code, inline code,
+// bold, italics and links. Raw HTML in the source is shown as text.
+func Markdown(src string) string {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var out strings.Builder
+	var para []string
+	list := "" // "ul" or "ol" while inside a list
+
+	flushPara := func() {
+		if len(para) > 0 {
+			out.WriteString("<p>" + inline(strings.Join(para, "\n")) + "</p>")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			out.WriteString("</" + list + ">")
+			list = ""
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimLeft(line, " ")
+
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flushPara()
+			closeList()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimLeft(lines[i], " "), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>")
+
+		case trimmed == "":
+			flushPara()
+			closeList()
+
+		case headingRe.MatchString(trimmed):
+			flushPara()
+			closeList()
+			m := headingRe.FindStringSubmatch(trimmed)
+			level := string(rune('0' + len(m[1])))
+			out.WriteString("<h" + level + ">" + inline(m[2]) + "</h" + level + ">")
+
+		case bulletRe.MatchString(trimmed), orderedRe.MatchString(trimmed):
+			flushPara()
+			kind, re := "ul", bulletRe
+			if orderedRe.MatchString(trimmed) {
+				kind, re = "ol", orderedRe
+			}
+			if list != kind {
+				closeList()
+				out.WriteString("<" + kind + ">")
+				list = kind
+			}
+			out.WriteString("<li>" + inline(re.ReplaceAllString(trimmed, "")) + "</li>")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flushPara()
+			closeList()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimLeft(lines[i], " "), ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(strings.TrimLeft(lines[i], " "), ">"), " "))
+			}
+			i--
+			out.WriteString("<blockquote><p>" + inline(strings.Join(quote, "\n")) + "</p></blockquote>")
+
+		default:
+			closeList()
+			para = append(para, trimmed)
+		}
+	}
+	flushPara()
+	closeList()
+
+	// The renderer only emits allowed tags, but run the result through the
+	// sanitizer anyway so link targets get the same checks as HTML input
+	return HTML(out.String())
+}
+
+// Plain renders plain text as HTML paragraphs, keeping line breaks
+func Plain(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	var out strings.Builder
+	for _, para := range strings.Split(src, "\n\n") {
+		para = strings.Trim(para, "\n")
+		if strings.TrimSpace(para) == "" {
+			continue
+		}
+		out.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(para), "\n", "<br>") + "</p>")
+	}
+	return out.String()
+}
+
+var (
+	headingRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	bulletRe  = regexp.MustCompile(`^[-*+]\s+`)
+	orderedRe = regexp.MustCompile(`^\d{1,9}[.)]\s+`)
+
+	codeSpanRe = regexp.MustCompile("`([^`]+)`")
+	linkRe     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	strongRe   = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	emRe       = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+// inline renders the inline markup of one block. The text is escaped first, so
+// only the markup produced here can become tags.
+func inline(text string) string {
+	// Code spans are set aside behind NUL-delimited placeholders so their
+	// contents aren't formatted
+	text = strings.ReplaceAll(text, "\x00", "")
+	var spans []string
+	text = codeSpanRe.ReplaceAllStringFunc(text, func(m string) string {
+		spans = append(spans, "<code>"+html.EscapeString(m[1:len(m)-1])+"</code>")
+		return "\x00" + strconv.Itoa(len(spans)-1) + "\x00"
+	})
+
+	text = html.EscapeString(text)
+	text = linkRe.ReplaceAllStringFunc(text, func(m string) string {
+		parts := linkRe.FindStringSubmatch(m)
+		return `<a href="` + parts[2] + `">` + parts[1] + "</a>"
+	})
+	text = strongRe.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = emRe.ReplaceAllString(text, "<em>$1$2</em>")
+
+	for i, span := range spans {
+		text = strings.Replace(text, "\x00"+strconv.Itoa(i)+"\x00", span, 1)
+	}
+	return text
+}
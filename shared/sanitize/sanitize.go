@@ -0,0 +1,273 @@
+// Package sanitize cleans untrusted HTML down to an allowlist of formatting tags,
+// and renders Markdown and plain text to HTML that is safe to embed in a page.
+//
+// HTML is not repaired in place: every tag in the output is rebuilt from the
+// allowlist and all text is re-escaped, so input the scanner misreads can only
+// come out as text, never as markup.
+package sanitize
+
+import (
+	"html"
+	"net/url"
+	"strings"
+)
+
+// allowedTags are the elements kept; anything else is removed, keeping its text
+var allowedTags = map[string]bool{
+	"a": true, "b": true, "blockquote": true, "br": true, "code": true, "em": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"hr": true, "i": true, "li": true, "ol": true, "p": true, "pre": true,
+	"s": true, "strong": true, "u": true, "ul": true,
+}
+
+// voidTags have no closing tag
+var voidTags = map[string]bool{"br": true, "hr": true}
+
+// droppedTags are removed together with everything inside them
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"template": true, "noscript": true, "textarea": true, "title": true,
+	"svg": true, "math": true, "xmp": true, "noembed": true, "noframes": true,
+}
+
+// allowedAttrs are the attributes kept per tag; every other attribute, including
+// all event handlers and style, is removed
+var allowedAttrs = map[string][]string{
+	"a": {"href", "title"},
+}
+
+// HTML returns s with everything outside the allowlist removed. Links keep only
+// http, https, mailto and same-site URLs and are marked rel="nofollow noopener".
+func HTML(s string) string {
+	var out strings.Builder
+	var open []string // allowed elements not yet closed, innermost last
+
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			out.WriteString(escapeText(s))
+			break
+		}
+		out.WriteString(escapeText(s[:lt]))
+		s = s[lt:]
+
+		// Comments, doctypes and processing instructions are dropped
+		if strings.HasPrefix(s, "<!--") {
+			s = skipPast(s[4:], "-->")
+			continue
+		}
+		if strings.HasPrefix(s, "<!") || strings.HasPrefix(s, "<?") {
+			s = skipPast(s[2:], ">")
+			continue
+		}
+
+		t, rest, ok := parseTag(s)
+		if !ok {
+			// Not a tag, just a less-than sign
+			out.WriteString("&lt;")
+			s = s[1:]
+			continue
+		}
+		s = rest
+
+		switch {
+		case droppedTags[t.name] && !t.closing:
+			s = skipElement(s, t.name)
+		case !allowedTags[t.name]:
+			// Unknown tags are removed but their text is kept
+		case t.closing:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == t.name {
+					// Close anything left open inside it too, so nesting stays balanced
+					for j := len(open) - 1; j >= i; j-- {
+						out.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		default:
+			out.WriteString(t.render())
+			if !voidTags[t.name] {
+				open = append(open, t.name)
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String()
+}
+
+// tag is a parsed start or end tag
+type tag struct {
+	name    string
+	closing bool
+	attrs   [][2]string
+}
+
+// render writes the tag with only its allowed attributes
+func (t tag) render() string {
+	var b strings.Builder
+	b.WriteString("<" + t.name)
+	for _, allowed := range allowedAttrs[t.name] {
+		for _, attr := range t.attrs {
+			if attr[0] != allowed {
+				continue
+			}
+			value := attr[1]
+			if allowed == "href" {
+				var ok bool
+				if value, ok = safeURL(value); !ok {
+					break
+				}
+			}
+			b.WriteString(" " + allowed + `="` + html.EscapeString(value) + `"`)
+			break
+		}
+	}
+	if t.name == "a" {
+		b.WriteString(` rel="nofollow noopener"`)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// parseTag reads a tag at the start of s ("<name ...>" or "</name>"), returning the
+// remainder of s after it. ok is false if s doesn't start with a complete tag.
+func parseTag(s string) (t tag, rest string, ok bool) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		t.closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && isNameByte(s[i], i == start) {
+		i++
+	}
+	if i == start {
+		return tag{}, s, false
+	}
+	t.name = strings.ToLower(s[start:i])
+
+	for i < len(s) {
+		// Skip whitespace and stray slashes between attributes
+		for i < len(s) && (isSpace(s[i]) || s[i] == '/') {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		if s[i] == '>' {
+			return t, s[i+1:], true
+		}
+
+		nameStart := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(s[nameStart:i])
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+
+		value := ""
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				quote := s[i]
+				end := strings.IndexByte(s[i+1:], quote)
+				if end < 0 {
+					return tag{}, s, false
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				valueStart := i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				value = s[valueStart:i]
+			}
+		}
+		if name != "" {
+			t.attrs = append(t.attrs, [2]string{name, html.UnescapeString(value)})
+		}
+	}
+	return tag{}, s, false
+}
+
+// skipElement skips past the end tag of name, or to the end of s if there is none
+func skipElement(s, name string) string {
+	lower := strings.ToLower(s)
+	for {
+		i := strings.Index(lower, "</"+name)
+		if i < 0 {
+			return ""
+		}
+		after := i + 2 + len(name)
+		if after >= len(lower) || isSpace(lower[after]) || lower[after] == '>' || lower[after] == '/' {
+			return skipPast(s[after:], ">")
+		}
+		s, lower = s[after:], lower[after:]
+	}
+}
+
+// skipPast returns s after the first marker, or "" if there is none
+func skipPast(s, marker string) string {
+	if i := strings.Index(s, marker); i >= 0 {
+		return s[i+len(marker):]
+	}
+	return ""
+}
+
+// safeURL returns a link target if it is http(s), mailto or same-site
+func safeURL(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	for _, r := range raw {
+		// Browsers ignore control characters and whitespace inside a scheme,
+		// e.g. "java\tscript:", so anything containing them is refused outright
+		if r < 0x20 || r == 0x7f {
+			return "", false
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return raw, true
+	case "":
+		// Relative URLs stay on this site; "//host" would leave it
+		if strings.HasPrefix(raw, "//") || strings.HasPrefix(raw, `\`) {
+			return "", false
+		}
+		return raw, true
+	default:
+		return "", false
+	}
+}
+
+// escapeText re-escapes text so only entities survive, never markup
+func escapeText(s string) string {
+	return html.EscapeString(html.UnescapeString(s))
+}
+
+func isNameByte(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,49 @@
+package sanitize
+
+import "testing"
+
+func TestHTMLNeutralizesXSS(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{name: "script", in: `<script>alert(1)</script>Lamp`, want: "Lamp"},
+		{name: "style", in: `<style>body{display:none}</style>Text`, want: "Text"},
+		{name: "event handler on void tag", in: `<img src=x onerror=alert(1)>`, want: ""},
+		{name: "svg onload", in: `<svg onload=alert(1)>`, want: ""},
+		{name: "iframe", in: `<iframe src="https://evil.example"></iframe>`, want: ""},
+		{name: "comment", in: `<!--<script>alert(1)</script>-->`, want: ""},
+		{name: "javascript link", in: `<a href="javascript:alert(1)">x</a>`, want: `<a rel="nofollow noopener">x</a>`},
+		{name: "mixed case scheme", in: `<a href="JaVaScRiPt:alert(1)">x</a>`, want: `<a rel="nofollow noopener">x</a>`},
+		{name: "entity in scheme", in: `<a href="java&#x09;script:alert(1)">x</a>`, want: `<a rel="nofollow noopener">x</a>`},
+		{name: "data link", in: `<a href="data:text/html;base64,PHNjcmlwdD4=">x</a>`, want: `<a rel="nofollow noopener">x</a>`},
+		{name: "handler on allowed tag", in: `<a href="https://example.com" onclick="alert(1)">x</a>`,
+			want: `<a href="https://example.com" rel="nofollow noopener">x</a>`},
+		{name: "style attribute", in: `<p style="background:url(javascript:alert(1))">x</p>`, want: "<p>x</p>"},
+		{name: "nested tag", in: `<scr<script>ipt>alert(1)</script>`, want: "ipt&gt;alert(1)"},
+		{name: "attribute breakout", in: `"><script>alert(1)</script>`, want: "&#34;&gt;"},
+		{name: "disallowed wrapper", in: `<div><b onmouseover=alert(1)>x</b></div>`, want: "<b>x</b>"},
+		{name: "formatting kept", in: `<b>bold</b> and <i>it</i>`, want: "<b>bold</b> and <i>it</i>"},
+		{name: "text escaped", in: `<p>1 < 2 & 3 > 2</p>`, want: "<p>1 &lt; 2 &amp; 3 &gt; 2</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTML(tt.in); got != tt.want {
+				t.Errorf("HTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownShowsRawHTMLAsText(t *testing.T) {
+	got := Markdown("[x](javascript:alert(1)) <script>alert(1)</script>")
+	want := `<p><a rel="nofollow noopener">x</a>) &lt;script&gt;alert(1)&lt;/script&gt;</p>`
+	if got != want {
+		t.Errorf("Markdown = %q, want %q", got, want)
+	}
+}
+
+func TestPlainEscapesHTML(t *testing.T) {
+	if got, want := Plain("<script>alert(1)</script>"), "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"; got != want {
+		t.Errorf("Plain = %q, want %q", got, want)
+	}
+}
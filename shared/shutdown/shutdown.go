@@ -0,0 +1,86 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultBudget is the overall time allowed for shutdown when SHUTDOWN_TIMEOUT is unset
+const DefaultBudget = 15 * time.Second
+
+// BudgetFromEnv reads SHUTDOWN_TIMEOUT, the overall time allowed for a shutdown sequence
+func BudgetFromEnv() (time.Duration, error) {
+	raw := os.Getenv("SHUTDOWN_TIMEOUT")
+	if raw == "" {
+		return DefaultBudget, nil
+	}
+	budget, err := time.ParseDuration(raw)
+	if err != nil || budget <= 0 {
+		return DefaultBudget, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q", raw)
+	}
+	return budget, nil
+}
+
+type step struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Sequence runs shutdown steps in the order they were added, e.g. stop serving HTTP,
+// then stop background work, then flush event sinks, then close the database
+type Sequence struct {
+	steps []step
+}
+
+// Add appends a step. fn should return once its work is done or ctx is cancelled.
+func (s *Sequence) Add(name string, fn func(ctx context.Context) error) {
+	s.steps = append(s.steps, step{name: name, fn: fn})
+}
+
+// AddCloser appends a step that calls close, for resources without a context-aware shutdown
+func (s *Sequence) AddCloser(name string, close func() error) {
+	s.Add(name, func(context.Context) error { return close() })
+}
+
+// Run executes every step in order, sharing budget between them. A failing or
+// overrunning step doesn't stop later ones from running, so resources are still
+// released; all errors are returned together.
+func (s *Sequence) Run(budget time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+
+	var errs []error
+	for _, st := range s.steps {
+		start := time.Now()
+		if err := st.fn(ctx); err != nil {
+			log.Printf("Shutdown: %s failed after %s: %v", st.name, time.Since(start).Round(time.Millisecond), err)
+			errs = append(errs, fmt.Errorf("%s: %w", st.name, err))
+			continue
+		}
+		log.Printf("Shutdown: %s done in %s", st.name, time.Since(start).Round(time.Millisecond))
+	}
+	return errors.Join(errs...)
+}
+
+// Wait returns a step function that calls wait (e.g. a WaitGroup's Wait) and
+// returns when it does, or gives up when ctx is cancelled
+func Wait(wait func()) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
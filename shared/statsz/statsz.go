@@ -0,0 +1,314 @@
+// Package statsz keeps a rolling summary of the requests a service handles and
+// serves it as JSON, for environments without Prometheus. Requests are counted
+// into 10-second slots covering the last 15 minutes; each slot holds fixed-size
+// counters, so recording a request allocates nothing and a snapshot only sums
+// a few arrays.
+package statsz
+
+import (
+	"database/sql"
+	"net/http"
+	"runtime/metrics"
+	"shared/httpx"
+	"sync"
+	"time"
+)
+
+const (
+	slotWidth = 10 * time.Second
+	slotCount = 90 // 15 minutes
+)
+
+// latencyBounds are the upper bounds of the latency histogram; slower requests
+// fall in a final overflow bucket
+var latencyBounds = [...]time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+}
+
+// latencyWindow is how far back percentiles are computed from
+const latencyWindow = 5 * time.Minute
+
+type slot struct {
+	index        int64 // which slot of time this is, so stale slots can be told apart
+	requests     int64
+	clientErrors int64
+	serverErrors int64
+	latency      [len(latencyBounds) + 1]int64
+}
+
+// Recorder counts requests. Use Middleware to record them and Handler to serve
+// the summary.
+type Recorder struct {
+	started time.Time
+	now     func() time.Time
+
+	mu           sync.Mutex
+	slots        [slotCount]slot
+	requests     int64 // since start
+	clientErrors int64
+	serverErrors int64
+}
+
+// New creates an empty Recorder
+func New() *Recorder {
+	return &Recorder{started: time.Now(), now: time.Now}
+}
+
+// Middleware records the status and duration of every request through next.
+// Event streams are counted but left out of latency, since they last as long as
+// the client stays connected.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := rec.now()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			rec.observe(sw.status, rec.now().Sub(start), r.Header.Get("Accept") == "text/event-stream")
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+func (rec *Recorder) observe(status int, elapsed time.Duration, streaming bool) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	bucket := len(latencyBounds)
+	for i, bound := range latencyBounds {
+		if elapsed <= bound {
+			bucket = i
+			break
+		}
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	s := rec.slot(rec.now())
+	s.requests++
+	rec.requests++
+	switch {
+	case status >= 500:
+		s.serverErrors++
+		rec.serverErrors++
+	case status >= 400:
+		s.clientErrors++
+		rec.clientErrors++
+	}
+	if !streaming {
+		s.latency[bucket]++
+	}
+}
+
+// slot returns the slot for t, clearing it if it last held an older slice of
+// time; rec.mu must be held
+func (rec *Recorder) slot(t time.Time) *slot {
+	index := t.UnixNano() / int64(slotWidth)
+	s := &rec.slots[index%slotCount]
+	if s.index != index {
+		*s = slot{index: index}
+	}
+	return s
+}
+
+// Summary is the JSON body of the statsz endpoint
+type Summary struct {
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Requests      RequestSummary `json:"requests"`
+	LatencyMS     LatencySummary `json:"latency_ms"`
+	Errors        ErrorSummary   `json:"errors"`
+	DB            *DBSummary     `json:"db,omitempty"`
+	Runtime       RuntimeSummary `json:"runtime"`
+}
+
+// RequestSummary gives the request rate per second over the last 1, 5 and 15 minutes
+type RequestSummary struct {
+	Total   int64   `json:"total"`
+	Rate1m  float64 `json:"rate_per_sec_1m"`
+	Rate5m  float64 `json:"rate_per_sec_5m"`
+	Rate15m float64 `json:"rate_per_sec_15m"`
+}
+
+// LatencySummary gives latency percentiles over the last 5 minutes, as the upper
+// bound of the histogram bucket each falls in; 0 when there were no requests
+type LatencySummary struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// ErrorSummary counts 4xx and 5xx responses since start and over the last 15 minutes
+type ErrorSummary struct {
+	ClientTotal int64 `json:"client_total"`
+	ServerTotal int64 `json:"server_total"`
+	Client15m   int64 `json:"client_15m"`
+	Server15m   int64 `json:"server_15m"`
+}
+
+// DBSummary is the connection pool state from sql.DB.Stats
+type DBSummary struct {
+	MaxOpen        int   `json:"max_open"`
+	Open           int   `json:"open"`
+	InUse          int   `json:"in_use"`
+	Idle           int   `json:"idle"`
+	WaitCount      int64 `json:"wait_count"`
+	WaitDurationMS int64 `json:"wait_duration_ms"`
+}
+
+// RuntimeSummary is read from runtime/metrics, which doesn't stop the world
+type RuntimeSummary struct {
+	Goroutines    uint64 `json:"goroutines"`
+	HeapBytes     uint64 `json:"heap_bytes"`
+	TotalMemBytes uint64 `json:"total_memory_bytes"`
+	GCCycles      uint64 `json:"gc_cycles"`
+	GOMAXPROCS    uint64 `json:"gomaxprocs"`
+	HeapGoalBytes uint64 `json:"heap_goal_bytes"`
+}
+
+var runtimeMetrics = []string{
+	"/sched/goroutines:goroutines",
+	"/memory/classes/heap/objects:bytes",
+	"/memory/classes/total:bytes",
+	"/gc/cycles/total:gc-cycles",
+	"/sched/gomaxprocs:threads",
+	"/gc/heap/goal:bytes",
+}
+
+// Summary computes the current summary; db may be nil
+func (rec *Recorder) Summary(db *sql.DB) Summary {
+	now := rec.now()
+	out := Summary{UptimeSeconds: int64(now.Sub(rec.started).Seconds())}
+
+	rec.mu.Lock()
+	current := now.UnixNano() / int64(slotWidth)
+	var last1, last5, last15 int64
+	var latency [len(latencyBounds) + 1]int64
+	for i := range rec.slots {
+		s := &rec.slots[i]
+		age := current - s.index
+		if s.requests == 0 || age < 0 || age >= slotCount {
+			continue
+		}
+		last15 += s.requests
+		out.Errors.Client15m += s.clientErrors
+		out.Errors.Server15m += s.serverErrors
+		if age < int64(latencyWindow/slotWidth) {
+			last5 += s.requests
+			for b, n := range s.latency {
+				latency[b] += n
+			}
+		}
+		if age < int64(time.Minute/slotWidth) {
+			last1 += s.requests
+		}
+	}
+	out.Requests.Total = rec.requests
+	out.Errors.ClientTotal = rec.clientErrors
+	out.Errors.ServerTotal = rec.serverErrors
+	rec.mu.Unlock()
+
+	out.Requests.Rate1m = rec.rate(last1, time.Minute, now)
+	out.Requests.Rate5m = rec.rate(last5, 5*time.Minute, now)
+	out.Requests.Rate15m = rec.rate(last15, 15*time.Minute, now)
+	out.LatencyMS = LatencySummary{
+		P50: percentile(latency, 0.50),
+		P95: percentile(latency, 0.95),
+		P99: percentile(latency, 0.99),
+	}
+
+	if db != nil {
+		stats := db.Stats()
+		out.DB = &DBSummary{
+			MaxOpen:        stats.MaxOpenConnections,
+			Open:           stats.OpenConnections,
+			InUse:          stats.InUse,
+			Idle:           stats.Idle,
+			WaitCount:      stats.WaitCount,
+			WaitDurationMS: stats.WaitDuration.Milliseconds(),
+		}
+	}
+
+	samples := make([]metrics.Sample, len(runtimeMetrics))
+	for i, name := range runtimeMetrics {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	values := make([]uint64, len(samples))
+	for i, s := range samples {
+		if s.Value.Kind() == metrics.KindUint64 {
+			values[i] = s.Value.Uint64()
+		}
+	}
+	out.Runtime = RuntimeSummary{
+		Goroutines:    values[0],
+		HeapBytes:     values[1],
+		TotalMemBytes: values[2],
+		GCCycles:      values[3],
+		GOMAXPROCS:    values[4],
+		HeapGoalBytes: values[5],
+	}
+	return out
+}
+
+// rate divides n by the window, or by the uptime while that is shorter (but at
+// least one slot, so the first requests after startup don't read as a spike)
+func (rec *Recorder) rate(n int64, window time.Duration, now time.Time) float64 {
+	window = min(window, max(now.Sub(rec.started), slotWidth))
+	return float64(n) / window.Seconds()
+}
+
+// percentile returns the upper bound in milliseconds of the bucket holding the
+// p-th request; requests past the last bound report that bound
+func percentile(latency [len(latencyBounds) + 1]int64, p float64) float64 {
+	var total int64
+	for _, n := range latency {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(p*float64(total) + 0.5)
+	var seen int64
+	for i, n := range latency {
+		seen += n
+		if seen >= max(rank, 1) {
+			bound := latencyBounds[min(i, len(latencyBounds)-1)]
+			return float64(bound) / float64(time.Millisecond)
+		}
+	}
+	return float64(latencyBounds[len(latencyBounds)-1]) / float64(time.Millisecond)
+}
+
+// Handler serves the summary as JSON; db may be nil if the service has no database
+func (rec *Recorder) Handler(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		httpx.WriteJSON(w, r, http.StatusOK, rec.Summary(db))
+	})
+}
+
+// statusWriter remembers the status a handler answered with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,50 @@
+package svcclient
+
+import (
+	"net/http"
+	"shared/auth"
+	"shared/httpx"
+	"shared/tenant"
+	"strings"
+	"time"
+)
+
+// Transport forwards the request-scoped context of a service-to-service call: the
+// remaining deadline budget, the tenant and the caller's identity
+type Transport struct {
+	Base http.RoundTripper // http.DefaultTransport if nil
+}
+
+func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	req = req.Clone(ctx)
+
+	// Pass on whatever is left of our own budget so the callee doesn't start work we'd discard
+	if deadline, ok := ctx.Deadline(); ok {
+		httpx.SetDeadline(req.Header, deadline)
+	}
+
+	if req.Header.Get(tenant.Header) == "" {
+		req.Header.Set(tenant.Header, tenant.FromContext(ctx))
+	}
+
+	if p := auth.FromContext(ctx); p.Authenticated() && req.Header.Get(auth.HeaderUserID) == "" {
+		req.Header.Set(auth.HeaderUserID, p.ID)
+		req.Header.Set(auth.HeaderRoles, strings.Join(p.Roles, ","))
+		if p.Impersonated() {
+			req.Header.Set(auth.HeaderImpersonatedBy, p.ImpersonatedBy)
+		}
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// New returns an http.Client for calling other services. timeout caps each call;
+// the context deadline, if sooner, still applies and is what the callee is told.
+func New(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: Transport{}, Timeout: timeout}
+}
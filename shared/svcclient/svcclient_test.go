@@ -0,0 +1,94 @@
+package svcclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"shared/auth"
+	"shared/httpx"
+	"shared/tenant"
+	"testing"
+	"time"
+)
+
+func TestInnerDeadlineIsNeverLaterThanOuter(t *testing.T) {
+	// inner stands for product-service: its own timeout is far longer than the caller's budget
+	var innerDeadline time.Time
+	innerMux := http.NewServeMux()
+	innerMux.HandleFunc("/products/5", func(w http.ResponseWriter, r *http.Request) {
+		innerDeadline, _ = r.Context().Deadline()
+	})
+	inner := httptest.NewServer(httpx.Timeouts(innerMux, httpx.RouteTimeouts{Default: time.Minute})(innerMux))
+	defer inner.Close()
+
+	// outer stands for a service called by the gateway, which calls inner
+	var outerDeadline time.Time
+	outerMux := http.NewServeMux()
+	outerMux.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+		outerDeadline, _ = r.Context().Deadline()
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, inner.URL+"/products/5", nil)
+		resp, err := New(30 * time.Second).Do(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		resp.Body.Close()
+	})
+	outer := httptest.NewServer(httpx.Timeouts(outerMux, httpx.RouteTimeouts{Default: 10 * time.Second})(outerMux))
+	defer outer.Close()
+
+	for _, budget := range []time.Duration{2 * time.Second, 20 * time.Second} {
+		gatewayDeadline := time.Now().Add(budget)
+		req, _ := http.NewRequest(http.MethodGet, outer.URL+"/orders", nil)
+		httpx.SetDeadline(req.Header, gatewayDeadline)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want 200", resp.StatusCode)
+		}
+
+		if outerDeadline.After(gatewayDeadline) {
+			t.Errorf("budget %v: outer deadline %v is later than the gateway's %v", budget, outerDeadline, gatewayDeadline)
+		}
+		if innerDeadline.IsZero() || innerDeadline.After(outerDeadline) {
+			t.Errorf("budget %v: inner deadline %v is later than the outer %v", budget, innerDeadline, outerDeadline)
+		}
+	}
+}
+
+func TestTransportForwardsTenantAndIdentity(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	ctx := tenant.WithTenant(context.Background(), "brand-b")
+	ctx = auth.WithPrincipal(ctx, auth.Principal{ID: "7", Roles: []string{"admin", "inventory"}, ImpersonatedBy: "staff-1"})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err := New(time.Second).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	latest := time.Now().Add(time.Second)
+
+	want := map[string]string{
+		tenant.Header:             "brand-b",
+		auth.HeaderUserID:         "7",
+		auth.HeaderRoles:          "admin,inventory",
+		auth.HeaderImpersonatedBy: "staff-1",
+	}
+	for header, value := range want {
+		if got.Get(header) != value {
+			t.Errorf("%s = %q, want %q", header, got.Get(header), value)
+		}
+	}
+	// The client's timeout is the budget the callee is told about
+	if deadline, ok := httpx.ParseDeadline(got.Get(httpx.DeadlineHeader)); !ok || deadline.After(latest) {
+		t.Errorf("%s = %q, want no later than the 1s client timeout", httpx.DeadlineHeader, got.Get(httpx.DeadlineHeader))
+	}
+}
@@ -0,0 +1,59 @@
+package tenant
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"shared/httpx"
+)
+
+// Header carries the tenant ID from the gateway to the services
+const Header = "X-Tenant-ID"
+
+// Default is the tenant that existing data was backfilled to, and the tenant
+// used when a request arrives without a tenant header
+const Default = "default"
+
+type contextKey struct{}
+
+// WithTenant returns a copy of ctx scoped to tenant id
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant the request is scoped to, or Default if none was set
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
+
+// Validator reports whether a tenant exists
+type Validator func(ctx context.Context, id string) (bool, error)
+
+// Middleware scopes each request to the tenant in the X-Tenant-ID header, after
+// checking it exists. Unknown tenants get 404 so tenant IDs can't be probed.
+func Middleware(validate Validator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(Header)
+			if id == "" {
+				id = Default
+			}
+
+			exists, err := validate(r.Context(), id)
+			if err != nil {
+				log.Printf("Could not validate tenant %q: %v", id, err)
+				httpx.Error(w, http.StatusInternalServerError, "could not validate tenant")
+				return
+			}
+			if !exists {
+				httpx.Error(w, http.StatusNotFound, "Not found")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), id)))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	known := map[string]bool{Default: true, "brand-b": true}
+	validate := func(ctx context.Context, id string) (bool, error) {
+		if id == "broken" {
+			return false, errors.New("connection refused")
+		}
+		return known[id], nil
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		want       int
+		wantTenant string
+	}{
+		{name: "no header", want: http.StatusOK, wantTenant: Default},
+		{name: "known tenant", header: "brand-b", want: http.StatusOK, wantTenant: "brand-b"},
+		// Unknown tenants look like missing resources, so IDs can't be probed
+		{name: "unknown tenant", header: "brand-c", want: http.StatusNotFound},
+		{name: "validation fails", header: "broken", want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := Middleware(validate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tt.header != "" {
+				r.Header.Set(Header, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", got, tt.wantTenant)
+			}
+		})
+	}
+}